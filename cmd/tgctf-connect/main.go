@@ -0,0 +1,162 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+// tgctf-connect 将题目实例的 TCP 端口映射到本地，无需平台发布宿主机端口
+//
+// 用法（连接命令可在比赛页面的实例面板复制）：
+//
+//	tgctf-connect -server https://ctf.example.com -instance <令牌>-<端口> -token <登录凭证> -listen 127.0.0.1:9999
+//	nc 127.0.0.1 9999
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func main() {
+	server := flag.String("server", "", "平台地址，如 https://ctf.example.com")
+	instance := flag.String("instance", "", "实例标识 <令牌>-<端口>")
+	token := flag.String("token", os.Getenv("TGCTF_TOKEN"), "平台登录凭证（也可通过环境变量 TGCTF_TOKEN 传递）")
+	listen := flag.String("listen", "127.0.0.1:9999", "本地监听地址")
+	flag.Parse()
+
+	if *server == "" || *instance == "" || *token == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	wsURL, err := tunnelURL(*server, *instance)
+	if err != nil {
+		log.Fatalf("平台地址无效: %v", err)
+	}
+
+	// 启动前先试连一次，凭证无效时直接退出
+	conn, err := dial(wsURL, *token)
+	if err != nil {
+		log.Fatalf("连接失败: %v", err)
+	}
+	conn.Close()
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("监听 %s 失败: %v", *listen, err)
+	}
+	log.Printf("已连接实例，本地监听 %s（例如: nc %s）", ln.Addr(), strings.Replace(ln.Addr().String(), ":", " ", 1))
+
+	var delay time.Duration
+	for {
+		local, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				log.Printf("本地监听已关闭: %v", err)
+				return
+			}
+			// 临时错误（如文件描述符耗尽）退避重试，避免空转
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			log.Printf("接受连接失败: %v，%v 后重试", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go handle(local, wsURL, *token)
+	}
+}
+
+// tunnelURL 构造隧道 WebSocket 地址
+func tunnelURL(server, instance string) (string, error) {
+	u, err := url.Parse(strings.TrimRight(server, "/"))
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http", "":
+		u.Scheme = "ws"
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("缺少主机名: %s", server)
+	}
+	u.Path += "/api/instances/tunnel/" + url.PathEscape(instance)
+	return u.String(), nil
+}
+
+// dial 建立隧道连接
+func dial(wsURL, token string) (*websocket.Conn, error) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	dialer := websocket.Dialer{HandshakeTimeout: 10 * time.Second, Proxy: http.ProxyFromEnvironment}
+	conn, resp, err := dialer.Dial(wsURL, header)
+	if err != nil {
+		if resp != nil {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return nil, fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// handle 每个本地连接对应一条隧道
+func handle(local net.Conn, wsURL, token string) {
+	defer local.Close()
+	log.Printf("新连接 %s", local.RemoteAddr())
+
+	conn, err := dial(wsURL, token)
+	if err != nil {
+		log.Printf("建立隧道失败: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// 实例 -> 本地（实例侧关闭时同时关闭本地连接）
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer local.Close()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if _, err := local.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	// 本地 -> 实例
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := local.Read(buf)
+		if n > 0 {
+			if werr := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+				break
+			}
+		}
+		if err != nil {
+			// 本地连接关闭，通知服务端结束隧道
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			break
+		}
+	}
+	<-done
+	log.Printf("连接关闭 %s", local.RemoteAddr())
+}
@@ -144,17 +144,36 @@ CREATE TABLE IF NOT EXISTS question_bank (
     docker_image VARCHAR(256),              -- Docker镜像名（容器题目）
     attachment_url TEXT,                    -- 附件URL或本地路径
     attachment_type VARCHAR(16) DEFAULT 'url', -- 附件类型: url(外部链接) | local(本地上传)
+    attachment_generator VARCHAR(16),       -- 动态附件生成方式: template(替换附件中的 {{FLAG}}) | script(沙箱运行生成脚本) | null(不生成)
+    attachment_script TEXT,                 -- 动态附件生成脚本（首行 #! 指定解释器，环境变量 FLAG/TEAM_ID/CHALLENGE_ID，标准输出为附件内容）
     -- 镜像性能配置 (JSON格式存储)
     ports TEXT,                             -- JSON数组: ["80", "8080", "22"]
     cpu_limit VARCHAR(32),                  -- 如: "1.0", "0.5"
     memory_limit VARCHAR(32),               -- 如: "512m", "1g"
     storage_limit VARCHAR(32),              -- 如: "1g", "5g"
     no_resource_limit BOOLEAN DEFAULT FALSE,-- 是否不限制性能
+    network_policy VARCHAR(16) DEFAULT 'full', -- 出网策略: full(不限制) | platform(仅平台) | none(禁止出网)
+    read_only_rootfs BOOLEAN DEFAULT FALSE, -- 只读根文件系统（/tmp 等目录挂载为 tmpfs）
+    allow_setuid BOOLEAN DEFAULT FALSE,     -- 允许容器内提权（不设置 no-new-privileges，setuid 程序如 /readflag 需要）
     flag_env VARCHAR(64) DEFAULT 'FLAG',     -- Flag注入环境变量名 (FLAG, GZCTF_FLAG, CTF_FLAG 等)
     flag_script VARCHAR(256),                  -- Flag注入脚本路径 (如 /flag.sh，容器启动后执行)
     needs_edit BOOLEAN DEFAULT FALSE,        -- 是否需要再次编辑（Excel导入时标记有多端口/附件的题目）
     image_status VARCHAR(16),                 -- 镜像状态: exists | not_found | null(未测试)
     image_checked_at TIMESTAMP,               -- 镜像最后检查时间
+    -- 题目包导入的默认值（添加到比赛时使用）
+    default_hints TEXT,                       -- JSON数组: ["提示1", "提示2"]，添加到比赛时生成未发布的提示
+    default_initial_score INTEGER,            -- 默认初始分数
+    default_min_score INTEGER,                -- 默认最低分数
+    -- 解题脚本自检（随机 Flag 启动测试容器，在沙箱中运行解题脚本）
+    solve_script TEXT,                        -- 解题脚本（首行 #! 指定解释器，参数: <host> <port>，输出中包含 Flag 即通过）
+    verify_status VARCHAR(16),                -- 自检结果: passed | failed | error | null(未自检)
+    verify_output TEXT,                       -- 最近一次自检的说明与脚本输出
+    verified_at TIMESTAMP,                    -- 最近一次自检时间
+    version INTEGER NOT NULL DEFAULT 1,       -- 当前版本号（修改题目内容时递增，快照见 question_revisions）
+    author VARCHAR(128),                      -- 出题人
+    source VARCHAR(256),                      -- 题目来源（如 XXCTF 2024 / 原创）
+    -- 全文检索（标题 + 描述，simple 分词；中文按子串匹配补充）
+    search_vector tsvector GENERATED ALWAYS AS (to_tsvector('simple', COALESCE(title, '') || ' ' || COALESCE(description, ''))) STORED,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -162,12 +181,96 @@ CREATE TABLE IF NOT EXISTS question_bank (
 CREATE INDEX idx_question_bank_type ON question_bank(type);
 CREATE INDEX idx_question_bank_category ON question_bank(category_id);
 CREATE INDEX idx_question_bank_difficulty ON question_bank(difficulty);
+CREATE INDEX idx_question_bank_search ON question_bank USING GIN(search_vector);
+
+-- 题库标签（普通题库与 AWD-F 题库共用）
+CREATE TABLE IF NOT EXISTS question_tags (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(32) NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS question_bank_tags (
+    question_id INTEGER NOT NULL REFERENCES question_bank(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES question_tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (question_id, tag_id)
+);
+
+CREATE INDEX idx_question_bank_tags_tag ON question_bank_tags(tag_id);
+
+-- 题库题目版本快照（比赛题目固定引用某个版本，题库修改不影响已有比赛）
+-- 内容列与 question_bank 同名同类型
+CREATE TABLE IF NOT EXISTS question_revisions (
+    id SERIAL PRIMARY KEY,
+    question_id INTEGER NOT NULL REFERENCES question_bank(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,                  -- 版本号（题目内递增）
+    title VARCHAR(256) NOT NULL,
+    type VARCHAR(32) NOT NULL,
+    category_id INTEGER NOT NULL REFERENCES categories(id),
+    difficulty INT NOT NULL DEFAULT 5,
+    description TEXT,
+    flag VARCHAR(512),
+    flag_type VARCHAR(32) DEFAULT 'static',
+    docker_image VARCHAR(256),
+    attachment_url TEXT,
+    attachment_type VARCHAR(16) DEFAULT 'url',
+    attachment_generator VARCHAR(16),
+    attachment_script TEXT,
+    ports TEXT,
+    cpu_limit VARCHAR(32),
+    memory_limit VARCHAR(32),
+    storage_limit VARCHAR(32),
+    no_resource_limit BOOLEAN DEFAULT FALSE,
+    network_policy VARCHAR(16) DEFAULT 'full',
+    read_only_rootfs BOOLEAN DEFAULT FALSE,
+    allow_setuid BOOLEAN DEFAULT FALSE,
+    flag_env VARCHAR(64) DEFAULT 'FLAG',
+    flag_script VARCHAR(256),
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(question_id, version)
+);
+
+-- 附件元数据（文件保存在附件存储后端，访问地址为 /attachments/<storage_key>）
+-- 引用数由题库、版本快照、临时题目的 attachment_url 实时统计，仍被引用的附件不能删除
+CREATE TABLE IF NOT EXISTS attachments (
+    id SERIAL PRIMARY KEY,
+    storage_key VARCHAR(255) NOT NULL UNIQUE,  -- 存储对象键（随机文件名）
+    original_name VARCHAR(255),                -- 上传时的文件名
+    size BIGINT NOT NULL DEFAULT 0,            -- 字节数
+    sha256 CHAR(64) NOT NULL,                  -- 内容校验和（展示给选手）
+    content_type VARCHAR(128),                 -- 按文件内容检测的 MIME 类型
+    backend VARCHAR(16) NOT NULL DEFAULT 'local', -- 存储后端: local | s3
+    uploaded_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_attachments_sha256 ON attachments(sha256);
+
+-- 题目镜像构建记录（从上传的 Dockerfile 构建上下文构建，标签为 tgctf/q-<id>:<version>）
+CREATE TABLE IF NOT EXISTS question_image_builds (
+    id SERIAL PRIMARY KEY,
+    question_id INTEGER NOT NULL REFERENCES question_bank(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,                  -- 构建版本号（题目内递增）
+    image VARCHAR(256) NOT NULL,               -- 构建产物镜像标签
+    status VARCHAR(16) NOT NULL DEFAULT 'pending', -- pending | building | success | failed
+    log TEXT,                                  -- 构建日志（超长时保留末尾）
+    error TEXT,                                -- 失败原因
+    nodes TEXT,                                -- JSON数组: 完成构建的节点
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    UNIQUE(question_id, version)
+);
+
+CREATE INDEX idx_question_image_builds_question ON question_image_builds(question_id);
 
 -- 比赛题目关联表（从题库添加到比赛）
 CREATE TABLE IF NOT EXISTS contest_challenges (
     id SERIAL PRIMARY KEY,
     contest_id INTEGER NOT NULL REFERENCES contests(id) ON DELETE CASCADE,
     question_id INTEGER REFERENCES question_bank(id) ON DELETE CASCADE,  -- 允许为空（临时题目无题库关联）
+    question_version INTEGER,                    -- 固定的题库题目版本（question_revisions.version），升级需管理员显式操作
     initial_score INTEGER NOT NULL DEFAULT 500,  -- 初始分数
     min_score INTEGER NOT NULL DEFAULT 100,      -- 最低分数
     difficulty INTEGER NOT NULL DEFAULT 5,       -- 难度系数（用于动态计分）
@@ -176,6 +279,8 @@ CREATE TABLE IF NOT EXISTS contest_challenges (
     hint_released BOOLEAN DEFAULT FALSE,         -- 提示是否已发布
     status VARCHAR(32) NOT NULL DEFAULT 'hidden',  -- hidden | public
     release_time TIMESTAMP,                      -- 题目开放时间
+    instance_policy VARCHAR(16) NOT NULL DEFAULT 'team',  -- 容器实例策略: team（每队一个）| user（每人一个）| shared（全场共享）
+    warm_pool_size INTEGER NOT NULL DEFAULT 0,   -- 预热池容器数，0 表示不预热
     -- 临时题目字段（当 question_id 为 NULL 时使用）
     inline_title VARCHAR(256),                   -- 题目标题
     inline_type VARCHAR(32),                     -- 题目类型: static_attachment | static_container | dynamic_attachment | dynamic_container
@@ -189,6 +294,7 @@ CREATE TABLE IF NOT EXISTS contest_challenges (
     inline_ports TEXT,                           -- JSON数组: ["80", "8080"]
     inline_cpu_limit VARCHAR(32),                -- CPU限制
     inline_memory_limit VARCHAR(32),             -- 内存限制
+    inline_network_policy VARCHAR(16) DEFAULT 'full',  -- 出网策略: full | platform | none
     inline_flag_env VARCHAR(64) DEFAULT 'FLAG',  -- Flag注入环境变量名
     inline_flag_script VARCHAR(256),             -- Flag注入脚本路径
     -- 不定项选择题字段
@@ -218,6 +324,42 @@ CREATE TABLE IF NOT EXISTS contest_challenge_hints (
 CREATE INDEX idx_challenge_hints_challenge ON contest_challenge_hints(challenge_id);
 CREATE INDEX idx_challenge_hints_released ON contest_challenge_hints(released);
 
+-- 比赛题目的队伍专属附件（优先于题目附件，每支队伍下载到不同的文件）
+CREATE TABLE IF NOT EXISTS challenge_attachment_variants (
+    id SERIAL PRIMARY KEY,
+    challenge_id INTEGER NOT NULL REFERENCES contest_challenges(id) ON DELETE CASCADE,
+    team_id INTEGER NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    attachment_url TEXT NOT NULL,                -- 平台附件地址 /attachments/<key>
+    generated BOOLEAN NOT NULL DEFAULT FALSE,    -- 是否由动态附件生成器生成（手动上传的不会被覆盖）
+    question_version INTEGER,                    -- 生成时的题目版本，版本升级后重新生成
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(challenge_id, team_id)
+);
+
+-- 动态附件生成记录（每次生成一条，保留历史，用于根据泄露文件的校验和追溯队伍）
+CREATE TABLE IF NOT EXISTS generated_attachments (
+    id SERIAL PRIMARY KEY,
+    challenge_id INTEGER NOT NULL REFERENCES contest_challenges(id) ON DELETE CASCADE,
+    team_id INTEGER NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    attachment_url TEXT NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    question_version INTEGER,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_generated_attachments_sha256 ON generated_attachments(sha256);
+
+-- 附件下载统计（按队伍，通过签名链接下载时计数）
+CREATE TABLE IF NOT EXISTS attachment_downloads (
+    challenge_id INTEGER NOT NULL REFERENCES contest_challenges(id) ON DELETE CASCADE,
+    team_id INTEGER NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    download_count INTEGER NOT NULL DEFAULT 0,
+    first_downloaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_downloaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (challenge_id, team_id)
+);
+
 -- 旧的题目表（保留向后兼容，可逐步迁移）
 CREATE TABLE IF NOT EXISTS challenges (
     id SERIAL PRIMARY KEY,
@@ -263,7 +405,7 @@ CREATE TABLE IF NOT EXISTS team_challenge_flags (
 CREATE INDEX idx_team_challenge_flags_team ON team_challenge_flags(team_id);
 CREATE INDEX idx_team_challenge_flags_challenge ON team_challenge_flags(challenge_id);
 
--- 队伍容器实例表（基于队伍，同队共享实例）
+-- 队伍容器实例表（按题目实例策略归属队伍、个人或全场共享）
 CREATE TABLE IF NOT EXISTS team_instances (
     id SERIAL PRIMARY KEY,
     team_id INTEGER NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
@@ -272,12 +414,21 @@ CREATE TABLE IF NOT EXISTS team_instances (
     container_id VARCHAR(64) NOT NULL,            -- Docker容器ID
     container_name VARCHAR(128),                  -- 容器名称
     ports TEXT,                                   -- JSON: {"80": "32768", "8080": "32769"}
+    backend VARCHAR(32) NOT NULL DEFAULT 'docker', -- 容器后端: docker | podman | kubernetes
+    node VARCHAR(128),                            -- 所在节点
+    restart_count INTEGER NOT NULL DEFAULT 0,     -- 巡检自动重启次数（距上次重启超过一段时间后清零）
+    restarted_at TIMESTAMP,                       -- 最近一次巡检自动重启时间
     status VARCHAR(32) NOT NULL DEFAULT 'running',  -- running | stopped | destroyed
     expires_at TIMESTAMP NOT NULL,                -- 过期时间
     created_by INTEGER REFERENCES users(id),      -- 创建者用户ID
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,  -- 个人实例所属用户
+    owner_key VARCHAR(64) NOT NULL DEFAULT '',    -- 实例归属: t:<队伍ID> | u:<用户ID> | shared
+    memory_mb INTEGER NOT NULL DEFAULT 0,         -- 计入全局内存总量的内存(MB)
+    cpu_cores NUMERIC(6,2) NOT NULL DEFAULT 0,    -- 计入队伍 CPU 配额的核数
+    access_token VARCHAR(64),                     -- 平台代理访问令牌（每次创建重新生成）
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    UNIQUE(team_id, challenge_id)                 -- 每队每题只能有一个实例
+    UNIQUE(challenge_id, owner_key)               -- 每个归属每题只能有一个实例
 );
 
 CREATE INDEX idx_team_instances_team ON team_instances(team_id);
@@ -285,6 +436,99 @@ CREATE INDEX idx_team_instances_contest ON team_instances(contest_id);
 CREATE INDEX idx_team_instances_challenge ON team_instances(challenge_id);
 CREATE INDEX idx_team_instances_status ON team_instances(status);
 CREATE INDEX idx_team_instances_expires ON team_instances(expires_at);
+CREATE UNIQUE INDEX idx_team_instances_access_token ON team_instances(access_token);
+
+-- 容器实例排队表（全局容量或端口池不足时按先后顺序创建）
+CREATE TABLE IF NOT EXISTS instance_queue (
+    id SERIAL PRIMARY KEY,
+    contest_id INTEGER NOT NULL REFERENCES contests(id) ON DELETE CASCADE,
+    challenge_id INTEGER NOT NULL REFERENCES contest_challenges(id) ON DELETE CASCADE,
+    team_id INTEGER NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,  -- 发起排队的用户
+    policy VARCHAR(16) NOT NULL DEFAULT 'team',   -- 实例策略: team | user | shared
+    owner_key VARCHAR(64) NOT NULL,               -- 实例归属: t:<队伍ID> | u:<用户ID> | shared
+    memory_mb INTEGER NOT NULL DEFAULT 0,         -- 预计占用内存(MB)
+    status VARCHAR(32) NOT NULL DEFAULT 'waiting',  -- waiting | launching | ready | failed | cancelled
+    instance_id INTEGER REFERENCES team_instances(id) ON DELETE SET NULL,  -- 创建成功的实例
+    error TEXT,                                   -- 失败原因
+    job_id INTEGER,                               -- 关联的实例任务
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_instance_queue_status ON instance_queue(status, id);
+CREATE INDEX idx_instance_queue_owner ON instance_queue(challenge_id, owner_key);
+
+-- 实例任务表（创建/销毁/续期异步执行，状态变化推送给队伍）
+CREATE TABLE IF NOT EXISTS instance_jobs (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL,                    -- create | destroy | extend
+    contest_id INTEGER NOT NULL REFERENCES contests(id) ON DELETE CASCADE,
+    challenge_id INTEGER NOT NULL REFERENCES contest_challenges(id) ON DELETE CASCADE,
+    team_id INTEGER NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,  -- 发起任务的用户
+    policy VARCHAR(16) NOT NULL DEFAULT 'team',   -- 实例策略: team | user | shared
+    owner_key VARCHAR(64) NOT NULL,               -- 实例归属: t:<队伍ID> | u:<用户ID> | shared
+    status VARCHAR(32) NOT NULL DEFAULT 'queued', -- queued | pulling | starting | injecting_flag | stopping | extending | ready | done | failed
+    instance_id INTEGER REFERENCES team_instances(id) ON DELETE SET NULL,  -- 关联的实例
+    memory_mb INTEGER NOT NULL DEFAULT 0,         -- 创建任务预计占用内存(MB)，任务结束前计入队伍配额
+    cpu_cores NUMERIC(6,2) NOT NULL DEFAULT 0,    -- 创建任务预计占用核数，任务结束前计入队伍配额
+    message TEXT,                                 -- 当前进度说明或失败原因
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP                         -- 任务结束时间
+);
+
+CREATE INDEX idx_instance_jobs_owner ON instance_jobs(challenge_id, owner_key, status);
+CREATE INDEX idx_instance_jobs_team ON instance_jobs(team_id, contest_id);
+
+-- 实例任务状态变化记录
+CREATE TABLE IF NOT EXISTS instance_job_events (
+    id SERIAL PRIMARY KEY,
+    job_id INTEGER NOT NULL REFERENCES instance_jobs(id) ON DELETE CASCADE,
+    status VARCHAR(32) NOT NULL,                  -- 变化后的状态
+    message TEXT,                                 -- 进度说明
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_instance_job_events_job ON instance_job_events(job_id, id);
+
+-- 预热池容器表（比赛进行中预先启动的题目容器，选手启动实例时直接领取）
+CREATE TABLE IF NOT EXISTS warm_pool_containers (
+    id SERIAL PRIMARY KEY,
+    contest_id INTEGER NOT NULL REFERENCES contests(id) ON DELETE CASCADE,
+    challenge_id INTEGER NOT NULL REFERENCES contest_challenges(id) ON DELETE CASCADE,
+    container_id VARCHAR(64) NOT NULL,            -- 容器ID
+    container_name VARCHAR(128),                  -- 容器名称
+    ports TEXT,                                   -- JSON: {"80": "32768"}
+    backend VARCHAR(32) NOT NULL DEFAULT 'docker', -- 容器后端
+    node VARCHAR(128),                            -- 所在节点
+    memory_mb INTEGER NOT NULL DEFAULT 0,         -- 计入全局内存总量的内存(MB)
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_warm_pool_challenge ON warm_pool_containers(challenge_id);
+
+-- 实例资源占用采样表（定期采集运行中实例的 CPU / 内存 / 网络 / 进程数，保留 24 小时）
+CREATE TABLE IF NOT EXISTS instance_usage_samples (
+    id BIGSERIAL PRIMARY KEY,
+    instance_type VARCHAR(16) NOT NULL,           -- team | awdf
+    instance_id INTEGER NOT NULL,                 -- team_instances.id / team_instances_awdf.id
+    team_id INTEGER NOT NULL,
+    contest_id INTEGER NOT NULL,
+    challenge_id INTEGER NOT NULL,
+    cpu_percent REAL NOT NULL DEFAULT 0,          -- CPU 占用（100 表示一个核心）
+    memory_bytes BIGINT NOT NULL DEFAULT 0,       -- 内存占用
+    memory_limit_bytes BIGINT NOT NULL DEFAULT 0, -- 内存上限
+    net_rx_bytes BIGINT NOT NULL DEFAULT 0,       -- 累计接收字节数
+    net_tx_bytes BIGINT NOT NULL DEFAULT 0,       -- 累计发送字节数
+    pids INTEGER NOT NULL DEFAULT 0,              -- 进程数
+    sampled_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_instance_usage_sampled ON instance_usage_samples(sampled_at);
+CREATE INDEX idx_instance_usage_team ON instance_usage_samples(team_id, sampled_at);
+CREATE INDEX idx_instance_usage_challenge ON instance_usage_samples(challenge_id, sampled_at);
 
 -- 比赛-队伍关联表（队伍报名参赛及审核状态）
 CREATE TABLE IF NOT EXISTS contest_teams (
@@ -372,9 +616,33 @@ INSERT INTO system_settings (key, value, description) VALUES
     ('container_initial_ttl', '120', '容器初始有效期(分钟)'),
     ('container_extend_ttl', '120', '单次续期时长(分钟)'),
     ('container_extend_window', '15', '续期窗口(剩余分钟数)'),
-    ('auto_destroy_expired', 'true', '自动销毁过期实例')
+    ('auto_destroy_expired', 'true', '自动销毁过期实例'),
+    ('container_max_running', '0', '全局最大运行容器数(0不限)'),
+    ('container_max_memory', '0', '全局容器内存总量上限MB(0不限)'),
+    ('container_publish_ports', 'true', '队伍实例发布宿主机端口(关闭后仅能通过平台代理访问)'),
+    ('team_memory_quota', '0', '每队运行中实例内存总量上限MB(0不限)'),
+    ('team_cpu_quota', '0', '每队运行中实例CPU核数上限(0不限)'),
+    ('runaway_cpu_percent', '0', '失控判定: CPU占用达到限制的百分比(0不检测)'),
+    ('runaway_pids', '1000', '失控判定: 进程数上限(0不检测)')
 ON CONFLICT (key) DO NOTHING;
 
+-- Docker 工作节点表（多主机调度，未配置任何节点时所有容器运行在本机）
+CREATE TABLE IF NOT EXISTS docker_hosts (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(64) UNIQUE NOT NULL,             -- 节点名称（写入 team_instances.node）
+    endpoint VARCHAR(255) NOT NULL DEFAULT '',    -- 守护进程地址: tcp://10.0.0.2:2376 / ssh://root@10.0.0.2，空表示本机
+    public_host VARCHAR(255) NOT NULL DEFAULT '', -- 选手访问地址（IP或域名），空表示使用平台域名
+    capacity INTEGER NOT NULL DEFAULT 0,          -- 最大实例数，0 表示不限
+    labels TEXT DEFAULT '[]',                     -- JSON: ["team", "awdf"]，为空时接受所有类型实例
+    status VARCHAR(32) NOT NULL DEFAULT 'active', -- active | draining
+    healthy BOOLEAN NOT NULL DEFAULT TRUE,        -- 健康检查结果
+    fail_count INTEGER NOT NULL DEFAULT 0,        -- 连续健康检查失败次数
+    last_error TEXT,                              -- 最近一次检查错误
+    last_check_at TIMESTAMP,                      -- 最近一次检查时间
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 -- 队伍首次查看题目记录表（用于计算解题用时）
 CREATE TABLE IF NOT EXISTS challenge_first_views (
     id SERIAL PRIMARY KEY,
@@ -390,6 +658,25 @@ CREATE INDEX idx_challenge_first_views_contest ON challenge_first_views(contest_
 CREATE INDEX idx_challenge_first_views_challenge ON challenge_first_views(challenge_id);
 CREATE INDEX idx_challenge_first_views_team ON challenge_first_views(team_id);
 
+-- 选手题目评价表（每人每题一次，仅限队伍解出或尝试过的题目）
+CREATE TABLE IF NOT EXISTS challenge_feedback (
+    id SERIAL PRIMARY KEY,
+    contest_id INTEGER NOT NULL REFERENCES contests(id) ON DELETE CASCADE,
+    challenge_id INTEGER NOT NULL,                -- 可能来自 contest_challenges 或 contest_challenges_awdf
+    question_id INTEGER,                          -- 评价时对应的题库题目（临时题目为空），用于跨比赛汇总
+    team_id INTEGER REFERENCES teams(id) ON DELETE SET NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    solved BOOLEAN NOT NULL DEFAULT FALSE,        -- 评价时队伍是否已解出
+    difficulty SMALLINT NOT NULL CHECK (difficulty BETWEEN 1 AND 10), -- 体感难度投票（与题目难度同为 1-10）
+    quality SMALLINT NOT NULL CHECK (quality BETWEEN 1 AND 5),        -- 题目质量星级
+    comment TEXT,                                 -- 选填留言（脑洞、非预期、环境问题等）
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(contest_id, challenge_id, user_id)
+);
+
+CREATE INDEX idx_challenge_feedback_challenge ON challenge_feedback(contest_id, challenge_id);
+CREATE INDEX idx_challenge_feedback_question ON challenge_feedback(question_id);
+
 -- 系统日志表（记录所有操作）
 CREATE TABLE IF NOT EXISTS system_logs (
     id SERIAL PRIMARY KEY,
@@ -455,6 +742,9 @@ CREATE TABLE IF NOT EXISTS question_bank_awdf (
     memory_limit VARCHAR(32),                      -- 如: "512m", "1g"
     storage_limit VARCHAR(32),                     -- 如: "1g", "5g"
     no_resource_limit BOOLEAN DEFAULT FALSE,       -- 是否不限制性能
+    network_policy VARCHAR(16) DEFAULT 'full',     -- 出网策略: full(不限制) | platform(仅平台) | none(禁止出网)
+    read_only_rootfs BOOLEAN DEFAULT FALSE,        -- 只读根文件系统（/tmp 等目录挂载为 tmpfs）
+    allow_setuid BOOLEAN DEFAULT FALSE,            -- 允许容器内提权（不设置 no-new-privileges，setuid 程序如 /readflag 需要）
     -- AWD-F 专属配置
     exp_script TEXT,                               -- EXP脚本内容（用于攻击验证）
     check_script TEXT,                             -- 功能检测脚本（验证服务是否正常）
@@ -465,12 +755,29 @@ CREATE TABLE IF NOT EXISTS question_bank_awdf (
     -- 状态
     image_status VARCHAR(16),                      -- 镜像状态: exists | not_found | null
     image_checked_at TIMESTAMP,                    -- 镜像最后检查时间
+    -- 题目包导入的默认计分（添加到比赛时使用）
+    default_initial_score INTEGER,
+    default_min_score INTEGER,
+    default_defense_score INTEGER,
+    default_attack_interval INTEGER,
+    author VARCHAR(128),                           -- 出题人
+    source VARCHAR(256),                           -- 题目来源
+    search_vector tsvector GENERATED ALWAYS AS (to_tsvector('simple', COALESCE(title, '') || ' ' || COALESCE(description, ''))) STORED,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 CREATE INDEX idx_question_bank_awdf_category ON question_bank_awdf(category_id);
 CREATE INDEX idx_question_bank_awdf_difficulty ON question_bank_awdf(difficulty);
+CREATE INDEX idx_question_bank_awdf_search ON question_bank_awdf USING GIN(search_vector);
+
+CREATE TABLE IF NOT EXISTS question_bank_awdf_tags (
+    question_id INTEGER NOT NULL REFERENCES question_bank_awdf(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES question_tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (question_id, tag_id)
+);
+
+CREATE INDEX idx_question_bank_awdf_tags_tag ON question_bank_awdf_tags(tag_id);
 
 -- AWD-F 比赛题目关联表
 CREATE TABLE IF NOT EXISTS contest_challenges_awdf (
@@ -577,6 +884,10 @@ CREATE TABLE IF NOT EXISTS team_instances_awdf (
     container_name VARCHAR(128),                  -- 容器名称
     ports TEXT,                                   -- JSON: {"80": "32768", "8080": "32769"}
     ssh_password VARCHAR(32),                     -- SSH登录密码（16位随机）
+    backend VARCHAR(32) NOT NULL DEFAULT 'docker', -- 容器后端: docker | podman | kubernetes
+    node VARCHAR(128),                            -- 所在节点
+    restart_count INTEGER NOT NULL DEFAULT 0,     -- 巡检自动重启次数（距上次重启超过一段时间后清零）
+    restarted_at TIMESTAMP,                       -- 最近一次巡检自动重启时间
     status VARCHAR(32) NOT NULL DEFAULT 'running',  -- running | stopped | destroyed
     expires_at TIMESTAMP NOT NULL,                -- 过期时间
     created_by INTEGER REFERENCES users(id),      -- 创建者用户ID
@@ -590,4 +901,23 @@ CREATE INDEX idx_team_instances_awdf_contest ON team_instances_awdf(contest_id);
 CREATE INDEX idx_team_instances_awdf_challenge ON team_instances_awdf(challenge_id);
 CREATE INDEX idx_team_instances_awdf_status ON team_instances_awdf(status);
 CREATE INDEX idx_team_instances_awdf_expires ON team_instances_awdf(expires_at);
+
+-- AWD-F Web 终端会话记录（选手在浏览器中进入本队容器的操作记录，用于争议排查）
+CREATE TABLE IF NOT EXISTS awdf_terminal_sessions (
+    id SERIAL PRIMARY KEY,
+    contest_id INTEGER NOT NULL REFERENCES contests(id) ON DELETE CASCADE,
+    challenge_id INTEGER NOT NULL REFERENCES contest_challenges_awdf(id) ON DELETE CASCADE,
+    team_id INTEGER NOT NULL REFERENCES teams(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    container_id VARCHAR(64) NOT NULL,             -- 会话所在容器
+    client_ip VARCHAR(64),                         -- 选手IP
+    input TEXT NOT NULL DEFAULT '',                -- 键盘输入记录
+    output TEXT NOT NULL DEFAULT '',               -- 终端输出记录
+    truncated BOOLEAN NOT NULL DEFAULT FALSE,      -- 记录是否超出上限被截断
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    ended_at TIMESTAMP                             -- 为空表示会话进行中
+);
+
+CREATE INDEX idx_awdf_terminal_sessions_contest ON awdf_terminal_sessions(contest_id);
+CREATE INDEX idx_awdf_terminal_sessions_team ON awdf_terminal_sessions(team_id);
   
\ No newline at end of file
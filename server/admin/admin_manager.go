@@ -407,7 +407,7 @@ func HandleGrantPermission(c *gin.Context, db *sql.DB) {
 	}
 	// 检查 org.{id}.* 格式
 	if !isValid {
-		matched, _ := regexp.MatchString(`^org\.\d+\.(view|user\.view|user\.edit|user\.ban|team\.view|team\.edit|team\.ban|docker\.view|docker\.delete|anticheat\.view|logs\.view)$`, req.Permission)
+		matched, _ := regexp.MatchString(`^org\.\d+\.(view|user\.view|user\.edit|user\.ban|team\.view|team\.edit|team\.ban|docker\.view|docker\.delete|docker\.exec|anticheat\.view|logs\.view)$`, req.Permission)
 		isValid = matched
 	}
 	if !isValid {
@@ -462,8 +462,8 @@ func HandleGrantPermission(c *gin.Context, db *sql.DB) {
 			}
 		}
 
-		// 4. docker.delete 需要 docker.view
-		if subPerm == "docker.delete" {
+		// 4. docker.delete/docker.exec 需要 docker.view
+		if subPerm == "docker.delete" || subPerm == "docker.exec" {
 			autoGrantPerm := fmt.Sprintf("org.%s.docker.view", orgIDStr)
 			_, err := db.Exec(`INSERT INTO admin_permissions (user_id, permission, granted_by, granted_at) SELECT $1::bigint, $2::text, $3::bigint, NOW() WHERE NOT EXISTS (SELECT 1 FROM admin_permissions WHERE user_id = $1::bigint AND permission = $2::text AND resource_type IS NULL)`, id, autoGrantPerm, grantedBy)
 			if err != nil {
@@ -566,6 +566,7 @@ func HandleRevokePermissionByName(c *gin.Context, db *sql.DB) {
 				fmt.Sprintf("org.%s.team.ban", orgIDStr),
 				fmt.Sprintf("org.%s.docker.view", orgIDStr),
 				fmt.Sprintf("org.%s.docker.delete", orgIDStr),
+				fmt.Sprintf("org.%s.docker.exec", orgIDStr),
 				fmt.Sprintf("org.%s.anticheat.view", orgIDStr),
 				fmt.Sprintf("org.%s.logs.view", orgIDStr),
 			)
@@ -582,9 +583,10 @@ func HandleRevokePermissionByName(c *gin.Context, db *sql.DB) {
 				fmt.Sprintf("org.%s.team.ban", orgIDStr),
 			)
 		} else if subPerm == "docker.view" {
-			// 撤销 docker.view → 级联 docker.delete
+			// 撤销 docker.view → 级联 docker.delete + docker.exec
 			permsToDelete = append(permsToDelete,
 				fmt.Sprintf("org.%s.docker.delete", orgIDStr),
+				fmt.Sprintf("org.%s.docker.exec", orgIDStr),
 			)
 		}
 	}
@@ -11,12 +11,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os/exec"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"tgctf/server/container"
 )
 
 // HandleAdminCommonOrgUsers 查看组织成员
@@ -455,12 +455,13 @@ func HandleAdminCommonOrgDockerInstances(c *gin.Context, db *sql.DB) {
 				ti.contest_id, COALESCE(ct.name, '未知比赛') as contest_name,
 				ti.challenge_id, COALESCE(q.title, cc.inline_title, '未知题目') as challenge_name,
 				COALESCE(ti.created_by, 0), COALESCE(u.display_name, '-') as user_name,
-				COALESCE(ti.ports, '{}') as ports, ti.status, ti.expires_at, ti.created_at
+				COALESCE(ti.ports, '{}') as ports, ti.status, ti.expires_at, ti.created_at,
+				'jeopardy' as source_table
 			FROM team_instances ti
 			LEFT JOIN teams t ON ti.team_id = t.id
 			LEFT JOIN contests ct ON ti.contest_id = ct.id
 			LEFT JOIN contest_challenges cc ON ti.challenge_id = cc.id
-			LEFT JOIN question_bank q ON cc.question_id = q.id
+			LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
 			LEFT JOIN users u ON ti.created_by = u.id
 			WHERE u.organization_id = $1
 			UNION ALL
@@ -470,7 +471,8 @@ func HandleAdminCommonOrgDockerInstances(c *gin.Context, db *sql.DB) {
 				tia.contest_id, COALESCE(ct.name, '未知比赛') as contest_name,
 				tia.challenge_id, COALESCE(qa.title, '未知题目') as challenge_name,
 				COALESCE(tia.created_by, 0), COALESCE(u.display_name, '系统') as user_name,
-				COALESCE(tia.ports, '{}') as ports, tia.status, tia.expires_at, tia.created_at
+				COALESCE(tia.ports, '{}') as ports, tia.status, tia.expires_at, tia.created_at,
+				'awdf' as source_table
 			FROM team_instances_awdf tia
 			LEFT JOIN teams t ON tia.team_id = t.id
 			LEFT JOIN contests ct ON tia.contest_id = ct.id
@@ -512,12 +514,12 @@ func HandleAdminCommonOrgDockerInstances(c *gin.Context, db *sql.DB) {
 
 	for rows.Next() {
 		var id, teamID, contestID, challengeID, createdBy int64
-		var containerID, containerName, teamName, contestName, challengeName, userName, portsJSON, instStatus string
+		var containerID, containerName, teamName, contestName, challengeName, userName, portsJSON, instStatus, source string
 		var expiresAt, createdAt time.Time
 		err := rows.Scan(&id, &containerID, &containerName,
 			&teamID, &teamName, &contestID, &contestName,
 			&challengeID, &challengeName, &createdBy, &userName,
-			&portsJSON, &instStatus, &expiresAt, &createdAt)
+			&portsJSON, &instStatus, &expiresAt, &createdAt, &source)
 		if err != nil {
 			continue
 		}
@@ -540,6 +542,7 @@ func HandleAdminCommonOrgDockerInstances(c *gin.Context, db *sql.DB) {
 			"expiresAt":     expiresAt.Format("2006-01-02 15:04:05"),
 			"createdAt":     createdAt.Format("2006-01-02 15:04:05"),
 			"isExpired":     now.After(expiresAt),
+			"source":        source,
 		})
 	}
 
@@ -565,12 +568,12 @@ func HandleAdminCommonDestroyOrgDockerInstance(c *gin.Context, db *sql.DB) {
 	}
 
 	// 查询实例信息，验证用户属于该组织
-	var containerID string
+	var containerID, backend, node string
 	var createdBy sql.NullInt64
-	err := db.QueryRow(`SELECT ti.container_id, ti.created_by FROM team_instances ti WHERE ti.id = $1`, instanceID).Scan(&containerID, &createdBy)
+	err := db.QueryRow(`SELECT ti.container_id, ti.created_by, COALESCE(ti.backend, ''), COALESCE(ti.node, '') FROM team_instances ti WHERE ti.id = $1`, instanceID).Scan(&containerID, &createdBy, &backend, &node)
 	if err != nil {
 		// 尝试 awdf 表
-		err = db.QueryRow(`SELECT tia.container_id, tia.created_by FROM team_instances_awdf tia WHERE tia.id = $1`, instanceID).Scan(&containerID, &createdBy)
+		err = db.QueryRow(`SELECT tia.container_id, tia.created_by, COALESCE(tia.backend, ''), COALESCE(tia.node, '') FROM team_instances_awdf tia WHERE tia.id = $1`, instanceID).Scan(&containerID, &createdBy, &backend, &node)
 		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "INSTANCE_NOT_FOUND"})
 			return
@@ -594,7 +597,7 @@ func HandleAdminCommonDestroyOrgDockerInstance(c *gin.Context, db *sql.DB) {
 	// 停止并删除容器
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	exec.CommandContext(ctx, "docker", "rm", "-f", containerID).Run()
+	container.For(backend, node).Remove(ctx, containerID)
 
 	// 更新数据库状态（两张表都尝试更新）
 	db.Exec(`UPDATE team_instances SET status = 'destroyed', updated_at = CURRENT_TIMESTAMP WHERE id = $1`, instanceID)
@@ -664,7 +667,7 @@ func HandleAdminCommonOrgAntiCheat(c *gin.Context, db *sql.DB) {
 		       COUNT(DISTINCT s.team_id) as team_count
 		FROM submissions s
 		JOIN contest_challenges cc ON s.challenge_id = cc.id
-		LEFT JOIN question_bank q ON cc.question_id = q.id
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
 		JOIN contests c ON cc.contest_id = c.id
 		JOIN users u2 ON s.user_id = u2.id
 		WHERE s.is_correct = true AND s.ip_address IS NOT NULL AND s.ip_address != ''
@@ -902,7 +905,7 @@ func getOrgSubPermissions(db *sql.DB, userID int64, role string, orgID string, c
 	var subPermSuffixes []string
 	switch category {
 	case "docker":
-		subPermSuffixes = []string{"view", "delete"}
+		subPermSuffixes = []string{"view", "delete", "exec"}
 	case "anticheat", "logs":
 		subPermSuffixes = []string{"view"}
 	default:
@@ -92,7 +92,7 @@ func HandleGetSuspiciousRecords(c *gin.Context, db *sql.DB) {
 		       COUNT(DISTINCT s.team_id) as team_count
 		FROM submissions s
 		JOIN contest_challenges cc ON s.challenge_id = cc.id
-		LEFT JOIN question_bank q ON cc.question_id = q.id
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
 		JOIN contests c ON cc.contest_id = c.id
 		WHERE s.is_correct = true AND s.ip_address IS NOT NULL AND s.ip_address != ''
 	`
@@ -141,7 +141,7 @@ func HandleGetSuspiciousRecords(c *gin.Context, db *sql.DB) {
 		       COUNT(DISTINCT s.team_id) as team_count
 		FROM submissions s
 		JOIN contest_challenges cc ON s.challenge_id = cc.id
-		LEFT JOIN question_bank q ON cc.question_id = q.id
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
 		JOIN contests c ON cc.contest_id = c.id
 		WHERE s.is_correct = false AND s.is_cheating = false
 		  AND LENGTH(s.flag) > 10
@@ -710,7 +710,7 @@ func CheckAndBroadcastSameIPDiffTeam(db *sql.DB, ip string, challengeID, contest
 	db.QueryRow(`
 		SELECT COALESCE(q.title, cc.inline_title, ''), c.name
 		FROM contest_challenges cc
-		LEFT JOIN question_bank q ON cc.question_id = q.id
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
 		JOIN contests c ON cc.contest_id = c.id
 		WHERE cc.id = $1
 	`, challengeID).Scan(&challengeName, &contestName)
@@ -748,7 +748,7 @@ func CheckAndBroadcastSameWrongFlag(db *sql.DB, flag string, challengeID, contes
 	db.QueryRow(`
 		SELECT COALESCE(q.title, cc.inline_title, ''), c.name
 		FROM contest_challenges cc
-		LEFT JOIN question_bank q ON cc.question_id = q.id
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
 		JOIN contests c ON cc.contest_id = c.id
 		WHERE cc.id = $1
 	`, challengeID).Scan(&challengeName, &contestName)
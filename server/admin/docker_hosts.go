@@ -0,0 +1,183 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package admin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"tgctf/server/container"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DockerHostRequest 工作节点创建/更新请求
+type DockerHostRequest struct {
+	Name       string   `json:"name"`
+	Endpoint   string   `json:"endpoint"`
+	PublicHost string   `json:"publicHost"`
+	Capacity   int      `json:"capacity"`
+	Labels     []string `json:"labels"`
+	Status     string   `json:"status"`
+}
+
+// normalize 校验并规范化请求参数
+func (req *DockerHostRequest) normalize() (string, string) {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Endpoint = strings.TrimSpace(req.Endpoint)
+	req.PublicHost = strings.TrimSpace(req.PublicHost)
+	if req.Name == "" {
+		return "NAME_REQUIRED", "节点名称不能为空"
+	}
+	if req.Capacity < 0 {
+		return "INVALID_CAPACITY", "容量不能为负数"
+	}
+	if req.Status == "" {
+		req.Status = container.HostStatusActive
+	}
+	if req.Status != container.HostStatusActive && req.Status != container.HostStatusDraining {
+		return "INVALID_STATUS", "状态只能为 active 或 draining"
+	}
+	labels := []string{}
+	for _, l := range req.Labels {
+		if l = strings.TrimSpace(l); l != "" {
+			labels = append(labels, l)
+		}
+	}
+	req.Labels = labels
+	return "", ""
+}
+
+// HandleListDockerHosts 获取工作节点列表
+func HandleListDockerHosts(c *gin.Context, db *sql.DB) {
+	hosts, err := container.ListHosts(db)
+	if err != nil {
+		log.Printf("list docker hosts error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR"})
+		return
+	}
+	c.JSON(http.StatusOK, hosts)
+}
+
+// HandleCreateDockerHost 添加工作节点
+func HandleCreateDockerHost(c *gin.Context, db *sql.DB) {
+	var req DockerHostRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST"})
+		return
+	}
+	if code, msg := req.normalize(); code != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": code, "message": msg})
+		return
+	}
+
+	labelsJSON, _ := json.Marshal(req.Labels)
+	var id int64
+	err := db.QueryRow(`INSERT INTO docker_hosts (name, endpoint, public_host, capacity, labels, status)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		req.Name, req.Endpoint, req.PublicHost, req.Capacity, string(labelsJSON), req.Status).Scan(&id)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			c.JSON(http.StatusConflict, gin.H{"error": "NAME_EXISTS", "message": "节点名称已存在"})
+			return
+		}
+		log.Printf("create docker host error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR"})
+		return
+	}
+
+	container.LoadHosts(db)
+
+	// 立即检查一次健康状态
+	if h, err := container.GetHost(db, id); err == nil {
+		container.CheckHost(db, h)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": id, "message": "创建成功"})
+}
+
+// HandleUpdateDockerHost 更新工作节点（节点名称不可修改，已有实例记录依赖该名称）
+func HandleUpdateDockerHost(c *gin.Context, db *sql.DB) {
+	hostID := c.Param("id")
+
+	var req DockerHostRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST"})
+		return
+	}
+	var name string
+	if err := db.QueryRow(`SELECT name FROM docker_hosts WHERE id = $1`, hostID).Scan(&name); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "NOT_FOUND"})
+		return
+	}
+	req.Name = name
+	if code, msg := req.normalize(); code != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": code, "message": msg})
+		return
+	}
+
+	labelsJSON, _ := json.Marshal(req.Labels)
+	_, err := db.Exec(`UPDATE docker_hosts SET endpoint = $1, public_host = $2, capacity = $3, labels = $4, status = $5,
+		updated_at = CURRENT_TIMESTAMP WHERE id = $6`,
+		req.Endpoint, req.PublicHost, req.Capacity, string(labelsJSON), req.Status, hostID)
+	if err != nil {
+		log.Printf("update docker host error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR"})
+		return
+	}
+
+	container.LoadHosts(db)
+	c.JSON(http.StatusOK, gin.H{"message": "更新成功"})
+}
+
+// HandleDeleteDockerHost 删除工作节点（节点上仍有运行中实例时拒绝删除，需先排空）
+func HandleDeleteDockerHost(c *gin.Context, db *sql.DB) {
+	hostID := c.Param("id")
+
+	var name string
+	if err := db.QueryRow(`SELECT name FROM docker_hosts WHERE id = $1`, hostID).Scan(&name); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "NOT_FOUND"})
+		return
+	}
+
+	var running int
+	db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM team_instances WHERE node = $1 AND status = 'running') +
+		       (SELECT COUNT(*) FROM team_instances_awdf WHERE node = $1 AND status IN ('running', 'starting'))`,
+		name).Scan(&running)
+	if running > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "HOST_IN_USE", "message": "该节点上仍有运行中的实例，请先设置为排空并等待实例销毁"})
+		return
+	}
+
+	if _, err := db.Exec(`DELETE FROM docker_hosts WHERE id = $1`, hostID); err != nil {
+		log.Printf("delete docker host error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "INTERNAL_ERROR"})
+		return
+	}
+
+	container.LoadHosts(db)
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
+
+// HandleCheckDockerHost 手动检查工作节点健康状态
+func HandleCheckDockerHost(c *gin.Context, db *sql.DB) {
+	hostID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	h, err := container.GetHost(db, hostID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "NOT_FOUND"})
+		return
+	}
+	if err := container.CheckHost(db, h); err != nil {
+		c.JSON(http.StatusOK, gin.H{"healthy": false, "message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"healthy": true, "message": "节点正常"})
+}
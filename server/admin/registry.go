@@ -0,0 +1,172 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package admin
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"tgctf/server/container"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 私有镜像仓库凭证：以 JSON 数组整体加密（AES-256-GCM）后存入 system_settings.registry_credentials
+// 密钥取 SETTINGS_ENCRYPTION_KEY，未配置时使用 JWT_SECRET 派生（更换后需重新录入凭证）
+
+const (
+	registryCredentialsKey = "registry_credentials"
+	encryptedPrefix        = "enc:v1:"
+)
+
+// settingsCipher 由配置的密钥派生 AES-256-GCM
+func settingsCipher() (cipher.AEAD, error) {
+	secret := os.Getenv("SETTINGS_ENCRYPTION_KEY")
+	if secret == "" {
+		secret = os.Getenv("JWT_SECRET")
+	}
+	if secret == "" {
+		return nil, errors.New("未配置 SETTINGS_ENCRYPTION_KEY")
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSetting 加密敏感设置值（随机 nonce 置于密文之前）
+func encryptSetting(plain []byte) (string, error) {
+	gcm, err := settingsCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plain, []byte(registryCredentialsKey))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSetting 解密敏感设置值
+func decryptSetting(value string) ([]byte, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return nil, errors.New("设置值未加密")
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := settingsCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("密文长度错误")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(registryCredentialsKey))
+}
+
+// GetRegistryCredentials 读取并解密私有仓库凭证（未配置或解密失败时返回空）
+func GetRegistryCredentials(db *sql.DB) []container.RegistryAuth {
+	value := GetSystemSetting(db, registryCredentialsKey, "")
+	if value == "" {
+		return nil
+	}
+	plain, err := decryptSetting(value)
+	if err != nil {
+		return nil
+	}
+	var creds []container.RegistryAuth
+	json.Unmarshal(plain, &creds)
+	return creds
+}
+
+// HandleGetRegistries 获取私有仓库列表（不返回密码）
+func HandleGetRegistries(c *gin.Context, db *sql.DB) {
+	value := GetSystemSetting(db, registryCredentialsKey, "")
+	registries := []gin.H{}
+	if value != "" {
+		plain, err := decryptSetting(value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"registries": registries, "error": "DECRYPT_FAILED", "message": "凭证解密失败，密钥可能已更换，请重新录入"})
+			return
+		}
+		var creds []container.RegistryAuth
+		json.Unmarshal(plain, &creds)
+		for _, cred := range creds {
+			registries = append(registries, gin.H{"registry": cred.Registry, "username": cred.Username, "hasPassword": cred.Password != ""})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"registries": registries})
+}
+
+// HandleUpdateRegistries 保存私有仓库列表（整体替换，密码留空表示沿用已保存的密码）
+func HandleUpdateRegistries(c *gin.Context, db *sql.DB) {
+	var req struct {
+		Registries []container.RegistryAuth `json:"registries"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST"})
+		return
+	}
+
+	existing := make(map[string]string)
+	for _, cred := range GetRegistryCredentials(db) {
+		existing[cred.Registry+"|"+cred.Username] = cred.Password
+	}
+
+	creds := []container.RegistryAuth{}
+	seen := make(map[string]bool)
+	for _, cred := range req.Registries {
+		cred.Registry = strings.TrimSpace(cred.Registry)
+		cred.Username = strings.TrimSpace(cred.Username)
+		if cred.Registry == "" || cred.Username == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REGISTRY", "message": "仓库地址和用户名不能为空"})
+			return
+		}
+		if seen[cred.Registry] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "DUPLICATE_REGISTRY", "message": "仓库地址重复: " + cred.Registry})
+			return
+		}
+		seen[cred.Registry] = true
+		if cred.Password == "" {
+			cred.Password = existing[cred.Registry+"|"+cred.Username]
+		}
+		if cred.Password == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "MISSING_PASSWORD", "message": "请填写仓库密码: " + cred.Registry})
+			return
+		}
+		creds = append(creds, cred)
+	}
+
+	plain, _ := json.Marshal(creds)
+	value, err := encryptSetting(plain)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ENCRYPT_FAILED", "message": err.Error()})
+		return
+	}
+	_, err = db.Exec(`
+		INSERT INTO system_settings (key, value, description, updated_at) VALUES ($1, $2, '私有镜像仓库凭证(加密)', CURRENT_TIMESTAMP)
+		ON CONFLICT (key) DO UPDATE SET value = $2, updated_at = CURRENT_TIMESTAMP`,
+		registryCredentialsKey, value)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR", "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "仓库凭证已保存"})
+}
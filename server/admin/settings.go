@@ -5,18 +5,19 @@
 package admin
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
-	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"tgctf/server/container"
 )
 
 // 端口分配全局锁，防止并发分配冲突
@@ -24,12 +25,19 @@ var portAllocMutex sync.Mutex
 
 // SystemSettings Docker容器策略设置
 type SystemSettings struct {
-	ContainerInitialTTL   int  `json:"containerInitialTtl"`   // 初始有效期(分钟)
-	ContainerExtendTTL    int  `json:"containerExtendTtl"`    // 单次续期时长(分钟)
-	ContainerExtendWindow int  `json:"containerExtendWindow"` // 续期窗口(剩余分钟数)
-	AutoDestroyExpired    bool `json:"autoDestroyExpired"`    // 自动销毁过期实例
-	PortRangeStart        int  `json:"portRangeStart"`        // 端口范围起始
-	PortRangeEnd          int  `json:"portRangeEnd"`          // 端口范围结束
+	ContainerInitialTTL   int     `json:"containerInitialTtl"`   // 初始有效期(分钟)
+	ContainerExtendTTL    int     `json:"containerExtendTtl"`    // 单次续期时长(分钟)
+	ContainerExtendWindow int     `json:"containerExtendWindow"` // 续期窗口(剩余分钟数)
+	AutoDestroyExpired    bool    `json:"autoDestroyExpired"`    // 自动销毁过期实例
+	PortRangeStart        int     `json:"portRangeStart"`        // 端口范围起始
+	PortRangeEnd          int     `json:"portRangeEnd"`          // 端口范围结束
+	ContainerMaxRunning   int     `json:"containerMaxRunning"`   // 全局最大运行容器数，0 表示不限
+	ContainerMaxMemory    int     `json:"containerMaxMemory"`    // 全局容器内存总量上限(MB)，0 表示不限
+	ContainerPublishPorts bool    `json:"containerPublishPorts"` // 队伍实例发布宿主机端口，关闭后仅能通过平台代理访问
+	TeamMemoryQuota       int     `json:"teamMemoryQuota"`       // 每队运行中实例内存总量上限(MB)，0 表示不限
+	TeamCPUQuota          float64 `json:"teamCpuQuota"`          // 每队运行中实例 CPU 核数上限，0 表示不限
+	RunawayCPUPercent     int     `json:"runawayCpuPercent"`     // CPU 占用持续达到限制的该百分比时判定失控，0 表示不检测
+	RunawayPids           int     `json:"runawayPids"`           // 进程数持续超过该值时判定失控，0 表示不检测
 }
 
 // HandleGetSystemSettings 获取系统设置
@@ -39,11 +47,13 @@ func HandleGetSystemSettings(c *gin.Context, db *sql.DB) {
 		ContainerExtendTTL:    120,
 		ContainerExtendWindow: 15,
 		AutoDestroyExpired:    true,
+		ContainerPublishPorts: true,
+		RunawayPids:           1000,
 		PortRangeStart:        49152, // 默认端口范围（IANA动态端口）
 		PortRangeEnd:          65535,
 	}
 
-	rows, err := db.Query(`SELECT key, value FROM system_settings WHERE key IN ('container_initial_ttl', 'container_extend_ttl', 'container_extend_window', 'auto_destroy_expired', 'port_range_start', 'port_range_end')`)
+	rows, err := db.Query(`SELECT key, value FROM system_settings WHERE key IN ('container_initial_ttl', 'container_extend_ttl', 'container_extend_window', 'auto_destroy_expired', 'port_range_start', 'port_range_end', 'container_max_running', 'container_max_memory', 'container_publish_ports', 'team_memory_quota', 'team_cpu_quota', 'runaway_cpu_percent', 'runaway_pids')`)
 	if err != nil {
 		c.JSON(http.StatusOK, settings) // 返回默认值
 		return
@@ -78,6 +88,32 @@ func HandleGetSystemSettings(c *gin.Context, db *sql.DB) {
 			if v, err := strconv.Atoi(value); err == nil {
 				settings.PortRangeEnd = v
 			}
+		case "container_max_running":
+			if v, err := strconv.Atoi(value); err == nil {
+				settings.ContainerMaxRunning = v
+			}
+		case "container_max_memory":
+			if v, err := strconv.Atoi(value); err == nil {
+				settings.ContainerMaxMemory = v
+			}
+		case "container_publish_ports":
+			settings.ContainerPublishPorts = value == "true"
+		case "team_memory_quota":
+			if v, err := strconv.Atoi(value); err == nil {
+				settings.TeamMemoryQuota = v
+			}
+		case "team_cpu_quota":
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				settings.TeamCPUQuota = v
+			}
+		case "runaway_cpu_percent":
+			if v, err := strconv.Atoi(value); err == nil {
+				settings.RunawayCPUPercent = v
+			}
+		case "runaway_pids":
+			if v, err := strconv.Atoi(value); err == nil {
+				settings.RunawayPids = v
+			}
 		}
 	}
 
@@ -100,6 +136,13 @@ func HandleUpdateSystemSettings(c *gin.Context, db *sql.DB) {
 		"auto_destroy_expired":    strconv.FormatBool(req.AutoDestroyExpired),
 		"port_range_start":        strconv.Itoa(req.PortRangeStart),
 		"port_range_end":          strconv.Itoa(req.PortRangeEnd),
+		"container_max_running":   strconv.Itoa(req.ContainerMaxRunning),
+		"container_max_memory":    strconv.Itoa(req.ContainerMaxMemory),
+		"container_publish_ports": strconv.FormatBool(req.ContainerPublishPorts),
+		"team_memory_quota":       strconv.Itoa(req.TeamMemoryQuota),
+		"team_cpu_quota":          strconv.FormatFloat(req.TeamCPUQuota, 'f', -1, 64),
+		"runaway_cpu_percent":     strconv.Itoa(req.RunawayCPUPercent),
+		"runaway_pids":            strconv.Itoa(req.RunawayPids),
 	}
 
 	for key, value := range updates {
@@ -153,6 +196,47 @@ func GetContainerExtendWindow(db *sql.DB) int {
 	return 15
 }
 
+// GetContainerMaxRunning 获取全局最大运行容器数（0 表示不限）
+func GetContainerMaxRunning(db *sql.DB) int {
+	v, _ := strconv.Atoi(GetSystemSetting(db, "container_max_running", "0"))
+	return v
+}
+
+// GetContainerMaxMemory 获取全局容器内存总量上限（MB，0 表示不限）
+func GetContainerMaxMemory(db *sql.DB) int {
+	v, _ := strconv.Atoi(GetSystemSetting(db, "container_max_memory", "0"))
+	return v
+}
+
+// GetTeamMemoryQuota 获取每队运行中实例内存总量上限（MB，0 表示不限）
+func GetTeamMemoryQuota(db *sql.DB) int {
+	v, _ := strconv.Atoi(GetSystemSetting(db, "team_memory_quota", "0"))
+	return v
+}
+
+// GetTeamCPUQuota 获取每队运行中实例 CPU 核数上限（0 表示不限）
+func GetTeamCPUQuota(db *sql.DB) float64 {
+	v, _ := strconv.ParseFloat(GetSystemSetting(db, "team_cpu_quota", "0"), 64)
+	return v
+}
+
+// GetRunawayCPUPercent 获取失控判定的 CPU 占用百分比（相对实例 CPU 限制，0 表示不检测）
+func GetRunawayCPUPercent(db *sql.DB) int {
+	v, _ := strconv.Atoi(GetSystemSetting(db, "runaway_cpu_percent", "0"))
+	return v
+}
+
+// GetRunawayPids 获取失控判定的进程数上限（0 表示不检测）
+func GetRunawayPids(db *sql.DB) int {
+	v, _ := strconv.Atoi(GetSystemSetting(db, "runaway_pids", "1000"))
+	return v
+}
+
+// IsContainerPublishPortsEnabled 队伍实例是否发布宿主机端口
+func IsContainerPublishPortsEnabled(db *sql.DB) bool {
+	return GetSystemSetting(db, "container_publish_ports", "true") == "true"
+}
+
 // IsAutoDestroyExpiredEnabled 检查是否启用自动销毁
 func IsAutoDestroyExpiredEnabled(db *sql.DB) bool {
 	value := GetSystemSetting(db, "auto_destroy_expired", "true")
@@ -222,6 +306,17 @@ func isPortAvailable(port int) bool {
 
 // AllocatePorts 批量分配端口（加锁防止并发冲突）
 func AllocatePorts(db *sql.DB, count int) ([]int, error) {
+	return allocatePorts(db, "", false, count)
+}
+
+// AllocatePortsOnNode 在指定工作节点上分配端口
+// 只排除该节点上已占用的端口（预分配端口在所有节点上保留），远程节点不做本机端口检测
+func AllocatePortsOnNode(db *sql.DB, node string, count int) ([]int, error) {
+	return allocatePorts(db, node, true, count)
+}
+
+// allocatePorts 端口分配实现，perNode 为 false 时排除所有节点上已占用的端口
+func allocatePorts(db *sql.DB, node string, perNode bool, count int) ([]int, error) {
 	portAllocMutex.Lock()
 	defer portAllocMutex.Unlock()
 	
@@ -229,9 +324,19 @@ func AllocatePorts(db *sql.DB, count int) ([]int, error) {
 	
 	// 查询所有正在使用的端口（平台管理的容器）
 	usedPorts := make(map[int]bool)
+
+	// 按节点过滤
+	nodeFilter := ""
+	args := []interface{}{}
+	if perNode {
+		nodeFilter = " AND COALESCE(node, '') = $1"
+		args = append(args, node)
+	}
+	checkLocal := !perNode || container.IsLocalNode(node)
 	
-	// 查询 Jeopardy 模式容器端口
-	rows, err := db.Query(`SELECT ports FROM team_instances WHERE status = 'running' AND ports IS NOT NULL AND ports != ''`)
+	// 查询 Jeopardy 模式容器端口（包含预热池容器）
+	rows, err := db.Query(`SELECT ports FROM team_instances WHERE status = 'running' AND ports IS NOT NULL AND ports != ''`+nodeFilter+`
+		UNION ALL SELECT ports FROM warm_pool_containers WHERE ports IS NOT NULL AND ports != ''`+nodeFilter, args...)
 	if err == nil {
 		defer rows.Close()
 		for rows.Next() {
@@ -250,7 +355,7 @@ func AllocatePorts(db *sql.DB, count int) ([]int, error) {
 	}
 	
 	// 查询 AWD-F 模式容器端口（包含 running 和 starting 状态）
-	rowsAWDF, err := db.Query(`SELECT ports FROM team_instances_awdf WHERE status IN ('running', 'starting') AND ports IS NOT NULL AND ports != ''`)
+	rowsAWDF, err := db.Query(`SELECT ports FROM team_instances_awdf WHERE status IN ('running', 'starting') AND ports IS NOT NULL AND ports != ''`+nodeFilter, args...)
 	if err == nil {
 		defer rowsAWDF.Close()
 		for rowsAWDF.Next() {
@@ -296,8 +401,8 @@ func AllocatePorts(db *sql.DB, count int) ([]int, error) {
 		if usedPorts[port] {
 			continue
 		}
-		// 检测系统是否占用该端口
-		if !isPortAvailable(port) {
+		// 检测系统是否占用该端口（仅本机）
+		if checkLocal && !isPortAvailable(port) {
 			continue
 		}
 		allocated = append(allocated, port)
@@ -319,7 +424,7 @@ func CleanupExpiredInstances(db *sql.DB) {
 
 	// 查询所有过期且还在运行的实例
 	rows, err := db.Query(`
-		SELECT id, container_id, team_id, challenge_id 
+		SELECT id, container_id, team_id, challenge_id, COALESCE(backend, ''), COALESCE(node, '')
 		FROM team_instances 
 		WHERE status = 'running' AND expires_at < CURRENT_TIMESTAMP`)
 	if err != nil {
@@ -331,13 +436,15 @@ func CleanupExpiredInstances(db *sql.DB) {
 		var id int64
 		var containerID string
 		var teamID int64
-		var challengeID string
-		if err := rows.Scan(&id, &containerID, &teamID, &challengeID); err != nil {
+		var challengeID, backend, node string
+		if err := rows.Scan(&id, &containerID, &teamID, &challengeID, &backend, &node); err != nil {
 			continue
 		}
 
 		// 销毁容器
-		exec.Command("docker", "rm", "-f", containerID).Run()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		container.For(backend, node).Remove(ctx, containerID)
+		cancel()
 
 		// 更新数据库状态
 		db.Exec(`UPDATE team_instances SET status = 'expired', updated_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
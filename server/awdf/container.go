@@ -12,13 +12,13 @@ import (
 	"fmt"
 	"log"
 	"math/big"
-	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"tgctf/server/container"
 )
 
 // AWDFContainerManager AWD-F 容器管理器
@@ -34,6 +34,9 @@ var AddContainerEventFunc func(db *sql.DB, contestID string, eventType string, t
 // 端口分配函数（从 docker 包引入）
 var AllocatePortsFunc func(db *sql.DB, count int) ([]int, error)
 
+// 按工作节点分配端口的函数（由 main.go 注入）
+var AllocateNodePortsFunc func(db *sql.DB, node string, count int) ([]int, error)
+
 // 容器 TTL 获取函数
 var GetContainerTTLFunc func(db *sql.DB) int
 
@@ -224,7 +227,8 @@ func StartAllContainersForContest(db *sql.DB, contestID int64, callback Progress
 
 	// 获取所有公开的 AWD-F 题目
 	challengeRows, err := db.Query(`
-		SELECT cc.id, q.title, q.docker_image, q.ports, q.cpu_limit, q.memory_limit, q.flag_env, q.flag_script
+		SELECT cc.id, q.title, q.docker_image, q.ports, q.cpu_limit, q.memory_limit, q.flag_env, q.flag_script, q.network_policy,
+			q.storage_limit, COALESCE(q.no_resource_limit, false), COALESCE(q.read_only_rootfs, false), COALESCE(q.allow_setuid, false)
 		FROM contest_challenges_awdf cc
 		JOIN question_bank_awdf q ON cc.question_id = q.id
 		WHERE cc.contest_id = $1 AND cc.status = 'public' AND q.docker_image IS NOT NULL AND q.docker_image != ''
@@ -235,19 +239,25 @@ func StartAllContainersForContest(db *sql.DB, contestID int64, callback Progress
 	}
 
 	type ChallengeInfo struct {
-		ID          int64
-		Title       string
-		DockerImage string
-		Ports       sql.NullString
-		CPULimit    sql.NullString
-		MemoryLimit sql.NullString
-		FlagEnv     sql.NullString
-		FlagScript  sql.NullString
+		ID              int64
+		Title           string
+		DockerImage     string
+		Ports           sql.NullString
+		CPULimit        sql.NullString
+		MemoryLimit     sql.NullString
+		FlagEnv         sql.NullString
+		FlagScript      sql.NullString
+		Network         sql.NullString // 出网策略
+		StorageLimit    sql.NullString // 磁盘配额
+		NoResourceLimit bool           // 不限制 CPU / 内存 / 磁盘 / 进程数
+		ReadOnly        bool           // 只读根文件系统
+		AllowSetuid     bool           // 允许容器内提权
 	}
 	var challenges []ChallengeInfo
 	for challengeRows.Next() {
 		var ch ChallengeInfo
-		challengeRows.Scan(&ch.ID, &ch.Title, &ch.DockerImage, &ch.Ports, &ch.CPULimit, &ch.MemoryLimit, &ch.FlagEnv, &ch.FlagScript)
+		challengeRows.Scan(&ch.ID, &ch.Title, &ch.DockerImage, &ch.Ports, &ch.CPULimit, &ch.MemoryLimit, &ch.FlagEnv, &ch.FlagScript, &ch.Network,
+		&ch.StorageLimit, &ch.NoResourceLimit, &ch.ReadOnly, &ch.AllowSetuid)
 		challenges = append(challenges, ch)
 	}
 	challengeRows.Close()
@@ -295,7 +305,7 @@ func StartAllContainersForContest(db *sql.DB, contestID int64, callback Progress
 			flag := GetOrCreateAWDFFlag(db, team.ID, contestID, ch.ID)
 
 			// 创建容器（使用比赛结束时间作为过期时间）
-			containerID, ports, err := createAWDFContainerWithEndTime(db, team.ID, contestID, ch.ID, ch, flag, contestEndTime)
+			containerID, ports, err := createAWDFContainerWithEndTime(db, team.ID, contestID, ch.ID, ch, flag, contestEndTime, "")
 			if err != nil {
 				log.Printf("[AWD-F] 队伍 %s 题目 %s 容器创建失败: %v", team.Name, ch.Title, err)
 				if callback != nil {
@@ -332,21 +342,28 @@ func StartContainersForChallenge(db *sql.DB, contestID, challengeID int64) error
 
 	// 获取题目配置
 	var ch struct {
-		ID          int64
-		Title       string
-		DockerImage string
-		Ports       sql.NullString
-		CPULimit    sql.NullString
-		MemoryLimit sql.NullString
-		FlagEnv     sql.NullString
-		FlagScript  sql.NullString
+		ID              int64
+		Title           string
+		DockerImage     string
+		Ports           sql.NullString
+		CPULimit        sql.NullString
+		MemoryLimit     sql.NullString
+		FlagEnv         sql.NullString
+		FlagScript      sql.NullString
+		Network         sql.NullString // 出网策略
+		StorageLimit    sql.NullString // 磁盘配额
+		NoResourceLimit bool           // 不限制 CPU / 内存 / 磁盘 / 进程数
+		ReadOnly        bool           // 只读根文件系统
+		AllowSetuid     bool           // 允许容器内提权
 	}
 	err = db.QueryRow(`
-		SELECT cc.id, q.title, q.docker_image, q.ports, q.cpu_limit, q.memory_limit, q.flag_env, q.flag_script
+		SELECT cc.id, q.title, q.docker_image, q.ports, q.cpu_limit, q.memory_limit, q.flag_env, q.flag_script, q.network_policy,
+			q.storage_limit, COALESCE(q.no_resource_limit, false), COALESCE(q.read_only_rootfs, false), COALESCE(q.allow_setuid, false)
 		FROM contest_challenges_awdf cc
 		JOIN question_bank_awdf q ON cc.question_id = q.id
 		WHERE cc.id = $1 AND q.docker_image IS NOT NULL AND q.docker_image != ''
-	`, challengeID).Scan(&ch.ID, &ch.Title, &ch.DockerImage, &ch.Ports, &ch.CPULimit, &ch.MemoryLimit, &ch.FlagEnv, &ch.FlagScript)
+	`, challengeID).Scan(&ch.ID, &ch.Title, &ch.DockerImage, &ch.Ports, &ch.CPULimit, &ch.MemoryLimit, &ch.FlagEnv, &ch.FlagScript, &ch.Network,
+		&ch.StorageLimit, &ch.NoResourceLimit, &ch.ReadOnly, &ch.AllowSetuid)
 	if err != nil {
 		log.Printf("[AWD-F] 题目 %d 没有配置Docker镜像，跳过容器创建", challengeID)
 		return nil
@@ -407,7 +424,7 @@ func StartContainersForChallenge(db *sql.DB, contestID, challengeID int64) error
 		flag := GetOrCreateAWDFFlag(db, team.ID, contestID, challengeID)
 
 		// 创建容器
-		containerID, _, err := createAWDFContainerWithEndTime(db, team.ID, contestID, challengeID, ch, flag, contestEndTime)
+		containerID, _, err := createAWDFContainerWithEndTime(db, team.ID, contestID, challengeID, ch, flag, contestEndTime, "")
 		if err != nil {
 			log.Printf("[AWD-F] 队伍 %s 题目 %s 容器创建失败: %v", team.Name, ch.Title, err)
 			continue
@@ -427,7 +444,7 @@ func StopContainersForChallenge(db *sql.DB, contestID, challengeID int64) error
 
 	// 获取该题目所有运行中的容器
 	rows, err := db.Query(`
-		SELECT id, container_id, container_name 
+		SELECT id, container_id, container_name, COALESCE(backend, ''), COALESCE(node, '')
 		FROM team_instances_awdf 
 		WHERE contest_id = $1 AND challenge_id = $2 AND status = 'running'
 	`, contestID, challengeID)
@@ -439,12 +456,12 @@ func StopContainersForChallenge(db *sql.DB, contestID, challengeID int64) error
 	var count int
 	for rows.Next() {
 		var instanceID int64
-		var containerID, containerName string
-		rows.Scan(&instanceID, &containerID, &containerName)
+		var containerID, containerName, backend, node string
+		rows.Scan(&instanceID, &containerID, &containerName, &backend, &node)
 
 		// 销毁容器
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		exec.CommandContext(ctx, "docker", "rm", "-f", containerID).Run()
+		container.For(backend, node).Remove(ctx, containerID)
 		cancel()
 
 		// 更新数据库状态
@@ -462,7 +479,7 @@ func StopAllContainersForContest(db *sql.DB, contestID int64) error {
 
 	// 获取所有运行中的容器
 	rows, err := db.Query(`
-		SELECT id, container_id, container_name 
+		SELECT id, container_id, container_name, COALESCE(backend, ''), COALESCE(node, '')
 		FROM team_instances_awdf 
 		WHERE contest_id = $1 AND status = 'running'
 	`, contestID)
@@ -474,12 +491,12 @@ func StopAllContainersForContest(db *sql.DB, contestID int64) error {
 	var count int
 	for rows.Next() {
 		var instanceID int64
-		var containerID, containerName string
-		rows.Scan(&instanceID, &containerID, &containerName)
+		var containerID, containerName, backend, node string
+		rows.Scan(&instanceID, &containerID, &containerName, &backend, &node)
 
 		// 销毁容器
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		exec.CommandContext(ctx, "docker", "rm", "-f", containerID).Run()
+		container.For(backend, node).Remove(ctx, containerID)
 		cancel()
 
 		// 更新数据库状态
@@ -532,35 +549,57 @@ func generateUUID() string {
 		uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
 }
 
-// createAWDFContainer 创建单个 AWD-F 容器
-func createAWDFContainer(db *sql.DB, teamID, contestID, challengeID int64, ch struct {
-	ID          int64
-	Title       string
-	DockerImage string
-	Ports       sql.NullString
-	CPULimit    sql.NullString
-	MemoryLimit sql.NullString
-	FlagEnv     sql.NullString
-	FlagScript  sql.NullString
-}, flag string, ttlSeconds int) (string, map[string]string, error) {
+// awdfChallengeConfig AWD-F 题目容器配置
+type awdfChallengeConfig = struct {
+	ID              int64
+	Title           string
+	DockerImage     string
+	Ports           sql.NullString
+	CPULimit        sql.NullString
+	MemoryLimit     sql.NullString
+	FlagEnv         sql.NullString
+	FlagScript      sql.NullString
+	Network         sql.NullString // 出网策略
+	StorageLimit    sql.NullString // 磁盘配额
+	NoResourceLimit bool           // 不限制 CPU / 内存 / 磁盘 / 进程数
+	ReadOnly        bool           // 只读根文件系统
+	AllowSetuid     bool           // 允许容器内提权
+}
 
+// buildAWDFSpec 构造 AWD-F 容器参数（优先使用队伍预分配端口）
+func buildAWDFSpec(db *sql.DB, teamID, contestID, challengeID int64, ch awdfChallengeConfig, flag, node string) (*container.Spec, error) {
 	// 解析端口列表
 	var portList []string
 	if ch.Ports.Valid && ch.Ports.String != "" {
 		json.Unmarshal([]byte(ch.Ports.String), &portList)
 	}
 
-	containerName := fmt.Sprintf("tg_team_%d_%d_%d", teamID, challengeID, time.Now().Unix())
-	args := []string{"run", "-d", "--name", containerName}
+	spec := &container.Spec{
+		Name:        fmt.Sprintf("tg_team_%d_%d_%d", teamID, challengeID, time.Now().Unix()),
+		Image:       ch.DockerImage,
+		Ports:       portList,
+		CPULimit:    ch.CPULimit.String,
+		MemoryLimit: ch.MemoryLimit.String,
+		Network:     ch.Network.String,
+		ReadOnly:    ch.ReadOnly,
+		AllowSetuid: ch.AllowSetuid,
+		Labels: map[string]string{
+			"tg.type":         "awdf",
+			"tg.team_id":      strconv.FormatInt(teamID, 10),
+			"tg.challenge_id": strconv.FormatInt(challengeID, 10),
+			"tg.contest_id":   strconv.FormatInt(contestID, 10),
+		},
+	}
+	container.ApplyLimits(spec, ch.StorageLimit.String, ch.NoResourceLimit)
 
 	// 分配端口（优先使用预分配端口）
-	portInfo := make(map[string]string)
 	if len(portList) > 0 {
 		// 查询队伍的预分配端口
 		var teamPorts []int
 		var portsArray []byte
 		err := db.QueryRow(`SELECT allocated_ports FROM contest_teams WHERE contest_id = $1 AND team_id = $2`, contestID, teamID).Scan(&portsArray)
 		if err == nil && len(portsArray) > 2 {
+			// 解析 PostgreSQL 数组格式: {1,2,3}
 			portsStr := string(portsArray[1 : len(portsArray)-1])
 			if portsStr != "" {
 				parts := strings.Split(portsStr, ",")
@@ -573,306 +612,114 @@ func createAWDFContainer(db *sql.DB, teamID, contestID, challengeID int64, ch st
 		}
 
 		if len(teamPorts) >= len(portList) {
+			// 使用预分配端口
 			log.Printf("[AWD-F] 队伍 %d 使用预分配端口: %v", teamID, teamPorts[:len(portList)])
-			for i, containerPort := range portList {
-				hostPort := teamPorts[i]
-				args = append(args, "-p", fmt.Sprintf("%d:%s", hostPort, containerPort))
-				portInfo[containerPort] = strconv.Itoa(hostPort)
-			}
-		} else if AllocatePortsFunc != nil {
+			spec.HostPorts = teamPorts[:len(portList)]
+		} else if AllocateNodePortsFunc != nil {
+			// Fallback: 在容器所在节点动态分配端口
 			log.Printf("[AWD-F] 队伍 %d 预分配端口不足，动态分配", teamID)
-			allocatedPorts, err := AllocatePortsFunc(db, len(portList))
+			allocatedPorts, err := AllocateNodePortsFunc(db, node, len(portList))
 			if err != nil {
-				return "", nil, fmt.Errorf("端口分配失败: %v", err)
-			}
-			for i, containerPort := range portList {
-				hostPort := allocatedPorts[i]
-				args = append(args, "-p", fmt.Sprintf("%d:%s", hostPort, containerPort))
-				portInfo[containerPort] = strconv.Itoa(hostPort)
-			}
-		} else {
-			for _, port := range portList {
-				args = append(args, "-p", fmt.Sprintf(":%s", port))
+				return nil, fmt.Errorf("端口分配失败: %v", err)
 			}
+			spec.HostPorts = allocatedPorts
 		}
-	}
-
-	// 资源限制
-	if ch.CPULimit.Valid && ch.CPULimit.String != "" {
-		args = append(args, "--cpus", ch.CPULimit.String)
-	}
-	if ch.MemoryLimit.Valid && ch.MemoryLimit.String != "" {
-		args = append(args, "-m", ch.MemoryLimit.String)
+		// 否则由容器后端自动分配
 	}
 
 	// Flag 注入
-	useCmdArg := false
-	if ch.FlagEnv.Valid && ch.FlagEnv.String != "" {
-		envNames := strings.Split(ch.FlagEnv.String, ",")
-		for _, en := range envNames {
-			en = strings.TrimSpace(en)
-			if en == "CMDARG" || en == "$1" {
-				useCmdArg = true
-			} else if en != "" {
-				args = append(args, "-e", fmt.Sprintf("%s=%s", en, flag))
-			}
-		}
-	} else {
-		args = append(args, "-e", fmt.Sprintf("FLAG=%s", flag))
-	}
-
-	// 标签
-	args = append(args, "--label", "tg.type=awdf")
-	args = append(args, "--label", fmt.Sprintf("tg.team_id=%d", teamID))
-	args = append(args, "--label", fmt.Sprintf("tg.challenge_id=%d", challengeID))
-	args = append(args, "--label", fmt.Sprintf("tg.contest_id=%d", contestID))
+	spec.Env, spec.Args = container.FlagEnv(ch.FlagEnv.String, flag)
+	return spec, nil
+}
 
-	args = append(args, ch.DockerImage)
+// runAWDFContainer 选择工作节点，启动容器并执行 Flag 注入脚本
+func runAWDFContainer(db *sql.DB, teamID, contestID, challengeID int64, ch awdfChallengeConfig, flag string, extraEnv ...string) (container.Runtime, *container.Result, error) {
+	rt, node, err := container.Place(db, "awdf")
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// 命令行参数传递 Flag
-	if useCmdArg {
-		args = append(args, flag)
+	spec, err := buildAWDFSpec(db, teamID, contestID, challengeID, ch, flag, node)
+	if err != nil {
+		return nil, nil, err
 	}
+	spec.Env = append(spec.Env, extraEnv...)
 
-	// 执行 Docker 命令
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "docker", args...)
-	output, err := cmd.CombinedOutput()
+	result, err := rt.Run(ctx, spec)
 	if err != nil {
-		return "", nil, fmt.Errorf("docker run 失败: %v, output: %s", err, string(output))
-	}
-
-	outputLines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	containerID := strings.TrimSpace(outputLines[len(outputLines)-1])
-	if len(containerID) > 12 {
-		containerID = containerID[:12]
-	}
-
-	// 如果使用 Docker 自动分配端口，查询端口映射
-	if len(portInfo) == 0 && len(portList) > 0 {
-		time.Sleep(500 * time.Millisecond)
-		portCmd := exec.CommandContext(ctx, "docker", "port", containerID)
-		portOutput, _ := portCmd.CombinedOutput()
-		lines := strings.Split(string(portOutput), "\n")
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if line == "" || strings.Contains(line, "[::") {
-				continue
-			}
-			parts := strings.Split(line, " -> ")
-			if len(parts) == 2 {
-				containerPort := strings.Split(parts[0], "/")[0]
-				hostAddr := parts[1]
-				if idx := strings.LastIndex(hostAddr, ":"); idx != -1 {
-					portInfo[containerPort] = hostAddr[idx+1:]
-				}
-			}
-		}
+		return nil, nil, err
 	}
 
 	// 执行 Flag 注入脚本
 	if ch.FlagScript.Valid && ch.FlagScript.String != "" {
 		time.Sleep(500 * time.Millisecond)
-		scriptCmd := exec.CommandContext(ctx, "docker", "exec", containerID, "sh", ch.FlagScript.String, flag)
-		scriptCmd.CombinedOutput() // 忽略错误，不阻塞
+		rt.Exec(ctx, result.ID, "sh", ch.FlagScript.String, flag) // 忽略错误，不阻塞
+	}
+	return rt, result, nil
+}
+
+// createAWDFContainer 创建单个 AWD-F 容器
+func createAWDFContainer(db *sql.DB, teamID, contestID, challengeID int64, ch awdfChallengeConfig, flag string, ttlSeconds int) (string, map[string]string, error) {
+	rt, result, err := runAWDFContainer(db, teamID, contestID, challengeID, ch, flag)
+	if err != nil {
+		return "", nil, err
 	}
 
 	// 保存到数据库
-	portsJSON, _ := json.Marshal(portInfo)
+	portsJSON, _ := json.Marshal(result.Ports)
 	expiresAt := time.Now().Add(time.Duration(ttlSeconds) * time.Second)
 
 	_, err = db.Exec(`
-		INSERT INTO team_instances_awdf (team_id, contest_id, challenge_id, container_id, container_name, ports, status, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6, 'running', $7)
+		INSERT INTO team_instances_awdf (team_id, contest_id, challenge_id, container_id, container_name, ports, status, expires_at, backend, node)
+		VALUES ($1, $2, $3, $4, $5, $6, 'running', $7, $8, $9)
 		ON CONFLICT (team_id, challenge_id) DO UPDATE SET
-			container_id = $4, container_name = $5, ports = $6, status = 'running', expires_at = $7, updated_at = NOW()
-	`, teamID, contestID, challengeID, containerID, containerName, string(portsJSON), expiresAt)
+			container_id = $4, container_name = $5, ports = $6, status = 'running', expires_at = $7, backend = $8, node = $9, updated_at = NOW()
+	`, teamID, contestID, challengeID, result.ID, result.Name, string(portsJSON), expiresAt, rt.Name(), result.Node)
 
 	if err != nil {
 		// 创建失败，清理容器
-		exec.Command("docker", "rm", "-f", containerID).Run()
+		rt.Remove(context.Background(), result.ID)
 		return "", nil, fmt.Errorf("保存数据库失败: %v", err)
 	}
 
-	return containerID, portInfo, nil
+	return result.ID, result.Ports, nil
 }
 
-// createAWDFContainerWithEndTime 创建 AWD-F 容器（使用比赛结束时间作为过期时间）
-func createAWDFContainerWithEndTime(db *sql.DB, teamID, contestID, challengeID int64, ch struct {
-	ID          int64
-	Title       string
-	DockerImage string
-	Ports       sql.NullString
-	CPULimit    sql.NullString
-	MemoryLimit sql.NullString
-	FlagEnv     sql.NullString
-	FlagScript  sql.NullString
-}, flag string, expiresAt time.Time) (string, map[string]string, error) {
+// createAWDFContainerWithEndTime 创建 AWD-F 容器（使用比赛结束时间作为过期时间），sshPassword 为空时生成新密码
+func createAWDFContainerWithEndTime(db *sql.DB, teamID, contestID, challengeID int64, ch awdfChallengeConfig, flag string, expiresAt time.Time, sshPassword string) (string, map[string]string, error) {
 
 	// 生成 SSH 密码
-	sshPassword := generateSSHPassword()
-
-	// 解析端口列表
-	var portList []string
-	if ch.Ports.Valid && ch.Ports.String != "" {
-		json.Unmarshal([]byte(ch.Ports.String), &portList)
+	if sshPassword == "" {
+		sshPassword = generateSSHPassword()
 	}
 
-	containerName := fmt.Sprintf("tg_team_%d_%d_%d", teamID, challengeID, time.Now().Unix())
-	args := []string{"run", "-d", "--name", containerName}
-
-	// 分配端口（优先使用预分配端口）
-	portInfo := make(map[string]string)
-	if len(portList) > 0 {
-		// 查询队伍的预分配端口
-		var teamPorts []int
-		var portsArray []byte
-		err := db.QueryRow(`SELECT allocated_ports FROM contest_teams WHERE contest_id = $1 AND team_id = $2`, contestID, teamID).Scan(&portsArray)
-		if err == nil && len(portsArray) > 2 {
-			// 解析 PostgreSQL 数组格式: {1,2,3}
-			portsStr := string(portsArray[1 : len(portsArray)-1])
-			if portsStr != "" {
-				parts := strings.Split(portsStr, ",")
-				for _, p := range parts {
-					if port, err := strconv.Atoi(strings.TrimSpace(p)); err == nil {
-						teamPorts = append(teamPorts, port)
-					}
-				}
-			}
-		}
-
-		if len(teamPorts) >= len(portList) {
-			// 使用预分配端口
-			log.Printf("[AWD-F] 队伍 %d 使用预分配端口: %v", teamID, teamPorts[:len(portList)])
-			for i, containerPort := range portList {
-				hostPort := teamPorts[i]
-				args = append(args, "-p", fmt.Sprintf("%d:%s", hostPort, containerPort))
-				portInfo[containerPort] = strconv.Itoa(hostPort)
-			}
-		} else if AllocatePortsFunc != nil {
-			// Fallback: 动态分配端口
-			log.Printf("[AWD-F] 队伍 %d 预分配端口不足，动态分配", teamID)
-			allocatedPorts, err := AllocatePortsFunc(db, len(portList))
-			if err != nil {
-				return "", nil, fmt.Errorf("端口分配失败: %v", err)
-			}
-			for i, containerPort := range portList {
-				hostPort := allocatedPorts[i]
-				args = append(args, "-p", fmt.Sprintf("%d:%s", hostPort, containerPort))
-				portInfo[containerPort] = strconv.Itoa(hostPort)
-			}
-		} else {
-			// 使用 Docker 自动分配
-			for _, port := range portList {
-				args = append(args, "-p", fmt.Sprintf(":%s", port))
-			}
-		}
-	}
-
-	// 资源限制
-	if ch.CPULimit.Valid && ch.CPULimit.String != "" {
-		args = append(args, "--cpus", ch.CPULimit.String)
-	}
-	if ch.MemoryLimit.Valid && ch.MemoryLimit.String != "" {
-		args = append(args, "-m", ch.MemoryLimit.String)
-	}
-
-	// Flag 注入
-	useCmdArg := false
-	if ch.FlagEnv.Valid && ch.FlagEnv.String != "" {
-		envNames := strings.Split(ch.FlagEnv.String, ",")
-		for _, en := range envNames {
-			en = strings.TrimSpace(en)
-			if en == "CMDARG" || en == "$1" {
-				useCmdArg = true
-			} else if en != "" {
-				args = append(args, "-e", fmt.Sprintf("%s=%s", en, flag))
-			}
-		}
-	} else {
-		args = append(args, "-e", fmt.Sprintf("FLAG=%s", flag))
-	}
-
-	// SSH 密码注入
-	args = append(args, "-e", fmt.Sprintf("SSH_PASSWORD=%s", sshPassword))
-
-	// 标签
-	args = append(args, "--label", "tg.type=awdf")
-	args = append(args, "--label", fmt.Sprintf("tg.team_id=%d", teamID))
-	args = append(args, "--label", fmt.Sprintf("tg.challenge_id=%d", challengeID))
-	args = append(args, "--label", fmt.Sprintf("tg.contest_id=%d", contestID))
-
-	args = append(args, ch.DockerImage)
-
-	// 命令行参数传递 Flag
-	if useCmdArg {
-		args = append(args, flag)
-	}
-
-	// 执行 Docker 命令
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "docker", args...)
-	output, err := cmd.CombinedOutput()
+	// SSH 密码通过环境变量注入
+	rt, result, err := runAWDFContainer(db, teamID, contestID, challengeID, ch, flag, fmt.Sprintf("SSH_PASSWORD=%s", sshPassword))
 	if err != nil {
-		return "", nil, fmt.Errorf("docker run 失败: %v, output: %s", err, string(output))
-	}
-
-	outputLines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	containerID := strings.TrimSpace(outputLines[len(outputLines)-1])
-	if len(containerID) > 12 {
-		containerID = containerID[:12]
-	}
-
-	// 如果使用 Docker 自动分配端口，查询端口映射
-	if len(portInfo) == 0 && len(portList) > 0 {
-		time.Sleep(500 * time.Millisecond)
-		portCmd := exec.CommandContext(ctx, "docker", "port", containerID)
-		portOutput, _ := portCmd.CombinedOutput()
-		lines := strings.Split(string(portOutput), "\n")
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if line == "" || strings.Contains(line, "[::") {
-				continue
-			}
-			parts := strings.Split(line, " -> ")
-			if len(parts) == 2 {
-				containerPort := strings.Split(parts[0], "/")[0]
-				hostAddr := parts[1]
-				if idx := strings.LastIndex(hostAddr, ":"); idx != -1 {
-					portInfo[containerPort] = hostAddr[idx+1:]
-				}
-			}
-		}
-	}
-
-	// 执行 Flag 注入脚本
-	if ch.FlagScript.Valid && ch.FlagScript.String != "" {
-		time.Sleep(500 * time.Millisecond)
-		scriptCmd := exec.CommandContext(ctx, "docker", "exec", containerID, "sh", ch.FlagScript.String, flag)
-		scriptCmd.CombinedOutput() // 忽略错误，不阻塞
+		return "", nil, err
 	}
 
 	// 保存到数据库（包含 SSH 密码）
-	portsJSON, _ := json.Marshal(portInfo)
+	portsJSON, _ := json.Marshal(result.Ports)
 
 	_, err = db.Exec(`
-		INSERT INTO team_instances_awdf (team_id, contest_id, challenge_id, container_id, container_name, ports, ssh_password, status, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, 'running', $8)
+		INSERT INTO team_instances_awdf (team_id, contest_id, challenge_id, container_id, container_name, ports, ssh_password, status, expires_at, backend, node)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 'running', $8, $9, $10)
 		ON CONFLICT (team_id, challenge_id) DO UPDATE SET
-			container_id = $4, container_name = $5, ports = $6, ssh_password = $7, status = 'running', expires_at = $8, updated_at = NOW()
-	`, teamID, contestID, challengeID, containerID, containerName, string(portsJSON), sshPassword, expiresAt)
+			container_id = $4, container_name = $5, ports = $6, ssh_password = $7, status = 'running', expires_at = $8,
+			backend = $9, node = $10, updated_at = NOW()
+	`, teamID, contestID, challengeID, result.ID, result.Name, string(portsJSON), sshPassword, expiresAt, rt.Name(), result.Node)
 
 	if err != nil {
 		// 创建失败，清理容器
-		exec.Command("docker", "rm", "-f", containerID).Run()
+		rt.Remove(context.Background(), result.ID)
 		return "", nil, fmt.Errorf("保存数据库失败: %v", err)
 	}
 
-	return containerID, portInfo, nil
+	return result.ID, result.Ports, nil
 }
 
 // OnContestStatusChange 比赛状态变更钩子函数
@@ -922,44 +769,75 @@ func HandleContestStatusChange(db *sql.DB, contestID int64, oldStatus, newStatus
 // ResetTeamContainer 重置队伍的容器（销毁并重建，补丁不保留）
 func ResetTeamContainer(db *sql.DB, teamID, contestID, challengeID int64) (map[string]string, error) {
 	log.Printf("[AWD-F] 重置容器: 队伍 %d 比赛 %d 题目 %d", teamID, contestID, challengeID)
+	portInfo, err := redeployTeamContainer(db, teamID, contestID, challengeID, false)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[AWD-F] 容器重置成功: 队伍 %d 题目 %d", teamID, challengeID)
+	return portInfo, nil
+}
+
+// RecreateTeamContainer 补建队伍丢失的容器（容器对账使用）：保留补丁记录与 SSH 密码，并重新应用已生效的补丁
+func RecreateTeamContainer(db *sql.DB, teamID, contestID, challengeID int64) error {
+	log.Printf("[AWD-F] 补建容器: 队伍 %d 比赛 %d 题目 %d", teamID, contestID, challengeID)
+	if _, err := redeployTeamContainer(db, teamID, contestID, challengeID, true); err != nil {
+		return err
+	}
+	reapplyPatches(db, teamID, contestID, challengeID)
+	return nil
+}
 
+// redeployTeamContainer 销毁队伍现有容器并按题目配置重新创建，keepState 为 true 时保留补丁记录与 SSH 密码
+func redeployTeamContainer(db *sql.DB, teamID, contestID, challengeID int64, keepState bool) (map[string]string, error) {
 	// 1. 获取现有容器信息
-	var oldContainerID string
+	var oldContainerID, oldBackend, oldNode string
 	err := db.QueryRow(`
-		SELECT container_id FROM team_instances_awdf 
+		SELECT container_id, COALESCE(backend, ''), COALESCE(node, '') FROM team_instances_awdf 
 		WHERE team_id = $1 AND contest_id = $2 AND challenge_id = $3 AND status = 'running'
-	`, teamID, contestID, challengeID).Scan(&oldContainerID)
+	`, teamID, contestID, challengeID).Scan(&oldContainerID, &oldBackend, &oldNode)
 
 	if err == nil && oldContainerID != "" {
 		// 2. 销毁旧容器
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		exec.CommandContext(ctx, "docker", "rm", "-f", oldContainerID).Run()
+		container.For(oldBackend, oldNode).Remove(ctx, oldContainerID)
 		cancel()
 
 		// 更新数据库状态
 		db.Exec(`UPDATE team_instances_awdf SET status = 'destroyed', updated_at = NOW() WHERE team_id = $1 AND challenge_id = $2`, teamID, challengeID)
 	}
 
-	// 3. 删除该队伍该题的补丁记录（重置后不保留）
-	db.Exec(`DELETE FROM awdf_patches WHERE team_id = $1 AND contest_id = $2 AND challenge_id = $3`, teamID, contestID, challengeID)
+	// 3. 重置时删除该队伍该题的补丁记录（重置后不保留）；补建时沿用原 SSH 密码
+	var sshPassword string
+	if keepState {
+		db.QueryRow(`SELECT COALESCE(ssh_password, '') FROM team_instances_awdf WHERE team_id = $1 AND challenge_id = $2`, teamID, challengeID).Scan(&sshPassword)
+	} else {
+		db.Exec(`DELETE FROM awdf_patches WHERE team_id = $1 AND contest_id = $2 AND challenge_id = $3`, teamID, contestID, challengeID)
+	}
 
 	// 4. 获取题目配置
 	var ch struct {
-		ID          int64
-		Title       string
-		DockerImage string
-		Ports       sql.NullString
-		CPULimit    sql.NullString
-		MemoryLimit sql.NullString
-		FlagEnv     sql.NullString
-		FlagScript  sql.NullString
+		ID              int64
+		Title           string
+		DockerImage     string
+		Ports           sql.NullString
+		CPULimit        sql.NullString
+		MemoryLimit     sql.NullString
+		FlagEnv         sql.NullString
+		FlagScript      sql.NullString
+		Network         sql.NullString // 出网策略
+		StorageLimit    sql.NullString // 磁盘配额
+		NoResourceLimit bool           // 不限制 CPU / 内存 / 磁盘 / 进程数
+		ReadOnly        bool           // 只读根文件系统
+		AllowSetuid     bool           // 允许容器内提权
 	}
 	err = db.QueryRow(`
-		SELECT cc.id, q.title, q.docker_image, q.ports, q.cpu_limit, q.memory_limit, q.flag_env, q.flag_script
+		SELECT cc.id, q.title, q.docker_image, q.ports, q.cpu_limit, q.memory_limit, q.flag_env, q.flag_script, q.network_policy,
+			q.storage_limit, COALESCE(q.no_resource_limit, false), COALESCE(q.read_only_rootfs, false), COALESCE(q.allow_setuid, false)
 		FROM contest_challenges_awdf cc
 		JOIN question_bank_awdf q ON cc.question_id = q.id
 		WHERE cc.id = $1
-	`, challengeID).Scan(&ch.ID, &ch.Title, &ch.DockerImage, &ch.Ports, &ch.CPULimit, &ch.MemoryLimit, &ch.FlagEnv, &ch.FlagScript)
+	`, challengeID).Scan(&ch.ID, &ch.Title, &ch.DockerImage, &ch.Ports, &ch.CPULimit, &ch.MemoryLimit, &ch.FlagEnv, &ch.FlagScript, &ch.Network,
+		&ch.StorageLimit, &ch.NoResourceLimit, &ch.ReadOnly, &ch.AllowSetuid)
 	if err != nil {
 		return nil, fmt.Errorf("获取题目配置失败: %v", err)
 	}
@@ -981,12 +859,10 @@ func ResetTeamContainer(db *sql.DB, teamID, contestID, challengeID int64) (map[s
 	}
 
 	// 7. 创建新容器（使用比赛结束时间作为过期时间）
-	_, portInfo, err := createAWDFContainerWithEndTime(db, teamID, contestID, challengeID, ch, flag, contestEndTime)
+	_, portInfo, err := createAWDFContainerWithEndTime(db, teamID, contestID, challengeID, ch, flag, contestEndTime, sshPassword)
 	if err != nil {
 		return nil, fmt.Errorf("创建容器失败: %v", err)
 	}
-
-	log.Printf("[AWD-F] 容器重置成功: 队伍 %d 题目 %d", teamID, challengeID)
 	return portInfo, nil
 }
 
@@ -141,18 +141,22 @@ func HandleAddAWDFContestChallenge(c *gin.Context, db *sql.DB) {
 		return
 	}
 
-	// 设置默认值
+	// 设置默认值（优先使用题目包导入的默认计分）
+	var defaultInitial, defaultMin, defaultDefense, defaultInterval sql.NullInt64
+	db.QueryRow(`
+		SELECT default_initial_score, default_min_score, default_defense_score, default_attack_interval
+		FROM question_bank_awdf WHERE id = $1`, req.QuestionID).Scan(&defaultInitial, &defaultMin, &defaultDefense, &defaultInterval)
 	if req.InitialScore == 0 {
-		req.InitialScore = 500
+		req.InitialScore = nullIntDefault(defaultInitial, 500)
 	}
 	if req.MinScore == 0 {
-		req.MinScore = 100
+		req.MinScore = nullIntDefault(defaultMin, 100)
 	}
 	if req.DefenseScore == 0 {
-		req.DefenseScore = 100
+		req.DefenseScore = nullIntDefault(defaultDefense, 100)
 	}
 	if req.AttackInterval == 0 {
-		req.AttackInterval = 60
+		req.AttackInterval = nullIntDefault(defaultInterval, 60)
 	}
 
 	// 插入关联记录
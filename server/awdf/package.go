@@ -0,0 +1,266 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package awdf
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"tgctf/server/bank"
+	"tgctf/server/challengepkg"
+	"tgctf/server/container"
+)
+
+// 导出时 EXP 与功能检测脚本在题目包中的路径
+const (
+	packageExpScript   = challengepkg.ScriptsDir + "/exp.sh"
+	packageCheckScript = challengepkg.ScriptsDir + "/check.sh"
+)
+
+// nullIfZero 0 值存为 NULL
+func nullIfZero(n int) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// HandleImportAWDFPackages 导入 AWD-F 题目包（challenge.yml 中 kind 为 awdf）
+func HandleImportAWDFPackages(c *gin.Context, db *sql.DB) {
+	files, err := challengepkg.ReadRequest(c.Request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_PACKAGE", "message": err.Error()})
+		return
+	}
+	pkgs, err := challengepkg.Split(files)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_PACKAGE", "message": err.Error()})
+		return
+	}
+
+	results := []gin.H{}
+	successCount := 0
+	for _, pkg := range pkgs {
+		id, message, err := importAWDFPackage(db, pkg)
+		result := gin.H{"dir": pkg.Dir, "title": pkg.Spec.Title, "success": err == nil}
+		if err != nil {
+			result["message"] = err.Error()
+		} else {
+			result["id"] = id
+			result["message"] = message
+			successCount++
+		}
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": successCount,
+		"failed":  len(results) - successCount,
+		"results": results,
+	})
+}
+
+// importAWDFPackage 导入单个 AWD-F 题目包
+func importAWDFPackage(db *sql.DB, pkg *challengepkg.Package) (int64, string, error) {
+	spec := &pkg.Spec
+	if err := spec.Validate(challengepkg.KindAWDF); err != nil {
+		return 0, "", err
+	}
+	buildContext := pkg.BuildContext()
+	if spec.Container.Image == "" && buildContext == nil {
+		return 0, "", fmt.Errorf("AWD-F 题目需要 container.image 或 Dockerfile")
+	}
+	networkPolicy, ok := container.ParseNetworkPolicy(spec.Container.Network)
+	if !ok {
+		return 0, "", fmt.Errorf("无效的 container.network: %s", spec.Container.Network)
+	}
+	categoryID, categoryName, err := challengepkg.ResolveCategory(db, spec.Category)
+	if err != nil {
+		return 0, "", err
+	}
+
+	awdf := spec.AWDF
+	if awdf == nil {
+		awdf = &challengepkg.AWDF{}
+	}
+	readScript := func(name string) (string, error) {
+		if name == "" {
+			return "", nil
+		}
+		data, ok := pkg.File(name)
+		if !ok {
+			return "", fmt.Errorf("脚本不存在: %s", name)
+		}
+		return string(data), nil
+	}
+	expScript, err := readScript(awdf.Exp)
+	if err != nil {
+		return 0, "", err
+	}
+	checkScript, err := readScript(awdf.Check)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var ports, whitelist string
+	if len(spec.Container.Ports) > 0 {
+		data, _ := json.Marshal(spec.Container.Ports)
+		ports = string(data)
+	}
+	if len(awdf.PatchWhitelist) > 0 {
+		data, _ := json.Marshal(awdf.PatchWhitelist)
+		whitelist = string(data)
+	}
+
+	var id int64
+	err = db.QueryRow(`
+		INSERT INTO question_bank_awdf (
+			title, category_id, difficulty, description, docker_image,
+			ports, cpu_limit, memory_limit, storage_limit, no_resource_limit,
+			exp_script, check_script, patch_whitelist, vulnerable_file,
+			flag_env, flag_script, network_policy, read_only_rootfs, allow_setuid,
+			default_initial_score, default_min_score, default_defense_score, default_attack_interval, author, source
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25)
+		RETURNING id
+	`,
+		spec.Title, categoryID, spec.Difficulty, spec.Description, spec.Container.Image,
+		NullIfEmpty(ports), NullIfEmpty(spec.Limits.CPU),
+		NullIfEmpty(spec.Limits.Memory), NullIfEmpty(spec.Limits.Storage), spec.Limits.Unlimited,
+		NullIfEmpty(expScript), NullIfEmpty(checkScript),
+		NullIfEmpty(whitelist), NullIfEmpty(awdf.VulnerableFile),
+		NullIfEmpty(spec.Flag.Env), NullIfEmpty(spec.Flag.Script), networkPolicy, spec.Container.ReadOnlyRootfs, spec.Container.AllowSetuid,
+		nullIfZero(spec.Scoring.Initial), nullIfZero(spec.Scoring.Minimum),
+		nullIfZero(spec.Scoring.Defense), nullIfZero(spec.Scoring.AttackInterval),
+		NullIfEmpty(spec.Author), NullIfEmpty(spec.Source),
+	).Scan(&id)
+	if err != nil {
+		return 0, "", fmt.Errorf("数据库错误: %v", err)
+	}
+	bank.SetTags(db, bank.AWDF, id, spec.Tags)
+
+	message := "导入成功，类别: " + categoryName
+	if buildContext != nil {
+		if err := challengepkg.SaveContext(challengepkg.KindAWDF, id, buildContext); err != nil {
+			message += "；保存 Dockerfile 构建上下文失败: " + err.Error()
+		} else if spec.Container.Image == "" {
+			message += "；已保存 Dockerfile 构建上下文，请构建镜像后填写镜像名"
+		}
+	}
+	return id, message, nil
+}
+
+// HandleExportAWDFPackages 导出 AWD-F 题目包: /awdf/questions/export?ids=1,2,3
+func HandleExportAWDFPackages(c *gin.Context, db *sql.DB) {
+	ids, err := challengepkg.ParseIDs(c.Query("ids"))
+	if err != nil || len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_IDS", "message": "请选择要导出的题目"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT q.id, q.title, COALESCE(cat.name, ''), q.difficulty, COALESCE(q.description, ''),
+			q.docker_image, COALESCE(q.ports, ''), COALESCE(q.network_policy, ''), COALESCE(q.read_only_rootfs, false), COALESCE(q.allow_setuid, false),
+			COALESCE(q.cpu_limit, ''), COALESCE(q.memory_limit, ''), COALESCE(q.storage_limit, ''), COALESCE(q.no_resource_limit, false),
+			COALESCE(q.exp_script, ''), COALESCE(q.check_script, ''), COALESCE(q.patch_whitelist, ''), COALESCE(q.vulnerable_file, ''),
+			COALESCE(q.flag_env, ''), COALESCE(q.flag_script, ''),
+			COALESCE(q.default_initial_score, 0), COALESCE(q.default_min_score, 0),
+			COALESCE(q.default_defense_score, 0), COALESCE(q.default_attack_interval, 0),
+			COALESCE(q.author, ''), COALESCE(q.source, '')
+		FROM question_bank_awdf q
+		LEFT JOIN categories cat ON q.category_id = cat.id
+		WHERE q.id = ANY($1)
+		ORDER BY q.id`, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	defer rows.Close()
+
+	tags := bank.LoadTags(db, bank.AWDF, ids)
+	var buf bytes.Buffer
+	w := challengepkg.NewWriter(&buf)
+	count := 0
+	for rows.Next() {
+		var id int64
+		var ports, expScript, checkScript, whitelist string
+		spec := challengepkg.Spec{
+			Kind:      challengepkg.KindAWDF,
+			Flag:      &challengepkg.Flag{},
+			Container: &challengepkg.Container{},
+			Limits:    &challengepkg.Limits{},
+			Scoring:   &challengepkg.Scoring{},
+			AWDF:      &challengepkg.AWDF{},
+		}
+		if err := rows.Scan(&id, &spec.Title, &spec.Category, &spec.Difficulty, &spec.Description,
+			&spec.Container.Image, &ports, &spec.Container.Network, &spec.Container.ReadOnlyRootfs, &spec.Container.AllowSetuid,
+			&spec.Limits.CPU, &spec.Limits.Memory, &spec.Limits.Storage, &spec.Limits.Unlimited,
+			&expScript, &checkScript, &whitelist, &spec.AWDF.VulnerableFile,
+			&spec.Flag.Env, &spec.Flag.Script,
+			&spec.Scoring.Initial, &spec.Scoring.Minimum,
+			&spec.Scoring.Defense, &spec.Scoring.AttackInterval, &spec.Author, &spec.Source); err != nil {
+			continue
+		}
+		spec.Tags = tags[id]
+		if ports != "" {
+			json.Unmarshal([]byte(ports), &spec.Container.Ports)
+		}
+		if whitelist != "" {
+			json.Unmarshal([]byte(whitelist), &spec.AWDF.PatchWhitelist)
+		}
+
+		files := make(map[string][]byte)
+		if expScript != "" {
+			spec.AWDF.Exp = packageExpScript
+			files[packageExpScript] = []byte(expScript)
+		}
+		if checkScript != "" {
+			spec.AWDF.Check = packageCheckScript
+			files[packageCheckScript] = []byte(checkScript)
+		}
+		for name, data := range challengepkg.LoadContext(challengepkg.KindAWDF, id) {
+			files[challengepkg.DockerDir+"/"+name] = data
+		}
+		if spec.Flag.Env == "FLAG" {
+			spec.Flag.Env = ""
+		}
+		if *spec.Flag == (challengepkg.Flag{}) {
+			spec.Flag = nil
+		}
+		if spec.Container.Network == container.NetworkFull {
+			spec.Container.Network = ""
+		}
+		if *spec.Limits == (challengepkg.Limits{}) {
+			spec.Limits = nil
+		}
+		if *spec.Scoring == (challengepkg.Scoring{}) {
+			spec.Scoring = nil
+		}
+
+		dir := ""
+		if len(ids) > 1 {
+			dir = challengepkg.DirName(id, spec.Title)
+		}
+		if err := w.Add(dir, spec, files); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "EXPORT_FAILED", "message": err.Error()})
+			return
+		}
+		count++
+	}
+	if err := w.Close(); err != nil || count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "QUESTION_NOT_FOUND"})
+		return
+	}
+
+	filename := "awdf-challenges.zip"
+	if len(ids) == 1 {
+		filename = fmt.Sprintf("awdf-challenge-%d.zip", ids[0])
+	}
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
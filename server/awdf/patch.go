@@ -5,12 +5,14 @@
 package awdf
 
 import (
+	"context"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"os/exec"
@@ -19,6 +21,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"tgctf/server/container"
 )
 
 // AddPatchEventFunc 补丁事件记录函数（由 main.go 注入）
@@ -198,11 +201,11 @@ func HandleUploadPatch(c *gin.Context, db *sql.DB) {
 // applyPatch 验证并应用补丁到容器
 func applyPatch(db *sql.DB, patchID int64, patchPath, whitelist string, teamID int64, contestID, challengeID string, teamName, challengeName string) {
 	// 获取队伍的容器信息
-	var containerID string
+	var containerID, backend, node string
 	err := db.QueryRow(`
-		SELECT container_id FROM team_instances_awdf 
+		SELECT container_id, COALESCE(backend, ''), COALESCE(node, '') FROM team_instances_awdf 
 		WHERE team_id = $1 AND contest_id = $2 AND challenge_id = $3 AND status = 'running'
-	`, teamID, contestID, challengeID).Scan(&containerID)
+	`, teamID, contestID, challengeID).Scan(&containerID, &backend, &node)
 
 	if err != nil {
 		updatePatchStatus(db, patchID, "failed", "未找到运行中的容器，请先部署环境")
@@ -283,17 +286,12 @@ func applyPatch(db *sql.DB, patchID int64, patchPath, whitelist string, teamID i
 	}
 
 	// 复制文件到容器
-	for _, relPath := range filesToCopy {
-		srcPath := filepath.Join(tempDir, relPath)
-		destPath := "/" + relPath
-		cmd := exec.Command("docker", "cp", srcPath, containerID+":"+destPath)
-		if err := cmd.Run(); err != nil {
-			updatePatchStatus(db, patchID, "failed", fmt.Sprintf("应用补丁失败: %s", relPath))
-			if AddPatchEventFunc != nil {
-				AddPatchEventFunc(db, contestID, "patch_rejected", teamName, "应用失败", challengeName)
-			}
-			return
+	if relPath, err := copyPatchFiles(container.For(backend, node), containerID, tempDir, filesToCopy); err != nil {
+		updatePatchStatus(db, patchID, "failed", fmt.Sprintf("应用补丁失败: %s", relPath))
+		if AddPatchEventFunc != nil {
+			AddPatchEventFunc(db, contestID, "patch_rejected", teamName, "应用失败", challengeName)
 		}
+		return
 	}
 
 	// 更新状态为已应用
@@ -306,6 +304,71 @@ func applyPatch(db *sql.DB, patchID int64, patchPath, whitelist string, teamID i
 	}
 }
 
+// copyPatchFiles 将解压目录中的文件复制到容器内的同名路径，失败时返回出错的文件
+func copyPatchFiles(rt container.Runtime, containerID, dir string, files []string) (string, error) {
+	for _, relPath := range files {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := rt.CopyTo(ctx, containerID, filepath.Join(dir, relPath), "/"+relPath)
+		cancel()
+		if err != nil {
+			return relPath, err
+		}
+	}
+	return "", nil
+}
+
+// reapplyPatches 容器重建后按应用顺序重新应用队伍已生效的补丁（白名单已在首次应用时校验）
+func reapplyPatches(db *sql.DB, teamID, contestID, challengeID int64) {
+	var containerID, backend, node string
+	err := db.QueryRow(`
+		SELECT container_id, COALESCE(backend, ''), COALESCE(node, '') FROM team_instances_awdf
+		WHERE team_id = $1 AND contest_id = $2 AND challenge_id = $3 AND status = 'running'
+	`, teamID, contestID, challengeID).Scan(&containerID, &backend, &node)
+	if err != nil {
+		return
+	}
+	rows, err := db.Query(`
+		SELECT patch_file FROM awdf_patches
+		WHERE team_id = $1 AND contest_id = $2 AND challenge_id = $3 AND status = 'applied'
+		ORDER BY applied_at, id
+	`, teamID, contestID, challengeID)
+	if err != nil {
+		return
+	}
+	var patchFiles []string
+	for rows.Next() {
+		var f string
+		if rows.Scan(&f) == nil {
+			patchFiles = append(patchFiles, f)
+		}
+	}
+	rows.Close()
+
+	rt := container.For(backend, node)
+	for _, patchPath := range patchFiles {
+		tempDir, err := os.MkdirTemp("", "patch-extract-*")
+		if err != nil {
+			continue
+		}
+		var files []string
+		if err := exec.Command("unzip", "-o", patchPath, "-d", tempDir).Run(); err == nil {
+			filepath.Walk(tempDir, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					relPath, _ := filepath.Rel(tempDir, path)
+					files = append(files, relPath)
+				}
+				return err
+			})
+			if relPath, err := copyPatchFiles(rt, containerID, tempDir, files); err != nil {
+				log.Printf("[AWD-F] 重新应用补丁失败: 队伍 %d 题目 %d 文件 %s: %v", teamID, challengeID, relPath, err)
+			}
+		} else {
+			log.Printf("[AWD-F] 重新应用补丁失败: 队伍 %d 题目 %d 补丁 %s: %v", teamID, challengeID, patchPath, err)
+		}
+		os.RemoveAll(tempDir)
+	}
+}
+
 // updatePatchStatus 更新补丁状态
 func updatePatchStatus(db *sql.DB, patchID int64, status, reason string) {
 	if reason != "" {
@@ -7,34 +7,47 @@ package awdf
 import (
 	"database/sql"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"tgctf/server/bank"
+	"tgctf/server/challengepkg"
+	"tgctf/server/container"
 )
 
 // AWDFQuestion AWD-F题库题目
 type AWDFQuestion struct {
-	ID              int64   `json:"id"`
-	Title           string  `json:"title"`
-	CategoryID      int64   `json:"categoryId"`
-	CategoryName    *string `json:"categoryName,omitempty"`
-	Difficulty      int     `json:"difficulty"`
-	Description     *string `json:"description"`
-	DockerImage     string  `json:"dockerImage"`
-	Ports           *string `json:"ports"`
-	CPULimit        *string `json:"cpuLimit"`
-	MemoryLimit     *string `json:"memoryLimit"`
-	StorageLimit    *string `json:"storageLimit"`
-	NoResourceLimit bool    `json:"noResourceLimit"`
-	ExpScript       *string `json:"expScript"`
-	CheckScript     *string `json:"checkScript"`
-	PatchWhitelist  *string `json:"patchWhitelist"`
-	VulnerableFile  *string `json:"vulnerableFile"`
-	FlagEnv         *string `json:"flagEnv"`
-	FlagScript      *string `json:"flagScript"`
-	ImageStatus     *string `json:"imageStatus"`
-	CreatedAt       string  `json:"createdAt"`
-	UpdatedAt       string  `json:"updatedAt"`
+	ID              int64    `json:"id"`
+	Title           string   `json:"title"`
+	CategoryID      int64    `json:"categoryId"`
+	CategoryName    *string  `json:"categoryName,omitempty"`
+	Difficulty      int      `json:"difficulty"`
+	Description     *string  `json:"description"`
+	DockerImage     string   `json:"dockerImage"`
+	Ports           *string  `json:"ports"`
+	CPULimit        *string  `json:"cpuLimit"`
+	MemoryLimit     *string  `json:"memoryLimit"`
+	StorageLimit    *string  `json:"storageLimit"`
+	NoResourceLimit bool     `json:"noResourceLimit"`
+	NetworkPolicy   string   `json:"networkPolicy"`
+	ReadOnlyRootfs  bool     `json:"readOnlyRootfs"`
+	AllowSetuid     bool     `json:"allowSetuid"`
+	ExpScript       *string  `json:"expScript"`
+	CheckScript     *string  `json:"checkScript"`
+	PatchWhitelist  *string  `json:"patchWhitelist"`
+	VulnerableFile  *string  `json:"vulnerableFile"`
+	FlagEnv         *string  `json:"flagEnv"`
+	FlagScript      *string  `json:"flagScript"`
+	ImageStatus     *string  `json:"imageStatus"`
+	Author          *string  `json:"author"`
+	Source          *string  `json:"source"`
+	Tags            []string `json:"tags"`
+	UsedCount       int      `json:"usedCount"`  // 使用该题目的比赛数
+	LastUsedAt      *string  `json:"lastUsedAt"` // 最近使用的比赛开始时间
+	CreatedAt       string   `json:"createdAt"`
+	UpdatedAt       string   `json:"updatedAt"`
 }
 
 // nullStringToPtr 将 sql.NullString 转换为 *string
@@ -53,77 +66,99 @@ func NullIfEmpty(s string) interface{} {
 	return s
 }
 
+// nullIntDefault sql.NullInt64 为空时返回默认值
+func nullIntDefault(n sql.NullInt64, def int) int {
+	if n.Valid {
+		return int(n.Int64)
+	}
+	return def
+}
+
 // CreateAWDFQuestionRequest 创建AWD-F题目请求
 type CreateAWDFQuestionRequest struct {
-	Title           string `json:"title" binding:"required"`
-	CategoryID      int64  `json:"categoryId" binding:"required"`
-	Difficulty      int    `json:"difficulty"`
-	Description     string `json:"description"`
-	DockerImage     string `json:"dockerImage" binding:"required"`
-	Ports           string `json:"ports"`
-	CPULimit        string `json:"cpuLimit"`
-	MemoryLimit     string `json:"memoryLimit"`
-	StorageLimit    string `json:"storageLimit"`
-	NoResourceLimit bool   `json:"noResourceLimit"`
-	ExpScript       string `json:"expScript"`
-	CheckScript     string `json:"checkScript"`
-	PatchWhitelist  string `json:"patchWhitelist"`
-	VulnerableFile  string `json:"vulnerableFile"`
-	FlagEnv         string `json:"flagEnv"`
-	FlagScript      string `json:"flagScript"`
+	Title           string   `json:"title" binding:"required"`
+	CategoryID      int64    `json:"categoryId" binding:"required"`
+	Difficulty      int      `json:"difficulty"`
+	Description     string   `json:"description"`
+	DockerImage     string   `json:"dockerImage" binding:"required"`
+	Ports           string   `json:"ports"`
+	CPULimit        string   `json:"cpuLimit"`
+	MemoryLimit     string   `json:"memoryLimit"`
+	StorageLimit    string   `json:"storageLimit"`
+	NoResourceLimit bool     `json:"noResourceLimit"`
+	NetworkPolicy   string   `json:"networkPolicy"`
+	ReadOnlyRootfs  bool     `json:"readOnlyRootfs"`
+	AllowSetuid     bool     `json:"allowSetuid"`
+	ExpScript       string   `json:"expScript"`
+	CheckScript     string   `json:"checkScript"`
+	PatchWhitelist  string   `json:"patchWhitelist"`
+	VulnerableFile  string   `json:"vulnerableFile"`
+	FlagEnv         string   `json:"flagEnv"`
+	FlagScript      string   `json:"flagScript"`
+	Author          string   `json:"author"`
+	Source          string   `json:"source"`
+	Tags            []string `json:"tags"`
 }
 
 // UpdateAWDFQuestionRequest 更新AWD-F题目请求
 type UpdateAWDFQuestionRequest struct {
-	Title           string `json:"title"`
-	CategoryID      int64  `json:"categoryId"`
-	Difficulty      int    `json:"difficulty"`
-	Description     string `json:"description"`
-	DockerImage     string `json:"dockerImage"`
-	Ports           string `json:"ports"`
-	CPULimit        string `json:"cpuLimit"`
-	MemoryLimit     string `json:"memoryLimit"`
-	StorageLimit    string `json:"storageLimit"`
-	NoResourceLimit bool   `json:"noResourceLimit"`
-	ExpScript       string `json:"expScript"`
-	CheckScript     string `json:"checkScript"`
-	PatchWhitelist  string `json:"patchWhitelist"`
-	VulnerableFile  string `json:"vulnerableFile"`
-	FlagEnv         string `json:"flagEnv"`
-	FlagScript      string `json:"flagScript"`
+	Title           string    `json:"title"`
+	CategoryID      int64     `json:"categoryId"`
+	Difficulty      int       `json:"difficulty"`
+	Description     string    `json:"description"`
+	DockerImage     string    `json:"dockerImage"`
+	Ports           string    `json:"ports"`
+	CPULimit        string    `json:"cpuLimit"`
+	MemoryLimit     string    `json:"memoryLimit"`
+	StorageLimit    string    `json:"storageLimit"`
+	NoResourceLimit bool      `json:"noResourceLimit"`
+	NetworkPolicy   string    `json:"networkPolicy"`
+	ReadOnlyRootfs  bool      `json:"readOnlyRootfs"`
+	AllowSetuid     bool      `json:"allowSetuid"`
+	ExpScript       string    `json:"expScript"`
+	CheckScript     string    `json:"checkScript"`
+	PatchWhitelist  string    `json:"patchWhitelist"`
+	VulnerableFile  string    `json:"vulnerableFile"`
+	FlagEnv         string    `json:"flagEnv"`
+	FlagScript      string    `json:"flagScript"`
+	Author          string    `json:"author"`
+	Source          string    `json:"source"`
+	Tags            *[]string `json:"tags"` // 为空时保持不变
+}
+
+// nullTimeToPtr 将 sql.NullTime 转换为 RFC3339 格式的 *string
+func nullTimeToPtr(t sql.NullTime) *string {
+	if !t.Valid {
+		return nil
+	}
+	s := t.Time.Format(time.RFC3339)
+	return &s
 }
 
 // HandleListAWDFQuestions 获取AWD-F题库列表
+// 查询参数与普通题库一致（见 bank.Filter），不支持 type
 func HandleListAWDFQuestions(c *gin.Context, db *sql.DB) {
-	categoryID := c.Query("categoryId")
-	difficulty := c.Query("difficulty")
+	filter, err := bank.ParseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_FILTER", "details": err.Error()})
+		return
+	}
 
 	query := `
 		SELECT q.id, q.title, q.category_id, c.name as category_name,
 			q.difficulty, q.description, q.docker_image, q.ports,
-			q.cpu_limit, q.memory_limit, q.storage_limit, q.no_resource_limit,
+			q.cpu_limit, q.memory_limit, q.storage_limit, q.no_resource_limit, COALESCE(q.network_policy, 'full'), COALESCE(q.read_only_rootfs, false), COALESCE(q.allow_setuid, false),
 			q.exp_script, q.check_script, q.patch_whitelist, q.vulnerable_file,
 			q.flag_env, q.flag_script, q.image_status,
+			q.author, q.source, ` + bank.AWDF.UsageColumns("q") + `,
 			q.created_at, q.updated_at
 		FROM question_bank_awdf q
 		LEFT JOIN categories c ON q.category_id = c.id
 		WHERE 1=1
 	`
 	args := []interface{}{}
-	argIndex := 1
-
-	if categoryID != "" {
-		query += " AND q.category_id = $" + string(rune('0'+argIndex))
-		args = append(args, categoryID)
-		argIndex++
-	}
-	if difficulty != "" {
-		query += " AND q.difficulty = $" + string(rune('0'+argIndex))
-		args = append(args, difficulty)
-		argIndex++
-	}
-
-	query += " ORDER BY q.created_at ASC"
+	where, orderBy := filter.Where(bank.AWDF, "q", &args)
+	query += where + " ORDER BY " + orderBy
 
 	rows, err := db.Query(query, args...)
 	if err != nil {
@@ -140,18 +175,24 @@ func HandleListAWDFQuestions(c *gin.Context, db *sql.DB) {
 		var cpuLimit, memoryLimit, storageLimit sql.NullString
 		var expScript, checkScript, patchWhitelist, vulnerableFile sql.NullString
 		var flagEnv, flagScript, imageStatus sql.NullString
+		var author, source sql.NullString
+		var lastUsedAt sql.NullTime
 
 		err := rows.Scan(
 			&q.ID, &q.Title, &q.CategoryID, &categoryName,
 			&q.Difficulty, &description, &q.DockerImage, &ports,
-			&cpuLimit, &memoryLimit, &storageLimit, &q.NoResourceLimit,
+			&cpuLimit, &memoryLimit, &storageLimit, &q.NoResourceLimit, &q.NetworkPolicy, &q.ReadOnlyRootfs, &q.AllowSetuid,
 			&expScript, &checkScript, &patchWhitelist, &vulnerableFile,
 			&flagEnv, &flagScript, &imageStatus,
+			&author, &source, &q.UsedCount, &lastUsedAt,
 			&createdAt, &updatedAt,
 		)
 		if err != nil {
 			continue
 		}
+		q.Author = nullStringToPtr(author)
+		q.Source = nullStringToPtr(source)
+		q.LastUsedAt = nullTimeToPtr(lastUsedAt)
 		q.CategoryName = nullStringToPtr(categoryName)
 		q.Description = nullStringToPtr(description)
 		q.Ports = nullStringToPtr(ports)
@@ -173,6 +214,17 @@ func HandleListAWDFQuestions(c *gin.Context, db *sql.DB) {
 	if questions == nil {
 		questions = []AWDFQuestion{}
 	}
+	ids := make([]int64, len(questions))
+	for i := range questions {
+		ids[i] = questions[i].ID
+	}
+	tags := bank.LoadTags(db, bank.AWDF, ids)
+	for i := range questions {
+		questions[i].Tags = tags[questions[i].ID]
+		if questions[i].Tags == nil {
+			questions[i].Tags = []string{}
+		}
+	}
 
 	c.JSON(http.StatusOK, questions)
 }
@@ -196,6 +248,11 @@ func HandleCreateAWDFQuestion(c *gin.Context, db *sql.DB) {
 	if req.Difficulty == 0 {
 		req.Difficulty = 5
 	}
+	networkPolicy, ok := container.ParseNetworkPolicy(req.NetworkPolicy)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_NETWORK_POLICY"})
+		return
+	}
 
 	var id int64
 	err := db.QueryRow(`
@@ -203,8 +260,8 @@ func HandleCreateAWDFQuestion(c *gin.Context, db *sql.DB) {
 			title, category_id, difficulty, description, docker_image,
 			ports, cpu_limit, memory_limit, storage_limit, no_resource_limit,
 			exp_script, check_script, patch_whitelist, vulnerable_file,
-			flag_env, flag_script
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+			flag_env, flag_script, network_policy, read_only_rootfs, author, source, allow_setuid
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
 		RETURNING id
 	`,
 		req.Title, req.CategoryID, req.Difficulty, req.Description, req.DockerImage,
@@ -212,13 +269,18 @@ func HandleCreateAWDFQuestion(c *gin.Context, db *sql.DB) {
 		NullIfEmpty(req.MemoryLimit), NullIfEmpty(req.StorageLimit), req.NoResourceLimit,
 		NullIfEmpty(req.ExpScript), NullIfEmpty(req.CheckScript),
 		NullIfEmpty(req.PatchWhitelist), NullIfEmpty(req.VulnerableFile),
-		NullIfEmpty(req.FlagEnv), NullIfEmpty(req.FlagScript),
+		NullIfEmpty(req.FlagEnv), NullIfEmpty(req.FlagScript), networkPolicy, req.ReadOnlyRootfs,
+		NullIfEmpty(strings.TrimSpace(req.Author)), NullIfEmpty(strings.TrimSpace(req.Source)), req.AllowSetuid,
 	).Scan(&id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR", "details": err.Error()})
 		return
 	}
+	if err := bank.SetTags(db, bank.AWDF, id, req.Tags); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR", "details": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusCreated, gin.H{"id": id, "message": "AWD-F question created"})
 }
@@ -233,13 +295,16 @@ func HandleGetAWDFQuestion(c *gin.Context, db *sql.DB) {
 	var cpuLimit, memoryLimit, storageLimit sql.NullString
 	var expScript, checkScript, patchWhitelist, vulnerableFile sql.NullString
 	var flagEnv, flagScript, imageStatus sql.NullString
+	var author, source sql.NullString
+	var lastUsedAt sql.NullTime
 
 	err := db.QueryRow(`
 		SELECT q.id, q.title, q.category_id, c.name as category_name,
 			q.difficulty, q.description, q.docker_image, q.ports,
-			q.cpu_limit, q.memory_limit, q.storage_limit, q.no_resource_limit,
+			q.cpu_limit, q.memory_limit, q.storage_limit, q.no_resource_limit, COALESCE(q.network_policy, 'full'), COALESCE(q.read_only_rootfs, false), COALESCE(q.allow_setuid, false),
 			q.exp_script, q.check_script, q.patch_whitelist, q.vulnerable_file,
 			q.flag_env, q.flag_script, q.image_status,
+			q.author, q.source, `+bank.AWDF.UsageColumns("q")+`,
 			q.created_at, q.updated_at
 		FROM question_bank_awdf q
 		LEFT JOIN categories c ON q.category_id = c.id
@@ -247,9 +312,10 @@ func HandleGetAWDFQuestion(c *gin.Context, db *sql.DB) {
 	`, id).Scan(
 		&q.ID, &q.Title, &q.CategoryID, &categoryName,
 		&q.Difficulty, &description, &q.DockerImage, &ports,
-		&cpuLimit, &memoryLimit, &storageLimit, &q.NoResourceLimit,
+		&cpuLimit, &memoryLimit, &storageLimit, &q.NoResourceLimit, &q.NetworkPolicy, &q.ReadOnlyRootfs, &q.AllowSetuid,
 		&expScript, &checkScript, &patchWhitelist, &vulnerableFile,
 		&flagEnv, &flagScript, &imageStatus,
+		&author, &source, &q.UsedCount, &lastUsedAt,
 		&createdAt, &updatedAt,
 	)
 
@@ -275,6 +341,13 @@ func HandleGetAWDFQuestion(c *gin.Context, db *sql.DB) {
 	q.FlagEnv = nullStringToPtr(flagEnv)
 	q.FlagScript = nullStringToPtr(flagScript)
 	q.ImageStatus = nullStringToPtr(imageStatus)
+	q.Author = nullStringToPtr(author)
+	q.Source = nullStringToPtr(source)
+	q.LastUsedAt = nullTimeToPtr(lastUsedAt)
+	q.Tags = bank.LoadTags(db, bank.AWDF, []int64{q.ID})[q.ID]
+	if q.Tags == nil {
+		q.Tags = []string{}
+	}
 	q.CreatedAt = createdAt.Format(time.RFC3339)
 	q.UpdatedAt = updatedAt.Format(time.RFC3339)
 
@@ -300,6 +373,11 @@ func HandleUpdateAWDFQuestion(c *gin.Context, db *sql.DB) {
 			return
 		}
 	}
+	networkPolicy, ok := container.ParseNetworkPolicy(req.NetworkPolicy)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_NETWORK_POLICY"})
+		return
+	}
 
 	result, err := db.Exec(`
 		UPDATE question_bank_awdf SET
@@ -319,6 +397,11 @@ func HandleUpdateAWDFQuestion(c *gin.Context, db *sql.DB) {
 			vulnerable_file = $14,
 			flag_env = $15,
 			flag_script = $16,
+			network_policy = $18,
+			read_only_rootfs = $19,
+			author = $20,
+			source = $21,
+			allow_setuid = $22,
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = $17
 	`,
@@ -327,7 +410,8 @@ func HandleUpdateAWDFQuestion(c *gin.Context, db *sql.DB) {
 		NullIfEmpty(req.MemoryLimit), NullIfEmpty(req.StorageLimit), req.NoResourceLimit,
 		NullIfEmpty(req.ExpScript), NullIfEmpty(req.CheckScript),
 		NullIfEmpty(req.PatchWhitelist), NullIfEmpty(req.VulnerableFile),
-		NullIfEmpty(req.FlagEnv), NullIfEmpty(req.FlagScript), id,
+		NullIfEmpty(req.FlagEnv), NullIfEmpty(req.FlagScript), id, networkPolicy, req.ReadOnlyRootfs,
+		NullIfEmpty(strings.TrimSpace(req.Author)), NullIfEmpty(strings.TrimSpace(req.Source)), req.AllowSetuid,
 	)
 
 	if err != nil {
@@ -340,6 +424,13 @@ func HandleUpdateAWDFQuestion(c *gin.Context, db *sql.DB) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "QUESTION_NOT_FOUND"})
 		return
 	}
+	if req.Tags != nil {
+		questionID, _ := strconv.ParseInt(id, 10, 64)
+		if err := bank.SetTags(db, bank.AWDF, questionID, *req.Tags); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+			return
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "AWD-F question updated"})
 }
@@ -367,10 +458,38 @@ func HandleDeleteAWDFQuestion(c *gin.Context, db *sql.DB) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "QUESTION_NOT_FOUND"})
 		return
 	}
+	if qid, err := strconv.ParseInt(id, 10, 64); err == nil {
+		challengepkg.RemoveContext(challengepkg.KindAWDF, qid)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "AWD-F question deleted"})
 }
 
+// HandleListAWDFQuestionTags AWD-F 题库标签及使用数: GET /awdf/questions/tags
+func HandleListAWDFQuestionTags(c *gin.Context, db *sql.DB) {
+	tags, err := bank.ListTags(db, bank.AWDF)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+// HandleAWDFQuestionContests 使用该 AWD-F 题目的比赛: GET /awdf/questions/:id/contests
+func HandleAWDFQuestionContests(c *gin.Context, db *sql.DB) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_ID"})
+		return
+	}
+	usages, err := bank.UsedIn(db, bank.AWDF, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	c.JSON(http.StatusOK, usages)
+}
+
 // HandleGetAWDFStats 获取AWD-F题库统计
 func HandleGetAWDFStats(c *gin.Context, db *sql.DB) {
 	var total int
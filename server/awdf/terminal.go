@@ -0,0 +1,270 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package awdf
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tgctf/server/container"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// 防守 Web 终端：攻击成功（解锁 SSH 防守通道）后，选手可以直接在浏览器中进入本队的 AWD-F 容器，
+// 无需外部 SSH 客户端。每次会话的输入与输出都保存到 awdf_terminal_sessions，供管理员排查争议
+
+const (
+	defenseTerminalIdleTimeout = 30 * time.Minute // 无输入超时自动断开
+	defenseInputLimit          = 64 * 1024        // 输入记录上限
+	defenseOutputLimit         = 256 * 1024       // 输出记录上限
+)
+
+var defenseTerminalUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 32 * 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// TerminalSession 终端会话记录
+type TerminalSession struct {
+	ID            int64   `json:"id"`
+	ChallengeID   int64   `json:"challengeId"`
+	ChallengeName string  `json:"challengeName"`
+	TeamID        int64   `json:"teamId"`
+	TeamName      string  `json:"teamName"`
+	UserID        int64   `json:"userId"`
+	Username      string  `json:"username"`
+	ContainerID   string  `json:"containerId"`
+	ClientIP      string  `json:"clientIp"`
+	Truncated     bool    `json:"truncated"`
+	StartedAt     string  `json:"startedAt"`
+	EndedAt       *string `json:"endedAt"`
+	Input         string  `json:"input,omitempty"`
+	Output        string  `json:"output,omitempty"`
+}
+
+// VerifyUserTokenFunc 校验 WebSocket 连接携带的登录凭证（浏览器无法设置 Authorization 头，通过 token 参数传递），
+// 由 main.go 注入 docker.VerifyUserToken，与实例事件、TCP 隧道共用同一套校验
+var VerifyUserTokenFunc func(db *sql.DB, secret []byte, tokenStr string) (int64, bool)
+
+// HandleDefenseTerminal 防守 Web 终端: /api/contests/:id/challenges/:challengeId/terminal?token=
+func HandleDefenseTerminal(c *gin.Context, jwtSecret []byte, db *sql.DB) {
+	contestID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	challengeID, _ := strconv.ParseInt(c.Param("challengeId"), 10, 64)
+
+	if VerifyUserTokenFunc == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "TERMINAL_UNAVAILABLE"})
+		return
+	}
+	userID, ok := VerifyUserTokenFunc(db, jwtSecret, c.Query("token"))
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "INVALID_TOKEN"})
+		return
+	}
+
+	var teamID sql.NullInt64
+	db.QueryRow(`SELECT team_id FROM users WHERE id = $1`, userID).Scan(&teamID)
+	if !teamID.Valid {
+		c.JSON(http.StatusForbidden, gin.H{"error": "NO_TEAM", "message": "您未加入任何队伍"})
+		return
+	}
+
+	var status, mode string
+	err := db.QueryRow(`SELECT status, mode FROM contests WHERE id = $1`, contestID).Scan(&status, &mode)
+	if err != nil || status != "running" || mode != "awd-f" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CONTEST_NOT_RUNNING", "message": "比赛未进行中或不是 AWD-F 模式"})
+		return
+	}
+
+	var teamStatus string
+	err = db.QueryRow(`SELECT status FROM contest_teams WHERE contest_id = $1 AND team_id = $2`, contestID, teamID.Int64).Scan(&teamStatus)
+	if err != nil || teamStatus != "approved" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "TEAM_NOT_APPROVED", "message": "队伍未通过审核"})
+		return
+	}
+
+	// 与 SSH 防守通道一致：攻击成功后才开放
+	var hasSolved bool
+	db.QueryRow(`SELECT EXISTS(SELECT 1 FROM team_solves_awdf WHERE team_id = $1 AND challenge_id = $2 AND contest_id = $3)`,
+		teamID.Int64, challengeID, contestID).Scan(&hasSolved)
+	if !hasSolved {
+		c.JSON(http.StatusForbidden, gin.H{"error": "DEFENSE_LOCKED", "message": "您需要先解题（获取攻击分），才能获得防守权限"})
+		return
+	}
+
+	var containerID, backend, node string
+	err = db.QueryRow(`
+		SELECT container_id, COALESCE(backend, ''), COALESCE(node, '')
+		FROM team_instances_awdf WHERE team_id = $1 AND contest_id = $2 AND challenge_id = $3 AND status = 'running'`,
+		teamID.Int64, contestID, challengeID).Scan(&containerID, &backend, &node)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "NO_INSTANCE", "message": "队伍没有运行中的容器"})
+		return
+	}
+	opener, ok := container.For(backend, node).(container.TerminalOpener)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "UNSUPPORTED", "message": "该容器后端不支持 Web 终端"})
+		return
+	}
+
+	cols, _ := strconv.Atoi(c.Query("cols"))
+	rows, _ := strconv.Atoi(c.Query("rows"))
+	term, err := opener.OpenTerminal(containerID, uint16(cols), uint16(rows))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "TERMINAL_FAILED", "message": err.Error()})
+		return
+	}
+	defer term.Close()
+
+	conn, err := defenseTerminalUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var sessionID int64
+	err = db.QueryRow(`
+		INSERT INTO awdf_terminal_sessions (contest_id, challenge_id, team_id, user_id, container_id, client_ip)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		contestID, challengeID, teamID.Int64, userID, containerID, c.ClientIP()).Scan(&sessionID)
+	if err != nil {
+		// 无法留存记录时不开放终端
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "session record failed"), time.Now().Add(time.Second))
+		return
+	}
+
+	input := container.NewTranscript(defenseInputLimit)
+	output := container.NewTranscript(defenseOutputLimit)
+
+	// 终端输出 -> 浏览器
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := term.Read(buf)
+			if n > 0 {
+				output.Write(buf[:n])
+				if werr := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "session ended"), time.Now().Add(time.Second))
+				return
+			}
+		}
+	}()
+
+	// 浏览器输入 -> 终端
+	for {
+		conn.SetReadDeadline(time.Now().Add(defenseTerminalIdleTimeout))
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		if msgType == websocket.TextMessage && len(data) > 0 && data[0] == '{' {
+			var msg struct {
+				Type string `json:"type"`
+				Cols uint16 `json:"cols"`
+				Rows uint16 `json:"rows"`
+			}
+			if json.Unmarshal(data, &msg) == nil && msg.Type == "resize" {
+				term.Resize(msg.Cols, msg.Rows)
+				continue
+			}
+		}
+		input.Write(data)
+		if _, err := term.Write(data); err != nil {
+			break
+		}
+	}
+	term.Close()
+	<-done
+
+	db.Exec(`UPDATE awdf_terminal_sessions SET input = $1, output = $2, truncated = $3, ended_at = CURRENT_TIMESTAMP WHERE id = $4`,
+		input.String(), output.String(), input.Truncated() || output.Truncated(), sessionID)
+}
+
+// HandleAdminListTerminalSessions 管理员查看比赛的防守终端会话列表（可按队伍筛选，不含记录内容）
+func HandleAdminListTerminalSessions(c *gin.Context, db *sql.DB) {
+	contestID := c.Param("id")
+
+	query := `
+		SELECT s.id, s.challenge_id, COALESCE(q.title, '未知题目'), s.team_id, t.name, s.user_id, u.username,
+			s.container_id, COALESCE(s.client_ip, ''), s.truncated, s.started_at, s.ended_at
+		FROM awdf_terminal_sessions s
+		JOIN teams t ON s.team_id = t.id
+		JOIN users u ON s.user_id = u.id
+		LEFT JOIN contest_challenges_awdf cc ON s.challenge_id = cc.id
+		LEFT JOIN question_bank_awdf q ON cc.question_id = q.id
+		WHERE s.contest_id = $1`
+	args := []interface{}{contestID}
+	if teamID := c.Query("teamId"); teamID != "" {
+		query += ` AND s.team_id = $2`
+		args = append(args, teamID)
+	}
+	query += ` ORDER BY s.started_at DESC LIMIT 500`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	defer rows.Close()
+
+	sessions := []TerminalSession{}
+	for rows.Next() {
+		var s TerminalSession
+		var startedAt time.Time
+		var endedAt sql.NullTime
+		if err := rows.Scan(&s.ID, &s.ChallengeID, &s.ChallengeName, &s.TeamID, &s.TeamName, &s.UserID, &s.Username,
+			&s.ContainerID, &s.ClientIP, &s.Truncated, &startedAt, &endedAt); err != nil {
+			continue
+		}
+		s.StartedAt = startedAt.Format(time.RFC3339)
+		if endedAt.Valid {
+			t := endedAt.Time.Format(time.RFC3339)
+			s.EndedAt = &t
+		}
+		sessions = append(sessions, s)
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// HandleAdminGetTerminalSession 管理员查看单个终端会话的输入与输出记录
+func HandleAdminGetTerminalSession(c *gin.Context, db *sql.DB) {
+	var s TerminalSession
+	var startedAt time.Time
+	var endedAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT s.id, s.challenge_id, COALESCE(q.title, '未知题目'), s.team_id, t.name, s.user_id, u.username,
+			s.container_id, COALESCE(s.client_ip, ''), s.truncated, s.started_at, s.ended_at, s.input, s.output
+		FROM awdf_terminal_sessions s
+		JOIN teams t ON s.team_id = t.id
+		JOIN users u ON s.user_id = u.id
+		LEFT JOIN contest_challenges_awdf cc ON s.challenge_id = cc.id
+		LEFT JOIN question_bank_awdf q ON cc.question_id = q.id
+		WHERE s.id = $1 AND s.contest_id = $2`, c.Param("sessionId"), c.Param("id")).Scan(
+		&s.ID, &s.ChallengeID, &s.ChallengeName, &s.TeamID, &s.TeamName, &s.UserID, &s.Username,
+		&s.ContainerID, &s.ClientIP, &s.Truncated, &startedAt, &endedAt, &s.Input, &s.Output)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "NOT_FOUND", "message": "会话记录不存在"})
+		return
+	}
+	s.StartedAt = startedAt.Format(time.RFC3339)
+	if endedAt.Valid {
+		t := endedAt.Time.Format(time.RFC3339)
+		s.EndedAt = &t
+	}
+
+	c.JSON(http.StatusOK, s)
+}
@@ -0,0 +1,365 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+// Package bank 题库公共功能：标签、出题人 / 来源、比赛使用情况与检索条件（普通题库与 AWD-F 题库共用）
+package bank
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Kind 题库类型对应的数据表
+type Kind struct {
+	Questions  string // 题库表
+	Tags       string // 题目-标签关联表
+	Challenges string // 比赛题目表（question_id 引用题库）
+}
+
+var (
+	// Jeopardy 普通题库
+	Jeopardy = Kind{Questions: "question_bank", Tags: "question_bank_tags", Challenges: "contest_challenges"}
+	// AWDF AWD-F 题库
+	AWDF = Kind{Questions: "question_bank_awdf", Tags: "question_bank_awdf_tags", Challenges: "contest_challenges_awdf"}
+)
+
+const (
+	// MaxTags 每道题目的标签数上限
+	MaxTags = 20
+	// MaxTagLength 标签最大字符数
+	MaxTagLength = 32
+	// maxSearchTerms 检索词数上限
+	maxSearchTerms = 10
+)
+
+// NormalizeTags 去除空白与重复标签（不区分大小写，保留首次出现的写法），超出上限的部分丢弃
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(tag), " ")
+		if tag == "" || utf8.RuneCountInString(tag) > MaxTagLength {
+			continue
+		}
+		key := strings.ToLower(tag)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, tag)
+		if len(result) == MaxTags {
+			break
+		}
+	}
+	return result
+}
+
+// SetTags 替换题目的标签，同名标签（不区分大小写）复用已有记录，不再被使用的标签一并删除
+func SetTags(db *sql.DB, kind Kind, questionID int64, tags []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM `+kind.Tags+` WHERE question_id = $1`, questionID); err != nil {
+		return err
+	}
+	for _, tag := range NormalizeTags(tags) {
+		var tagID int64
+		err := tx.QueryRow(`SELECT id FROM question_tags WHERE LOWER(name) = LOWER($1)`, tag).Scan(&tagID)
+		if err == sql.ErrNoRows {
+			err = tx.QueryRow(`
+				INSERT INTO question_tags (name) VALUES ($1)
+				ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING id`, tag).Scan(&tagID)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO `+kind.Tags+` (question_id, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			questionID, tagID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM question_tags t
+		WHERE NOT EXISTS (SELECT 1 FROM question_bank_tags WHERE tag_id = t.id)
+		  AND NOT EXISTS (SELECT 1 FROM question_bank_awdf_tags WHERE tag_id = t.id)`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LoadTags 批量查询题目标签，返回 题目ID -> 标签列表
+func LoadTags(db *sql.DB, kind Kind, questionIDs []int64) map[int64][]string {
+	result := make(map[int64][]string, len(questionIDs))
+	if len(questionIDs) == 0 {
+		return result
+	}
+	rows, err := db.Query(`
+		SELECT qt.question_id, t.name FROM `+kind.Tags+` qt
+		JOIN question_tags t ON t.id = qt.tag_id
+		WHERE qt.question_id = ANY($1)
+		ORDER BY t.name`, questionIDs)
+	if err != nil {
+		return result
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name string
+		if rows.Scan(&id, &name) == nil {
+			result[id] = append(result[id], name)
+		}
+	}
+	return result
+}
+
+// Tag 标签及使用数
+type Tag struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"` // 该题库中使用此标签的题目数
+}
+
+// ListTags 题库中使用的标签（按使用数降序）
+func ListTags(db *sql.DB, kind Kind) ([]Tag, error) {
+	rows, err := db.Query(`
+		SELECT t.id, t.name, COUNT(*) FROM question_tags t
+		JOIN ` + kind.Tags + ` qt ON qt.tag_id = t.id
+		GROUP BY t.id, t.name
+		ORDER BY COUNT(*) DESC, t.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		if rows.Scan(&t.ID, &t.Name, &t.Count) == nil {
+			tags = append(tags, t)
+		}
+	}
+	return tags, nil
+}
+
+// UsageColumns 比赛使用次数与最近使用时间（比赛开始时间）的 SQL 列，alias 为题库表别名
+func (k Kind) UsageColumns(alias string) string {
+	return `(SELECT COUNT(*) FROM ` + k.Challenges + ` u WHERE u.question_id = ` + alias + `.id),
+		(SELECT MAX(uc.start_time) FROM ` + k.Challenges + ` u JOIN contests uc ON uc.id = u.contest_id WHERE u.question_id = ` + alias + `.id)`
+}
+
+// Usage 使用题目的比赛
+type Usage struct {
+	ContestID       int64   `json:"contestId"`
+	ContestName     string  `json:"contestName"`
+	Status          string  `json:"status"`
+	StartTime       string  `json:"startTime"`
+	ChallengeID     int64   `json:"challengeId"`
+	Version         *int    `json:"questionVersion,omitempty"` // 比赛固定的题目版本（仅普通题库）
+	ChallengeStatus *string `json:"challengeStatus,omitempty"`
+}
+
+// UsedIn 查询使用该题目的比赛（按开始时间倒序）
+func UsedIn(db *sql.DB, kind Kind, questionID int64) ([]Usage, error) {
+	version := "NULL::INTEGER"
+	if kind.Challenges == Jeopardy.Challenges {
+		version = "cc.question_version"
+	}
+	rows, err := db.Query(`
+		SELECT ct.id, ct.name, ct.status, ct.start_time, cc.id, `+version+`, cc.status
+		FROM `+kind.Challenges+` cc
+		JOIN contests ct ON ct.id = cc.contest_id
+		WHERE cc.question_id = $1
+		ORDER BY ct.start_time DESC, ct.id DESC`, questionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	usages := []Usage{}
+	for rows.Next() {
+		var u Usage
+		var start time.Time
+		var v sql.NullInt64
+		var status sql.NullString
+		if err := rows.Scan(&u.ContestID, &u.ContestName, &u.Status, &start, &u.ChallengeID, &v, &status); err != nil {
+			continue
+		}
+		u.StartTime = start.Format(time.RFC3339)
+		if v.Valid {
+			n := int(v.Int64)
+			u.Version = &n
+		}
+		if status.Valid {
+			u.ChallengeStatus = &status.String
+		}
+		usages = append(usages, u)
+	}
+	return usages, nil
+}
+
+// Filter 题库列表检索条件（查询参数）
+type Filter struct {
+	Search        string     // q: 全文检索标题与描述
+	CategoryID    int64      // categoryId
+	Type          string     // type: 题目类型（仅普通题库）
+	Difficulty    int        // difficulty: 指定难度
+	MinDifficulty int        // minDifficulty
+	MaxDifficulty int        // maxDifficulty
+	ImageStatus   string     // imageStatus: exists | not_found | unchecked
+	Tags          []string   // tags: 逗号分隔，需同时包含全部标签
+	Author        string     // author: 出题人（包含匹配）
+	Source        string     // source: 来源（包含匹配）
+	Used          string     // used: true 只看用过的 | false 只看未用过的
+	UsedAfter     *time.Time // lastUsedAfter: 最近使用不早于该日期
+	UsedBefore    *time.Time // lastUsedBefore: 最近使用早于该日期（从未使用的题目也包括在内）
+}
+
+// ParseFilter 从查询参数解析检索条件，日期格式为 2006-01-02
+func ParseFilter(c *gin.Context) (Filter, error) {
+	f := Filter{
+		Search:      strings.TrimSpace(c.Query("q")),
+		Type:        c.Query("type"),
+		ImageStatus: c.Query("imageStatus"),
+		Author:      strings.TrimSpace(c.Query("author")),
+		Source:      strings.TrimSpace(c.Query("source")),
+		Used:        c.Query("used"),
+	}
+	ints := []struct {
+		name string
+		dst  *int
+	}{{"difficulty", &f.Difficulty}, {"minDifficulty", &f.MinDifficulty}, {"maxDifficulty", &f.MaxDifficulty}}
+	for _, p := range ints {
+		if v := c.Query(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s", p.name)
+			}
+			*p.dst = n
+		}
+	}
+	if v := c.Query("categoryId"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("invalid categoryId")
+		}
+		f.CategoryID = n
+	}
+	if v := c.Query("tags"); v != "" {
+		f.Tags = NormalizeTags(strings.Split(v, ","))
+	}
+	switch f.ImageStatus {
+	case "", "exists", "not_found", "unchecked":
+	default:
+		return f, fmt.Errorf("invalid imageStatus")
+	}
+	switch f.Used {
+	case "", "true", "false":
+	default:
+		return f, fmt.Errorf("invalid used")
+	}
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"lastUsedAfter", &f.UsedAfter}, {"lastUsedBefore", &f.UsedBefore}} {
+		if v := c.Query(p.name); v != "" {
+			t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+			if err != nil {
+				return f, fmt.Errorf("invalid %s", p.name)
+			}
+			*p.dst = &t
+		}
+	}
+	return f, nil
+}
+
+// likePattern 包含匹配的 ILIKE 模式（转义通配符）
+func likePattern(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(s) + "%"
+}
+
+// Where 生成检索条件（以 AND 开头）与排序，参数追加到 args；alias 为题库表别名
+func (f Filter) Where(kind Kind, alias string, args *[]interface{}) (where, orderBy string) {
+	var b strings.Builder
+	arg := func(v interface{}) string {
+		*args = append(*args, v)
+		return "$" + strconv.Itoa(len(*args))
+	}
+	col := func(name string) string { return alias + "." + name }
+
+	orderBy = col("created_at") + " ASC"
+	if f.Search != "" {
+		// 每个词需命中全文索引，或作为子串出现在标题 / 描述中（simple 分词无法切分中文）
+		terms := strings.Fields(f.Search)
+		if len(terms) > maxSearchTerms {
+			terms = terms[:maxSearchTerms]
+		}
+		for _, term := range terms {
+			like := arg(likePattern(term))
+			fmt.Fprintf(&b, " AND (%s @@ plainto_tsquery('simple', %s) OR %s ILIKE %s OR %s ILIKE %s)",
+				col("search_vector"), arg(term), col("title"), like, col("description"), like)
+		}
+		orderBy = fmt.Sprintf("(%s ILIKE %s) DESC, ts_rank(%s, websearch_to_tsquery('simple', %s)) DESC, %s ASC",
+			col("title"), arg(likePattern(f.Search)), col("search_vector"), arg(f.Search), col("created_at"))
+	}
+	if f.CategoryID > 0 {
+		fmt.Fprintf(&b, " AND %s = %s", col("category_id"), arg(f.CategoryID))
+	}
+	if f.Type != "" && kind.Questions == Jeopardy.Questions {
+		fmt.Fprintf(&b, " AND %s = %s", col("type"), arg(f.Type))
+	}
+	if f.Difficulty > 0 {
+		fmt.Fprintf(&b, " AND %s = %s", col("difficulty"), arg(f.Difficulty))
+	}
+	if f.MinDifficulty > 0 {
+		fmt.Fprintf(&b, " AND %s >= %s", col("difficulty"), arg(f.MinDifficulty))
+	}
+	if f.MaxDifficulty > 0 {
+		fmt.Fprintf(&b, " AND %s <= %s", col("difficulty"), arg(f.MaxDifficulty))
+	}
+	switch f.ImageStatus {
+	case "exists", "not_found":
+		fmt.Fprintf(&b, " AND %s = %s", col("image_status"), arg(f.ImageStatus))
+	case "unchecked":
+		fmt.Fprintf(&b, " AND %s IS NULL", col("image_status"))
+	}
+	if len(f.Tags) > 0 {
+		lower := make([]string, len(f.Tags))
+		for i, t := range f.Tags {
+			lower[i] = strings.ToLower(t)
+		}
+		fmt.Fprintf(&b, ` AND (SELECT COUNT(*) FROM %s ft JOIN question_tags t ON t.id = ft.tag_id
+			WHERE ft.question_id = %s AND LOWER(t.name) = ANY(%s)) = %d`, kind.Tags, col("id"), arg(lower), len(lower))
+	}
+	if f.Author != "" {
+		fmt.Fprintf(&b, " AND %s ILIKE %s", col("author"), arg(likePattern(f.Author)))
+	}
+	if f.Source != "" {
+		fmt.Fprintf(&b, " AND %s ILIKE %s", col("source"), arg(likePattern(f.Source)))
+	}
+
+	used := fmt.Sprintf("EXISTS (SELECT 1 FROM %s u WHERE u.question_id = %s)", kind.Challenges, col("id"))
+	switch f.Used {
+	case "true":
+		b.WriteString(" AND " + used)
+	case "false":
+		b.WriteString(" AND NOT " + used)
+	}
+	lastUsed := fmt.Sprintf("(SELECT MAX(uc.start_time) FROM %s u JOIN contests uc ON uc.id = u.contest_id WHERE u.question_id = %s)",
+		kind.Challenges, col("id"))
+	if f.UsedAfter != nil {
+		fmt.Fprintf(&b, " AND %s >= %s", lastUsed, arg(*f.UsedAfter))
+	}
+	if f.UsedBefore != nil {
+		fmt.Fprintf(&b, " AND (%s < %s OR NOT %s)", lastUsed, arg(*f.UsedBefore), used)
+	}
+	return b.String(), orderBy
+}
@@ -0,0 +1,597 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package challengepkg
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// 题目包格式：一个目录（或其 zip 压缩包）包含
+//
+//	challenge.yml   题目元数据、flag、端口、资源限制、提示与计分
+//	attachments/    附件（challenge.yml 中 attachment 引用的文件）
+//	docker/         可选的镜像构建上下文（包含 Dockerfile）
+//	scripts/        AWD-F 的 EXP 与功能检测脚本
+//
+// 一个 zip 中可以包含多个题目目录，每个包含 challenge.yml 的目录视为一道题
+
+const (
+	SpecFile       = "challenge.yml"
+	SpecVersion    = 1
+	DockerDir      = "docker"
+	AttachmentsDir = "attachments"
+	ScriptsDir     = "scripts"
+
+	KindJeopardy = "jeopardy"
+	KindAWDF     = "awdf"
+
+	MaxPackageSize = 512 << 20 // 单次导入解压后的总大小上限
+	maxFileCount   = 10000
+)
+
+// ContextDir 导入题目包时保存的镜像构建上下文目录
+const ContextDir = "./build-contexts"
+
+// Spec challenge.yml 内容
+type Spec struct {
+	Version     int        `yaml:"version"`
+	Kind        string     `yaml:"kind"` // jeopardy | awdf
+	Title       string     `yaml:"title"`
+	Category    string     `yaml:"category"`
+	Type        string     `yaml:"type,omitempty"` // 仅 jeopardy: static_attachment | static_container | dynamic_attachment | dynamic_container
+	Difficulty  int        `yaml:"difficulty,omitempty"`
+	Description string     `yaml:"description,omitempty"`
+	Author      string     `yaml:"author,omitempty"`
+	Source      string     `yaml:"source,omitempty"`
+	Tags        []string   `yaml:"tags,omitempty"`
+	Flag        *Flag      `yaml:"flag,omitempty"`
+	Container   *Container `yaml:"container,omitempty"`
+	Limits      *Limits    `yaml:"limits,omitempty"`
+	Hints       []string   `yaml:"hints,omitempty"`
+	Scoring     *Scoring   `yaml:"scoring,omitempty"`
+	Attachment  string     `yaml:"attachment,omitempty"`           // 外部链接，或包内文件路径（如 attachments/src.zip）
+	Solve       string     `yaml:"solve,omitempty"`                // 仅 jeopardy: 包内解题脚本路径（如 scripts/solve.py），用于自检
+	Generator   string     `yaml:"attachment_generator,omitempty"` // 仅 jeopardy 动态附件: template | script
+	GenScript   string     `yaml:"attachment_script,omitempty"`    // 仅 jeopardy 动态附件: 包内附件生成脚本路径（generator 为 script 时必填）
+	AWDF        *AWDF      `yaml:"awdf,omitempty"`
+}
+
+// Flag flag 配置
+type Flag struct {
+	Type   string `yaml:"type,omitempty"`  // static | dynamic
+	Value  string `yaml:"value,omitempty"` // 静态 flag
+	Env    string `yaml:"env,omitempty"`   // 注入的环境变量名，默认 FLAG
+	Script string `yaml:"script,omitempty"`
+}
+
+// Container 容器配置
+type Container struct {
+	Image          string   `yaml:"image,omitempty"`
+	Build          string   `yaml:"build,omitempty"` // 包内构建上下文目录，默认 docker
+	Ports          []string `yaml:"ports,omitempty"`
+	Network        string   `yaml:"network,omitempty"` // full | platform | none
+	ReadOnlyRootfs bool     `yaml:"read_only_rootfs,omitempty"`
+	AllowSetuid    bool     `yaml:"allow_setuid,omitempty"` // 不设置 no-new-privileges
+}
+
+// Limits 资源限制
+type Limits struct {
+	CPU       string `yaml:"cpu,omitempty"`
+	Memory    string `yaml:"memory,omitempty"`
+	Storage   string `yaml:"storage,omitempty"`
+	Unlimited bool   `yaml:"unlimited,omitempty"`
+}
+
+// Scoring 添加到比赛时的默认计分
+type Scoring struct {
+	Initial        int `yaml:"initial,omitempty"`
+	Minimum        int `yaml:"minimum,omitempty"`
+	Defense        int `yaml:"defense,omitempty"`         // 仅 awdf: 每轮防守得分
+	AttackInterval int `yaml:"attack_interval,omitempty"` // 仅 awdf: 攻击间隔（秒）
+}
+
+// AWDF AWD-F 专属配置
+type AWDF struct {
+	Exp            string   `yaml:"exp,omitempty"`   // 包内 EXP 脚本路径
+	Check          string   `yaml:"check,omitempty"` // 包内功能检测脚本路径
+	PatchWhitelist []string `yaml:"patch_whitelist,omitempty"`
+	VulnerableFile string   `yaml:"vulnerable_file,omitempty"`
+}
+
+// Package 一道题目的包内容（路径相对于 challenge.yml 所在目录）
+type Package struct {
+	Dir   string // 在上传内容中的目录，用于提示
+	Spec  Spec
+	Files map[string][]byte
+}
+
+// File 读取包内文件
+func (p *Package) File(name string) ([]byte, bool) {
+	name, err := cleanPath(name)
+	if err != nil {
+		return nil, false
+	}
+	data, ok := p.Files[name]
+	return data, ok
+}
+
+// Subtree 返回某个目录下的全部文件（路径相对于该目录）
+func (p *Package) Subtree(dir string) map[string][]byte {
+	dir, err := cleanPath(dir)
+	if err != nil {
+		return nil
+	}
+	files := make(map[string][]byte)
+	for name, data := range p.Files {
+		if strings.HasPrefix(name, dir+"/") {
+			files[strings.TrimPrefix(name, dir+"/")] = data
+		}
+	}
+	return files
+}
+
+// BuildContext 返回镜像构建上下文（未提供 Dockerfile 时返回 nil）
+func (p *Package) BuildContext() map[string][]byte {
+	dir := DockerDir
+	if p.Spec.Container != nil && p.Spec.Container.Build != "" {
+		dir = p.Spec.Container.Build
+	}
+	files := p.Subtree(dir)
+	if _, ok := files["Dockerfile"]; !ok {
+		return nil
+	}
+	return files
+}
+
+// IsURL 附件字段是否为外部链接
+func IsURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// Validate 校验通用字段并补全可选的配置段
+func (s *Spec) Validate(kind string) error {
+	if s.Version > SpecVersion {
+		return fmt.Errorf("不支持的题目包版本: %d", s.Version)
+	}
+	if s.Kind == "" {
+		s.Kind = KindJeopardy
+	}
+	if s.Kind != kind {
+		return fmt.Errorf("题目包类型为 %s，不能导入到 %s 题库", s.Kind, kind)
+	}
+	if strings.TrimSpace(s.Title) == "" {
+		return errors.New("title 不能为空")
+	}
+	if s.Difficulty == 0 {
+		s.Difficulty = 5
+	}
+	if s.Difficulty < 1 || s.Difficulty > 10 {
+		return errors.New("difficulty 必须在 1-10 之间")
+	}
+	if s.Container == nil {
+		s.Container = &Container{}
+	}
+	if s.Limits == nil {
+		s.Limits = &Limits{}
+	}
+	if s.Flag == nil {
+		s.Flag = &Flag{}
+	}
+	if s.Scoring == nil {
+		s.Scoring = &Scoring{}
+	}
+	return nil
+}
+
+// cleanPath 规范化包内路径，拒绝绝对路径与跳出包目录的路径
+func cleanPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Clean(strings.TrimPrefix(name, "./"))
+	if name == "." || strings.HasPrefix(name, "/") || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("非法路径: %s", name)
+	}
+	return name, nil
+}
+
+// ReadZip 解析 zip 压缩包
+func ReadZip(r io.ReaderAt, size int64) (map[string][]byte, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析 zip: %v", err)
+	}
+	if len(zr.File) > maxFileCount {
+		return nil, errors.New("压缩包内文件过多")
+	}
+	files := make(map[string][]byte)
+	var total int64
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name, err := cleanPath(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		// 跳过 macOS 打包产生的元数据
+		if strings.HasPrefix(name, "__MACOSX/") || path.Base(name) == ".DS_Store" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, MaxPackageSize-total+1))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("读取 %s 失败: %v", name, err)
+		}
+		total += int64(len(data))
+		if total > MaxPackageSize {
+			return nil, errors.New("题目包解压后超过大小限制")
+		}
+		files[name] = data
+	}
+	return files, nil
+}
+
+// ReadTar 解析 tar 压缩包（支持 gzip 压缩）
+func ReadTar(r io.Reader) (map[string][]byte, error) {
+	tr := tar.NewReader(r)
+	files := make(map[string][]byte)
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("无法解析 tar: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, err := cleanPath(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) >= maxFileCount {
+			return nil, errors.New("压缩包内文件过多")
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, MaxPackageSize-total+1))
+		if err != nil {
+			return nil, fmt.Errorf("读取 %s 失败: %v", name, err)
+		}
+		total += int64(len(data))
+		if total > MaxPackageSize {
+			return nil, errors.New("压缩包解压后超过大小限制")
+		}
+		files[name] = data
+	}
+	return files, nil
+}
+
+// ReadArchive 按文件头识别 zip / tar / tar.gz 并解析
+func ReadArchive(data []byte) (map[string][]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK")):
+		return ReadZip(bytes.NewReader(data), int64(len(data)))
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("无法解压 gzip: %v", err)
+		}
+		defer gz.Close()
+		return ReadTar(gz)
+	default:
+		return ReadTar(bytes.NewReader(data))
+	}
+}
+
+// ContextRoot 定位构建上下文根目录（Dockerfile 所在的最浅目录），返回以该目录为根的文件集合
+func ContextRoot(files map[string][]byte) (map[string][]byte, error) {
+	depth := func(dir string) int {
+		if dir == "." {
+			return -1
+		}
+		return strings.Count(dir, "/")
+	}
+	root := ""
+	found := false
+	for name := range files {
+		if path.Base(name) != "Dockerfile" {
+			continue
+		}
+		dir := path.Dir(name)
+		if !found || depth(dir) < depth(root) || (depth(dir) == depth(root) && dir < root) {
+			root, found = dir, true
+		}
+	}
+	if !found {
+		return nil, errors.New("构建上下文中未找到 Dockerfile")
+	}
+	if root == "." {
+		return files, nil
+	}
+	sub := make(map[string][]byte)
+	for name, data := range files {
+		if strings.HasPrefix(name, root+"/") {
+			sub[strings.TrimPrefix(name, root+"/")] = data
+		}
+	}
+	return sub, nil
+}
+
+// Extract 将文件集合写入目录
+func Extract(files map[string][]byte, dir string) error {
+	for name, data := range files {
+		name, err := cleanPath(name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadRequest 读取上传的题目包：file 字段为压缩包（zip / tar / tar.gz），或 files + paths 字段为目录上传（paths 与 files 一一对应）
+func ReadRequest(r *http.Request) (map[string][]byte, error) {
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		return nil, errors.New("请上传题目包")
+	}
+	form := r.MultipartForm
+	if headers := form.File["file"]; len(headers) > 0 {
+		f, err := headers[0].Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		data, err := io.ReadAll(io.LimitReader(f, MaxPackageSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > MaxPackageSize {
+			return nil, errors.New("题目包超过大小限制")
+		}
+		return ReadArchive(data)
+	}
+
+	headers := form.File["files"]
+	paths := form.Value["paths"]
+	if len(headers) == 0 {
+		return nil, errors.New("请上传题目包")
+	}
+	if len(paths) != len(headers) {
+		return nil, errors.New("目录上传缺少文件路径")
+	}
+	files := make(map[string][]byte)
+	var total int64
+	for i, header := range headers {
+		name, err := cleanPath(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		total += header.Size
+		if total > MaxPackageSize {
+			return nil, errors.New("题目包超过大小限制")
+		}
+		f, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[name] = data
+	}
+	return files, nil
+}
+
+// Split 按 challenge.yml 拆分为若干题目包（嵌套在其他题目目录内的 challenge.yml 不单独拆分）
+func Split(files map[string][]byte) ([]*Package, error) {
+	var dirs []string
+	for name := range files {
+		if path.Base(name) == SpecFile {
+			dirs = append(dirs, path.Dir(name))
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, errors.New("未找到 challenge.yml")
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i]) < len(dirs[j]) || (len(dirs[i]) == len(dirs[j]) && dirs[i] < dirs[j])
+	})
+
+	var roots []string
+	for _, dir := range dirs {
+		nested := false
+		for _, root := range roots {
+			if root == "." || strings.HasPrefix(dir, root+"/") {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			roots = append(roots, dir)
+		}
+	}
+
+	var pkgs []*Package
+	for _, root := range roots {
+		pkg := &Package{Dir: root, Files: make(map[string][]byte)}
+		for name, data := range files {
+			if root == "." {
+				pkg.Files[name] = data
+			} else if strings.HasPrefix(name, root+"/") {
+				pkg.Files[strings.TrimPrefix(name, root+"/")] = data
+			}
+		}
+		if err := yaml.Unmarshal(pkg.Files[SpecFile], &pkg.Spec); err != nil {
+			return nil, fmt.Errorf("%s/%s 解析失败: %v", root, SpecFile, err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// Writer 导出题目包（多道题目时每道题一个目录）
+type Writer struct {
+	zw *zip.Writer
+}
+
+// NewWriter 创建导出写入器
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{zw: zip.NewWriter(w)}
+}
+
+// Add 写入一道题目，dir 为空时写在压缩包根目录
+func (w *Writer) Add(dir string, spec Spec, files map[string][]byte) error {
+	spec.Version = SpecVersion
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	if err := w.write(path.Join(dir, SpecFile), data); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := w.write(path.Join(dir, name), files[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) write(name string, data []byte) error {
+	f, err := w.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// Close 结束写入
+func (w *Writer) Close() error {
+	return w.zw.Close()
+}
+
+// DirName 导出目录名（标题中不适合作为文件名的字符替换为 _）
+func DirName(id int64, title string) string {
+	var b strings.Builder
+	for _, r := range title {
+		switch {
+		case r < 0x20, strings.ContainsRune(`/\:*?"<>| `, r):
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return fmt.Sprintf("%d-%s", id, b.String())
+}
+
+// contextFile 构建上下文保存路径
+func contextFile(kind string, id int64) string {
+	return filepath.Join(ContextDir, fmt.Sprintf("%s-%d.zip", kind, id))
+}
+
+// SaveContext 保存题目的镜像构建上下文（zip）
+func SaveContext(kind string, id int64, files map[string][]byte) error {
+	if err := os.MkdirAll(ContextDir, 0755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		f.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(contextFile(kind, id), buf.Bytes(), 0644)
+}
+
+// LoadContext 读取题目保存的镜像构建上下文（不存在时返回 nil）
+func LoadContext(kind string, id int64) map[string][]byte {
+	data, err := os.ReadFile(contextFile(kind, id))
+	if err != nil {
+		return nil
+	}
+	files, err := ReadZip(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil
+	}
+	return files
+}
+
+// RemoveContext 删除题目保存的镜像构建上下文
+func RemoveContext(kind string, id int64) {
+	os.Remove(contextFile(kind, id))
+}
+
+// ResolveCategory 按名称（不区分大小写）查找类别，不存在时与 Excel 导入一致归入 OTHER/MISC
+func ResolveCategory(db *sql.DB, name string) (id int64, resolved string, err error) {
+	name = strings.TrimSpace(name)
+	if name != "" {
+		err = db.QueryRow("SELECT id, name FROM categories WHERE UPPER(name) = UPPER($1)", name).Scan(&id, &resolved)
+		if err == nil {
+			return id, resolved, nil
+		}
+	}
+	err = db.QueryRow("SELECT id, name FROM categories WHERE UPPER(name) IN ('OTHER', 'MISC') ORDER BY UPPER(name) = 'OTHER' DESC LIMIT 1").Scan(&id, &resolved)
+	if err != nil {
+		return 0, "", fmt.Errorf("无效的类别且找不到OTHER/MISC: %s", name)
+	}
+	return id, resolved, nil
+}
+
+// ParseIDs 解析逗号分隔的题目ID列表
+func ParseIDs(s string) ([]int64, error) {
+	var ids []int64
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,408 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CLIRuntime 基于命令行的容器后端（docker / podman 参数兼容）
+type CLIRuntime struct {
+	name     string
+	bin      string
+	node     string // 工作节点名称，本机为空
+	endpoint string // 远程守护进程地址: tcp://host:2376 / ssh://user@host
+}
+
+// NewCLIRuntime 创建命令行容器后端
+func NewCLIRuntime(name, bin string) *CLIRuntime {
+	return &CLIRuntime{name: name, bin: bin}
+}
+
+// Name 后端名称
+func (r *CLIRuntime) Name() string {
+	return r.name
+}
+
+// WithNode 返回绑定到指定工作节点的后端
+func (r *CLIRuntime) WithNode(node, endpoint string) Runtime {
+	return &CLIRuntime{name: r.name, bin: r.bin, node: node, endpoint: endpoint}
+}
+
+// command 构造命令（远程节点通过 -H / --url 指定守护进程）
+func (r *CLIRuntime) command(ctx context.Context, args ...string) *exec.Cmd {
+	if r.endpoint != "" {
+		flag := "-H"
+		if r.bin == "podman" {
+			flag = "--url"
+		}
+		args = append([]string{flag, r.endpoint}, args...)
+	}
+	return exec.CommandContext(ctx, r.bin, args...)
+}
+
+// Ping 检查守护进程是否可用
+func (r *CLIRuntime) Ping(ctx context.Context) error {
+	output, err := r.command(ctx, "version", "--format", "{{.Server.Version}}").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s version 失败: %v, output: %s", r.bin, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// HasImage 镜像是否已存在于节点本地
+func (r *CLIRuntime) HasImage(ctx context.Context, image string) bool {
+	return r.command(ctx, "image", "inspect", "--format", "{{.Id}}", image).Run() == nil
+}
+
+// Pull 拉取镜像
+func (r *CLIRuntime) Pull(ctx context.Context, image string) error {
+	output, err := r.command(ctx, "pull", image).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s pull 失败: %v, output: %s", r.bin, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// BuildImage 构建镜像（上下文目录由客户端打包发送到 Docker 守护进程，远程节点同样适用）
+func (r *CLIRuntime) BuildImage(ctx context.Context, contextDir, tag string, out io.Writer) error {
+	cmd := r.command(ctx, "build", "--tag", tag, contextDir)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s build 失败: %v", r.bin, err)
+	}
+	return nil
+}
+
+// Run 创建并启动容器
+func (r *CLIRuntime) Run(ctx context.Context, spec *Spec) (*Result, error) {
+	args := []string{"run", "-d", "--name", spec.Name}
+
+	// 受限出网策略的实例加入专用网络
+	network, err := r.ensureNetwork(ctx, spec.Network)
+	if err != nil {
+		return nil, err
+	}
+	if network != "" {
+		args = append(args, "--network", network)
+	}
+
+	portInfo := make(map[string]string)
+	autoPorts := !spec.Internal && len(spec.HostPorts) < len(spec.Ports)
+	for i, containerPort := range spec.Ports {
+		if spec.Internal {
+			// 不发布端口，宿主机端口留空
+			portInfo[containerPort] = ""
+		} else if autoPorts {
+			args = append(args, "-p", fmt.Sprintf(":%s", containerPort))
+		} else {
+			args = append(args, "-p", fmt.Sprintf("%d:%s", spec.HostPorts[i], containerPort))
+			portInfo[containerPort] = strconv.Itoa(spec.HostPorts[i])
+		}
+	}
+
+	if spec.CPULimit != "" {
+		args = append(args, "--cpus", spec.CPULimit)
+	}
+	if spec.MemoryLimit != "" {
+		args = append(args, "-m", spec.MemoryLimit)
+	}
+	storageOpt := r.storageOptSupported()
+	args = append(args, HardeningArgs(spec, storageOpt)...)
+	for _, e := range spec.Env {
+		args = append(args, "-e", e)
+	}
+
+	// 标签按 key 排序，保证参数顺序稳定
+	keys := make([]string, 0, len(spec.Labels))
+	for k := range spec.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--label", fmt.Sprintf("%s=%s", k, spec.Labels[k]))
+	}
+
+	args = append(args, spec.Image)
+	args = append(args, spec.Args...)
+
+	output, err := r.command(ctx, args...).CombinedOutput()
+	if err != nil {
+		// 清理失败的容器（可能处于 Created 状态）
+		r.command(context.Background(), "rm", "-f", spec.Name).Run()
+		// 存储驱动不支持磁盘配额时记录下来，不带磁盘配额重试（只读根文件系统仍由 tmpfs 限制）
+		if spec.StorageLimit != "" && storageOpt && IsStorageOptError(string(output)) {
+			r.markStorageOptUnsupported()
+			return r.Run(ctx, spec)
+		}
+		return nil, fmt.Errorf("%s run 失败: %v, output: %s", r.bin, err, strings.TrimSpace(string(output)))
+	}
+
+	// docker run 输出可能包含 stderr 警告，容器 ID 在最后一行
+	outputLines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	containerID := strings.TrimSpace(outputLines[len(outputLines)-1])
+	if len(containerID) > 12 {
+		containerID = containerID[:12]
+	}
+
+	// 自动分配端口时需要查询端口映射
+	if autoPorts && len(spec.Ports) > 0 {
+		for i := 0; i < 10; i++ {
+			time.Sleep(500 * time.Millisecond)
+			if ports, err := r.ports(ctx, containerID); err == nil && len(ports) > 0 {
+				portInfo = ports
+				break
+			}
+			if i >= 2 {
+				if st, err := r.Inspect(ctx, containerID); err == nil && !st.Running {
+					break
+				}
+			}
+		}
+	}
+
+	return &Result{ID: containerID, Name: spec.Name, Ports: portInfo, Node: r.node}, nil
+}
+
+// ports 解析 docker port 输出
+func (r *CLIRuntime) ports(ctx context.Context, id string) (map[string]string, error) {
+	output, err := r.command(ctx, "port", id).CombinedOutput()
+	if err != nil {
+		return nil, err
+	}
+	portInfo := make(map[string]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.Contains(line, "[::") {
+			continue
+		}
+		parts := strings.Split(line, " -> ")
+		if len(parts) == 2 {
+			containerPort := strings.Split(parts[0], "/")[0]
+			hostAddr := parts[1]
+			if idx := strings.LastIndex(hostAddr, ":"); idx != -1 {
+				portInfo[containerPort] = hostAddr[idx+1:]
+			}
+		}
+	}
+	return portInfo, nil
+}
+
+// Start 启动已停止的容器
+func (r *CLIRuntime) Start(ctx context.Context, id string) error {
+	output, err := r.command(ctx, "start", id).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s start 失败: %v, output: %s", r.bin, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Remove 强制删除容器
+func (r *CLIRuntime) Remove(ctx context.Context, id string) error {
+	output, err := r.command(ctx, "rm", "-f", id).CombinedOutput()
+	if err != nil && !isNotFound(string(output)) {
+		return fmt.Errorf("%s rm 失败: %v, output: %s", r.bin, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Inspect 查询容器状态
+func (r *CLIRuntime) Inspect(ctx context.Context, id string) (*State, error) {
+	output, err := r.command(ctx, "inspect", "--type=container", id).CombinedOutput()
+	if err != nil {
+		if isNotFound(string(output)) {
+			return &State{Exists: false}, nil
+		}
+		return nil, fmt.Errorf("%s inspect 失败: %v, output: %s", r.bin, err, strings.TrimSpace(string(output)))
+	}
+
+	var result []struct {
+		State struct {
+			Status   string
+			Running  bool
+			ExitCode int
+		}
+		NetworkSettings struct {
+			Networks map[string]struct {
+				IPAddress string
+			}
+		}
+	}
+	if err := json.Unmarshal(output, &result); err != nil || len(result) == 0 {
+		return nil, fmt.Errorf("解析 inspect 输出失败: %v", err)
+	}
+
+	st := &State{
+		Node:     r.node,
+		Exists:   true,
+		Running:  result[0].State.Running,
+		Status:   result[0].State.Status,
+		ExitCode: result[0].State.ExitCode,
+	}
+	for _, n := range result[0].NetworkSettings.Networks {
+		if n.IPAddress != "" {
+			st.IP = n.IPAddress
+			break
+		}
+	}
+	return st, nil
+}
+
+// Exec 在容器内执行命令
+func (r *CLIRuntime) Exec(ctx context.Context, id string, cmd ...string) ([]byte, error) {
+	args := append([]string{"exec", id}, cmd...)
+	return r.command(ctx, args...).CombinedOutput()
+}
+
+// Logs 获取容器日志
+func (r *CLIRuntime) Logs(ctx context.Context, id string, tail int) ([]byte, error) {
+	return r.command(ctx, "logs", "--tail", strconv.Itoa(tail), id).CombinedOutput()
+}
+
+// CopyTo 复制文件到容器
+func (r *CLIRuntime) CopyTo(ctx context.Context, id, src, dest string) error {
+	output, err := r.command(ctx, "cp", src, id+":"+dest).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s cp 失败: %v, output: %s", r.bin, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// List 列出带有指定标签的容器
+func (r *CLIRuntime) List(ctx context.Context, label string) ([]Container, error) {
+	output, err := r.command(ctx, "ps", "-a", "--no-trunc", "--filter", "label="+label, "--format", "{{json .}}").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s ps 失败: %v, output: %s", r.bin, err, strings.TrimSpace(string(output)))
+	}
+
+	list := []Container{}
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var item struct {
+			ID        string
+			Names     string
+			Labels    string
+			State     string
+			CreatedAt string
+		}
+		if json.Unmarshal([]byte(line), &item) != nil {
+			continue
+		}
+		id := item.ID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		labels := make(map[string]string)
+		for _, kv := range strings.Split(item.Labels, ",") {
+			if idx := strings.Index(kv, "="); idx > 0 {
+				labels[kv[:idx]] = kv[idx+1:]
+			}
+		}
+		// CreatedAt 格式: 2006-01-02 15:04:05 -0700 MST
+		created, _ := time.Parse("2006-01-02 15:04:05 -0700 MST", item.CreatedAt)
+		list = append(list, Container{
+			ID:      id,
+			Name:    item.Names,
+			Labels:  labels,
+			Running: item.State == "running",
+			Status:  item.State,
+			Node:    r.node,
+			Created: created,
+		})
+	}
+	return list, nil
+}
+
+// isNotFound 判断命令输出是否为容器不存在
+func isNotFound(output string) bool {
+	return strings.Contains(output, "No such container") ||
+		strings.Contains(output, "No such object") ||
+		strings.Contains(output, "no such container") ||
+		strings.Contains(output, "not found")
+}
+
+// Stats 查询运行中容器的资源占用（一次调用采样节点上所有运行中的容器，再按 ID 过滤）
+func (r *CLIRuntime) Stats(ctx context.Context, ids []string) (map[string]*Stats, error) {
+	output, err := r.command(ctx, "stats", "--no-stream", "--no-trunc", "--format",
+		"{{.ID}}\t{{.CPUPerc}}\t{{.MemUsage}}\t{{.NetIO}}\t{{.PIDs}}").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s stats 失败: %v, output: %s", r.bin, err, strings.TrimSpace(string(output)))
+	}
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		wanted[id] = true
+	}
+
+	result := make(map[string]*Stats)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) < 5 {
+			continue
+		}
+		id := fields[0]
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		if !wanted[id] {
+			continue
+		}
+		st := &Stats{}
+		st.CPUPercent, _ = strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(fields[1]), "%"), 64)
+		st.MemoryBytes, st.MemoryLimit = parseSizePair(fields[2])
+		st.NetRxBytes, st.NetTxBytes = parseSizePair(fields[3])
+		st.PIDs, _ = strconv.Atoi(strings.TrimSpace(fields[4]))
+		result[id] = st
+	}
+	return result, nil
+}
+
+// parseSizePair 解析 "1.5MiB / 512MiB" 形式的一对容量
+func parseSizePair(s string) (int64, int64) {
+	parts := strings.SplitN(s, "/", 2)
+	a := parseSize(parts[0])
+	if len(parts) < 2 {
+		return a, 0
+	}
+	return a, parseSize(parts[1])
+}
+
+// parseSize 解析 docker stats 输出的容量（kB / MB 为十进制，KiB / MiB 为二进制），无法解析时返回 0
+func parseSize(s string) int64 {
+	s = strings.TrimSpace(s)
+	i := 0
+	for i < len(s) && (s[i] == '.' || (s[i] >= '0' && s[i] <= '9')) {
+		i++
+	}
+	v, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0
+	}
+	units := map[string]float64{
+		"": 1, "b": 1,
+		"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+		"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40,
+	}
+	unit, ok := units[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0
+	}
+	return int64(v * unit)
+}
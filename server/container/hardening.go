@@ -0,0 +1,140 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package container
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// pidsLimit 题目容器默认进程数上限（CONTAINER_PIDS_LIMIT，0 表示不限）
+	pidsLimit = envInt("CONTAINER_PIDS_LIMIT", 1024)
+	// capDrop 移除的 Linux 能力（CONTAINER_CAP_DROP，逗号分隔，设为 none 表示不移除）
+	capDrop = splitAddrs(envOr("CONTAINER_CAP_DROP", "NET_RAW,MKNOD,SETFCAP,SYS_PTRACE"))
+	// noNewPrivileges 禁止进程提权（CONTAINER_NO_NEW_PRIVILEGES，默认开启），依赖 setuid 程序（如 /readflag）的题目需勾选「允许提权」
+	noNewPrivileges = envOr("CONTAINER_NO_NEW_PRIVILEGES", "true") != "false"
+	// ulimits 资源上限（CONTAINER_ULIMITS，逗号分隔，如 nofile=4096:8192,core=0）
+	ulimits = splitAddrs(envOr("CONTAINER_ULIMITS", "nofile=4096:8192,core=0"))
+
+	// storageOptUnsupported 不支持 --storage-opt size 的节点（存储驱动非 overlay2+xfs pquota / btrfs / zfs 等）
+	storageOptUnsupported sync.Map
+)
+
+// 只读根文件系统下挂载为 tmpfs 的可写目录
+var writableDirs = []string{"/tmp", "/var/tmp", "/run"}
+
+// 未配置磁盘配额时 tmpfs 目录的默认大小
+const defaultTmpfsSize = "64m"
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv(key))); err == nil {
+		return v
+	}
+	return def
+}
+
+// ApplyLimits 按题目配置填充磁盘配额与进程数上限
+// no_resource_limit 的题目不做 CPU / 内存 / 磁盘 / 进程数限制（安全加固选项仍然生效）
+func ApplyLimits(spec *Spec, storageLimit string, noResourceLimit bool) {
+	if noResourceLimit {
+		spec.CPULimit, spec.MemoryLimit, spec.StorageLimit, spec.PidsLimit = "", "", "", 0
+		return
+	}
+	spec.StorageLimit = strings.TrimSpace(storageLimit)
+	spec.PidsLimit = pidsLimit
+}
+
+// HardeningArgs 生成 docker / podman run 的磁盘配额与安全加固参数
+// 只读根文件系统下可写目录挂载为 tmpfs，大小取磁盘配额（占用计入容器内存限制）；
+// 节点不支持 --storage-opt 且根文件系统可写时磁盘配额不生效（不覆盖镜像自带的 /tmp 等目录）
+func HardeningArgs(spec *Spec, storageOpt bool) []string {
+	var args []string
+	if spec.PidsLimit > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(spec.PidsLimit))
+	}
+	if spec.StorageLimit != "" && storageOpt {
+		args = append(args, "--storage-opt", "size="+spec.StorageLimit)
+	}
+	if spec.ReadOnly {
+		size := spec.StorageLimit
+		if size == "" {
+			size = defaultTmpfsSize
+		}
+		for _, dir := range writableDirs {
+			args = append(args, "--tmpfs", dir+":rw,exec,mode=1777,size="+size)
+		}
+		args = append(args, "--read-only")
+	}
+	for _, c := range capDrop {
+		if !strings.EqualFold(c, "none") {
+			args = append(args, "--cap-drop", strings.ToUpper(c))
+		}
+	}
+	if noNewPrivileges && !spec.AllowSetuid {
+		args = append(args, "--security-opt", "no-new-privileges")
+	}
+	for _, u := range ulimits {
+		args = append(args, "--ulimit", u)
+	}
+	return args
+}
+
+// IsStorageOptError 判断 run 失败是否由存储驱动不支持 --storage-opt size 导致
+func IsStorageOptError(output string) bool {
+	return strings.Contains(output, "storage-opt") || strings.Contains(output, "storage option")
+}
+
+// storageOptSupported 节点是否支持 --storage-opt size（未知时先尝试，失败后记录）
+func (r *CLIRuntime) storageOptSupported() bool {
+	_, unsupported := storageOptUnsupported.Load(r.name + "|" + r.endpoint)
+	return !unsupported
+}
+
+// markStorageOptUnsupported 记录节点不支持 --storage-opt size，之后的容器不再设置磁盘配额
+func (r *CLIRuntime) markStorageOptUnsupported() {
+	if _, loaded := storageOptUnsupported.LoadOrStore(r.name+"|"+r.endpoint, true); !loaded {
+		log.Printf("[Container] 节点 %q 的存储驱动不支持磁盘配额，仅只读根文件系统的题目可限制写入量", r.node)
+	}
+}
+
+// k8sSecurityContext 生成容器的 securityContext（Kubernetes 不支持按 Pod 设置进程数与 ulimit，由 kubelet 统一配置）
+func k8sSecurityContext(spec *Spec) map[string]interface{} {
+	drop := []string{}
+	for _, c := range capDrop {
+		if !strings.EqualFold(c, "none") {
+			drop = append(drop, strings.ToUpper(c))
+		}
+	}
+	return map[string]interface{}{
+		"readOnlyRootFilesystem":   spec.ReadOnly,
+		"allowPrivilegeEscalation": !noNewPrivileges || spec.AllowSetuid,
+		"capabilities":             map[string]interface{}{"drop": drop},
+	}
+}
+
+// k8sWritableVolumes 只读根文件系统下的可写目录（内存型 emptyDir）
+func k8sWritableVolumes(spec *Spec) ([]interface{}, []interface{}) {
+	if !spec.ReadOnly {
+		return nil, nil
+	}
+	size := k8sQuantity(spec.StorageLimit)
+	if size == "" {
+		size = k8sQuantity(defaultTmpfsSize)
+	}
+	var volumes, mounts []interface{}
+	for i, dir := range writableDirs {
+		name := "writable-" + strconv.Itoa(i)
+		volumes = append(volumes, map[string]interface{}{
+			"name":     name,
+			"emptyDir": map[string]interface{}{"medium": "Memory", "sizeLimit": size},
+		})
+		mounts = append(mounts, map[string]interface{}{"name": name, "mountPath": dir})
+	}
+	return volumes, mounts
+}
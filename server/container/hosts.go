@@ -0,0 +1,295 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package container
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+// 工作节点状态
+const (
+	HostStatusActive   = "active"   // 正常调度
+	HostStatusDraining = "draining" // 管理员手动排空，不再调度新实例
+)
+
+// 连续健康检查失败达到该次数后标记为不健康
+const hostMaxFailures = 3
+
+// Host Docker 工作节点
+type Host struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Endpoint    string   `json:"endpoint"`   // 守护进程地址，空表示本机
+	PublicHost  string   `json:"publicHost"` // 选手访问地址
+	Capacity    int      `json:"capacity"`   // 最大实例数，0 表示不限
+	Labels      []string `json:"labels"`     // 节点标签
+	Status      string   `json:"status"`     // active | draining
+	Healthy     bool     `json:"healthy"`
+	FailCount   int      `json:"failCount"`
+	LastError   string   `json:"lastError"`
+	LastCheckAt string   `json:"lastCheckAt"`
+	Running     int      `json:"running"` // 运行中实例数
+	CreatedAt   string   `json:"createdAt"`
+}
+
+// ListHosts 查询所有工作节点（含运行中实例数）
+func ListHosts(db *sql.DB) ([]Host, error) {
+	rows, err := db.Query(`
+		SELECT id, name, endpoint, public_host, capacity, COALESCE(labels, '[]'), status, healthy, fail_count,
+		       COALESCE(last_error, ''), last_check_at, created_at
+		FROM docker_hosts ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	running := countRunningByNode(db)
+	hosts := []Host{}
+	for rows.Next() {
+		var h Host
+		var labelsJSON string
+		var lastCheckAt sql.NullTime
+		var createdAt time.Time
+		if err := rows.Scan(&h.ID, &h.Name, &h.Endpoint, &h.PublicHost, &h.Capacity, &labelsJSON, &h.Status, &h.Healthy,
+			&h.FailCount, &h.LastError, &lastCheckAt, &createdAt); err != nil {
+			continue
+		}
+		json.Unmarshal([]byte(labelsJSON), &h.Labels)
+		if h.Labels == nil {
+			h.Labels = []string{}
+		}
+		if lastCheckAt.Valid {
+			h.LastCheckAt = lastCheckAt.Time.Format("2006-01-02 15:04:05")
+		}
+		h.CreatedAt = createdAt.Format("2006-01-02 15:04:05")
+		h.Running = running[h.Name]
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// GetHost 查询单个工作节点
+func GetHost(db *sql.DB, id int64) (*Host, error) {
+	hosts, err := ListHosts(db)
+	if err != nil {
+		return nil, err
+	}
+	for i := range hosts {
+		if hosts[i].ID == id {
+			return &hosts[i], nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// countRunningByNode 统计各节点运行中的实例数
+func countRunningByNode(db *sql.DB) map[string]int {
+	counts := make(map[string]int)
+	rows, err := db.Query(`
+		SELECT node, COUNT(*) FROM (
+			SELECT COALESCE(node, '') AS node FROM team_instances WHERE status = 'running'
+			UNION ALL
+			SELECT COALESCE(node, '') AS node FROM team_instances_awdf WHERE status IN ('running', 'starting')
+		) t GROUP BY node`)
+	if err != nil {
+		return counts
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var node string
+		var n int
+		if rows.Scan(&node, &n) == nil {
+			counts[node] = n
+		}
+	}
+	return counts
+}
+
+// LoadHosts 从数据库加载工作节点地址到运行时
+func LoadHosts(db *sql.DB) error {
+	hosts, err := ListHosts(db)
+	if err != nil {
+		return err
+	}
+	m := make(map[string]string, len(hosts))
+	for _, h := range hosts {
+		m[h.Name] = h.Endpoint
+	}
+	SetNodes(m)
+	return nil
+}
+
+// hasLabel 节点是否接受该类型实例（无标签的节点接受所有类型）
+func (h *Host) hasLabel(kind string) bool {
+	if len(h.Labels) == 0 || kind == "" {
+		return true
+	}
+	for _, l := range h.Labels {
+		if l == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Place 为新实例选择负载最低的工作节点，返回绑定该节点的运行时
+// kind 为实例类型（team / awdf），节点配置了标签时只接受标签内的类型
+// 未注册任何节点或后端不支持多节点时返回默认后端（本机）
+func Place(db *sql.DB, kind string) (Runtime, string, error) {
+	rt := Default()
+	if _, ok := rt.(NodeRuntime); !ok {
+		return rt, "", nil
+	}
+
+	hosts, err := ListHosts(db)
+	if err != nil || len(hosts) == 0 {
+		return rt, "", nil
+	}
+
+	var best *Host
+	var bestLoad float64
+	for i := range hosts {
+		h := &hosts[i]
+		if h.Status != HostStatusActive || !h.Healthy || !h.hasLabel(kind) {
+			continue
+		}
+		if h.Capacity > 0 && h.Running >= h.Capacity {
+			continue
+		}
+		load := float64(h.Running) / 1000
+		if h.Capacity > 0 {
+			load = float64(h.Running) / float64(h.Capacity)
+		}
+		if best == nil || load < bestLoad {
+			best, bestLoad = h, load
+		}
+	}
+	if best == nil {
+		return nil, "", fmt.Errorf("没有可用的工作节点（均已满载、排空或不健康）")
+	}
+	return rt.(NodeRuntime).WithNode(best.Name, best.Endpoint), best.Name, nil
+}
+
+// Eligible 返回可调度该类型实例的所有节点（节点名称 -> 运行时），用于镜像预拉取与检查
+// 未注册任何节点或后端不支持多节点时只返回本机（节点名称为空）
+func Eligible(db *sql.DB, kind string) map[string]Runtime {
+	rt := Default()
+	nr, ok := rt.(NodeRuntime)
+	if !ok {
+		return map[string]Runtime{"": rt}
+	}
+	hosts, err := ListHosts(db)
+	if err != nil || len(hosts) == 0 {
+		return map[string]Runtime{"": rt}
+	}
+	eligible := make(map[string]Runtime)
+	for i := range hosts {
+		h := &hosts[i]
+		if h.Status != HostStatusActive || !h.Healthy || !h.hasLabel(kind) {
+			continue
+		}
+		eligible[h.Name] = nr.WithNode(h.Name, h.Endpoint)
+	}
+	return eligible
+}
+
+// IsLocalNode 节点是否为本机（端口占用可通过 net.Listen 检测）
+func IsLocalNode(node string) bool {
+	if node == "" {
+		return true
+	}
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+	return nodes[node] == ""
+}
+
+// PublicHost 获取节点的选手访问地址，未配置时返回空（前端使用当前站点域名）
+func PublicHost(db *sql.DB, node string) string {
+	if node == "" {
+		return ""
+	}
+	var host string
+	db.QueryRow(`SELECT public_host FROM docker_hosts WHERE name = $1`, node).Scan(&host)
+	return host
+}
+
+// DialHost 平台连接节点发布端口使用的地址：优先选手访问地址，其次守护进程地址中的主机名
+// 本机节点返回空（平台以 host 网络运行时直接连接容器 IP）
+func DialHost(db *sql.DB, node string) string {
+	if IsLocalNode(node) {
+		return ""
+	}
+	if host := PublicHost(db, node); host != "" {
+		return host
+	}
+	runtimesMu.RLock()
+	endpoint := nodes[node]
+	runtimesMu.RUnlock()
+	if u, err := url.Parse(endpoint); err == nil {
+		return u.Hostname()
+	}
+	return ""
+}
+
+// CheckHost 检查单个节点的健康状态并写回数据库
+func CheckHost(db *sql.DB, h *Host) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var err error
+	rt := Default()
+	if nr, ok := rt.(NodeRuntime); ok {
+		if p, ok := nr.WithNode(h.Name, h.Endpoint).(Pinger); ok {
+			err = p.Ping(ctx)
+		}
+	}
+
+	if err == nil {
+		if !h.Healthy {
+			log.Printf("[Container] 工作节点 %s 已恢复", h.Name)
+		}
+		db.Exec(`UPDATE docker_hosts SET healthy = true, fail_count = 0, last_error = NULL, last_check_at = CURRENT_TIMESTAMP WHERE id = $1`, h.ID)
+		return nil
+	}
+
+	failCount := h.FailCount + 1
+	healthy := failCount < hostMaxFailures
+	if h.Healthy && !healthy {
+		log.Printf("[Container] 工作节点 %s 连续 %d 次健康检查失败，停止调度: %v", h.Name, failCount, err)
+	}
+	db.Exec(`UPDATE docker_hosts SET healthy = $2, fail_count = $3, last_error = $4, last_check_at = CURRENT_TIMESTAMP WHERE id = $1`,
+		h.ID, healthy, failCount, err.Error())
+	return err
+}
+
+// CheckHosts 检查所有节点
+func CheckHosts(db *sql.DB) {
+	hosts, err := ListHosts(db)
+	if err != nil {
+		return
+	}
+	for i := range hosts {
+		CheckHost(db, &hosts[i])
+	}
+}
+
+// StartHostHealthChecker 启动节点健康检查任务
+func StartHostHealthChecker(db *sql.DB) {
+	if err := LoadHosts(db); err != nil {
+		log.Printf("[Container] 加载工作节点失败: %v", err)
+	}
+	ticker := time.NewTicker(30 * time.Second)
+	go func() {
+		for range ticker.C {
+			CheckHosts(db)
+		}
+	}()
+}
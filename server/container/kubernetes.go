@@ -0,0 +1,421 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KubernetesRuntime 基于 kubectl 的 Kubernetes 后端
+// 每个实例对应一个 Pod 和一个 NodePort 类型的 Service
+type KubernetesRuntime struct {
+	namespace string
+}
+
+// NewKubernetesRuntime 创建 Kubernetes 后端
+func NewKubernetesRuntime(namespace string) *KubernetesRuntime {
+	if namespace == "" {
+		namespace = "tgctf"
+	}
+	return &KubernetesRuntime{namespace: namespace}
+}
+
+// Name 后端名称
+func (r *KubernetesRuntime) Name() string {
+	return BackendKubernetes
+}
+
+// kubectl 构造 kubectl 命令（固定命名空间）
+func (r *KubernetesRuntime) kubectl(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, "kubectl", append([]string{"-n", r.namespace}, args...)...)
+}
+
+var k8sNameRe = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// k8sName 将容器名转换为合法的 Kubernetes 资源名（小写字母、数字、-，最长 63）
+func k8sName(name string) string {
+	n := k8sNameRe.ReplaceAllString(strings.ToLower(name), "-")
+	n = strings.Trim(n, "-")
+	if len(n) > 63 {
+		n = strings.Trim(n[:63], "-")
+	}
+	return n
+}
+
+// k8sQuantity 将 docker 风格的内存限制（512m / 1g）转换为 Kubernetes 数量（512Mi / 1Gi）
+func k8sQuantity(mem string) string {
+	mem = strings.TrimSpace(mem)
+	if mem == "" {
+		return ""
+	}
+	lower := strings.ToLower(mem)
+	for suffix, unit := range map[string]string{"k": "Ki", "m": "Mi", "g": "Gi", "kb": "Ki", "mb": "Mi", "gb": "Gi"} {
+		if strings.HasSuffix(lower, suffix) {
+			num := strings.TrimSuffix(lower, suffix)
+			if _, err := strconv.ParseFloat(num, 64); err == nil {
+				return num + unit
+			}
+		}
+	}
+	return mem
+}
+
+// Run 创建 Pod 和 Service
+func (r *KubernetesRuntime) Run(ctx context.Context, spec *Spec) (*Result, error) {
+	name := k8sName(spec.Name)
+	labels := map[string]string{"app": name}
+	for k, v := range spec.Labels {
+		labels[k] = v
+	}
+	if spec.Network != "" && spec.Network != NetworkFull {
+		labels["tg.egress"] = spec.Network
+	}
+
+	env := []map[string]string{}
+	for _, e := range spec.Env {
+		if idx := strings.Index(e, "="); idx > 0 {
+			env = append(env, map[string]string{"name": e[:idx], "value": e[idx+1:]})
+		}
+	}
+
+	containerPorts := []map[string]interface{}{}
+	servicePorts := []map[string]interface{}{}
+	for _, p := range spec.Ports {
+		port, err := strconv.Atoi(strings.Split(p, "/")[0])
+		if err != nil {
+			continue
+		}
+		containerPorts = append(containerPorts, map[string]interface{}{"containerPort": port})
+		servicePorts = append(servicePorts, map[string]interface{}{
+			"name":       fmt.Sprintf("p%d", port),
+			"port":       port,
+			"targetPort": port,
+		})
+	}
+
+	// 资源限制：requests 取 limits 的同值，保证调度时预留资源
+	resources := map[string]interface{}{}
+	limits := map[string]string{}
+	if spec.CPULimit != "" {
+		limits["cpu"] = spec.CPULimit
+	}
+	if q := k8sQuantity(spec.MemoryLimit); q != "" {
+		limits["memory"] = q
+	}
+	if q := k8sQuantity(spec.StorageLimit); q != "" {
+		limits["ephemeral-storage"] = q
+	}
+	if len(limits) > 0 {
+		resources["limits"] = limits
+		resources["requests"] = limits
+	}
+
+	containerSpec := map[string]interface{}{
+		"name":            "challenge",
+		"image":           spec.Image,
+		"env":             env,
+		"ports":           containerPorts,
+		"resources":       resources,
+		"securityContext": k8sSecurityContext(spec),
+	}
+	if len(spec.Args) > 0 {
+		containerSpec["args"] = spec.Args
+	}
+	volumes, mounts := k8sWritableVolumes(spec)
+	if len(mounts) > 0 {
+		containerSpec["volumeMounts"] = mounts
+	}
+	podSpec := map[string]interface{}{
+		"restartPolicy":                 "Always",
+		"automountServiceAccountToken":  false,
+		"enableServiceLinks":            false,
+		"containers":                    []interface{}{containerSpec},
+		"terminationGracePeriodSeconds": 5,
+	}
+	if len(volumes) > 0 {
+		podSpec["volumes"] = volumes
+	}
+
+	items := []interface{}{
+		map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata":   map[string]interface{}{"name": name, "labels": labels},
+			"spec":       podSpec,
+		},
+	}
+	// 受限出网策略通过 NetworkPolicy 实现（需集群网络插件支持），随实例一同 apply 保证已存在
+	if spec.Network != "" && spec.Network != NetworkFull {
+		items = append(items, k8sEgressPolicy(spec.Network))
+	}
+	// 不发布端口时使用 ClusterIP，仅集群内（平台代理）可以访问；NodePort 由集群分配
+	serviceType := "NodePort"
+	if spec.Internal {
+		serviceType = "ClusterIP"
+	}
+	if len(servicePorts) > 0 {
+		items = append(items, map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"name": name, "labels": labels},
+			"spec": map[string]interface{}{
+				"type":     serviceType,
+				"selector": map[string]string{"app": name},
+				"ports":    servicePorts,
+			},
+		})
+	}
+
+	manifest, _ := json.Marshal(map[string]interface{}{"apiVersion": "v1", "kind": "List", "items": items})
+	cmd := r.kubectl(ctx, "apply", "-f", "-")
+	cmd.Stdin = bytes.NewReader(manifest)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		r.Remove(context.Background(), name)
+		return nil, fmt.Errorf("kubectl apply 失败: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	// 等待 Pod 就绪
+	if output, err := r.kubectl(ctx, "wait", "--for=condition=Ready", "pod/"+name, "--timeout=60s").CombinedOutput(); err != nil {
+		r.Remove(context.Background(), name)
+		return nil, fmt.Errorf("等待 Pod 就绪失败: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	result := &Result{ID: name, Name: name, Ports: make(map[string]string)}
+	if st, err := r.Inspect(ctx, name); err == nil {
+		result.Node = st.Node
+	}
+
+	// 读取 Service 实际分配的 NodePort
+	if len(servicePorts) > 0 {
+		output, err := r.kubectl(ctx, "get", "service", name, "-o", "json").Output()
+		if err == nil {
+			var svc struct {
+				Spec struct {
+					Ports []struct {
+						Port     int `json:"port"`
+						NodePort int `json:"nodePort"`
+					} `json:"ports"`
+				} `json:"spec"`
+			}
+			if json.Unmarshal(output, &svc) == nil {
+				for _, p := range svc.Spec.Ports {
+					if spec.Internal {
+						result.Ports[strconv.Itoa(p.Port)] = ""
+					} else {
+						result.Ports[strconv.Itoa(p.Port)] = strconv.Itoa(p.NodePort)
+					}
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Start Pod 不能原地重启（kubelet 对崩溃的容器按退避时间重试），按当前定义强制替换为同名的新 Pod，
+// 环境变量、资源限制等与原 Pod 一致；Service 按标签选择 Pod，NodePort 保持不变
+func (r *KubernetesRuntime) Start(ctx context.Context, id string) error {
+	output, err := r.kubectl(ctx, "get", "pod", id, "-o", "json").CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(output), "NotFound") {
+			return fmt.Errorf("pod %s 不存在", id)
+		}
+		return fmt.Errorf("kubectl get pod 失败: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	var pod map[string]interface{}
+	if err := json.Unmarshal(output, &pod); err != nil {
+		return fmt.Errorf("解析 Pod 定义失败: %v", err)
+	}
+	// 去掉运行时字段，否则重新创建会被拒绝
+	delete(pod, "status")
+	if meta, ok := pod["metadata"].(map[string]interface{}); ok {
+		for _, k := range []string{"resourceVersion", "uid", "creationTimestamp", "managedFields"} {
+			delete(meta, k)
+		}
+	}
+	manifest, _ := json.Marshal(pod)
+	cmd := r.kubectl(ctx, "replace", "--force", "-f", "-")
+	cmd.Stdin = bytes.NewReader(manifest)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("kubectl replace 失败: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Remove 删除 Pod 和 Service
+func (r *KubernetesRuntime) Remove(ctx context.Context, id string) error {
+	output, err := r.kubectl(ctx, "delete", "pod,service", id, "--ignore-not-found", "--wait=false").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("kubectl delete 失败: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Inspect 查询 Pod 状态
+func (r *KubernetesRuntime) Inspect(ctx context.Context, id string) (*State, error) {
+	output, err := r.kubectl(ctx, "get", "pod", id, "-o", "json").CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(output), "NotFound") {
+			return &State{Exists: false}, nil
+		}
+		return nil, fmt.Errorf("kubectl get pod 失败: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	var pod struct {
+		Spec struct {
+			NodeName string `json:"nodeName"`
+		} `json:"spec"`
+		Status struct {
+			Phase             string `json:"phase"`
+			PodIP             string `json:"podIP"`
+			ContainerStatuses []struct {
+				State struct {
+					Terminated *struct {
+						ExitCode int `json:"exitCode"`
+					} `json:"terminated"`
+				} `json:"state"`
+				LastState struct {
+					Terminated *struct {
+						ExitCode int `json:"exitCode"`
+					} `json:"terminated"`
+				} `json:"lastState"`
+			} `json:"containerStatuses"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(output, &pod); err != nil {
+		return nil, fmt.Errorf("解析 Pod 状态失败: %v", err)
+	}
+
+	st := &State{
+		Exists:  true,
+		Running: pod.Status.Phase == "Running",
+		Status:  pod.Status.Phase,
+		Node:    pod.Spec.NodeName,
+		IP:      pod.Status.PodIP,
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Terminated != nil {
+			st.Running = false
+			st.ExitCode = cs.State.Terminated.ExitCode
+		} else if cs.LastState.Terminated != nil {
+			st.ExitCode = cs.LastState.Terminated.ExitCode
+		}
+	}
+	return st, nil
+}
+
+// Exec 在 Pod 内执行命令
+func (r *KubernetesRuntime) Exec(ctx context.Context, id string, cmd ...string) ([]byte, error) {
+	args := append([]string{"exec", id, "--"}, cmd...)
+	return r.kubectl(ctx, args...).CombinedOutput()
+}
+
+// Logs 获取 Pod 日志
+func (r *KubernetesRuntime) Logs(ctx context.Context, id string, tail int) ([]byte, error) {
+	return r.kubectl(ctx, "logs", "--tail", strconv.Itoa(tail), id).CombinedOutput()
+}
+
+// CopyTo 复制文件到 Pod
+func (r *KubernetesRuntime) CopyTo(ctx context.Context, id, src, dest string) error {
+	output, err := r.kubectl(ctx, "cp", src, id+":"+dest).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("kubectl cp 失败: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// List 列出带有指定标签的 Pod
+func (r *KubernetesRuntime) List(ctx context.Context, label string) ([]Container, error) {
+	output, err := r.kubectl(ctx, "get", "pods", "-l", label, "-o", "json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("kubectl get pods 失败: %v", err)
+	}
+
+	var pods struct {
+		Items []struct {
+			Metadata struct {
+				Name              string            `json:"name"`
+				Labels            map[string]string `json:"labels"`
+				CreationTimestamp time.Time         `json:"creationTimestamp"`
+			} `json:"metadata"`
+			Spec struct {
+				NodeName string `json:"nodeName"`
+			} `json:"spec"`
+			Status struct {
+				Phase string `json:"phase"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(output, &pods); err != nil {
+		return nil, fmt.Errorf("解析 Pod 列表失败: %v", err)
+	}
+
+	list := []Container{}
+	for _, p := range pods.Items {
+		list = append(list, Container{
+			ID:      p.Metadata.Name,
+			Name:    p.Metadata.Name,
+			Labels:  p.Metadata.Labels,
+			Running: p.Status.Phase == "Running",
+			Status:  p.Status.Phase,
+			Node:    p.Spec.NodeName,
+			Created: p.Metadata.CreationTimestamp,
+		})
+	}
+	return list, nil
+}
+
+// Stats 通过 metrics-server（kubectl top）查询 Pod 资源占用，仅包含 CPU 与内存
+func (r *KubernetesRuntime) Stats(ctx context.Context, ids []string) (map[string]*Stats, error) {
+	output, err := r.kubectl(ctx, "top", "pod", "-l", "tg.type", "--no-headers").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("kubectl top 失败: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	result := make(map[string]*Stats)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !wanted[fields[0]] {
+			continue
+		}
+		st := &Stats{}
+		// CPU: 250m（毫核）或 1（核）
+		if cpu := fields[1]; strings.HasSuffix(cpu, "m") {
+			v, _ := strconv.ParseFloat(strings.TrimSuffix(cpu, "m"), 64)
+			st.CPUPercent = v / 10
+		} else {
+			v, _ := strconv.ParseFloat(cpu, 64)
+			st.CPUPercent = v * 100
+		}
+		st.MemoryBytes = parseK8sBytes(fields[2])
+		result[fields[0]] = st
+	}
+	return result, nil
+}
+
+// parseK8sBytes 解析 Kubernetes 内存数量（128Mi / 1Gi / 512Ki）
+func parseK8sBytes(q string) int64 {
+	for suffix, unit := range map[string]float64{"Ki": 1 << 10, "Mi": 1 << 20, "Gi": 1 << 30, "Ti": 1 << 40} {
+		if strings.HasSuffix(q, suffix) {
+			v, _ := strconv.ParseFloat(strings.TrimSuffix(q, suffix), 64)
+			return int64(v * unit)
+		}
+	}
+	v, _ := strconv.ParseFloat(q, 64)
+	return int64(v)
+}
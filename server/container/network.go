@@ -0,0 +1,222 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package container
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 实例出网策略
+const (
+	NetworkFull     = "full"     // 不限制出网（使用默认网络）
+	NetworkPlatform = "platform" // 仅允许访问平台所在节点及 EGRESS_PLATFORM_ADDRS
+	NetworkNone     = "none"     // 禁止主动出网（仅能响应入站连接）
+)
+
+// ParseNetworkPolicy 校验出网策略，空值视为 full
+func ParseNetworkPolicy(policy string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(policy)) {
+	case "", NetworkFull:
+		return NetworkFull, true
+	case NetworkPlatform:
+		return NetworkPlatform, true
+	case NetworkNone:
+		return NetworkNone, true
+	}
+	return "", false
+}
+
+var (
+	// platformAddrs platform 策略下允许访问的地址（IP 或 CIDR，逗号分隔）
+	platformAddrs = splitAddrs(os.Getenv("EGRESS_PLATFORM_ADDRS"))
+	// networkHelperImage 下发 iptables 规则的辅助镜像（需包含 iptables，以 host 网络运行）
+	networkHelperImage = envOr("NETWORK_POLICY_HELPER_IMAGE", "nicolaka/netshoot")
+	// iptablesBin 与宿主机一致的 iptables 命令（iptables / iptables-legacy / iptables-nft）
+	iptablesBin = envOr("NETWORK_POLICY_IPTABLES", "iptables")
+
+	// preparedNetworks 最近一次确认网络与规则就绪的时间（endpoint|policy -> time.Time），避免每次创建实例都下发
+	preparedNetworks sync.Map
+)
+
+// networkRecheckInterval 网络与出网规则的复查间隔（守护进程重启或 iptables 被清空后规则会丢失，需要重新下发）
+const networkRecheckInterval = time.Minute
+
+func envOr(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+func splitAddrs(s string) []string {
+	var addrs []string
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// networkName 受限策略对应的容器网络名称
+func networkName(policy string) string {
+	return "tgctf-egress-" + policy
+}
+
+// bridgeName 受限策略对应的网桥名称（固定名称便于 iptables 按接口匹配，长度不超过 15）
+func bridgeName(policy string) string {
+	return "tg-egr-" + policy
+}
+
+// chainName 受限策略对应的 iptables 链
+func chainName(policy string) string {
+	return "TGCTF-EGRESS-" + strings.ToUpper(policy)
+}
+
+// egressScript 生成下发出网规则的脚本（幂等，可重复执行）
+// 规则已存在时（iptables -C 检查通过）直接退出，不重建策略链；否则重新下发
+// 容器转发流量统一跳转到策略链：放行已建立的连接（入站访问的回包）、平台地址，其余丢弃
+// none 策略同时拦截访问宿主机本身的新连接；platform 策略允许访问宿主机（平台所在节点）
+func egressScript(policy string) string {
+	ipt, chain, iface := iptablesBin, chainName(policy), bridgeName(policy)
+	var b strings.Builder
+	b.WriteString("set -e\n")
+	fmt.Fprintf(&b, "FWD=FORWARD; %s -n -L DOCKER-USER >/dev/null 2>&1 && FWD=DOCKER-USER\n", ipt)
+	check := fmt.Sprintf("%s -C %s -j DROP 2>/dev/null && %s -C $FWD -i %s -j %s 2>/dev/null", ipt, chain, ipt, iface, chain)
+	if policy == NetworkNone {
+		check += fmt.Sprintf(" && %s -C INPUT -i %s -j %s 2>/dev/null", ipt, iface, chain)
+	}
+	fmt.Fprintf(&b, "if %s; then exit 0; fi\n", check)
+	fmt.Fprintf(&b, "%s -N %s 2>/dev/null || %s -F %s\n", ipt, chain, ipt, chain)
+	fmt.Fprintf(&b, "%s -A %s -m conntrack --ctstate ESTABLISHED,RELATED -j RETURN\n", ipt, chain)
+	if policy == NetworkPlatform {
+		for _, addr := range platformAddrs {
+			fmt.Fprintf(&b, "%s -A %s -d %s -j RETURN\n", ipt, chain, addr)
+		}
+	}
+	fmt.Fprintf(&b, "%s -A %s -j DROP\n", ipt, chain)
+	fmt.Fprintf(&b, "%s -C $FWD -i %s -j %s 2>/dev/null || %s -I $FWD -i %s -j %s\n", ipt, iface, chain, ipt, iface, chain)
+	if policy == NetworkNone {
+		fmt.Fprintf(&b, "%s -C INPUT -i %s -j %s 2>/dev/null || %s -I INPUT -i %s -j %s\n", ipt, iface, chain, ipt, iface, chain)
+	}
+	return b.String()
+}
+
+// ensureNetwork 确保节点上存在受限策略的网络及 iptables 规则，返回应使用的网络名
+// full 策略返回空字符串（使用默认网络）；准备失败时返回错误，不会退化为不受限网络
+func (r *CLIRuntime) ensureNetwork(ctx context.Context, policy string) (string, error) {
+	if policy == "" || policy == NetworkFull {
+		return "", nil
+	}
+	name := networkName(policy)
+	key := r.name + "|" + r.endpoint + "|" + policy
+	if at, ok := preparedNetworks.Load(key); ok && time.Since(at.(time.Time)) < networkRecheckInterval {
+		return name, nil
+	}
+
+	if err := r.command(ctx, "network", "inspect", name).Run(); err != nil {
+		args := []string{"network", "create", "--driver", "bridge"}
+		if r.bin == "podman" {
+			args = append(args, "--interface-name", bridgeName(policy))
+		} else {
+			// 禁止实例之间互访；none 策略关闭 NAT，即使规则失效也无法访问外网
+			args = append(args, "--opt", "com.docker.network.bridge.name="+bridgeName(policy),
+				"--opt", "com.docker.network.bridge.enable_icc=false")
+			if policy == NetworkNone {
+				args = append(args, "--opt", "com.docker.network.bridge.enable_ip_masquerade=false")
+			}
+		}
+		args = append(args, "--label", "tg.type=egress", name)
+		if output, err := r.command(ctx, args...).CombinedOutput(); err != nil && !strings.Contains(string(output), "already exists") {
+			return "", fmt.Errorf("创建网络 %s 失败: %v, output: %s", name, err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	if err := r.applyEgressRules(ctx, policy); err != nil {
+		return "", err
+	}
+	preparedNetworks.Store(key, time.Now())
+	return name, nil
+}
+
+// applyEgressRules 通过辅助容器在节点上检查并下发出网规则
+func (r *CLIRuntime) applyEgressRules(ctx context.Context, policy string) error {
+	output, err := r.command(ctx, "run", "--rm", "--network", "host", "--cap-add", "NET_ADMIN", "--cap-add", "NET_RAW",
+		"--entrypoint", "sh", networkHelperImage, "-c", egressScript(policy)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("下发出网规则失败: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// RecheckEgressRules 复查各节点受限网络的出网规则（由实例巡检任务定期调用）
+// 守护进程重启或 iptables 被清空后重新下发，已运行的实例无需等到下次创建实例即可恢复限制
+func RecheckEgressRules() {
+	for _, rt := range All() {
+		r, ok := rt.(*CLIRuntime)
+		if !ok {
+			continue
+		}
+		for _, policy := range []string{NetworkPlatform, NetworkNone} {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			if r.command(ctx, "network", "inspect", networkName(policy)).Run() == nil {
+				if err := r.applyEgressRules(ctx, policy); err != nil {
+					node := r.node
+					if node == "" {
+						node = "本机"
+					}
+					log.Printf("[Container] 节点 %s 复查 %s 出网规则失败: %v", node, policy, err)
+				} else {
+					preparedNetworks.Store(r.name+"|"+r.endpoint+"|"+policy, time.Now())
+				}
+			}
+			cancel()
+		}
+	}
+}
+
+// k8sEgressPolicy 生成限制出网的 NetworkPolicy（按 tg.egress 标签选择 Pod）
+// platform 策略放行 EGRESS_PLATFORM_ADDRS 与集群 DNS
+func k8sEgressPolicy(policy string) map[string]interface{} {
+	egress := []interface{}{}
+	if policy == NetworkPlatform {
+		to := []interface{}{}
+		for _, addr := range platformAddrs {
+			if !strings.Contains(addr, "/") {
+				addr += "/32"
+			}
+			to = append(to, map[string]interface{}{"ipBlock": map[string]string{"cidr": addr}})
+		}
+		if len(to) > 0 {
+			egress = append(egress, map[string]interface{}{"to": to})
+		}
+		egress = append(egress, map[string]interface{}{
+			"to": []interface{}{map[string]interface{}{
+				"namespaceSelector": map[string]interface{}{},
+				"podSelector":       map[string]interface{}{"matchLabels": map[string]string{"k8s-app": "kube-dns"}},
+			}},
+			"ports": []interface{}{
+				map[string]interface{}{"protocol": "UDP", "port": 53},
+				map[string]interface{}{"protocol": "TCP", "port": 53},
+			},
+		})
+	}
+	return map[string]interface{}{
+		"apiVersion": "networking.k8s.io/v1",
+		"kind":       "NetworkPolicy",
+		"metadata":   map[string]interface{}{"name": "tgctf-egress-" + policy},
+		"spec": map[string]interface{}{
+			"podSelector": map[string]interface{}{"matchLabels": map[string]string{"tg.egress": policy}},
+			"policyTypes": []string{"Egress"},
+			"egress":      egress,
+		},
+	}
+}
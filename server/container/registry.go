@@ -0,0 +1,75 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DefaultRegistry 镜像名未指定仓库地址时使用的仓库
+const DefaultRegistry = "docker.io"
+
+// RegistryAuth 私有镜像仓库凭证
+type RegistryAuth struct {
+	Registry string `json:"registry"` // 仓库地址，如 registry.example.com:5000
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// RegistryClient 支持仓库登录与摘要查询的后端（镜像预拉取使用）
+type RegistryClient interface {
+	// Login 登录私有仓库（凭证保存在平台所在机器的客户端配置中，远程节点拉取时同样生效）
+	Login(ctx context.Context, auth RegistryAuth) error
+	// ImageDigests 查询本地镜像的仓库摘要（repo@sha256:...），本地构建的镜像没有摘要
+	ImageDigests(ctx context.Context, image string) ([]string, error)
+}
+
+// ImageRegistry 解析镜像所在的仓库地址（第一段包含 . 或 : 或为 localhost 时视为仓库地址）
+func ImageRegistry(image string) string {
+	i := strings.Index(image, "/")
+	if i < 0 {
+		return DefaultRegistry
+	}
+	first := image[:i]
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return first
+	}
+	return DefaultRegistry
+}
+
+// PinnedDigest 镜像名中固定的摘要（name@sha256:...），未固定时返回空
+func PinnedDigest(image string) string {
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		return image[i+1:]
+	}
+	return ""
+}
+
+// Login 登录私有仓库（密码通过标准输入传入，不出现在进程参数中）
+func (r *CLIRuntime) Login(ctx context.Context, auth RegistryAuth) error {
+	cmd := r.command(ctx, "login", "--username", auth.Username, "--password-stdin", auth.Registry)
+	cmd.Stdin = strings.NewReader(auth.Password)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s login %s 失败: %v, output: %s", r.bin, auth.Registry, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// ImageDigests 查询本地镜像的仓库摘要
+func (r *CLIRuntime) ImageDigests(ctx context.Context, image string) ([]string, error) {
+	output, err := r.command(ctx, "image", "inspect", "--format", "{{json .RepoDigests}}", image).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s image inspect 失败: %v, output: %s", r.bin, err, strings.TrimSpace(string(output)))
+	}
+	var digests []string
+	if err := json.Unmarshal(output, &digests); err != nil {
+		return nil, fmt.Errorf("解析镜像摘要失败: %v", err)
+	}
+	return digests, nil
+}
@@ -0,0 +1,248 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 支持的容器后端
+const (
+	BackendDocker     = "docker"
+	BackendPodman     = "podman"
+	BackendKubernetes = "kubernetes"
+)
+
+// Spec 容器创建参数（与具体后端无关）
+type Spec struct {
+	Name         string            // 容器名称
+	Image        string            // 镜像名
+	Ports        []string          // 容器内端口: ["80", "22"]
+	HostPorts    []int             // 与 Ports 一一对应的宿主机端口，为空时由后端自动分配（Kubernetes 忽略，NodePort 由集群分配）
+	CPULimit     string            // 如: "1.0"
+	MemoryLimit  string            // 如: "512m"
+	Env          []string          // 环境变量: ["FLAG=flag{...}"]
+	Args         []string          // 追加到镜像名之后的命令行参数
+	Labels       map[string]string // 标签: tg.type / tg.team_id / tg.challenge_id ...
+	Internal     bool              // 不发布宿主机端口，仅通过平台代理访问
+	Network      string            // 出网策略: full | platform | none，为空等同 full
+	StorageLimit string            // 磁盘配额，如: "1g"（节点不支持时仅对只读根文件系统的 tmpfs 生效）
+	PidsLimit    int               // 进程数上限，0 表示不限
+	ReadOnly     bool              // 只读根文件系统（/tmp 等可写目录挂载为 tmpfs）
+	AllowSetuid  bool              // 允许容器内提权（不设置 no-new-privileges，供依赖 setuid 程序的题目使用）
+}
+
+// Result 容器创建结果
+type Result struct {
+	ID    string            // 容器ID（Kubernetes 后端为 Pod 名称）
+	Name  string            // 容器名称
+	Ports map[string]string // 端口映射: {"80": "32768"}
+	Node  string            // 所在节点（本机 Docker 为空）
+}
+
+// State 容器运行状态
+type State struct {
+	Exists   bool   // 容器是否存在
+	Running  bool   // 是否运行中
+	Status   string // 原始状态: running | exited | Pending ...
+	ExitCode int    // 退出码
+	Node     string // 所在节点
+	IP       string // 容器IP
+}
+
+// Container 容器列表项
+type Container struct {
+	ID      string            // 容器ID
+	Name    string            // 容器名称
+	Labels  map[string]string // 标签
+	Running bool              // 是否运行中
+	Status  string            // 原始状态
+	Node    string            // 所在节点
+	Created time.Time         // 创建时间
+}
+
+// Runtime 容器运行时接口，所有容器操作都经由此接口完成
+type Runtime interface {
+	// Name 后端名称（写入 team_instances.backend）
+	Name() string
+	// Run 创建并启动容器
+	Run(ctx context.Context, spec *Spec) (*Result, error)
+	// Start 启动已停止的容器（保留原有环境变量与端口映射；Kubernetes 重建同名 Pod）
+	Start(ctx context.Context, id string) error
+	// Remove 强制删除容器（不存在时不返回错误）
+	Remove(ctx context.Context, id string) error
+	// Inspect 查询容器状态
+	Inspect(ctx context.Context, id string) (*State, error)
+	// Exec 在容器内执行命令
+	Exec(ctx context.Context, id string, cmd ...string) ([]byte, error)
+	// Logs 获取容器最后 tail 行日志
+	Logs(ctx context.Context, id string, tail int) ([]byte, error)
+	// CopyTo 将本地文件复制到容器内
+	CopyTo(ctx context.Context, id, src, dest string) error
+	// List 列出带有指定标签的所有容器（包括已停止的）
+	List(ctx context.Context, label string) ([]Container, error)
+}
+
+// NodeRuntime 支持绑定到远程工作节点的后端（docker / podman）
+type NodeRuntime interface {
+	WithNode(node, endpoint string) Runtime
+}
+
+// Pinger 支持健康检查的后端
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ImagePuller 支持单独拉取镜像的后端（创建实例时可上报拉取进度）
+type ImagePuller interface {
+	// HasImage 镜像是否已存在于节点本地
+	HasImage(ctx context.Context, image string) bool
+	// Pull 拉取镜像
+	Pull(ctx context.Context, image string) error
+}
+
+// ImageBuilder 支持从 Dockerfile 构建镜像的后端
+type ImageBuilder interface {
+	// BuildImage 使用本地构建上下文目录构建镜像并打标签，构建输出写入 out
+	BuildImage(ctx context.Context, contextDir, tag string, out io.Writer) error
+}
+
+// Stats 容器资源占用采样
+type Stats struct {
+	CPUPercent  float64 // CPU 占用百分比（100 表示占满一个核心）
+	MemoryBytes int64   // 内存占用
+	MemoryLimit int64   // 内存上限（未知时为 0）
+	NetRxBytes  int64   // 累计接收字节数
+	NetTxBytes  int64   // 累计发送字节数
+	PIDs        int     // 进程数
+}
+
+// StatsReader 支持资源占用采样的后端
+type StatsReader interface {
+	// Stats 批量查询容器资源占用，返回 容器ID -> 采样（ID 与 Run 返回的一致），已停止或不存在的容器不包含在结果中
+	Stats(ctx context.Context, ids []string) (map[string]*Stats, error)
+}
+
+var (
+	runtimesMu     sync.RWMutex
+	runtimes       = make(map[string]Runtime)
+	defaultBackend = BackendDocker
+	nodes          = make(map[string]string) // 工作节点名称 -> 守护进程地址
+)
+
+func init() {
+	Register(NewCLIRuntime(BackendDocker, "docker"))
+	Register(NewCLIRuntime(BackendPodman, "podman"))
+	Register(NewKubernetesRuntime(os.Getenv("K8S_NAMESPACE")))
+}
+
+// Register 注册容器后端
+func Register(rt Runtime) {
+	runtimesMu.Lock()
+	defer runtimesMu.Unlock()
+	runtimes[rt.Name()] = rt
+}
+
+// Init 根据配置选择默认后端（CONTAINER_BACKEND: docker | podman | kubernetes）
+func Init(backend string) error {
+	backend = strings.ToLower(strings.TrimSpace(backend))
+	if backend == "" {
+		backend = BackendDocker
+	}
+	if backend == "k8s" {
+		backend = BackendKubernetes
+	}
+	runtimesMu.Lock()
+	defer runtimesMu.Unlock()
+	if _, ok := runtimes[backend]; !ok {
+		return fmt.Errorf("unknown container backend: %s", backend)
+	}
+	defaultBackend = backend
+	log.Printf("[Container] 使用容器后端: %s", backend)
+	return nil
+}
+
+// Default 获取默认容器后端（新建实例使用）
+func Default() Runtime {
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+	return runtimes[defaultBackend]
+}
+
+// Get 根据实例记录的后端名称获取运行时，为空或未知时回退到默认后端
+func Get(backend string) Runtime {
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+	if rt, ok := runtimes[backend]; ok {
+		return rt
+	}
+	return runtimes[defaultBackend]
+}
+
+// For 根据实例记录的后端和节点获取运行时
+// 节点为已注册的工作节点时返回绑定该节点的运行时，否则等同于 Get
+func For(backend, node string) Runtime {
+	rt := Get(backend)
+	if node == "" {
+		return rt
+	}
+	runtimesMu.RLock()
+	endpoint, ok := nodes[node]
+	runtimesMu.RUnlock()
+	if nr, isNode := rt.(NodeRuntime); isNode && ok {
+		return nr.WithNode(node, endpoint)
+	}
+	return rt
+}
+
+// All 返回默认后端在所有节点上的运行时（本机 + 已注册的工作节点）
+func All() []Runtime {
+	rt := Default()
+	nr, ok := rt.(NodeRuntime)
+	if !ok {
+		return []Runtime{rt}
+	}
+	runtimesMu.RLock()
+	defer runtimesMu.RUnlock()
+	all := []Runtime{rt}
+	for node, endpoint := range nodes {
+		if endpoint == "" {
+			continue // 本机节点已包含
+		}
+		all = append(all, nr.WithNode(node, endpoint))
+	}
+	return all
+}
+
+// SetNodes 替换已注册的工作节点列表
+func SetNodes(m map[string]string) {
+	runtimesMu.Lock()
+	defer runtimesMu.Unlock()
+	nodes = m
+}
+
+// FlagEnv 根据 flag_env 配置生成环境变量和命令行参数
+// flag_env 支持逗号分隔多个变量名，CMDARG / $1 表示将 flag 作为命令行参数传入
+func FlagEnv(flagEnv, flag string) (env []string, args []string) {
+	if strings.TrimSpace(flagEnv) == "" {
+		return []string{"FLAG=" + flag}, nil
+	}
+	for _, en := range strings.Split(flagEnv, ",") {
+		en = strings.TrimSpace(en)
+		if en == "CMDARG" || en == "$1" {
+			args = []string{flag}
+		} else if en != "" {
+			env = append(env, fmt.Sprintf("%s=%s", en, flag))
+		}
+	}
+	return env, args
+}
@@ -0,0 +1,165 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package container
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// sandboxImage 沙箱默认镜像（SANDBOX_IMAGE），需包含 sh 与脚本使用的解释器
+	sandboxImage = envOr("SANDBOX_IMAGE", "python:3.12-alpine")
+	// sandboxCPUs 沙箱 CPU 上限（SANDBOX_CPUS）
+	sandboxCPUs = envOr("SANDBOX_CPUS", "1")
+	// sandboxMemory 沙箱内存上限（SANDBOX_MEMORY）
+	sandboxMemory = envOr("SANDBOX_MEMORY", "256m")
+)
+
+const (
+	// 沙箱默认执行时间上限
+	defaultSandboxTimeout = 60 * time.Second
+	// 沙箱标准输出 / 标准错误各自保留的最大字节数
+	SandboxOutputLimit = 16 << 20
+	// 沙箱内脚本路径（/tmp 为 tmpfs）
+	sandboxScriptPath = "/tmp/script"
+)
+
+// SandboxSpec 沙箱脚本执行参数
+type SandboxSpec struct {
+	Script  string        // 脚本内容，首行 #! 指定解释器，未指定时使用 sh
+	Args    []string      // 脚本参数
+	Env     []string      // 环境变量: ["TARGET_HOST=127.0.0.1"]
+	Image   string        // 沙箱镜像，为空时使用 SANDBOX_IMAGE
+	Network string        // 网络: 为空时禁止联网；container:<id> 共享目标容器的网络命名空间（通过 127.0.0.1 访问题目）
+	Timeout time.Duration // 执行时间上限，为空时 60 秒
+}
+
+// SandboxResult 沙箱执行结果
+type SandboxResult struct {
+	Stdout   []byte        // 标准输出（超出上限时截断）
+	Stderr   []byte        // 标准错误（超出上限时截断）
+	ExitCode int           // 脚本退出码
+	TimedOut bool          // 是否超时被终止
+	Duration time.Duration // 执行耗时
+}
+
+// SandboxRunner 支持在一次性隔离容器中执行脚本的运行时（解题脚本、附件生成脚本等）
+// 沙箱不挂载宿主机目录、移除全部 Linux 能力、以 nobody 用户运行，并限制 CPU / 内存 / 进程数 / 执行时间
+type SandboxRunner interface {
+	RunSandbox(ctx context.Context, spec *SandboxSpec) (*SandboxResult, error)
+}
+
+// limitedBuffer 超出上限后丢弃后续写入的缓冲区
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// interpreter 解析脚本首行 #! 得到解释器命令，未指定时使用 sh
+func interpreter(script string) []string {
+	if !strings.HasPrefix(script, "#!") {
+		return []string{"sh"}
+	}
+	line := script[2:]
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	if fields := strings.Fields(line); len(fields) > 0 {
+		return fields
+	}
+	return []string{"sh"}
+}
+
+// RunSandbox 在一次性容器中执行脚本，脚本内容通过标准输入传入
+func (r *CLIRuntime) RunSandbox(ctx context.Context, spec *SandboxSpec) (*SandboxResult, error) {
+	image := spec.Image
+	if image == "" {
+		image = sandboxImage
+	}
+	network := spec.Network
+	if network == "" {
+		network = "none"
+	}
+	timeout := spec.Timeout
+	if timeout <= 0 {
+		timeout = defaultSandboxTimeout
+	}
+
+	name := fmt.Sprintf("tg_sandbox_%d", time.Now().UnixNano())
+	args := []string{"run", "--rm", "-i", "--name", name,
+		"--label", "tg.type=sandbox",
+		"--network", network,
+		"--cpus", sandboxCPUs, "-m", sandboxMemory,
+		"--pids-limit", strconv.Itoa(128),
+		"--read-only", "--tmpfs", "/tmp:rw,exec,mode=1777,size=" + defaultTmpfsSize,
+		"--cap-drop", "ALL", "--security-opt", "no-new-privileges",
+		"--user", "65534:65534", "-w", "/tmp", "-e", "HOME=/tmp",
+	}
+	for _, e := range spec.Env {
+		args = append(args, "-e", e)
+	}
+	// 先将标准输入写入脚本文件，再用解释器执行（脚本自身不再占用标准输入）
+	args = append(args, image, "sh", "-c", `cat > "$0" && exec "$@" < /dev/null`, sandboxScriptPath)
+	args = append(args, interpreter(spec.Script)...)
+	args = append(args, sandboxScriptPath)
+	args = append(args, spec.Args...)
+
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: SandboxOutputLimit}
+	stderr := &limitedBuffer{limit: SandboxOutputLimit}
+	cmd := r.command(runCtx, args...)
+	cmd.Stdin = strings.NewReader(spec.Script)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	result := &SandboxResult{Duration: time.Since(start)}
+
+	if runCtx.Err() != nil {
+		// 终止客户端不会停止容器，需要显式删除
+		rmCtx, rmCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		r.command(rmCtx, "rm", "-f", name).Run()
+		rmCancel()
+		result.TimedOut = errors.Is(runCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+		if !result.TimedOut {
+			return nil, ctx.Err()
+		}
+		result.ExitCode = -1
+	} else if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("%s run 失败: %v", r.bin, err)
+		}
+		// 125 为容器创建失败（镜像不存在、参数错误等），不属于脚本本身的退出码
+		if exitErr.ExitCode() == 125 {
+			return nil, fmt.Errorf("%s run 失败: %s", r.bin, strings.TrimSpace(stderr.String()))
+		}
+		result.ExitCode = exitErr.ExitCode()
+	}
+	result.Stdout = stdout.Bytes()
+	result.Stderr = stderr.Bytes()
+	return result, nil
+}
@@ -0,0 +1,127 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package container
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/creack/pty"
+)
+
+// shellProbe 优先使用 bash，镜像中没有时退回 sh
+const shellProbe = "if command -v bash >/dev/null 2>&1; then exec bash -il; else exec sh -i; fi"
+
+// Terminal 交互式终端会话：exec 命令运行在平台本地的 PTY 中，读写即终端的输出与输入
+type Terminal struct {
+	pty    *os.File
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+// TerminalOpener 支持交互式终端的运行时（docker / podman exec -it、kubectl exec -it）
+type TerminalOpener interface {
+	OpenTerminal(id string, cols, rows uint16) (*Terminal, error)
+}
+
+// Transcript 终端会话记录，超出上限后截断（用于审计）
+type Transcript struct {
+	mu        sync.Mutex
+	buf       []byte
+	limit     int
+	truncated bool
+}
+
+// NewTranscript 创建会话记录，limit 为保留的最大字节数
+func NewTranscript(limit int) *Transcript {
+	return &Transcript{limit: limit}
+}
+
+// Write 追加记录（永不失败，超出部分丢弃）
+func (t *Transcript) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(p)
+	if room := t.limit - len(t.buf); room < len(p) {
+		p = p[:room]
+		t.truncated = true
+	}
+	t.buf = append(t.buf, p...)
+	return n, nil
+}
+
+// String 返回记录内容（非法 UTF-8 字节替换为 U+FFFD，便于入库）
+func (t *Transcript) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.ToValidUTF8(string(t.buf), "\uFFFD")
+}
+
+// Truncated 记录是否被截断
+func (t *Transcript) Truncated() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.truncated
+}
+
+// startTerminal 在 PTY 中启动 exec 命令
+func startTerminal(cmd *exec.Cmd, cancel context.CancelFunc, cols, rows uint16) (*Terminal, error) {
+	if cols == 0 || rows == 0 {
+		cols, rows = 120, 32
+	}
+	f, err := pty.StartWithSize(cmd, &pty.Winsize{Cols: cols, Rows: rows})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("启动终端失败: %v", err)
+	}
+	return &Terminal{pty: f, cmd: cmd, cancel: cancel}, nil
+}
+
+// Read 读取终端输出
+func (t *Terminal) Read(p []byte) (int, error) {
+	return t.pty.Read(p)
+}
+
+// Write 写入终端输入
+func (t *Terminal) Write(p []byte) (int, error) {
+	return t.pty.Write(p)
+}
+
+// Resize 调整终端窗口大小
+func (t *Terminal) Resize(cols, rows uint16) error {
+	if cols == 0 || rows == 0 {
+		return nil
+	}
+	return pty.Setsize(t.pty, &pty.Winsize{Cols: cols, Rows: rows})
+}
+
+// Close 结束会话（终止 exec 进程并释放 PTY）
+func (t *Terminal) Close() error {
+	t.once.Do(func() {
+		t.cancel()
+		t.pty.Close()
+		t.cmd.Wait()
+	})
+	return nil
+}
+
+// OpenTerminal 在容器内打开交互式 shell
+func (r *CLIRuntime) OpenTerminal(id string, cols, rows uint16) (*Terminal, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := r.command(ctx, "exec", "-it", "-e", "TERM=xterm-256color", id, "sh", "-c", shellProbe)
+	return startTerminal(cmd, cancel, cols, rows)
+}
+
+// OpenTerminal 在 Pod 内打开交互式 shell
+func (r *KubernetesRuntime) OpenTerminal(id string, cols, rows uint16) (*Terminal, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := r.kubectl(ctx, "exec", "-it", id, "--", "env", "TERM=xterm-256color", "sh", "-c", shellProbe)
+	return startTerminal(cmd, cancel, cols, rows)
+}
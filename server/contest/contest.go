@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -23,6 +24,12 @@ var GenerateFlagsForTeamInContest func(db *sql.DB, contestID string, teamID int6
 // OnAWDFContestStatusChange AWD-F 比赛状态变更钩子
 var OnAWDFContestStatusChange func(db *sql.DB, contestID int64, oldStatus, newStatus, mode string)
 
+// CheckContestImages 检查比赛引用的镜像是否已在节点就绪，返回缺失项（由 main 注入 docker.MissingContestImages）
+var CheckContestImages func(db *sql.DB, contestID int64) []string
+
+// imageWarned 已提示过镜像缺失的比赛，避免自动开始检查每30秒重复打印
+var imageWarned sync.Map
+
 // StartContestStatusUpdater 启动比赛状态自动更新定时器
 func StartContestStatusUpdater(db *sql.DB) {
 	ticker := time.NewTicker(30 * time.Second) // 每30秒检查一次
@@ -50,6 +57,16 @@ func autoUpdateContestStatus(db *sql.DB) {
 			if err := startRows.Scan(&contestID, &mode); err != nil {
 				continue
 			}
+			// 镜像未就绪时不自动开始，等待管理员执行镜像预拉取
+			if CheckContestImages != nil {
+				if missing := CheckContestImages(db, contestID); len(missing) > 0 {
+					if _, warned := imageWarned.LoadOrStore(contestID, true); !warned {
+						log.Printf("[Contest] 比赛 %d 缺少镜像，暂不自动开始: %s", contestID, strings.Join(missing, ", "))
+					}
+					continue
+				}
+				imageWarned.Delete(contestID)
+			}
 			// 更新状态
 			_, err := db.Exec(`UPDATE contests SET status = 'running', updated_at = NOW() WHERE id = $1`, contestID)
 			if err != nil {
@@ -388,6 +405,14 @@ func HandleUpdateContest(c *gin.Context, db *sql.DB) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_STATUS"})
 			return
 		}
+		// 开始比赛前检查镜像是否已在所有节点就绪
+		if req.Status == "running" && oldStatus == "pending" && CheckContestImages != nil {
+			contestID, _ := strconv.ParseInt(id, 10, 64)
+			if missing := CheckContestImages(db, contestID); len(missing) > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "IMAGES_MISSING", "message": "比赛镜像未就绪，请先执行镜像预拉取", "missing": missing})
+				return
+			}
+		}
 		updates = append(updates, "status = $"+strconv.Itoa(argIndex))
 		args = append(args, req.Status)
 		argIndex++
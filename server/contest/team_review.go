@@ -536,7 +536,7 @@ func HandleAllocatePorts(c *gin.Context, db *sql.DB) {
 			WHERE cc.contest_id = $1 AND q.docker_image IS NOT NULL AND q.docker_image != ''`
 	} else {
 		challengeQuery = `SELECT q.ports FROM contest_challenges cc 
-			JOIN question_bank q ON cc.question_id = q.id 
+			JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version 
 			WHERE cc.contest_id = $1 AND q.docker_image IS NOT NULL AND q.docker_image != ''`
 	}
 
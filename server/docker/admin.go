@@ -18,6 +18,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"tgctf/server/container"
 	"tgctf/server/logs"
 )
 
@@ -39,6 +40,9 @@ type AdminInstanceInfo struct {
 	ExpiresAt     string            `json:"expiresAt"`
 	CreatedAt     string            `json:"createdAt"`
 	IsExpired     bool              `json:"isExpired"`
+	Backend       string            `json:"backend"` // 容器后端: docker | podman | kubernetes
+	Node          string            `json:"node"`    // 所在节点
+	Source        string            `json:"source"`  // 实例来源: jeopardy | awdf
 }
 
 // HandleAdminListInstances 获取所有容器实例列表
@@ -57,12 +61,13 @@ func HandleAdminListInstances(c *gin.Context, db *sql.DB) {
 				ti.challenge_id, COALESCE(q.title, cc.inline_title, '未知题目') as challenge_name,
 				COALESCE(ti.created_by, 0), COALESCE(u.display_name, '-') as user_name,
 				COALESCE(ti.ports, '{}') as ports, ti.status, ti.expires_at, ti.created_at,
+				COALESCE(ti.backend, '') as backend, COALESCE(ti.node, '') as node,
 				'jeopardy' as source_table
 			FROM team_instances ti
 			LEFT JOIN teams t ON ti.team_id = t.id
 			LEFT JOIN contests ct ON ti.contest_id = ct.id
 			LEFT JOIN contest_challenges cc ON ti.challenge_id = cc.id
-			LEFT JOIN question_bank q ON cc.question_id = q.id
+			LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
 			LEFT JOIN users u ON ti.created_by = u.id
 			UNION ALL
 			SELECT 
@@ -72,6 +77,7 @@ func HandleAdminListInstances(c *gin.Context, db *sql.DB) {
 				tia.challenge_id, COALESCE(qa.title, '未知题目') as challenge_name,
 				COALESCE(tia.created_by, 0), COALESCE(u.display_name, '系统') as user_name,
 				COALESCE(tia.ports, '{}') as ports, tia.status, tia.expires_at, tia.created_at,
+				COALESCE(tia.backend, '') as backend, COALESCE(tia.node, '') as node,
 				'awdf' as source_table
 			FROM team_instances_awdf tia
 			LEFT JOIN teams t ON tia.team_id = t.id
@@ -123,13 +129,12 @@ func HandleAdminListInstances(c *gin.Context, db *sql.DB) {
 		var inst AdminInstanceInfo
 		var portsJSON string
 		var expiresAt, createdAt time.Time
-		var sourceTable string
 		err := rows.Scan(&inst.ID, &inst.ContainerID, &inst.ContainerName,
 			&inst.TeamID, &inst.TeamName,
 			&inst.ContestID, &inst.ContestName,
 			&inst.ChallengeID, &inst.ChallengeName,
 			&inst.UserID, &inst.UserName,
-			&portsJSON, &inst.Status, &expiresAt, &createdAt, &sourceTable)
+			&portsJSON, &inst.Status, &expiresAt, &createdAt, &inst.Backend, &inst.Node, &inst.Source)
 		if err != nil {
 			log.Printf("[AdminListInstances] rows.Scan error: %v", err)
 			continue
@@ -156,10 +161,10 @@ func HandleAdminDestroyInstance(c *gin.Context, db *sql.DB) {
 	adminID := int64(claimsMap["sub"].(float64))
 
 	// 获取实例信息
-	var containerID, containerName, challengeName, teamName string
+	var containerID, containerName, challengeName, teamName, backend, node string
 	var contestID, challengeID, teamID int64
 	err := db.QueryRow(`
-		SELECT ti.container_id, ti.container_name, ti.contest_id, ti.challenge_id, ti.team_id,
+		SELECT ti.container_id, ti.container_name, ti.contest_id, ti.challenge_id, ti.team_id, COALESCE(ti.backend, ''), COALESCE(ti.node, ''),
 			CASE WHEN ct.mode = 'awd-f' 
 				THEN COALESCE(qa.title, '未知题目') 
 				ELSE COALESCE(q.title, '未知题目') 
@@ -168,11 +173,11 @@ func HandleAdminDestroyInstance(c *gin.Context, db *sql.DB) {
 		FROM team_instances ti
 		LEFT JOIN contests ct ON ti.contest_id = ct.id
 		LEFT JOIN contest_challenges cc ON ti.challenge_id = cc.id AND ct.mode != 'awd-f'
-		LEFT JOIN question_bank q ON cc.question_id = q.id
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
 		LEFT JOIN contest_challenges_awdf cca ON ti.challenge_id = cca.id AND ct.mode = 'awd-f'
 		LEFT JOIN question_bank_awdf qa ON cca.question_id = qa.id
 		LEFT JOIN teams t ON ti.team_id = t.id
-		WHERE ti.id = $1`, instanceID).Scan(&containerID, &containerName, &contestID, &challengeID, &teamID, &challengeName, &teamName)
+		WHERE ti.id = $1`, instanceID).Scan(&containerID, &containerName, &contestID, &challengeID, &teamID, &backend, &node, &challengeName, &teamName)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "实例不存在"})
 		return
@@ -181,7 +186,7 @@ func HandleAdminDestroyInstance(c *gin.Context, db *sql.DB) {
 	// 停止并删除容器
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	exec.CommandContext(ctx, "docker", "rm", "-f", containerID).Run()
+	container.For(backend, node).Remove(ctx, containerID)
 
 	// 更新数据库状态
 	_, err = db.Exec(`UPDATE team_instances SET status = 'destroyed', updated_at = CURRENT_TIMESTAMP WHERE id = $1`, instanceID)
@@ -189,6 +194,7 @@ func HandleAdminDestroyInstance(c *gin.Context, db *sql.DB) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新状态失败"})
 		return
 	}
+	wakeQueue()
 
 	// 记录日志
 	clientIP := c.ClientIP()
@@ -209,7 +215,7 @@ func HandleAdminCleanExpired(c *gin.Context, db *sql.DB) {
 
 	// 查询所有过期的运行中实例
 	rows, err := db.Query(`
-		SELECT id, container_id, container_name, team_id, contest_id, challenge_id
+		SELECT id, container_id, container_name, team_id, contest_id, challenge_id, COALESCE(backend, ''), COALESCE(node, '')
 		FROM team_instances
 		WHERE status = 'running' AND expires_at < CURRENT_TIMESTAMP`)
 	if err != nil {
@@ -225,12 +231,12 @@ func HandleAdminCleanExpired(c *gin.Context, db *sql.DB) {
 
 	for rows.Next() {
 		var id int64
-		var containerID, containerName string
+		var containerID, containerName, backend, node string
 		var teamID, contestID, challengeID int64
-		rows.Scan(&id, &containerID, &containerName, &teamID, &contestID, &challengeID)
+		rows.Scan(&id, &containerID, &containerName, &teamID, &contestID, &challengeID, &backend, &node)
 
 		// 停止容器
-		err := exec.CommandContext(ctx, "docker", "rm", "-f", containerID).Run()
+		err := container.For(backend, node).Remove(ctx, containerID)
 		if err != nil {
 			failed++
 			continue
@@ -240,6 +246,7 @@ func HandleAdminCleanExpired(c *gin.Context, db *sql.DB) {
 		db.Exec(`UPDATE team_instances SET status = 'destroyed', updated_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
 		cleaned++
 	}
+	wakeQueue()
 
 	// 记录日志
 	clientIP := c.ClientIP()
@@ -342,14 +349,14 @@ func HandleAdminBatchDestroy(c *gin.Context, db *sql.DB) {
 
 	var success, failed int
 	for _, id := range req.IDs {
-		var containerID string
-		err := db.QueryRow(`SELECT container_id FROM team_instances WHERE id = $1 AND status = 'running'`, id).Scan(&containerID)
+		var containerID, backend, node string
+		err := db.QueryRow(`SELECT container_id, COALESCE(backend, ''), COALESCE(node, '') FROM team_instances WHERE id = $1 AND status = 'running'`, id).Scan(&containerID, &backend, &node)
 		if err != nil {
 			failed++
 			continue
 		}
 
-		err = exec.CommandContext(ctx, "docker", "rm", "-f", containerID).Run()
+		err = container.For(backend, node).Remove(ctx, containerID)
 		if err != nil {
 			failed++
 			continue
@@ -358,6 +365,7 @@ func HandleAdminBatchDestroy(c *gin.Context, db *sql.DB) {
 		db.Exec(`UPDATE team_instances SET status = 'destroyed', updated_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
 		success++
 	}
+	wakeQueue()
 
 	// 记录日志
 	clientIP := c.ClientIP()
@@ -379,8 +387,8 @@ func HandleAdminGetContainerLogs(c *gin.Context, db *sql.DB) {
 	instanceID := c.Param("instanceId")
 	lines := c.DefaultQuery("lines", "100")
 
-	var containerID string
-	err := db.QueryRow(`SELECT container_id FROM team_instances WHERE id = $1`, instanceID).Scan(&containerID)
+	var containerID, backend, node string
+	err := db.QueryRow(`SELECT container_id, COALESCE(backend, ''), COALESCE(node, '') FROM team_instances WHERE id = $1`, instanceID).Scan(&containerID, &backend, &node)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "实例不存在"})
 		return
@@ -394,8 +402,7 @@ func HandleAdminGetContainerLogs(c *gin.Context, db *sql.DB) {
 		linesInt = 100
 	}
 
-	cmd := exec.CommandContext(ctx, "docker", "logs", "--tail", strconv.Itoa(linesInt), containerID)
-	output, err := cmd.CombinedOutput()
+	output, err := container.For(backend, node).Logs(ctx, containerID, linesInt)
 
 	c.JSON(http.StatusOK, gin.H{
 		"logs":        string(output),
@@ -0,0 +1,532 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tgctf/server/challengepkg"
+	"tgctf/server/container"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// 题目镜像构建：上传 Dockerfile 构建上下文（tar / zip）后在所有可调度题目实例的节点上异步构建，
+// 镜像标签为 tgctf/q-<题目ID>:<版本号>；构建日志可通过 WebSocket 实时查看，
+// 构建成功后自动更新题目的 docker_image 与 image_status，历史构建记录保留并可切换回旧版本
+
+// SaveQuestionRevision 保存题目版本快照（由 main.go 注入 question.SaveRevision，避免循环依赖）
+var SaveQuestionRevision func(db *sql.DB, questionID, userID int64) (int, error)
+
+// 镜像构建状态
+const (
+	BuildPending  = "pending"
+	BuildBuilding = "building"
+	BuildSuccess  = "success"
+	BuildFailed   = "failed"
+)
+
+const (
+	buildTimeout  = 30 * time.Minute
+	buildLogLimit = 256 * 1024 // 保存的构建日志上限（超出时保留末尾）
+)
+
+// imageBuild 进行中的构建：缓存日志并向订阅者推送
+type imageBuild struct {
+	id         int64
+	questionID int64
+	image      string
+	userID     int64 // 发起构建的管理员
+
+	mu   sync.Mutex
+	log  []byte
+	subs map[chan []byte]struct{}
+	done bool
+}
+
+// Write 追加构建日志并推送给订阅者（订阅者来不及接收时丢弃，断线重连后会重新获取完整日志）
+func (b *imageBuild) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.log = append(b.log, p...)
+	if len(b.log) > buildLogLimit {
+		b.log = b.log[len(b.log)-buildLogLimit:]
+	}
+	chunk := append([]byte(nil), p...)
+	for ch := range b.subs {
+		select {
+		case ch <- chunk:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// Logf 写入一行平台提示
+func (b *imageBuild) Logf(format string, args ...interface{}) {
+	fmt.Fprintf(b, "==> "+format+"\n", args...)
+}
+
+func (b *imageBuild) subscribe() ([]byte, chan []byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	snapshot := append([]byte(nil), b.log...)
+	if b.done {
+		return snapshot, nil, true
+	}
+	ch := make(chan []byte, 256)
+	b.subs[ch] = struct{}{}
+	return snapshot, ch, false
+}
+
+func (b *imageBuild) unsubscribe(ch chan []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+func (b *imageBuild) snapshot() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.ToValidUTF8(string(b.log), "\uFFFD")
+}
+
+func (b *imageBuild) finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = true
+	for ch := range b.subs {
+		close(ch)
+	}
+	b.subs = nil
+}
+
+var (
+	buildsMu     sync.Mutex
+	activeBuilds = make(map[int64]*imageBuild) // 题目ID -> 进行中的构建（同一题目同时只允许一个构建）
+)
+
+// RecoverImageBuilds 服务启动时将上次未完成的构建标记为失败
+func RecoverImageBuilds(db *sql.DB) {
+	db.Exec(`
+		UPDATE question_image_builds SET status = $1, error = '服务重启，构建中断', finished_at = CURRENT_TIMESTAMP
+		WHERE status IN ($2, $3)`, BuildFailed, BuildPending, BuildBuilding)
+}
+
+// buildRuntimes 可构建镜像的节点（与题目实例的调度范围一致）
+func buildRuntimes(db *sql.DB) map[string]container.ImageBuilder {
+	builders := make(map[string]container.ImageBuilder)
+	for node, rt := range container.Eligible(db, "team") {
+		if builder, ok := rt.(container.ImageBuilder); ok {
+			builders[node] = builder
+		}
+	}
+	return builders
+}
+
+// baseImages 解析 Dockerfile 中 FROM 引用的基础镜像（跳过多阶段构建中引用前序阶段的情况）
+func baseImages(dockerfile []byte) []string {
+	var images []string
+	stages := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(dockerfile))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		args := fields[1:]
+		for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if !stages[strings.ToLower(args[0])] && !strings.Contains(args[0], "$") && args[0] != "scratch" {
+			images = append(images, args[0])
+		}
+		if len(args) >= 3 && strings.EqualFold(args[1], "AS") {
+			stages[strings.ToLower(args[2])] = true
+		}
+	}
+	return images
+}
+
+// HandleStartImageBuild 上传构建上下文并开始构建: POST /questions/:id/build
+// file 字段为 tar / tar.gz / zip；不上传时使用题目已保存的构建上下文（如题目包导入时附带的 Dockerfile）
+func HandleStartImageBuild(c *gin.Context, db *sql.DB) {
+	questionID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_ID"})
+		return
+	}
+	var title string
+	if err := db.QueryRow("SELECT title FROM question_bank WHERE id = $1", questionID).Scan(&title); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "QUESTION_NOT_FOUND"})
+		return
+	}
+
+	var files map[string][]byte
+	if header, err := c.FormFile("file"); err == nil {
+		if header.Size > challengepkg.MaxPackageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "CONTEXT_TOO_LARGE", "message": "构建上下文超过大小限制"})
+			return
+		}
+		f, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "FILE_OPEN_ERROR"})
+			return
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err == nil {
+			files, err = challengepkg.ReadArchive(data)
+		}
+		if err == nil {
+			files, err = challengepkg.ContextRoot(files)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_CONTEXT", "message": err.Error()})
+			return
+		}
+		// 保存为题目的构建上下文，导出题目包时一并导出
+		if err := challengepkg.SaveContext(challengepkg.KindJeopardy, questionID, files); err != nil {
+			log.Printf("[Build] 保存题目 %d 构建上下文失败: %v", questionID, err)
+		}
+	} else {
+		files = challengepkg.LoadContext(challengepkg.KindJeopardy, questionID)
+		if files == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "NO_CONTEXT", "message": "请上传包含 Dockerfile 的构建上下文"})
+			return
+		}
+		if files, err = challengepkg.ContextRoot(files); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_CONTEXT", "message": err.Error()})
+			return
+		}
+	}
+
+	builders := buildRuntimes(db)
+	if len(builders) == 0 {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "UNSUPPORTED", "message": "当前容器后端不支持构建镜像，请推送到镜像仓库后填写镜像名"})
+		return
+	}
+
+	buildsMu.Lock()
+	defer buildsMu.Unlock()
+	if running, ok := activeBuilds[questionID]; ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "BUILD_RUNNING", "message": "该题目正在构建镜像", "buildId": running.id})
+		return
+	}
+
+	var buildID int64
+	var version int
+	err = db.QueryRow(`
+		INSERT INTO question_image_builds (question_id, version, image, status, created_by)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, '', $2, $3 FROM question_image_builds WHERE question_id = $1
+		RETURNING id, version`, questionID, BuildPending, c.GetInt64("userID")).Scan(&buildID, &version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR", "message": err.Error()})
+		return
+	}
+	image := fmt.Sprintf("tgctf/q-%d:%d", questionID, version)
+	db.Exec("UPDATE question_image_builds SET image = $1 WHERE id = $2", image, buildID)
+
+	build := &imageBuild{id: buildID, questionID: questionID, image: image, userID: c.GetInt64("userID"), subs: make(map[chan []byte]struct{})}
+	activeBuilds[questionID] = build
+	go runImageBuild(db, build, files, builders)
+
+	c.JSON(http.StatusAccepted, gin.H{"buildId": buildID, "version": version, "image": image, "message": "镜像构建已开始"})
+}
+
+// runImageBuild 在各节点依次构建镜像，全部成功后更新题目镜像
+func runImageBuild(db *sql.DB, build *imageBuild, files map[string][]byte, builders map[string]container.ImageBuilder) {
+	defer func() {
+		buildsMu.Lock()
+		delete(activeBuilds, build.questionID)
+		buildsMu.Unlock()
+		build.finish()
+	}()
+
+	var builtNodes []string
+	fail := func(err error) {
+		build.Logf("构建失败: %v", err)
+		nodes, _ := json.Marshal(builtNodes)
+		db.Exec(`
+			UPDATE question_image_builds SET status = $1, error = $2, log = $3, nodes = $4, finished_at = CURRENT_TIMESTAMP
+			WHERE id = $5`, BuildFailed, err.Error(), build.snapshot(), string(nodes), build.id)
+		log.Printf("[Build] 题目 %d 构建 %s 失败: %v", build.questionID, build.image, err)
+	}
+
+	db.Exec("UPDATE question_image_builds SET status = $1 WHERE id = $2", BuildBuilding, build.id)
+
+	dir, err := os.MkdirTemp("", "tgctf-build-*")
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	if err := challengepkg.Extract(files, dir); err != nil {
+		fail(err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), buildTimeout)
+	defer cancel()
+
+	nodes := make([]string, 0, len(builders))
+	for node := range builders {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	bases := baseImages(files["Dockerfile"])
+
+	for _, node := range nodes {
+		builder := builders[node]
+		build.Logf("节点 %s: 构建 %s", nodeLabel(node), build.image)
+		// 基础镜像位于私有仓库时先登录
+		if rt, ok := builder.(container.Runtime); ok {
+			for _, base := range bases {
+				if err := loginRegistry(ctx, db, rt, base); err != nil {
+					build.Logf("登录仓库 %s 失败: %v", container.ImageRegistry(base), err)
+				}
+			}
+		}
+		if err := builder.BuildImage(ctx, dir, build.image, build); err != nil {
+			fail(fmt.Errorf("节点 %s: %v", nodeLabel(node), err))
+			return
+		}
+		builtNodes = append(builtNodes, nodeLabel(node))
+	}
+
+	build.Logf("构建完成，题目镜像已更新为 %s", build.image)
+	nodesJSON, _ := json.Marshal(builtNodes)
+	db.Exec(`
+		UPDATE question_image_builds SET status = $1, log = $2, nodes = $3, finished_at = CURRENT_TIMESTAMP
+		WHERE id = $4`, BuildSuccess, build.snapshot(), string(nodesJSON), build.id)
+	db.Exec(`
+		UPDATE question_bank SET docker_image = $1, image_status = 'exists', image_checked_at = CURRENT_TIMESTAMP,
+			needs_edit = false, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`, build.image, build.questionID)
+	saveQuestionRevision(db, build.questionID, build.userID)
+	log.Printf("[Build] 题目 %d 镜像构建完成: %s", build.questionID, build.image)
+}
+
+// ImageBuildInfo 构建记录
+type ImageBuildInfo struct {
+	ID         int64    `json:"id"`
+	Version    int      `json:"version"`
+	Image      string   `json:"image"`
+	Status     string   `json:"status"`
+	Error      string   `json:"error,omitempty"`
+	Nodes      []string `json:"nodes"`
+	CreatedBy  string   `json:"createdBy"`
+	CreatedAt  string   `json:"createdAt"`
+	FinishedAt string   `json:"finishedAt,omitempty"`
+	Current    bool     `json:"current"` // 是否为题目当前使用的镜像
+	Log        string   `json:"log,omitempty"`
+}
+
+const imageBuildColumns = `
+	b.id, b.version, b.image, b.status, COALESCE(b.error, ''), COALESCE(b.nodes, ''),
+	COALESCE(u.display_name, ''), b.created_at, b.finished_at, b.image = COALESCE(q.docker_image, '')`
+
+func scanImageBuild(scanner interface{ Scan(...interface{}) error }, info *ImageBuildInfo) error {
+	var nodes string
+	var createdAt time.Time
+	var finishedAt sql.NullTime
+	if err := scanner.Scan(&info.ID, &info.Version, &info.Image, &info.Status, &info.Error, &nodes,
+		&info.CreatedBy, &createdAt, &finishedAt, &info.Current); err != nil {
+		return err
+	}
+	info.Nodes = []string{}
+	if nodes != "" {
+		json.Unmarshal([]byte(nodes), &info.Nodes)
+	}
+	info.CreatedAt = createdAt.Format(time.RFC3339)
+	if finishedAt.Valid {
+		info.FinishedAt = finishedAt.Time.Format(time.RFC3339)
+	}
+	return nil
+}
+
+// HandleListImageBuilds 题目镜像构建历史: GET /questions/:id/builds
+func HandleListImageBuilds(c *gin.Context, db *sql.DB) {
+	rows, err := db.Query(`
+		SELECT `+imageBuildColumns+`
+		FROM question_image_builds b
+		JOIN question_bank q ON b.question_id = q.id
+		LEFT JOIN users u ON b.created_by = u.id
+		WHERE b.question_id = $1
+		ORDER BY b.version DESC`, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR"})
+		return
+	}
+	defer rows.Close()
+
+	builds := []ImageBuildInfo{}
+	for rows.Next() {
+		var info ImageBuildInfo
+		if scanImageBuild(rows, &info) == nil {
+			builds = append(builds, info)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"builds":     builds,
+		"hasContext": challengepkg.LoadContext(challengepkg.KindJeopardy, parseInt64(c.Param("id"))) != nil,
+	})
+}
+
+// HandleGetImageBuild 构建详情（含日志）: GET /questions/:id/builds/:buildId
+func HandleGetImageBuild(c *gin.Context, db *sql.DB) {
+	var info ImageBuildInfo
+	err := scanImageBuild(db.QueryRow(`
+		SELECT `+imageBuildColumns+`
+		FROM question_image_builds b
+		JOIN question_bank q ON b.question_id = q.id
+		LEFT JOIN users u ON b.created_by = u.id
+		WHERE b.id = $1 AND b.question_id = $2`, c.Param("buildId"), c.Param("id")), &info)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "BUILD_NOT_FOUND"})
+		return
+	}
+
+	if build := activeBuild(info.ID); build != nil {
+		info.Log = build.snapshot()
+	} else {
+		db.QueryRow("SELECT COALESCE(log, '') FROM question_image_builds WHERE id = $1", info.ID).Scan(&info.Log)
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// HandleActivateImageBuild 将题目镜像切换为某次成功的构建: POST /questions/:id/builds/:buildId/activate
+func HandleActivateImageBuild(c *gin.Context, db *sql.DB) {
+	var image, status string
+	err := db.QueryRow("SELECT image, status FROM question_image_builds WHERE id = $1 AND question_id = $2",
+		c.Param("buildId"), c.Param("id")).Scan(&image, &status)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "BUILD_NOT_FOUND"})
+		return
+	}
+	if status != BuildSuccess {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "BUILD_NOT_SUCCESS", "message": "只能切换到构建成功的版本"})
+		return
+	}
+	db.Exec(`
+		UPDATE question_bank SET docker_image = $1, image_status = 'exists', image_checked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`, image, c.Param("id"))
+	saveQuestionRevision(db, parseInt64(c.Param("id")), c.GetInt64("userID"))
+	c.JSON(http.StatusOK, gin.H{"image": image, "message": "题目镜像已切换"})
+}
+
+// saveQuestionRevision 题目镜像变更后生成新版本（已添加到比赛的题目仍使用原镜像，需在比赛中显式升级）
+func saveQuestionRevision(db *sql.DB, questionID, userID int64) {
+	if SaveQuestionRevision == nil {
+		return
+	}
+	if _, err := SaveQuestionRevision(db, questionID, userID); err != nil {
+		log.Printf("[Build] 保存题目 %d 版本失败: %v", questionID, err)
+	}
+}
+
+// activeBuild 查找进行中的构建
+func activeBuild(buildID int64) *imageBuild {
+	buildsMu.Lock()
+	defer buildsMu.Unlock()
+	for _, build := range activeBuilds {
+		if build.id == buildID {
+			return build
+		}
+	}
+	return nil
+}
+
+func parseInt64(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
+
+var buildLogUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 32 * 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// HandleImageBuildLog 实时构建日志: GET /questions/:id/builds/:buildId/log（WebSocket，二进制帧）
+// 先推送已有日志，构建进行中时持续推送新输出，构建结束后关闭连接
+func HandleImageBuildLog(c *gin.Context, db *sql.DB) {
+	buildID := parseInt64(c.Param("buildId"))
+	var questionID int64
+	var savedLog string
+	err := db.QueryRow("SELECT question_id, COALESCE(log, '') FROM question_image_builds WHERE id = $1", buildID).Scan(&questionID, &savedLog)
+	if err != nil || strconv.FormatInt(questionID, 10) != c.Param("id") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "BUILD_NOT_FOUND"})
+		return
+	}
+
+	conn, err := buildLogUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// 客户端断开时结束推送
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	build := activeBuild(buildID)
+	if build == nil {
+		conn.WriteMessage(websocket.BinaryMessage, []byte(savedLog))
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "build finished"), time.Now().Add(time.Second))
+		return
+	}
+
+	snapshot, ch, done := build.subscribe()
+	if len(snapshot) > 0 {
+		conn.WriteMessage(websocket.BinaryMessage, snapshot)
+	}
+	if !done {
+		defer build.unsubscribe(ch)
+	loop:
+		for {
+			select {
+			case chunk, ok := <-ch:
+				if !ok {
+					break loop
+				}
+				if err := conn.WriteMessage(websocket.BinaryMessage, chunk); err != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "build finished"), time.Now().Add(time.Second))
+}
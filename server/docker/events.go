@@ -0,0 +1,172 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package docker
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+// 实例事件类型
+const (
+	EventQueuePosition = "queue_position" // 排队位置变化
+	EventInstanceReady = "instance_ready" // 排队的实例已启动
+	EventQueueFailed   = "queue_failed"   // 排队的实例创建失败或被取消
+	EventJobProgress   = "job_progress"   // 实例任务状态变化
+)
+
+// InstanceEvent 推送给队伍的实例事件
+type InstanceEvent struct {
+	Type        string `json:"type"`
+	ContestID   int64  `json:"contestId"`
+	ChallengeID int64  `json:"challengeId"`
+	TicketID    int64  `json:"ticketId,omitempty"`
+	JobID       int64  `json:"jobId,omitempty"`
+	JobKind     string `json:"jobKind,omitempty"`
+	Status      string `json:"status,omitempty"`
+	InstanceID  int64  `json:"instanceId,omitempty"`
+	Position    int    `json:"position,omitempty"`
+	Message     string `json:"message,omitempty"`
+	UserID      int64  `json:"-"` // 非 0 时仅推送给该用户（个人实例）
+}
+
+const (
+	eventSendBuffer   = 64               // 每个连接待发送的事件数上限，超出时断开连接
+	eventWriteTimeout = 10 * time.Second // 单条事件的写超时
+)
+
+// eventClient 事件推送连接：事件经缓冲通道由独立的写协程发送，慢连接不会阻塞推送方
+type eventClient struct {
+	conn   *websocket.Conn
+	userID int64
+	send   chan []byte
+}
+
+// writeLoop 发送缓冲的事件，写失败时关闭连接（读循环随之退出并注销连接）
+func (ec *eventClient) writeLoop() {
+	for data := range ec.send {
+		ec.conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		if err := ec.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			ec.conn.Close()
+			return
+		}
+	}
+}
+
+// WebSocket 连接管理（按队伍ID分组）
+var (
+	eventClients   = make(map[int64]map[*eventClient]struct{})
+	eventClientsMu sync.Mutex
+	eventUpgrader  = websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+)
+
+// VerifyUserToken 校验不经过中间件的 WebSocket 连接（实例事件、TCP 隧道、AWD-F 终端）携带的登录凭证：
+// 与登录中间件一致校验签名算法与 token_version，并拒绝已封禁或停用的账号
+func VerifyUserToken(db *sql.DB, secret []byte, tokenStr string) (int64, bool) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{"HS256"}))
+	if err != nil || !token.Valid {
+		return 0, false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, false
+	}
+	sub, _ := claims["sub"].(float64)
+	userID := int64(sub)
+
+	var dbTokenVersion int
+	var status string
+	if err := db.QueryRow(`SELECT COALESCE(token_version, 1), COALESCE(status, 'active') FROM users WHERE id = $1`, userID).Scan(&dbTokenVersion, &status); err != nil {
+		return 0, false
+	}
+	tokenVersion := 1
+	if tv, ok := claims["tokenVersion"].(float64); ok {
+		tokenVersion = int(tv)
+	}
+	return userID, tokenVersion == dbTokenVersion && status == "active"
+}
+
+// HandleInstanceEventsWebSocket 选手实例事件推送（不经过中间件，从 URL 参数验证 token）
+func HandleInstanceEventsWebSocket(c *gin.Context, jwtSecret []byte, db *sql.DB) {
+	tokenStr := c.Query("token")
+	if tokenStr == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "MISSING_TOKEN"})
+		return
+	}
+	userID, ok := VerifyUserToken(db, jwtSecret, tokenStr)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "INVALID_TOKEN"})
+		return
+	}
+
+	var teamID sql.NullInt64
+	db.QueryRow(`SELECT team_id FROM users WHERE id = $1`, userID).Scan(&teamID)
+	if !teamID.Valid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "NO_TEAM", "message": "您还未加入队伍"})
+		return
+	}
+
+	conn, err := eventUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	client := &eventClient{conn: conn, userID: userID, send: make(chan []byte, eventSendBuffer)}
+	eventClientsMu.Lock()
+	if eventClients[teamID.Int64] == nil {
+		eventClients[teamID.Int64] = make(map[*eventClient]struct{})
+	}
+	eventClients[teamID.Int64][client] = struct{}{}
+	eventClientsMu.Unlock()
+	go client.writeLoop()
+
+	defer func() {
+		eventClientsMu.Lock()
+		delete(eventClients[teamID.Int64], client)
+		if len(eventClients[teamID.Int64]) == 0 {
+			delete(eventClients, teamID.Int64)
+		}
+		close(client.send)
+		eventClientsMu.Unlock()
+	}()
+
+	// 保持连接，等待客户端断开
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+// PublishTeamEvent 向队伍的在线连接推送实例事件（只写入各连接的发送缓冲，不等待网络写入）
+func PublishTeamEvent(teamID int64, ev InstanceEvent) {
+	data, _ := json.Marshal(ev)
+
+	eventClientsMu.Lock()
+	defer eventClientsMu.Unlock()
+	for client := range eventClients[teamID] {
+		if ev.UserID != 0 && client.userID != ev.UserID {
+			continue
+		}
+		select {
+		case client.send <- data:
+		default:
+			// 缓冲已满说明连接长时间没有读取，直接断开（任务进度仍可通过轮询获取，页面再次部署时重新连接）
+			client.conn.Close()
+		}
+	}
+}
@@ -10,14 +10,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os/exec"
-	"strconv"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"tgctf/server/container"
 	"tgctf/server/logs"
 )
 
@@ -39,14 +37,15 @@ type TeamInstance struct {
 // 系统设置获取函数的类型定义
 type SettingsGetter func(db *sql.DB) int
 
-// 端口分配函数类型
-type PortAllocator func(db *sql.DB, count int) ([]int, error)
+// 端口分配函数类型（按工作节点分配）
+type PortAllocator func(db *sql.DB, node string, count int) ([]int, error)
 
 // 全局变量，用于注入系统设置获取函数
 var GetContainerTTL SettingsGetter
 var GetContainerExtendTTL SettingsGetter
 var GetContainerExtendWindow SettingsGetter
 var AllocatePorts PortAllocator
+var IsPortPublishEnabled func(db *sql.DB) bool
 
 // 并发控制：防止同一队伍对同一题目并发创建多个容器
 var (
@@ -57,9 +56,9 @@ var (
 // 全局端口分配锁：确保端口分配到 docker run 完成之间不会有竞态
 var portAllocMu sync.Mutex
 
-// getInstanceLock 获取指定队伍+题目组合的互斥锁
-func getInstanceLock(teamID int64, challengeID string) *sync.Mutex {
-	key := fmt.Sprintf("%d_%s", teamID, challengeID)
+// getInstanceLock 获取指定实例归属+题目组合的互斥锁
+func getInstanceLock(ownerKey string, challengeID string) *sync.Mutex {
+	key := ownerKey + "_" + challengeID
 	instanceMu.Lock()
 	defer instanceMu.Unlock()
 	if _, ok := instanceLocks[key]; !ok {
@@ -87,19 +86,36 @@ func HandleCreateUserInstance(c *gin.Context, db *sql.DB) {
 	}
 	fmt.Printf("[DEBUG] userID=%d, teamID=%d\n", userID, teamID.Int64)
 
-	// 获取队伍+题目的互斥锁，防止并发创建多个容器实例
-	lock := getInstanceLock(teamID.Int64, challengeID)
+	// 按题目的实例策略确定实例归属（队伍 / 个人 / 全场共享）
+	policy := GetInstancePolicy(db, challengeID)
+	ownerKey := InstanceOwnerKey(policy, teamID.Int64, userID)
+
+	// 获取实例归属+题目的互斥锁，防止并发创建多个容器实例
+	lock := getInstanceLock(ownerKey, challengeID)
 	lock.Lock()
 	defer lock.Unlock()
 
+	// 已有未完成的任务（创建中/排队中/销毁中）时不重复创建
+	if jobID, kind := findActiveJob(db, challengeID, ownerKey); jobID > 0 {
+		if kind == JobCreate {
+			c.JSON(http.StatusAccepted, gin.H{"jobId": jobID, "kind": kind, "status": JobQueued, "message": "该题目的容器正在创建中"})
+		} else {
+			c.JSON(http.StatusConflict, gin.H{"error": "JOB_IN_PROGRESS", "jobId": jobID, "message": "该题目的容器正在销毁或续期，请稍后再试"})
+		}
+		return
+	}
+
 	// 检查该题目是否已有实例
 	var existingID int64
 	var existingCreatedBy sql.NullInt64
-	err = db.QueryRow(`SELECT id, created_by FROM team_instances WHERE team_id = $1 AND challenge_id = $2 AND status = 'running'`,
-		teamID.Int64, challengeID).Scan(&existingID, &existingCreatedBy)
+	err = db.QueryRow(`SELECT id, created_by FROM team_instances WHERE challenge_id = $1 AND owner_key = $2 AND status = 'running'`,
+		challengeID, ownerKey).Scan(&existingID, &existingCreatedBy)
 	if err == nil {
 		// 该题目已有实例
-		if existingCreatedBy.Valid && existingCreatedBy.Int64 == userID {
+		if policy == PolicyShared {
+			// 共享实例已由其他选手启动，直接使用即可
+			c.JSON(http.StatusConflict, gin.H{"error": "INSTANCE_EXISTS", "message": "该题目的共享实例已在运行，刷新即可查看访问地址"})
+		} else if existingCreatedBy.Valid && existingCreatedBy.Int64 == userID {
 			// 是自己创建的，返回已存在
 			c.JSON(http.StatusConflict, gin.H{"error": "INSTANCE_EXISTS", "message": "您已有该题目的运行中实例"})
 		} else {
@@ -122,36 +138,39 @@ func HandleCreateUserInstance(c *gin.Context, db *sql.DB) {
 	}
 	fmt.Printf("[DEBUG] containerLimit=%d\n", containerLimit)
 
-	// 检查队伍当前运行的容器数量
+	// 检查当前运行的容器数量（队伍实例按队伍统计，个人实例按个人统计，共享实例不计入；创建中和排队中的请求同样占用名额）
+	scope, scopeID := limitScope(policy, teamID.Int64, userID)
 	var runningCount int
-	db.QueryRow(`SELECT COUNT(*) FROM team_instances WHERE team_id = $1 AND contest_id = $2 AND status = 'running'`,
-		teamID.Int64, contestID).Scan(&runningCount)
+	db.QueryRow(`SELECT (SELECT COUNT(*) FROM team_instances WHERE `+scope+` AND status = 'running') +
+		(SELECT COUNT(*) FROM instance_jobs WHERE `+scope+` AND kind = 'create' AND status NOT IN ('ready', 'done', 'failed'))`,
+		scopeID, contestID).Scan(&runningCount)
 	fmt.Printf("[DEBUG] runningCount=%d\n", runningCount)
 
 	// 如果达到限制
-	if containerLimit > 0 && runningCount >= containerLimit {
+	var stale *staleContainer
+	var staleInstanceID int64
+	if policy != PolicyShared && containerLimit > 0 && runningCount >= containerLimit {
 		// 检查是否有自己创建的容器可以销毁
 		var ownInstanceID int64
-		var ownContainerID, ownChallengeName string
+		var ownContainerID, ownChallengeName, ownBackend, ownNode string
 		var ownChallengeID int64
 		err = db.QueryRow(`
-			SELECT ti.id, ti.container_id, ti.challenge_id, COALESCE(q.title, '')
+			SELECT ti.id, ti.container_id, ti.challenge_id, COALESCE(q.title, ''), COALESCE(ti.backend, ''), COALESCE(ti.node, '')
 			FROM team_instances ti
 			LEFT JOIN contest_challenges cc ON ti.challenge_id = cc.id
-			LEFT JOIN question_bank q ON cc.question_id = q.id
-			WHERE ti.team_id = $1 AND ti.contest_id = $2 AND ti.status = 'running' AND ti.created_by = $3
+			LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
+			WHERE ti.`+scope+` AND ti.status = 'running' AND ti.created_by = $3
 			ORDER BY ti.created_at ASC LIMIT 1`,
-			teamID.Int64, contestID, userID).Scan(&ownInstanceID, &ownContainerID, &ownChallengeID, &ownChallengeName)
+			scopeID, contestID, userID).Scan(&ownInstanceID, &ownContainerID, &ownChallengeID, &ownChallengeName, &ownBackend, &ownNode)
 		
 		if err == nil {
 			// 有自己创建的容器
 			if forceDestroy {
-				// 强制销毁旧容器
+				// 强制销毁旧容器（实例记录立即失效，容器在创建任务开始时删除）
 				fmt.Printf("[DEBUG] Force destroying old container: %s\n", ownContainerID)
-				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-				exec.CommandContext(ctx, "docker", "rm", "-f", ownContainerID).Run()
-				cancel()
 				db.Exec(`UPDATE team_instances SET status = 'destroyed', updated_at = CURRENT_TIMESTAMP WHERE id = $1`, ownInstanceID)
+				stale = &staleContainer{ContainerID: ownContainerID, Backend: ownBackend, Node: ownNode}
+				staleInstanceID = ownInstanceID
 				runningCount--
 				// 继续创建新容器
 			} else {
@@ -192,248 +211,85 @@ func HandleCreateUserInstance(c *gin.Context, db *sql.DB) {
 		return
 	}
 
-	var dockerImage, ports, cpuLimit, memoryLimit, flagEnv, flagScript sql.NullString
-	var questionID int64
-	
-	if contestMode == "awd-f" {
-		// AWD-F 模式：查询 contest_challenges_awdf 和 question_bank_awdf
-		err = db.QueryRow(`
-			SELECT q.id, q.docker_image, q.ports, q.cpu_limit, q.memory_limit, q.flag_env, q.flag_script
-			FROM contest_challenges_awdf cc
-			JOIN question_bank_awdf q ON cc.question_id = q.id
-			WHERE cc.id = $1 AND cc.contest_id = $2`,
-			challengeID, contestID).Scan(&questionID, &dockerImage, &ports, &cpuLimit, &memoryLimit, &flagEnv, &flagScript)
-	} else {
-		// Jeopardy/AWD 模式：查询 contest_challenges 和 question_bank（支持临时题目）
-		err = db.QueryRow(`
-			SELECT COALESCE(q.id, 0), COALESCE(q.docker_image, cc.inline_docker_image), COALESCE(q.ports, cc.inline_ports), 
-			       COALESCE(q.cpu_limit, cc.inline_cpu_limit), COALESCE(q.memory_limit, cc.inline_memory_limit), 
-			       COALESCE(q.flag_env, cc.inline_flag_env), COALESCE(q.flag_script, cc.inline_flag_script)
-			FROM contest_challenges cc
-			LEFT JOIN question_bank q ON cc.question_id = q.id
-			WHERE cc.id = $1 AND cc.contest_id = $2`,
-			challengeID, contestID).Scan(&questionID, &dockerImage, &ports, &cpuLimit, &memoryLimit, &flagEnv, &flagScript)
-	}
+	launch, err := prepareLaunch(db, contestID, challengeID, teamID.Int64, userID, policy)
 	if err != nil {
-		fmt.Printf("[DEBUG] Query question failed: %v\n", err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "CHALLENGE_NOT_FOUND", "message": "题目不存在"})
+		respondLaunchError(c, err)
 		return
 	}
-	fmt.Printf("[DEBUG] questionID=%d, dockerImage=%s, ports=%s\n", questionID, dockerImage.String, ports.String)
 
-	if !dockerImage.Valid || dockerImage.String == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "NO_DOCKER_IMAGE", "message": "该题目没有配置容器镜像"})
+	// 检查队伍资源配额（被强制替换的旧实例已不计入），超额时恢复旧实例
+	quotaLock := teamQuotaLock(teamID.Int64)
+	quotaLock.Lock()
+	if err := checkTeamQuota(db, launch); err != nil {
+		quotaLock.Unlock()
+		if staleInstanceID > 0 {
+			db.Exec(`UPDATE team_instances SET status = 'running', updated_at = CURRENT_TIMESTAMP WHERE id = $1`, staleInstanceID)
+		}
+		respondLaunchError(c, err)
 		return
 	}
 
-	flag := GetOrCreateTeamFlag(db, teamID.Int64, contestID, challengeID)
-
-	var portList []string
-	if ports.Valid && ports.String != "" {
-		json.Unmarshal([]byte(ports.String), &portList)
-	}
-
-	containerName := fmt.Sprintf("tg_team_%d_%s_%d", teamID.Int64, challengeID, time.Now().Unix())
-	args := []string{"run", "-d", "--name", containerName}
-
-	// 从端口池分配端口
-	// 使用全局锁确保端口分配到 docker run 完成之间的原子性
-	portInfo := make(map[string]string)
-	needsPortLock := len(portList) > 0 && AllocatePorts != nil
-	if needsPortLock {
-		portAllocMu.Lock()
+	// 创建过程作为异步任务执行，状态变化通过 WebSocket 推送给队伍
+	launch.Job, err = createJob(db, JobCreate, contestID, challengeID, teamID.Int64, userID, policy)
+	if err == nil {
+		launch.Job.reserveResources(db, launch.MemoryMB, launch.CPUCores)
 	}
-	
-	if len(portList) > 0 {
-		if AllocatePorts != nil {
-			allocatedPorts, err := AllocatePorts(db, len(portList))
-			if err != nil {
-				if needsPortLock {
-					portAllocMu.Unlock()
-				}
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error":   "PORT_ALLOCATION_FAILED",
-					"message": "端口分配失败: " + err.Error(),
-				})
-				return
-			}
-			for i, containerPort := range portList {
-				hostPort := allocatedPorts[i]
-				args = append(args, "-p", fmt.Sprintf("%d:%s", hostPort, containerPort))
-				portInfo[containerPort] = strconv.Itoa(hostPort)
-			}
-			fmt.Printf("[DEBUG] Allocated ports: %v\n", portInfo)
-		} else {
-			// 回退到 Docker 自动分配
-			for _, port := range portList {
-				args = append(args, "-p", fmt.Sprintf(":%s", port))
-			}
-		}
+	quotaLock.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR", "message": "创建任务失败", "details": err.Error()})
+		return
 	}
 
-	if cpuLimit.Valid && cpuLimit.String != "" {
-		args = append(args, "--cpus", cpuLimit.String)
-	}
-	if memoryLimit.Valid && memoryLimit.String != "" {
-		args = append(args, "-m", memoryLimit.String)
+	// 已有选手在排队或全局容量已满时进入排队（先到先得），领取预热容器不占用新资源，无需排队
+	// 任务创建后即计入全局容量，进入排队时释放
+	capacityMu.Lock()
+	if stale == nil && !hasWarmContainer(db, challengeID) && (hasWaitingTickets(db) || !hasCapacity(db, launch.MemoryMB, launch.Job.ID)) {
+		respondQueued(c, db, launch)
+		capacityMu.Unlock()
+		return
 	}
+	capacityMu.Unlock()
 
-	// 处理 Flag 注入方式：环境变量 和/或 命令行参数
-	useCmdArg := false
-	if flagEnv.Valid && flagEnv.String != "" {
-		envNames := strings.Split(flagEnv.String, ",")
-		for _, en := range envNames {
-			en = strings.TrimSpace(en)
-			if en == "CMDARG" || en == "$1" {
-				useCmdArg = true
-			} else if en != "" {
-				args = append(args, "-e", fmt.Sprintf("%s=%s", en, flag))
-			}
-		}
-	} else {
-		args = append(args, "-e", fmt.Sprintf("FLAG=%s", flag))
-	}
+	go runCreateJob(db, launch, stale, c.ClientIP())
+	jobAccepted(c, launch.Job, "容器创建中")
+}
 
-	args = append(args, "--label", "tg.type=team")
-	args = append(args, "--label", fmt.Sprintf("tg.team_id=%d", teamID.Int64))
-	args = append(args, "--label", fmt.Sprintf("tg.challenge_id=%s", challengeID))
+// staleContainer 强制替换时需要删除的旧容器
+type staleContainer struct {
+	ContainerID string
+	Backend     string
+	Node        string
+}
 
-	args = append(args, dockerImage.String)
+// runCreateJob 执行创建任务：删除被替换的旧容器、启动新容器并更新任务状态
+func runCreateJob(db *sql.DB, l *instanceLaunch, stale *staleContainer, clientIP string) {
+	lock := getInstanceLock(l.OwnerKey, l.ChallengeID)
+	lock.Lock()
+	defer lock.Unlock()
 
-	// 如果配置了 CMDARG，将 flag 作为命令行参数追加到镜像名之后
-	if useCmdArg {
-		args = append(args, flag)
+	if stale != nil {
+		l.Job.update(db, JobStopping, 0, "正在删除旧容器")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		container.For(stale.Backend, stale.Node).Remove(ctx, stale.ContainerID)
+		cancel()
+		wakeQueue()
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
-
-	fmt.Printf("[DEBUG] Docker args: %v\n", args)
-	runCmd := exec.CommandContext(ctx, "docker", args...)
-	output, err := runCmd.CombinedOutput()
-	
-	// docker run 完成后释放端口锁
-	if needsPortLock {
-		portAllocMu.Unlock()
-	}
-	
+	result, err := launchInstance(db, l)
 	if err != nil {
-		// 清理失败的容器（可能处于 Created 状态）
-		cleanCmd := exec.Command("docker", "rm", "-f", containerName)
-		cleanCmd.Run() // 忽略清理错误
-		
-		fmt.Printf("[DEBUG] Docker run failed: %v, output: %s\n", err, string(output))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "CONTAINER_CREATE_FAILED",
-			"message": "创建容器失败",
-			"details": string(output),
-		})
-		return
-	}
-
-	outputLines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	containerID := strings.TrimSpace(outputLines[len(outputLines)-1])
-	if len(containerID) > 12 {
-		containerID = containerID[:12]
-	}
-	fmt.Printf("[DEBUG] Container ID: %s\n", containerID)
-
-	// 如果使用 Docker 自动分配端口，需要查询端口映射
-	if len(portInfo) == 0 && len(portList) > 0 {
-		for i := 0; i < 10; i++ {
-			time.Sleep(500 * time.Millisecond)
-			portCmd := exec.CommandContext(ctx, "docker", "port", containerID)
-			portOutput, err := portCmd.CombinedOutput()
-			if err == nil {
-				lines := strings.Split(string(portOutput), "\n")
-				for _, line := range lines {
-					line = strings.TrimSpace(line)
-					if line == "" || strings.Contains(line, "[::") {
-						continue
-					}
-					parts := strings.Split(line, " -> ")
-					if len(parts) == 2 {
-						containerPort := strings.Split(parts[0], "/")[0]
-						hostAddr := parts[1]
-						if idx := strings.LastIndex(hostAddr, ":"); idx != -1 {
-							portInfo[containerPort] = hostAddr[idx+1:]
-						}
-					}
-				}
-				if len(portInfo) > 0 {
-					break
-				}
-			}
-			if i >= 2 {
-				checkCmd := exec.CommandContext(ctx, "docker", "inspect", "-f", "{{.State.Running}}", containerID)
-				if out, err := checkCmd.Output(); err == nil && strings.TrimSpace(string(out)) != "true" {
-					break
-				}
-			}
-		}
-	}
-
-	// 如果配置了 flag_script，在容器启动后执行脚本注入 Flag
-	if flagScript.Valid && flagScript.String != "" {
-		fmt.Printf("[DEBUG] Executing flag script: %s\n", flagScript.String)
-		time.Sleep(500 * time.Millisecond) // 等待容器完全启动
-		scriptCmd := exec.CommandContext(ctx, "docker", "exec", containerID, "sh", flagScript.String, flag)
-		scriptOutput, scriptErr := scriptCmd.CombinedOutput()
-		if scriptErr != nil {
-			fmt.Printf("[DEBUG] Flag script execution failed: %v, output: %s\n", scriptErr, string(scriptOutput))
-			// 脚本执行失败不阻塞容器创建，仅记录日志
-		} else {
-			fmt.Printf("[DEBUG] Flag script executed successfully\n")
+		// 节点满载或端口池耗尽时进入排队，由调度器在资源释放后自动创建
+		if le, ok := err.(*launchError); ok && le.Retry {
+			enqueueLaunch(db, l)
+			return
 		}
-	}
-
-	portsJSON, _ := json.Marshal(portInfo)
-	initialTTL := 120 // 默认值
-	if GetContainerTTL != nil {
-		initialTTL = GetContainerTTL(db)
-	}
-	expiresAt := time.Now().Add(time.Duration(initialTTL) * time.Minute)
-
-	var instanceID int64
-	err = db.QueryRow(`
-		INSERT INTO team_instances (team_id, contest_id, challenge_id, container_id, container_name, ports, status, expires_at, created_by)
-		VALUES ($1, $2, $3, $4, $5, $6, 'running', $7, $8)
-		ON CONFLICT (team_id, challenge_id) DO UPDATE SET
-			container_id = $4, container_name = $5, ports = $6, status = 'running', expires_at = $7, created_by = $8, updated_at = CURRENT_TIMESTAMP
-		RETURNING id`,
-		teamID.Int64, contestID, challengeID, containerID, containerName, string(portsJSON), expiresAt, userID).Scan(&instanceID)
-	if err != nil {
-		fmt.Printf("[DEBUG] DB insert failed: %v\n", err)
-		exec.Command("docker", "rm", "-f", containerID).Run()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR", "message": "保存实例失败", "details": err.Error()})
+		l.Job.update(db, JobFailed, 0, err.Error())
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"instanceId":    instanceID,
-		"containerId":   containerID,
-		"containerName": containerName,
-		"ports":         portInfo,
-		"expiresAt":     expiresAt.Format("2006-01-02 15:04:05"),
-		"ttl":           initialTTL * 60,
-		"message":       "容器创建成功",
-	})
+	l.Job.update(db, JobReady, result.InstanceID, "容器创建成功")
 
 	// 记录容器创建日志
-	clientIP := c.ClientIP()
-	contestIDInt, _ := strconv.ParseInt(contestID, 10, 64)
-	challengeIDInt, _ := strconv.ParseInt(challengeID, 10, 64)
-	var displayName, challengeName string
-	db.QueryRow(`SELECT display_name FROM users WHERE id = $1`, userID).Scan(&displayName)
-	if contestMode == "awd-f" {
-		db.QueryRow(`SELECT q.title FROM question_bank_awdf q JOIN contest_challenges_awdf cc ON q.id = cc.question_id WHERE cc.id = $1`, challengeID).Scan(&challengeName)
-	} else {
-		db.QueryRow(`SELECT COALESCE(q.title, cc.inline_title) FROM contest_challenges cc LEFT JOIN question_bank q ON q.id = cc.question_id WHERE cc.id = $1`, challengeID).Scan(&challengeName)
-	}
-	logs.WriteLog(db, logs.TypeContainerCreate, logs.LevelSuccess, &userID, &teamID.Int64, &contestIDInt, &challengeIDInt, clientIP,
-		displayName+" 启动题目 ["+challengeName+"] 的容器实例", map[string]interface{}{
-			"containerId": containerID, "ports": portInfo,
-		})
+	logInstanceCreate(db, l, result, clientIP)
 }
 
 // HandleGetUserInstance 获取队伍容器实例
@@ -458,22 +314,26 @@ func HandleGetUserInstance(c *gin.Context, db *sql.DB) {
 	var found bool
 	var isAWDF bool
 	var sshPassword sql.NullString
+	var node string
+	var accessToken sql.NullString
+
+	policy := GetInstancePolicy(db, challengeID)
 
 	// 先查询普通容器实例表
 	err := db.QueryRow(`
-		SELECT id, team_id, contest_id, challenge_id, container_id, container_name, ports, status, expires_at, created_at, created_by
-		FROM team_instances WHERE team_id = $1 AND challenge_id = $2 AND status = 'running'`,
-		teamID.Int64, challengeID).Scan(&inst.ID, &inst.TeamID, &inst.ContestID, &inst.ChallengeID,
-		&inst.ContainerID, &inst.ContainerName, &portsJSON, &inst.Status, &expiresAt, &inst.CreatedAt, &createdBy)
+		SELECT id, team_id, contest_id, challenge_id, container_id, container_name, ports, status, expires_at, created_at, created_by, COALESCE(node, ''), access_token
+		FROM team_instances WHERE challenge_id = $1 AND owner_key = $2 AND status = 'running'`,
+		challengeID, InstanceOwnerKey(policy, teamID.Int64, userID)).Scan(&inst.ID, &inst.TeamID, &inst.ContestID, &inst.ChallengeID,
+		&inst.ContainerID, &inst.ContainerName, &portsJSON, &inst.Status, &expiresAt, &inst.CreatedAt, &createdBy, &node, &accessToken)
 	if err == nil {
 		found = true
 	} else {
 		// 再查询 AWD-F 容器实例表（包含 ssh_password）
 		err = db.QueryRow(`
-			SELECT id, team_id, contest_id, challenge_id, container_id, container_name, ports, ssh_password, status, expires_at, created_at, created_by
+			SELECT id, team_id, contest_id, challenge_id, container_id, container_name, ports, ssh_password, status, expires_at, created_at, created_by, COALESCE(node, '')
 			FROM team_instances_awdf WHERE team_id = $1 AND challenge_id = $2 AND status = 'running'`,
 			teamID.Int64, challengeID).Scan(&inst.ID, &inst.TeamID, &inst.ContestID, &inst.ChallengeID,
-			&inst.ContainerID, &inst.ContainerName, &portsJSON, &sshPassword, &inst.Status, &expiresAt, &inst.CreatedAt, &createdBy)
+			&inst.ContainerID, &inst.ContainerName, &portsJSON, &sshPassword, &inst.Status, &expiresAt, &inst.CreatedAt, &createdBy, &node)
 		if err == nil {
 			found = true
 			isAWDF = true
@@ -515,6 +375,7 @@ func HandleGetUserInstance(c *gin.Context, db *sql.DB) {
 		"challengeId":   inst.ChallengeID,
 		"containerId":   inst.ContainerID,
 		"containerName": inst.ContainerName,
+		"host":          container.PublicHost(db, node),
 		"ports":         inst.Ports,
 		"status":        inst.Status,
 		"expiresAt":     inst.ExpiresAt,
@@ -522,6 +383,16 @@ func HandleGetUserInstance(c *gin.Context, db *sql.DB) {
 		"createdAt":     inst.CreatedAt,
 		"isOwner":       isOwner,
 		"creatorName":   creatorName,
+		"policy":        policy,
+	}
+
+	// 平台代理访问地址（打开前需获取一次性票据）
+	if accessToken.Valid && accessToken.String != "" {
+		proxy := make(map[string]string)
+		for port := range inst.Ports {
+			proxy[port] = instanceProxyURL(accessToken.String, port)
+		}
+		response["proxy"] = proxy
 	}
 
 	// AWD-F 模式：检查是否攻击成功（已解题），成功后才返回 SSH 信息
@@ -559,44 +430,75 @@ func HandleDestroyUserInstance(c *gin.Context, db *sql.DB) {
 		return
 	}
 
-	var containerID string
+	// 共享实例由全场选手共用，选手不能销毁
+	policy := GetInstancePolicy(db, challengeID)
+	if policy == PolicyShared {
+		c.JSON(http.StatusForbidden, gin.H{"error": "SHARED_INSTANCE", "message": "共享实例由全场选手共用，无法销毁"})
+		return
+	}
+	ownerKey := InstanceOwnerKey(policy, teamID.Int64, userID)
+
+	var instanceID int64
+	var containerID, backend, node string
 	var createdBy sql.NullInt64
-	err := db.QueryRow(`SELECT container_id, created_by FROM team_instances WHERE team_id = $1 AND challenge_id = $2 AND status = 'running'`,
-		teamID.Int64, challengeID).Scan(&containerID, &createdBy)
+	err := db.QueryRow(`SELECT id, container_id, created_by, COALESCE(backend, ''), COALESCE(node, '') FROM team_instances WHERE challenge_id = $1 AND owner_key = $2 AND status = 'running'`,
+		challengeID, ownerKey).Scan(&instanceID, &containerID, &createdBy, &backend, &node)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "NO_INSTANCE", "message": "队伍没有运行中的实例"})
 		return
 	}
 
-	// 检查是否是创建者
-	if createdBy.Valid && createdBy.Int64 != userID {
+	// 个人实例只属于自己；队伍实例仅创建者可以销毁
+	if policy == PolicyTeam && createdBy.Valid && createdBy.Int64 != userID {
 		var creatorName string
 		db.QueryRow(`SELECT display_name FROM users WHERE id = $1`, createdBy.Int64).Scan(&creatorName)
 		c.JSON(http.StatusForbidden, gin.H{"error": "NOT_OWNER", "message": "该容器由队友 [" + creatorName + "] 创建，您无权销毁"})
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// 已有未完成的任务时不重复销毁
+	if jobID, kind := findActiveJob(db, challengeID, ownerKey); jobID > 0 {
+		if kind == JobDestroy {
+			c.JSON(http.StatusAccepted, gin.H{"jobId": jobID, "kind": kind, "status": JobQueued, "message": "容器正在销毁中"})
+		} else {
+			c.JSON(http.StatusConflict, gin.H{"error": "JOB_IN_PROGRESS", "jobId": jobID, "message": "该题目的容器有未完成的操作，请稍后再试"})
+		}
+		return
+	}
+
+	job, err := createJob(db, JobDestroy, c.Param("id"), challengeID, teamID.Int64, userID, policy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR", "message": "创建任务失败", "details": err.Error()})
+		return
+	}
+	go runDestroyJob(db, job, instanceID, containerID, backend, node, c.ClientIP())
+	jobAccepted(c, job, "容器销毁中")
+}
+
+// runDestroyJob 执行销毁任务：删除容器并更新实例记录
+func runDestroyJob(db *sql.DB, job *instanceJob, instanceID int64, containerID, backend, node, clientIP string) {
+	job.update(db, JobStopping, instanceID, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	exec.CommandContext(ctx, "docker", "rm", "-f", containerID).Run()
+	if err := container.For(backend, node).Remove(ctx, containerID); err != nil {
+		job.update(db, JobFailed, instanceID, "删除容器失败: "+err.Error())
+		return
+	}
 
-	db.Exec(`UPDATE team_instances SET status = 'destroyed', updated_at = CURRENT_TIMESTAMP WHERE team_id = $1 AND challenge_id = $2`,
-		teamID.Int64, challengeID)
+	db.Exec(`UPDATE team_instances SET status = 'destroyed', updated_at = CURRENT_TIMESTAMP WHERE id = $1`, instanceID)
+	wakeQueue()
+	job.update(db, JobDone, instanceID, "容器已销毁")
 
 	// 记录容器销毁日志
-	clientIP := c.ClientIP()
-	contestID := c.Param("id")
-	contestIDInt, _ := strconv.ParseInt(contestID, 10, 64)
-	challengeIDInt, _ := strconv.ParseInt(challengeID, 10, 64)
+	userID, teamID := job.UserID, job.TeamID
 	var displayName, challengeName string
 	db.QueryRow(`SELECT display_name FROM users WHERE id = $1`, userID).Scan(&displayName)
-	db.QueryRow(`SELECT COALESCE(q.title, cc.inline_title) FROM contest_challenges cc LEFT JOIN question_bank q ON q.id = cc.question_id WHERE cc.id = $1`, challengeID).Scan(&challengeName)
-	logs.WriteLog(db, logs.TypeContainerDestroy, logs.LevelSuccess, &userID, &teamID.Int64, &contestIDInt, &challengeIDInt, clientIP,
+	db.QueryRow(`SELECT COALESCE(q.title, cc.inline_title) FROM contest_challenges cc LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version WHERE cc.id = $1`, job.ChallengeID).Scan(&challengeName)
+	logs.WriteLog(db, logs.TypeContainerDestroy, logs.LevelSuccess, &userID, &teamID, &job.ContestID, &job.ChallengeID, clientIP,
 		displayName+" 销毁题目 ["+challengeName+"] 的容器实例", map[string]interface{}{
 			"containerId": containerID,
 		})
-
-	c.JSON(http.StatusOK, gin.H{"message": "容器已销毁"})
 }
 
 // HandleExtendUserInstance 延长队伍容器实例时间
@@ -623,9 +525,13 @@ func HandleExtendUserInstance(c *gin.Context, db *sql.DB) {
 		extendWindow = GetContainerExtendWindow(db)
 	}
 
+	// 队伍实例任一队员可续期，个人实例仅本人，共享实例任何选手均可续期
+	policy := GetInstancePolicy(db, challengeID)
+	ownerKey := InstanceOwnerKey(policy, teamID.Int64, userID)
+
 	var currentExpiresAt time.Time
-	err := db.QueryRow(`SELECT expires_at FROM team_instances WHERE team_id = $1 AND challenge_id = $2 AND status = 'running'`,
-		teamID.Int64, challengeID).Scan(&currentExpiresAt)
+	err := db.QueryRow(`SELECT expires_at FROM team_instances WHERE challenge_id = $1 AND owner_key = $2 AND status = 'running'`,
+		challengeID, ownerKey).Scan(&currentExpiresAt)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "NO_INSTANCE", "message": "队伍没有运行中的实例"})
 		return
@@ -648,49 +554,42 @@ func HandleExtendUserInstance(c *gin.Context, db *sql.DB) {
 		return
 	}
 
-	result, err := db.Exec(fmt.Sprintf(`
-		UPDATE team_instances SET expires_at = expires_at + INTERVAL '%d minutes', updated_at = CURRENT_TIMESTAMP
-		WHERE team_id = $1 AND challenge_id = $2 AND status = 'running'`, extendTTL),
-		teamID.Int64, challengeID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR"})
+	// 已有未完成的任务时不重复续期
+	if jobID, kind := findActiveJob(db, challengeID, ownerKey); jobID > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "JOB_IN_PROGRESS", "jobId": jobID, "kind": kind, "message": "该题目的容器有未完成的操作，请稍后再试"})
 		return
 	}
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "NO_INSTANCE", "message": "队伍没有运行中的实例"})
+	job, err := createJob(db, JobExtend, c.Param("id"), challengeID, teamID.Int64, userID, policy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR", "message": "创建任务失败", "details": err.Error()})
 		return
 	}
+	go runExtendJob(db, job, extendTTL, c.ClientIP())
+	jobAccepted(c, job, "容器续期中")
+}
 
-	var expiresAt time.Time
-	db.QueryRow(`SELECT expires_at FROM team_instances WHERE team_id = $1 AND challenge_id = $2`, teamID.Int64, challengeID).Scan(&expiresAt)
+// runExtendJob 执行续期任务：延长实例有效期
+func runExtendJob(db *sql.DB, job *instanceJob, extendTTL int, clientIP string) {
+	job.update(db, JobExtending, 0, "")
 
-	expiresLocal = expiresAt
-	if expiresAt.Location() == time.UTC {
-		expiresLocal = time.Date(expiresAt.Year(), expiresAt.Month(), expiresAt.Day(),
-			expiresAt.Hour(), expiresAt.Minute(), expiresAt.Second(), expiresAt.Nanosecond(), loc)
-	}
-	ttl := int(expiresLocal.Sub(now).Seconds())
-	if ttl < 0 {
-		ttl = 0
+	var instanceID int64
+	err := db.QueryRow(fmt.Sprintf(`
+		UPDATE team_instances SET expires_at = expires_at + INTERVAL '%d minutes', updated_at = CURRENT_TIMESTAMP
+		WHERE challenge_id = $1 AND owner_key = $2 AND status = 'running' RETURNING id`, extendTTL),
+		job.ChallengeID, job.OwnerKey).Scan(&instanceID)
+	if err != nil {
+		job.update(db, JobFailed, 0, "队伍没有运行中的实例")
+		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message":   fmt.Sprintf("已延长%d分钟", extendTTL),
-		"expiresAt": expiresAt.Format("2006-01-02 15:04:05"),
-		"ttl":       ttl,
-	})
+	job.update(db, JobDone, instanceID, fmt.Sprintf("已延长%d分钟", extendTTL))
 
 	// 记录容器续期日志
-	clientIP := c.ClientIP()
-	contestID := c.Param("id")
-	contestIDInt, _ := strconv.ParseInt(contestID, 10, 64)
-	challengeIDInt, _ := strconv.ParseInt(challengeID, 10, 64)
+	userID, teamID := job.UserID, job.TeamID
 	var displayName, challengeName string
 	db.QueryRow(`SELECT display_name FROM users WHERE id = $1`, userID).Scan(&displayName)
-	db.QueryRow(`SELECT COALESCE(q.title, cc.inline_title) FROM contest_challenges cc LEFT JOIN question_bank q ON q.id = cc.question_id WHERE cc.id = $1`, challengeID).Scan(&challengeName)
-	logs.WriteLog(db, logs.TypeContainerExtend, logs.LevelInfo, &userID, &teamID.Int64, &contestIDInt, &challengeIDInt, clientIP,
+	db.QueryRow(`SELECT COALESCE(q.title, cc.inline_title) FROM contest_challenges cc LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version WHERE cc.id = $1`, job.ChallengeID).Scan(&challengeName)
+	logs.WriteLog(db, logs.TypeContainerExtend, logs.LevelInfo, &userID, &teamID, &job.ContestID, &job.ChallengeID, clientIP,
 		displayName+" 续期题目 ["+challengeName+"] 的容器实例", map[string]interface{}{
 			"extendMinutes": extendTTL,
 		})
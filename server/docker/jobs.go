@@ -0,0 +1,204 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package docker
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 实例任务类型
+const (
+	JobCreate  = "create"  // 创建实例
+	JobDestroy = "destroy" // 销毁实例
+	JobExtend  = "extend"  // 续期实例
+)
+
+// 实例任务状态
+const (
+	JobQueued        = "queued"         // 等待执行（资源不足时在排队中）
+	JobPulling       = "pulling"        // 拉取镜像
+	JobStarting      = "starting"       // 启动容器
+	JobInjectingFlag = "injecting_flag" // 注入 Flag
+	JobStopping      = "stopping"       // 删除容器
+	JobExtending     = "extending"      // 延长有效期
+	JobReady         = "ready"          // 实例已就绪（创建任务结束）
+	JobDone          = "done"           // 销毁/续期完成
+	JobFailed        = "failed"         // 任务失败
+)
+
+// instanceJob 实例任务
+type instanceJob struct {
+	ID          int64
+	Kind        string
+	ContestID   int64
+	ChallengeID int64
+	TeamID      int64
+	UserID      int64
+	Policy      string
+	OwnerKey    string
+}
+
+// isJobFinished 任务是否已结束
+func isJobFinished(status string) bool {
+	return status == JobReady || status == JobDone || status == JobFailed
+}
+
+// createJob 新建实例任务（初始状态 queued）
+func createJob(db *sql.DB, kind, contestID, challengeID string, teamID, userID int64, policy string) (*instanceJob, error) {
+	j := &instanceJob{Kind: kind, TeamID: teamID, UserID: userID, Policy: policy, OwnerKey: InstanceOwnerKey(policy, teamID, userID)}
+	j.ContestID, _ = strconv.ParseInt(contestID, 10, 64)
+	j.ChallengeID, _ = strconv.ParseInt(challengeID, 10, 64)
+	err := db.QueryRow(`
+		INSERT INTO instance_jobs (kind, contest_id, challenge_id, team_id, user_id, policy, owner_key, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		kind, j.ContestID, j.ChallengeID, teamID, userID, policy, j.OwnerKey, JobQueued).Scan(&j.ID)
+	if err != nil {
+		return nil, err
+	}
+	db.Exec(`INSERT INTO instance_job_events (job_id, status) VALUES ($1, $2)`, j.ID, JobQueued)
+	return j, nil
+}
+
+// reserveResources 记录创建任务预计占用的内存 / CPU（任务结束前计入队伍配额）
+func (j *instanceJob) reserveResources(db *sql.DB, memoryMB int, cpuCores float64) {
+	db.Exec(`UPDATE instance_jobs SET memory_mb = $2, cpu_cores = $3 WHERE id = $1`, j.ID, memoryMB, cpuCores)
+}
+
+// loadJob 按ID查询实例任务
+func loadJob(db *sql.DB, id int64) *instanceJob {
+	j := &instanceJob{}
+	err := db.QueryRow(`SELECT id, kind, contest_id, challenge_id, team_id, user_id, policy, owner_key FROM instance_jobs WHERE id = $1`, id).Scan(
+		&j.ID, &j.Kind, &j.ContestID, &j.ChallengeID, &j.TeamID, &j.UserID, &j.Policy, &j.OwnerKey)
+	if err != nil {
+		return nil
+	}
+	return j
+}
+
+// findActiveJob 查询同一归属+题目未结束的任务
+func findActiveJob(db *sql.DB, challengeID, ownerKey string) (int64, string) {
+	var id int64
+	var kind string
+	db.QueryRow(`SELECT id, kind FROM instance_jobs WHERE challenge_id = $1 AND owner_key = $2 AND status NOT IN ('ready', 'done', 'failed') ORDER BY id LIMIT 1`,
+		challengeID, ownerKey).Scan(&id, &kind)
+	return id, kind
+}
+
+// update 记录任务状态变化并推送给队伍（j 为 nil 时忽略，便于预热池等无任务的调用）
+func (j *instanceJob) update(db *sql.DB, status string, instanceID int64, message string) {
+	if j == nil {
+		return
+	}
+	db.Exec(`
+		UPDATE instance_jobs SET status = $2, instance_id = COALESCE(NULLIF($3, 0), instance_id), message = NULLIF($4, ''),
+			updated_at = CURRENT_TIMESTAMP, finished_at = CASE WHEN $5 THEN CURRENT_TIMESTAMP ELSE NULL END
+		WHERE id = $1`, j.ID, status, instanceID, message, isJobFinished(status))
+	db.Exec(`INSERT INTO instance_job_events (job_id, status, message) VALUES ($1, $2, NULLIF($3, ''))`, j.ID, status, message)
+
+	ev := InstanceEvent{Type: EventJobProgress, ContestID: j.ContestID, ChallengeID: j.ChallengeID, JobID: j.ID, JobKind: j.Kind,
+		Status: status, InstanceID: instanceID, Message: message}
+	if j.Policy == PolicyUser {
+		ev.UserID = j.UserID
+	}
+	PublishTeamEvent(j.TeamID, ev)
+}
+
+// jobAccepted 返回任务已受理响应
+func jobAccepted(c *gin.Context, j *instanceJob, message string) {
+	c.JSON(http.StatusAccepted, gin.H{"jobId": j.ID, "kind": j.Kind, "status": JobQueued, "message": message})
+}
+
+// HandleGetInstanceJob 查询实例任务状态及状态变化记录
+func HandleGetInstanceJob(c *gin.Context, db *sql.DB) {
+	claims, _ := c.Get("claims")
+	claimsMap := claims.(jwt.MapClaims)
+	userID := int64(claimsMap["sub"].(float64))
+
+	var teamID sql.NullInt64
+	db.QueryRow(`SELECT team_id FROM users WHERE id = $1`, userID).Scan(&teamID)
+
+	var id, jobTeamID, jobUserID, challengeID int64
+	var instanceID sql.NullInt64
+	var kind, policy, status, message string
+	var createdAt, updatedAt time.Time
+	err := db.QueryRow(`
+		SELECT id, kind, team_id, user_id, challenge_id, policy, status, instance_id, COALESCE(message, ''), created_at, updated_at
+		FROM instance_jobs WHERE id = $1 AND contest_id = $2`, c.Param("jobId"), c.Param("id")).Scan(
+		&id, &kind, &jobTeamID, &jobUserID, &challengeID, &policy, &status, &instanceID, &message, &createdAt, &updatedAt)
+	if err != nil || !teamID.Valid || jobTeamID != teamID.Int64 || (policy == PolicyUser && jobUserID != userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "JOB_NOT_FOUND", "message": "任务不存在"})
+		return
+	}
+
+	events := []gin.H{}
+	rows, err := db.Query(`SELECT status, COALESCE(message, ''), created_at FROM instance_job_events WHERE job_id = $1 ORDER BY id`, id)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var evStatus, evMessage string
+			var at time.Time
+			if rows.Scan(&evStatus, &evMessage, &at) == nil {
+				events = append(events, gin.H{"status": evStatus, "message": evMessage, "at": at.Format("2006-01-02 15:04:05")})
+			}
+		}
+	}
+
+	resp := gin.H{
+		"jobId":       id,
+		"kind":        kind,
+		"challengeId": challengeID,
+		"status":      status,
+		"message":     message,
+		"finished":    isJobFinished(status),
+		"createdAt":   createdAt.Format("2006-01-02 15:04:05"),
+		"updatedAt":   updatedAt.Format("2006-01-02 15:04:05"),
+		"events":      events,
+	}
+	if instanceID.Valid {
+		resp["instanceId"] = instanceID.Int64
+	}
+	// 排队中的创建任务返回排队位置
+	if status == JobQueued {
+		var ticketID int64
+		if db.QueryRow(`SELECT id FROM instance_queue WHERE job_id = $1 AND status IN ('waiting', 'launching')`, id).Scan(&ticketID) == nil {
+			resp["ticketId"] = ticketID
+			resp["position"] = queuePosition(db, ticketID)
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// recoverInstanceJobs 服务重启时处理中断的任务：仍在排队的创建任务保留，其余标记为失败
+func recoverInstanceJobs(db *sql.DB) {
+	rows, err := db.Query(`
+		SELECT id FROM instance_jobs j
+		WHERE status NOT IN ('ready', 'done', 'failed')
+		  AND NOT EXISTS(SELECT 1 FROM instance_queue q WHERE q.job_id = j.id AND q.status IN ('waiting', 'launching'))`)
+	if err != nil {
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	for _, id := range ids {
+		loadJob(db, id).update(db, JobFailed, 0, "服务重启，任务已中断，请重试")
+	}
+	// 排队中断的创建过程会重新排队，对应任务回到 queued
+	db.Exec(`
+		UPDATE instance_jobs SET status = 'queued', updated_at = CURRENT_TIMESTAMP
+		WHERE status NOT IN ('ready', 'done', 'failed', 'queued')
+		  AND id IN (SELECT job_id FROM instance_queue WHERE status IN ('waiting', 'launching') AND job_id IS NOT NULL)`)
+}
@@ -0,0 +1,358 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package docker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"tgctf/server/container"
+	"tgctf/server/logs"
+)
+
+// 未配置内存限制的容器按该值计入全局内存总量
+const defaultInstanceMemoryMB = 256
+
+// instanceLaunch 容器实例创建参数（请求处理与排队调度共用）
+type instanceLaunch struct {
+	ContestID       string
+	ChallengeID     string
+	TeamID          int64
+	UserID          int64
+	Policy          string
+	OwnerKey        string
+	Image           string
+	Ports           []string
+	CPULimit        string
+	MemoryLimit     string
+	MemoryMB        int
+	CPUCores        float64
+	Network         string // 出网策略: full | platform | none
+	StorageLimit    string // 磁盘配额
+	NoResourceLimit bool   // 不限制 CPU / 内存 / 磁盘 / 进程数
+	ReadOnly        bool   // 只读根文件系统
+	AllowSetuid     bool   // 允许容器内提权
+	FlagEnv         string
+	FlagScript      string
+	Flag            string
+	Job             *instanceJob // 关联的任务，用于上报创建进度
+}
+
+// launchResult 容器实例创建结果
+type launchResult struct {
+	InstanceID    int64
+	ContainerID   string
+	ContainerName string
+	Node          string
+	Ports         map[string]string
+	ExpiresAt     time.Time
+	TTL           int // 初始有效期（分钟）
+}
+
+// launchError 创建失败原因，Retry 表示资源不足，可以排队等待
+type launchError struct {
+	Status  int
+	Code    string
+	Message string
+	Details string
+	Retry   bool
+}
+
+func (e *launchError) Error() string {
+	if e.Details != "" {
+		return e.Message + ": " + e.Details
+	}
+	return e.Message
+}
+
+// respondLaunchError 返回创建失败响应
+func respondLaunchError(c *gin.Context, err error) {
+	le, ok := err.(*launchError)
+	if !ok {
+		le = &launchError{Status: http.StatusInternalServerError, Code: "CONTAINER_CREATE_FAILED", Message: "创建容器失败", Details: err.Error()}
+	}
+	resp := gin.H{"error": le.Code, "message": le.Message}
+	if le.Details != "" {
+		resp["details"] = le.Details
+	}
+	c.JSON(le.Status, resp)
+}
+
+// parseMemoryMB 解析容器内存限制（512m / 1g / 1024k / 字节数），无法解析时返回 0
+func parseMemoryMB(s string) int {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.TrimSuffix(s, "b")
+	if s == "" {
+		return 0
+	}
+	unit := 1.0 / (1024 * 1024)
+	switch s[len(s)-1] {
+	case 'k':
+		unit, s = 1.0/1024, s[:len(s)-1]
+	case 'm':
+		unit, s = 1, s[:len(s)-1]
+	case 'g':
+		unit, s = 1024, s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 {
+		return 0
+	}
+	return int(v*unit + 0.5)
+}
+
+// prepareLaunch 查询题目容器配置并生成 Flag
+func prepareLaunch(db *sql.DB, contestID, challengeID string, teamID, userID int64, policy string) (*instanceLaunch, error) {
+	l, err := loadChallengeSpec(db, contestID, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	l.TeamID, l.UserID, l.Policy = teamID, userID, policy
+	l.OwnerKey = InstanceOwnerKey(policy, teamID, userID)
+
+	// 队伍/个人实例使用队伍动态 Flag，共享实例全场相同，只能使用题目静态 Flag
+	if policy == PolicyShared {
+		l.Flag = getSharedFlag(db, challengeID)
+		if l.Flag == "" {
+			return nil, &launchError{Status: http.StatusBadRequest, Code: "SHARED_FLAG_MISSING", Message: "共享实例题目需要配置静态 Flag"}
+		}
+	} else {
+		l.Flag = GetOrCreateTeamFlag(db, teamID, contestID, challengeID)
+	}
+	return l, nil
+}
+
+// loadChallengeSpec 查询题目的容器配置（不含归属与 Flag）
+func loadChallengeSpec(db *sql.DB, contestID, challengeID string) (*instanceLaunch, error) {
+	var dockerImage, ports, cpuLimit, memoryLimit, flagEnv, flagScript, network, storageLimit sql.NullString
+	var questionID int64
+	var noResourceLimit, readOnly, allowSetuid bool
+
+	// Jeopardy/AWD 模式：查询 contest_challenges 和 question_bank（支持临时题目）
+	err := db.QueryRow(`
+		SELECT COALESCE(q.question_id, 0), COALESCE(q.docker_image, cc.inline_docker_image), COALESCE(q.ports, cc.inline_ports),
+		       COALESCE(q.cpu_limit, cc.inline_cpu_limit), COALESCE(q.memory_limit, cc.inline_memory_limit),
+		       COALESCE(q.flag_env, cc.inline_flag_env), COALESCE(q.flag_script, cc.inline_flag_script),
+		       COALESCE(q.network_policy, cc.inline_network_policy),
+		       q.storage_limit, COALESCE(q.no_resource_limit, false), COALESCE(q.read_only_rootfs, false), COALESCE(q.allow_setuid, false)
+		FROM contest_challenges cc
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
+		WHERE cc.id = $1 AND cc.contest_id = $2`,
+		challengeID, contestID).Scan(&questionID, &dockerImage, &ports, &cpuLimit, &memoryLimit, &flagEnv, &flagScript, &network,
+		&storageLimit, &noResourceLimit, &readOnly, &allowSetuid)
+	if err != nil {
+		fmt.Printf("[DEBUG] Query question failed: %v\n", err)
+		return nil, &launchError{Status: http.StatusNotFound, Code: "CHALLENGE_NOT_FOUND", Message: "题目不存在"}
+	}
+	fmt.Printf("[DEBUG] questionID=%d, dockerImage=%s, ports=%s\n", questionID, dockerImage.String, ports.String)
+
+	if !dockerImage.Valid || dockerImage.String == "" {
+		return nil, &launchError{Status: http.StatusBadRequest, Code: "NO_DOCKER_IMAGE", Message: "该题目没有配置容器镜像"}
+	}
+
+	l := &instanceLaunch{
+		ContestID:       contestID,
+		ChallengeID:     challengeID,
+		Image:           dockerImage.String,
+		CPULimit:        cpuLimit.String,
+		MemoryLimit:     memoryLimit.String,
+		MemoryMB:        parseMemoryMB(memoryLimit.String),
+		CPUCores:        parseCPUCores(cpuLimit.String),
+		Network:         network.String,
+		StorageLimit:    storageLimit.String,
+		NoResourceLimit: noResourceLimit,
+		ReadOnly:        readOnly,
+		AllowSetuid:     allowSetuid,
+		FlagEnv:         flagEnv.String,
+		FlagScript:      flagScript.String,
+	}
+	if l.MemoryMB == 0 {
+		l.MemoryMB = defaultInstanceMemoryMB
+	}
+	if ports.Valid && ports.String != "" {
+		json.Unmarshal([]byte(ports.String), &l.Ports)
+	}
+	return l, nil
+}
+
+// launchInstance 选择节点、分配端口、启动容器并写入实例记录（预热池有可用容器时直接领取）
+func launchInstance(db *sql.DB, l *instanceLaunch) (*launchResult, error) {
+	if r := claimWarmContainer(db, l); r != nil {
+		return r, nil
+	}
+
+	containerName := fmt.Sprintf("tg_team_%d_%s_%d", l.TeamID, l.ChallengeID, time.Now().Unix())
+	if l.Policy == PolicyShared {
+		containerName = fmt.Sprintf("tg_shared_%s_%d", l.ChallengeID, time.Now().Unix())
+	}
+	spec := &container.Spec{
+		Name:        containerName,
+		Image:       l.Image,
+		Ports:       l.Ports,
+		CPULimit:    l.CPULimit,
+		MemoryLimit: l.MemoryLimit,
+		Network:     l.Network,
+		ReadOnly:    l.ReadOnly,
+		AllowSetuid: l.AllowSetuid,
+		Labels: map[string]string{
+			"tg.type":         "team",
+			"tg.team_id":      strconv.FormatInt(l.TeamID, 10),
+			"tg.challenge_id": l.ChallengeID,
+			"tg.policy":       l.Policy,
+		},
+	}
+	if l.Policy == PolicyUser {
+		spec.Labels["tg.user_id"] = strconv.FormatInt(l.UserID, 10)
+	}
+	container.ApplyLimits(spec, l.StorageLimit, l.NoResourceLimit)
+
+	// 处理 Flag 注入方式：环境变量 和/或 命令行参数
+	spec.Env, spec.Args = container.FlagEnv(l.FlagEnv, l.Flag)
+
+	// 选择工作节点并从该节点的端口池分配端口
+	// 使用全局锁确保节点选择、端口分配到 docker run 完成之间的原子性
+	portAllocMu.Lock()
+	rt, node, err := container.Place(db, "team")
+	if err != nil {
+		portAllocMu.Unlock()
+		return nil, &launchError{Status: http.StatusServiceUnavailable, Code: "NO_AVAILABLE_HOST", Message: "暂无可用的容器节点，请稍后再试", Details: err.Error(), Retry: true}
+	}
+
+	// 节点本地没有镜像时先单独拉取，便于上报进度（拉取期间不持有端口锁）
+	if puller, ok := rt.(container.ImagePuller); ok {
+		pullCtx, pullCancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		if !puller.HasImage(pullCtx, l.Image) {
+			portAllocMu.Unlock()
+			l.Job.update(db, JobPulling, 0, "正在拉取镜像 "+l.Image)
+			err := loginRegistry(pullCtx, db, rt, l.Image)
+			if err == nil {
+				err = puller.Pull(pullCtx, l.Image)
+			}
+			pullCancel()
+			if err != nil {
+				return nil, &launchError{Status: http.StatusInternalServerError, Code: "IMAGE_PULL_FAILED", Message: "拉取镜像失败", Details: err.Error()}
+			}
+			portAllocMu.Lock()
+		} else {
+			pullCancel()
+		}
+	}
+	l.Job.update(db, JobStarting, 0, "")
+
+	if err := publishPorts(db, rt, spec, node); err != nil {
+		portAllocMu.Unlock()
+		return nil, &launchError{Status: http.StatusInternalServerError, Code: "PORT_ALLOCATION_FAILED", Message: "端口分配失败: " + err.Error(), Retry: true}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	fmt.Printf("[DEBUG] Container spec: backend=%s, %+v\n", rt.Name(), spec)
+	result, err := rt.Run(ctx, spec)
+
+	// docker run 完成后释放端口锁
+	portAllocMu.Unlock()
+
+	if err != nil {
+		fmt.Printf("[DEBUG] Container run failed: %v\n", err)
+		return nil, &launchError{Status: http.StatusInternalServerError, Code: "CONTAINER_CREATE_FAILED", Message: "创建容器失败", Details: err.Error()}
+	}
+	fmt.Printf("[DEBUG] Container ID: %s, node: %s\n", result.ID, result.Node)
+
+	// 如果配置了 flag_script，在容器启动后执行脚本注入 Flag
+	if l.FlagScript != "" {
+		l.Job.update(db, JobInjectingFlag, 0, "")
+		fmt.Printf("[DEBUG] Executing flag script: %s\n", l.FlagScript)
+		time.Sleep(500 * time.Millisecond) // 等待容器完全启动
+		scriptOutput, scriptErr := rt.Exec(ctx, result.ID, "sh", l.FlagScript, l.Flag)
+		if scriptErr != nil {
+			fmt.Printf("[DEBUG] Flag script execution failed: %v, output: %s\n", scriptErr, string(scriptOutput))
+			// 脚本执行失败不阻塞容器创建，仅记录日志
+		} else {
+			fmt.Printf("[DEBUG] Flag script executed successfully\n")
+		}
+	}
+
+	return saveInstance(db, l, rt, result.ID, containerName, result.Node, result.Ports)
+}
+
+// publishPorts 从节点端口池分配宿主机端口；关闭端口发布时容器不占用端口，仅通过平台代理访问
+func publishPorts(db *sql.DB, rt container.Runtime, spec *container.Spec, node string) error {
+	if len(spec.Ports) == 0 {
+		return nil
+	}
+	if IsPortPublishEnabled != nil && !IsPortPublishEnabled(db) {
+		spec.Internal = true
+		return nil
+	}
+	// Kubernetes 的 NodePort 由集群在 service-node-port-range 内分配，创建后从 Service 读回，不使用节点端口池
+	if _, ok := rt.(container.NodeRuntime); !ok || AllocatePorts == nil {
+		return nil
+	}
+	allocated, err := AllocatePorts(db, node, len(spec.Ports))
+	if err != nil {
+		return err
+	}
+	spec.HostPorts = allocated
+	fmt.Printf("[DEBUG] Allocated ports: %v\n", allocated)
+	return nil
+}
+
+// saveInstance 写入实例记录，失败时删除容器
+func saveInstance(db *sql.DB, l *instanceLaunch, rt container.Runtime, containerID, containerName, node string, ports map[string]string) (*launchResult, error) {
+	portsJSON, _ := json.Marshal(ports)
+	initialTTL := 120 // 默认值
+	if GetContainerTTL != nil {
+		initialTTL = GetContainerTTL(db)
+	}
+	expiresAt := time.Now().Add(time.Duration(initialTTL) * time.Minute)
+
+	var instanceID int64
+	err := db.QueryRow(`
+		INSERT INTO team_instances (team_id, contest_id, challenge_id, container_id, container_name, ports, status, expires_at, created_by, backend, node, user_id, owner_key, memory_mb, access_token, cpu_cores)
+		VALUES ($1, $2, $3, $4, $5, $6, 'running', $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (challenge_id, owner_key) DO UPDATE SET
+			team_id = $1, container_id = $4, container_name = $5, ports = $6, status = 'running', expires_at = $7, created_by = $8,
+			backend = $9, node = $10, user_id = $11, memory_mb = $13, access_token = $14, cpu_cores = $15, restart_count = 0, updated_at = CURRENT_TIMESTAMP
+		RETURNING id`,
+		l.TeamID, l.ContestID, l.ChallengeID, containerID, containerName, string(portsJSON), expiresAt, l.UserID, rt.Name(), node,
+		l.UserID, l.OwnerKey, l.MemoryMB, newAccessToken(), l.CPUCores).Scan(&instanceID)
+	if err != nil {
+		fmt.Printf("[DEBUG] DB insert failed: %v\n", err)
+		rt.Remove(context.Background(), containerID)
+		return nil, &launchError{Status: http.StatusInternalServerError, Code: "DB_ERROR", Message: "保存实例失败", Details: err.Error()}
+	}
+
+	return &launchResult{
+		InstanceID:    instanceID,
+		ContainerID:   containerID,
+		ContainerName: containerName,
+		Node:          node,
+		Ports:         ports,
+		ExpiresAt:     expiresAt,
+		TTL:           initialTTL,
+	}, nil
+}
+
+// logInstanceCreate 记录容器创建日志
+func logInstanceCreate(db *sql.DB, l *instanceLaunch, r *launchResult, clientIP string) {
+	contestIDInt, _ := strconv.ParseInt(l.ContestID, 10, 64)
+	challengeIDInt, _ := strconv.ParseInt(l.ChallengeID, 10, 64)
+	userID, teamID := l.UserID, l.TeamID
+	var displayName, challengeName string
+	db.QueryRow(`SELECT display_name FROM users WHERE id = $1`, userID).Scan(&displayName)
+	db.QueryRow(`SELECT COALESCE(q.title, cc.inline_title) FROM contest_challenges cc LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version WHERE cc.id = $1`, l.ChallengeID).Scan(&challengeName)
+	logs.WriteLog(db, logs.TypeContainerCreate, logs.LevelSuccess, &userID, &teamID, &contestIDInt, &challengeIDInt, clientIP,
+		displayName+" 启动题目 ["+challengeName+"] 的容器实例", map[string]interface{}{
+			"containerId": r.ContainerID, "ports": r.Ports,
+		})
+}
@@ -0,0 +1,63 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package docker
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// 题目容器实例策略
+const (
+	PolicyTeam   = "team"   // 每队一个实例，队员共享
+	PolicyUser   = "user"   // 每人一个实例
+	PolicyShared = "shared" // 全场共享一个实例（如公共 SSRF 靶机）
+)
+
+// IsValidInstancePolicy 校验实例策略
+func IsValidInstancePolicy(policy string) bool {
+	return policy == PolicyTeam || policy == PolicyUser || policy == PolicyShared
+}
+
+// GetInstancePolicy 获取比赛题目的实例策略，未配置时为 team
+func GetInstancePolicy(db *sql.DB, challengeID string) string {
+	var policy string
+	db.QueryRow(`SELECT COALESCE(instance_policy, 'team') FROM contest_challenges WHERE id = $1`, challengeID).Scan(&policy)
+	if !IsValidInstancePolicy(policy) {
+		return PolicyTeam
+	}
+	return policy
+}
+
+// InstanceOwnerKey 实例归属键（team_instances.owner_key）: t:<队伍ID> | u:<用户ID> | shared
+func InstanceOwnerKey(policy string, teamID, userID int64) string {
+	switch policy {
+	case PolicyUser:
+		return fmt.Sprintf("u:%d", userID)
+	case PolicyShared:
+		return "shared"
+	}
+	return fmt.Sprintf("t:%d", teamID)
+}
+
+// limitScope 容器数量限制的统计范围（共享实例不计入限制）
+// 返回 WHERE 条件（参数 $1 为队伍或用户ID，$2 为比赛ID）和对应的ID
+func limitScope(policy string, teamID, userID int64) (string, int64) {
+	if policy == PolicyUser {
+		return `user_id = $1 AND contest_id = $2 AND owner_key LIKE 'u:%'`, userID
+	}
+	return `team_id = $1 AND contest_id = $2 AND owner_key LIKE 't:%'`, teamID
+}
+
+// getSharedFlag 共享实例使用题目的静态 Flag（全场相同，提交时按静态 Flag 校验）
+func getSharedFlag(db *sql.DB, challengeID string) string {
+	var flag string
+	db.QueryRow(`
+		SELECT COALESCE(NULLIF(q.flag, ''), cc.inline_flag, '')
+		FROM contest_challenges cc
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
+		WHERE cc.id = $1`, challengeID).Scan(&flag)
+	return flag
+}
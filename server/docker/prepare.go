@@ -0,0 +1,357 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package docker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tgctf/server/container"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 比赛镜像预拉取：收集比赛所有容器题（题库、临时题目、AWD-F）引用的镜像，
+// 使用私有仓库凭证拉取到所有可调度该类型实例的节点并校验摘要；缺少镜像的比赛不允许开始
+
+// GetRegistryCredentials 获取私有仓库凭证（由 main 注入 admin.GetRegistryCredentials）
+var GetRegistryCredentials func(db *sql.DB) []container.RegistryAuth
+
+// 镜像预拉取状态
+const (
+	PreparePending = "pending" // 等待拉取
+	PreparePulling = "pulling" // 拉取中
+	PrepareReady   = "ready"   // 所有节点已就绪
+	PrepareFailed  = "failed"  // 拉取或校验失败
+	PrepareSkipped = "skipped" // 后端不支持预拉取（如 Kubernetes 由 kubelet 拉取）
+)
+
+// PrepareNode 单个节点上的镜像状态
+type PrepareNode struct {
+	Node   string `json:"node"`
+	Status string `json:"status"`
+	Digest string `json:"digest"`
+	Error  string `json:"error,omitempty"`
+}
+
+// PrepareImage 单个镜像的预拉取进度
+type PrepareImage struct {
+	Image      string         `json:"image"`
+	Kind       string         `json:"kind"` // team | awdf，决定拉取到哪些节点
+	Challenges []string       `json:"challenges"`
+	Status     string         `json:"status"`
+	Digest     string         `json:"digest"`
+	Nodes      []*PrepareNode `json:"nodes"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// PrepareJob 比赛镜像预拉取任务
+type PrepareJob struct {
+	ContestID  int64           `json:"contestId"`
+	Status     string          `json:"status"` // pulling | ready | failed
+	StartedAt  string          `json:"startedAt"`
+	FinishedAt string          `json:"finishedAt,omitempty"`
+	Images     []*PrepareImage `json:"images"`
+}
+
+var (
+	prepareMu   sync.Mutex
+	prepareJobs = make(map[int64]*PrepareJob) // 比赛ID -> 最近一次预拉取任务
+)
+
+// contestImages 收集比赛引用的镜像（同一镜像被多道题引用时合并）
+func contestImages(db *sql.DB, contestID int64) ([]*PrepareImage, error) {
+	rows, err := db.Query(`
+		SELECT image, kind, title FROM (
+			SELECT COALESCE(NULLIF(q.docker_image, ''), cc.inline_docker_image) AS image, 'team' AS kind,
+				COALESCE(q.title, cc.inline_title, '') AS title
+			FROM contest_challenges cc
+			LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
+			WHERE cc.contest_id = $1
+			UNION ALL
+			SELECT qa.docker_image, 'awdf', qa.title
+			FROM contest_challenges_awdf cca
+			JOIN question_bank_awdf qa ON cca.question_id = qa.id
+			WHERE cca.contest_id = $1
+		) t WHERE COALESCE(image, '') <> ''`, contestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byKey := make(map[string]*PrepareImage)
+	var images []*PrepareImage
+	for rows.Next() {
+		var image, kind, title string
+		if err := rows.Scan(&image, &kind, &title); err != nil {
+			continue
+		}
+		image = strings.TrimSpace(image)
+		key := kind + "|" + image
+		img, ok := byKey[key]
+		if !ok {
+			img = &PrepareImage{Image: image, Kind: kind, Status: PreparePending, Challenges: []string{}, Nodes: []*PrepareNode{}}
+			byKey[key] = img
+			images = append(images, img)
+		}
+		img.Challenges = append(img.Challenges, title)
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].Image < images[j].Image })
+	return images, nil
+}
+
+// registryAuthFor 查找镜像所在仓库的凭证
+func registryAuthFor(db *sql.DB, image string) *container.RegistryAuth {
+	if GetRegistryCredentials == nil {
+		return nil
+	}
+	registry := container.ImageRegistry(image)
+	for _, cred := range GetRegistryCredentials(db) {
+		if cred.Registry == registry {
+			return &cred
+		}
+	}
+	return nil
+}
+
+// loginRegistry 拉取镜像前登录镜像所在的私有仓库（未配置凭证时跳过）
+func loginRegistry(ctx context.Context, db *sql.DB, rt container.Runtime, image string) error {
+	auth := registryAuthFor(db, image)
+	if auth == nil {
+		return nil
+	}
+	if client, ok := rt.(container.RegistryClient); ok {
+		return client.Login(ctx, *auth)
+	}
+	return nil
+}
+
+// pullAndVerify 在单个节点上拉取镜像并返回摘要
+// 拉取失败但节点本地已有镜像（如本地构建的镜像）时沿用本地镜像；镜像名固定了摘要时校验是否一致
+func pullAndVerify(db *sql.DB, rt container.Runtime, image string) (string, error) {
+	puller, ok := rt.(container.ImagePuller)
+	if !ok {
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+	defer cancel()
+
+	if err := loginRegistry(ctx, db, rt, image); err != nil {
+		return "", err
+	}
+	if err := puller.Pull(ctx, image); err != nil && !puller.HasImage(ctx, image) {
+		return "", err
+	}
+
+	client, ok := rt.(container.RegistryClient)
+	if !ok {
+		return "", nil
+	}
+	digests, err := client.ImageDigests(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	digest := ""
+	if len(digests) > 0 {
+		digest = digests[0][strings.LastIndex(digests[0], "@")+1:]
+	}
+	if pinned := container.PinnedDigest(image); pinned != "" {
+		for _, d := range digests {
+			if strings.HasSuffix(d, "@"+pinned) {
+				return pinned, nil
+			}
+		}
+		return digest, fmt.Errorf("镜像摘要不一致: 期望 %s，实际 %s", pinned, digest)
+	}
+	return digest, nil
+}
+
+// runPrepare 依次拉取每个镜像（同一镜像在各节点并行拉取）
+func runPrepare(db *sql.DB, job *PrepareJob) {
+	failed := false
+	for _, img := range job.Images {
+		targets := container.Eligible(db, img.Kind)
+
+		prepareMu.Lock()
+		img.Status = PreparePulling
+		for node := range targets {
+			img.Nodes = append(img.Nodes, &PrepareNode{Node: node, Status: PreparePulling})
+		}
+		sort.Slice(img.Nodes, func(i, j int) bool { return img.Nodes[i].Node < img.Nodes[j].Node })
+		if len(targets) == 0 {
+			img.Error = "没有可调度该类型实例的节点"
+		}
+		prepareMu.Unlock()
+
+		var wg sync.WaitGroup
+		for _, n := range img.Nodes {
+			wg.Add(1)
+			go func(n *PrepareNode, rt container.Runtime) {
+				defer wg.Done()
+				status := PrepareReady
+				if _, ok := rt.(container.ImagePuller); !ok {
+					status = PrepareSkipped
+				}
+				digest, err := pullAndVerify(db, rt, img.Image)
+				prepareMu.Lock()
+				defer prepareMu.Unlock()
+				n.Digest = digest
+				if err != nil {
+					n.Status, n.Error = PrepareFailed, err.Error()
+				} else {
+					n.Status = status
+				}
+			}(n, targets[n.Node])
+		}
+		wg.Wait()
+
+		// 汇总：任一节点失败即失败；各节点摘要不一致视为失败（同一题目在不同节点上运行的版本不同）
+		prepareMu.Lock()
+		img.Status = PrepareReady
+		if len(img.Nodes) == 0 {
+			img.Status = PrepareFailed
+		}
+		skipped := 0
+		for _, n := range img.Nodes {
+			switch n.Status {
+			case PrepareFailed:
+				img.Status = PrepareFailed
+				if img.Error == "" {
+					img.Error = fmt.Sprintf("节点 %s: %s", nodeLabel(n.Node), n.Error)
+				}
+			case PrepareSkipped:
+				skipped++
+			}
+			if n.Digest == "" {
+				continue
+			}
+			if img.Digest == "" {
+				img.Digest = n.Digest
+			} else if img.Digest != n.Digest && img.Status != PrepareFailed {
+				img.Status = PrepareFailed
+				img.Error = fmt.Sprintf("各节点镜像摘要不一致（%s 为 %s）", nodeLabel(n.Node), n.Digest)
+			}
+		}
+		if img.Status == PrepareReady && skipped > 0 && skipped == len(img.Nodes) {
+			img.Status = PrepareSkipped
+		}
+		if img.Status == PrepareFailed {
+			failed = true
+		}
+		prepareMu.Unlock()
+	}
+
+	prepareMu.Lock()
+	job.Status = PrepareReady
+	if failed {
+		job.Status = PrepareFailed
+	}
+	job.FinishedAt = time.Now().Format("2006-01-02 15:04:05")
+	prepareMu.Unlock()
+	log.Printf("[Prepare] 比赛 %d 镜像预拉取结束: %s", job.ContestID, job.Status)
+}
+
+// nodeLabel 节点显示名称（本机节点名称为空）
+func nodeLabel(node string) string {
+	if node == "" {
+		return "本机"
+	}
+	return node
+}
+
+// MissingContestImages 检查比赛引用的镜像是否已存在于所有可调度节点，返回缺失项（镜像@节点）
+// 不支持单独拉取镜像的后端不做检查
+func MissingContestImages(db *sql.DB, contestID int64) []string {
+	images, err := contestImages(db, contestID)
+	if err != nil {
+		return nil
+	}
+	var missing []string
+	for _, img := range images {
+		for node, rt := range container.Eligible(db, img.Kind) {
+			puller, ok := rt.(container.ImagePuller)
+			if !ok {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if !puller.HasImage(ctx, img.Image) {
+				missing = append(missing, img.Image+"@"+nodeLabel(node))
+			}
+			cancel()
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// HandleStartContestPrepare 开始比赛镜像预拉取
+func HandleStartContestPrepare(c *gin.Context, db *sql.DB) {
+	contestID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_ID"})
+		return
+	}
+	images, err := contestImages(db, contestID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+
+	prepareMu.Lock()
+	if job, ok := prepareJobs[contestID]; ok && job.Status == PreparePulling {
+		prepareMu.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "PREPARE_RUNNING", "message": "镜像预拉取正在进行中"})
+		return
+	}
+	job := &PrepareJob{ContestID: contestID, Status: PreparePulling, StartedAt: time.Now().Format("2006-01-02 15:04:05"), Images: images}
+	if len(images) == 0 {
+		job.Status = PrepareReady
+		job.FinishedAt = job.StartedAt
+		job.Images = []*PrepareImage{}
+	}
+	prepareJobs[contestID] = job
+	prepareMu.Unlock()
+
+	if len(images) > 0 {
+		log.Printf("[Prepare] 比赛 %d 开始预拉取 %d 个镜像", contestID, len(images))
+		go runPrepare(db, job)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("开始预拉取 %d 个镜像", len(images)), "total": len(images)})
+}
+
+// HandleGetContestPrepare 查询比赛镜像预拉取进度（未执行过时返回镜像列表）
+func HandleGetContestPrepare(c *gin.Context, db *sql.DB) {
+	contestID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_ID"})
+		return
+	}
+
+	prepareMu.Lock()
+	if job, ok := prepareJobs[contestID]; ok {
+		c.JSON(http.StatusOK, job) // 持锁序列化，避免与拉取协程并发读写
+		prepareMu.Unlock()
+		return
+	}
+	prepareMu.Unlock()
+
+	images, err := contestImages(db, contestID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	if images == nil {
+		images = []*PrepareImage{}
+	}
+	c.JSON(http.StatusOK, PrepareJob{ContestID: contestID, Status: PreparePending, Images: images})
+}
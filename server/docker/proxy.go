@@ -0,0 +1,477 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package docker
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"tgctf/server/container"
+)
+
+// 平台代理访问实例：
+//   路径模式 /i/<令牌>-<端口>/...
+//   域名模式 <令牌>-<端口>.<InstanceProxyDomain>（需配置泛域名解析，推荐，实例与平台不同源）
+// 选手先通过比赛页面获取一次性票据，代理校验后写入会话 Cookie，之后的请求（包括 WebSocket）凭 Cookie 访问
+
+// InstanceProxyDomain 域名模式使用的实例根域名（由 main.go 从 INSTANCE_PROXY_DOMAIN 注入，为空时只使用路径模式）
+var InstanceProxyDomain string
+
+const (
+	proxyPathPrefix  = "/i/"
+	proxyTicketParam = "tg_ticket"
+	proxyCookieName  = "tg_instance"
+	proxyAudience    = "instance-proxy"
+	proxyTicketTTL   = time.Minute      // 票据有效期
+	proxySessionTTL  = 12 * time.Hour   // 会话 Cookie 有效期
+	proxyTargetTTL   = 30 * time.Second // 目标地址缓存时间
+)
+
+// 代理转发使用的连接池
+var proxyTransport = &http.Transport{
+	DialContext:           (&net.Dialer{Timeout: 5 * time.Second}).DialContext,
+	MaxIdleConnsPerHost:   16,
+	IdleConnTimeout:       90 * time.Second,
+	ResponseHeaderTimeout: 60 * time.Second,
+}
+
+// 实例目标地址缓存（避免每个请求都查询容器状态）
+var (
+	proxyTargets   = make(map[string]proxyTarget)
+	proxyTargetsMu sync.Mutex
+)
+
+type proxyTarget struct {
+	addr string
+	at   time.Time
+}
+
+// 已兑换的代理票据（jti -> 票据过期时间），每张票据只能兑换一次会话
+var (
+	usedTickets   = make(map[string]time.Time)
+	usedTicketsMu sync.Mutex
+)
+
+// proxyInstance 代理访问的实例
+type proxyInstance struct {
+	ID          int64
+	TeamID      int64
+	OwnerKey    string
+	ContainerID string
+	Backend     string
+	Node        string
+	Ports       map[string]string
+}
+
+// newAccessToken 生成实例代理访问令牌
+func newAccessToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// proxySigningKey 代理票据签名密钥（由 JWT 密钥派生，票据不能当作登录 token 使用）
+func proxySigningKey(secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(proxyAudience))
+	return mac.Sum(nil)
+}
+
+// signProxyToken 签发代理票据或会话
+func signProxyToken(secret []byte, userID, instanceID int64, typ string, ttl time.Duration) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": userID,
+		"iid": instanceID,
+		"typ": typ,
+		"jti": newAccessToken(),
+		"aud": proxyAudience,
+		"exp": time.Now().Add(ttl).Unix(),
+	})
+	s, _ := token.SignedString(proxySigningKey(secret))
+	return s
+}
+
+// verifyProxyToken 校验代理票据或会话，返回用户ID；票据校验通过后即作废，重复使用视为无效
+func verifyProxyToken(secret []byte, tokenStr, typ string, instanceID int64) (int64, bool) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		return proxySigningKey(secret), nil
+	}, jwt.WithAudience(proxyAudience), jwt.WithValidMethods([]string{"HS256"}))
+	if err != nil || !token.Valid {
+		return 0, false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, false
+	}
+	sub, _ := claims["sub"].(float64)
+	iid, _ := claims["iid"].(float64)
+	t, _ := claims["typ"].(string)
+	if t != typ || int64(iid) != instanceID {
+		return 0, false
+	}
+	if typ == "ticket" {
+		jti, _ := claims["jti"].(string)
+		exp, _ := claims["exp"].(float64)
+		if !consumeProxyTicket(jti, time.Unix(int64(exp), 0)) {
+			return 0, false
+		}
+	}
+	return int64(sub), true
+}
+
+// consumeProxyTicket 记录票据已兑换（顺带清理已过期的记录），票据已兑换过时返回 false
+func consumeProxyTicket(jti string, exp time.Time) bool {
+	if jti == "" {
+		return false
+	}
+	usedTicketsMu.Lock()
+	defer usedTicketsMu.Unlock()
+	now := time.Now()
+	for k, t := range usedTickets {
+		if now.After(t) {
+			delete(usedTickets, k)
+		}
+	}
+	if _, used := usedTickets[jti]; used {
+		return false
+	}
+	usedTickets[jti] = exp
+	return true
+}
+
+// rewriteProxyCookies 改写实例设置的 Cookie：去掉 Domain 属性，禁止覆盖代理会话 Cookie；
+// 路径模式下 Path 限定在实例前缀内，避免题目服务为平台路径设置 Cookie
+func rewriteProxyCookies(resp *http.Response, prefix string) {
+	cookies := resp.Cookies()
+	if len(cookies) == 0 {
+		return
+	}
+	resp.Header.Del("Set-Cookie")
+	for _, ck := range cookies {
+		if ck.Name == proxyCookieName {
+			continue
+		}
+		ck.Domain = ""
+		if prefix != "" {
+			ck.Path = prefix + "/" + strings.TrimPrefix(ck.Path, "/")
+		}
+		if v := ck.String(); v != "" {
+			resp.Header.Add("Set-Cookie", v)
+		}
+	}
+}
+
+// parseProxySegment 解析 "<令牌>-<端口>"，未指定端口时端口为空
+func parseProxySegment(seg string) (string, string) {
+	if idx := strings.LastIndex(seg, "-"); idx > 0 {
+		return seg[:idx], seg[idx+1:]
+	}
+	return seg, ""
+}
+
+// instanceProxyURL 实例端口的代理访问地址
+func instanceProxyURL(token, port string) string {
+	seg := token + "-" + port
+	if InstanceProxyDomain != "" {
+		return "//" + seg + "." + InstanceProxyDomain + "/"
+	}
+	return proxyPathPrefix + seg + "/"
+}
+
+// loadProxyInstance 根据访问令牌查询运行中的实例
+func loadProxyInstance(db *sql.DB, token string) (*proxyInstance, error) {
+	inst := &proxyInstance{}
+	var portsJSON string
+	err := db.QueryRow(`
+		SELECT id, team_id, owner_key, container_id, COALESCE(backend, ''), COALESCE(node, ''), COALESCE(ports, '{}')
+		FROM team_instances WHERE access_token = $1 AND status = 'running'`, token).Scan(
+		&inst.ID, &inst.TeamID, &inst.OwnerKey, &inst.ContainerID, &inst.Backend, &inst.Node, &portsJSON)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(portsJSON), &inst.Ports)
+	return inst, nil
+}
+
+// defaultPort 未指定端口时使用编号最小的端口
+func (inst *proxyInstance) defaultPort() string {
+	ports := make([]string, 0, len(inst.Ports))
+	for p := range inst.Ports {
+		ports = append(ports, p)
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		a, _ := strconv.Atoi(ports[i])
+		b, _ := strconv.Atoi(ports[j])
+		return a < b
+	})
+	if len(ports) == 0 {
+		return ""
+	}
+	return ports[0]
+}
+
+// canAccessInstance 用户是否可以访问实例：队伍实例限本队队员，个人实例限本人，共享实例限已加入队伍的选手
+func canAccessInstance(db *sql.DB, userID int64, inst *proxyInstance) bool {
+	if strings.HasPrefix(inst.OwnerKey, "u:") {
+		return inst.OwnerKey == fmt.Sprintf("u:%d", userID)
+	}
+	var teamID sql.NullInt64
+	var status string
+	db.QueryRow(`SELECT team_id, status FROM users WHERE id = $1`, userID).Scan(&teamID, &status)
+	if !teamID.Valid || status == "banned" {
+		return false
+	}
+	return inst.OwnerKey == PolicyShared || teamID.Int64 == inst.TeamID
+}
+
+// resolveProxyAddr 选择可连通的目标地址：优先容器IP（平台需能路由到实例网络），其次远程节点上发布的宿主机端口
+func resolveProxyAddr(db *sql.DB, inst *proxyInstance, port string) (string, error) {
+	key := fmt.Sprintf("%d/%s", inst.ID, port)
+	proxyTargetsMu.Lock()
+	if t, ok := proxyTargets[key]; ok && time.Since(t.at) < proxyTargetTTL {
+		proxyTargetsMu.Unlock()
+		return t.addr, nil
+	}
+	proxyTargetsMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var candidates []string
+	if st, err := container.For(inst.Backend, inst.Node).Inspect(ctx, inst.ContainerID); err == nil && st.IP != "" {
+		candidates = append(candidates, net.JoinHostPort(st.IP, port))
+	}
+	if hostPort := inst.Ports[port]; hostPort != "" {
+		if host := container.DialHost(db, inst.Node); host != "" {
+			candidates = append(candidates, net.JoinHostPort(host, hostPort))
+		}
+	}
+
+	for _, addr := range candidates {
+		conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+		if err != nil {
+			continue
+		}
+		conn.Close()
+		proxyTargetsMu.Lock()
+		proxyTargets[key] = proxyTarget{addr: addr, at: time.Now()}
+		proxyTargetsMu.Unlock()
+		return addr, nil
+	}
+	return "", fmt.Errorf("实例端口 %s 无法连接", port)
+}
+
+// isSecureRequest 请求是否经由 HTTPS 到达
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// serveInstanceProxy 代理请求到实例容器，prefix 为路径模式下需要去掉的路径前缀（域名模式为空）
+func serveInstanceProxy(c *gin.Context, jwtSecret []byte, db *sql.DB, seg, prefix string) {
+	token, port := parseProxySegment(seg)
+	inst, err := loadProxyInstance(db, token)
+	if err != nil {
+		c.String(http.StatusNotFound, "实例不存在或已销毁")
+		return
+	}
+	if port == "" {
+		port = inst.defaultPort()
+	}
+	if _, ok := inst.Ports[port]; !ok {
+		c.String(http.StatusNotFound, "实例没有开放该端口")
+		return
+	}
+
+	cookiePath := "/"
+	if prefix != "" {
+		cookiePath = prefix + "/"
+	}
+
+	// 一次性票据：校验后写入会话 Cookie 并去掉 URL 中的票据
+	if ticket := c.Query(proxyTicketParam); ticket != "" {
+		userID, ok := verifyProxyToken(jwtSecret, ticket, "ticket", inst.ID)
+		if !ok || !canAccessInstance(db, userID, inst) {
+			c.String(http.StatusForbidden, "访问票据无效或已过期，请从比赛页面重新打开实例")
+			return
+		}
+		sameSite := http.SameSiteLaxMode
+		if prefix != "" && isSecureRequest(c.Request) {
+			sameSite = http.SameSiteNoneMode // 路径模式页面运行在沙箱中，需要跨站携带 Cookie
+		}
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     proxyCookieName,
+			Value:    signProxyToken(jwtSecret, userID, inst.ID, "session", proxySessionTTL),
+			Path:     cookiePath,
+			MaxAge:   int(proxySessionTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   isSecureRequest(c.Request),
+			SameSite: sameSite,
+		})
+		q := c.Request.URL.Query()
+		q.Del(proxyTicketParam)
+		target := c.Request.URL.Path
+		if len(q) > 0 {
+			target += "?" + q.Encode()
+		}
+		c.Redirect(http.StatusFound, target)
+		return
+	}
+
+	cookie, err := c.Request.Cookie(proxyCookieName)
+	if err != nil {
+		c.String(http.StatusUnauthorized, "请从比赛页面打开实例")
+		return
+	}
+	userID, ok := verifyProxyToken(jwtSecret, cookie.Value, "session", inst.ID)
+	if !ok || !canAccessInstance(db, userID, inst) {
+		c.String(http.StatusForbidden, "无权访问该实例")
+		return
+	}
+
+	addr, err := resolveProxyAddr(db, inst, port)
+	if err != nil {
+		c.String(http.StatusBadGateway, err.Error())
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Transport: proxyTransport,
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = addr
+			req.URL.Path = strings.TrimPrefix(req.URL.Path, prefix)
+			req.URL.RawPath = ""
+			if req.URL.Path == "" {
+				req.URL.Path = "/"
+			}
+			if prefix != "" {
+				req.Header.Set("X-Forwarded-Prefix", prefix)
+			}
+			req.Header.Set("X-Forwarded-Host", c.Request.Host)
+			// 会话 Cookie 只用于平台鉴权，不转发给容器
+			cookies := req.Cookies()
+			req.Header.Del("Cookie")
+			for _, ck := range cookies {
+				if ck.Name != proxyCookieName {
+					req.AddCookie(ck)
+				}
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			rewriteProxyCookies(resp, prefix)
+			// 路径模式与平台同源，使用沙箱隔离，防止题目页面读取平台的登录信息
+			if prefix != "" {
+				resp.Header.Set("Content-Security-Policy", "sandbox allow-scripts allow-forms allow-popups allow-modals allow-downloads")
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			proxyTargetsMu.Lock()
+			delete(proxyTargets, fmt.Sprintf("%d/%s", inst.ID, port))
+			proxyTargetsMu.Unlock()
+			http.Error(w, "实例连接失败: "+err.Error(), http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+// HandleInstanceProxy 路径模式代理入口: /i/:seg/*path
+func HandleInstanceProxy(c *gin.Context, jwtSecret []byte, db *sql.DB) {
+	seg := c.Param("seg")
+	serveInstanceProxy(c, jwtSecret, db, seg, strings.TrimSuffix(proxyPathPrefix, "/")+"/"+seg)
+}
+
+// InstanceProxyHostMiddleware 域名模式代理入口：Host 为 <令牌>-<端口>.<InstanceProxyDomain> 的请求全部转发给实例
+func InstanceProxyHostMiddleware(jwtSecret []byte, db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if InstanceProxyDomain == "" {
+			c.Next()
+			return
+		}
+		host := c.Request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		suffix := "." + InstanceProxyDomain
+		if !strings.HasSuffix(host, suffix) || strings.Contains(strings.TrimSuffix(host, suffix), ".") {
+			c.Next()
+			return
+		}
+		serveInstanceProxy(c, jwtSecret, db, strings.TrimSuffix(host, suffix), "")
+		c.Abort()
+	}
+}
+
+// loadInstanceAccess 查询当前用户可访问的运行中实例及请求的端口（port 参数为空时使用默认端口）
+func loadInstanceAccess(c *gin.Context, db *sql.DB, unavailableCode, unavailableMsg string) (int64, string, string, int64, bool) {
+	challengeID := c.Param("challengeId")
+
+	claims, _ := c.Get("claims")
+	claimsMap := claims.(jwt.MapClaims)
+	userID := int64(claimsMap["sub"].(float64))
+
+	var teamID sql.NullInt64
+	db.QueryRow(`SELECT team_id FROM users WHERE id = $1`, userID).Scan(&teamID)
+	if !teamID.Valid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "NO_TEAM", "message": "您还未加入队伍"})
+		return 0, "", "", 0, false
+	}
+
+	var instanceID int64
+	var accessToken sql.NullString
+	var portsJSON string
+	err := db.QueryRow(`SELECT id, access_token, COALESCE(ports, '{}') FROM team_instances WHERE challenge_id = $1 AND owner_key = $2 AND status = 'running'`,
+		challengeID, InstanceOwnerKey(GetInstancePolicy(db, challengeID), teamID.Int64, userID)).Scan(&instanceID, &accessToken, &portsJSON)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "NO_INSTANCE", "message": "队伍没有运行中的实例"})
+		return 0, "", "", 0, false
+	}
+	if !accessToken.Valid || accessToken.String == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": unavailableCode, "message": unavailableMsg})
+		return 0, "", "", 0, false
+	}
+
+	inst := &proxyInstance{ID: instanceID}
+	json.Unmarshal([]byte(portsJSON), &inst.Ports)
+	port := c.Query("port")
+	if port == "" {
+		port = inst.defaultPort()
+	}
+	if _, ok := inst.Ports[port]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_PORT", "message": "实例没有开放该端口"})
+		return 0, "", "", 0, false
+	}
+	return instanceID, accessToken.String, port, userID, true
+}
+
+// HandleCreateProxyTicket 获取实例代理访问地址（附带一次性票据）
+func HandleCreateProxyTicket(c *gin.Context, jwtSecret []byte, db *sql.DB) {
+	instanceID, accessToken, port, userID, ok := loadInstanceAccess(c, db, "PROXY_UNAVAILABLE", "该实例不支持代理访问，请重新启动实例")
+	if !ok {
+		return
+	}
+
+	ticket := signProxyToken(jwtSecret, userID, instanceID, "ticket", proxyTicketTTL)
+	c.JSON(http.StatusOK, gin.H{
+		"url":       instanceProxyURL(accessToken, port) + "?" + proxyTicketParam + "=" + ticket,
+		"expiresIn": int(proxyTicketTTL.Seconds()),
+	})
+}
@@ -0,0 +1,371 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package docker
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 排队状态
+const (
+	QueueWaiting   = "waiting"   // 等待资源
+	QueueLaunching = "launching" // 正在创建
+	QueueReady     = "ready"     // 已创建
+	QueueFailed    = "failed"    // 创建失败
+	QueueCancelled = "cancelled" // 已取消
+)
+
+// 排队超过该时长自动取消
+const queueTicketTimeout = 30 * time.Minute
+
+// 全局容量设置（由 main.go 注入）
+var GetContainerMaxRunning SettingsGetter
+var GetContainerMaxMemory SettingsGetter
+
+var (
+	queueMu   sync.Mutex               // 同一时刻只有一个调度过程
+	queueWake = make(chan struct{}, 1) // 有实例销毁或新排队时唤醒调度
+)
+
+// capacityMu 全局容量检查与占用名额（创建任务开始执行、排队请求转为 launching、预热容器开始创建）之间互斥，
+// 避免并发创建时都通过检查而超出上限
+var (
+	capacityMu        sync.Mutex
+	warmPending       int // 正在创建的预热容器数（尚未写入 warm_pool_containers）
+	warmPendingMemory int // 正在创建的预热容器内存(MB)
+)
+
+// wakeQueue 唤醒排队调度
+func wakeQueue() {
+	select {
+	case queueWake <- struct{}{}:
+	default:
+	}
+}
+
+// queueTicket 排队记录
+type queueTicket struct {
+	ID          int64
+	ContestID   int64
+	ChallengeID int64
+	TeamID      int64
+	UserID      int64
+	Policy      string
+	OwnerKey    string
+	MemoryMB    int
+	JobID       int64
+	CreatedAt   time.Time
+}
+
+// hasCapacity 全局容量是否还能容纳一个新实例（调用方需持有 capacityMu，并在同一锁内占用名额）
+// 已开始执行但尚未运行的创建任务（排队等待中的除外）与正在创建的预热容器同样计入，excludeJobID 为本次检查的创建任务自身
+func hasCapacity(db *sql.DB, memoryMB int, excludeJobID int64) bool {
+	maxRunning, maxMemory := 0, 0
+	if GetContainerMaxRunning != nil {
+		maxRunning = GetContainerMaxRunning(db)
+	}
+	if GetContainerMaxMemory != nil {
+		maxMemory = GetContainerMaxMemory(db)
+	}
+	if maxRunning <= 0 && maxMemory <= 0 {
+		return true
+	}
+
+	// 预热池中的容器同样占用资源
+	var running, usedMemory int
+	db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(memory_mb), 0) FROM (
+			SELECT memory_mb FROM team_instances WHERE status = 'running'
+			UNION ALL
+			SELECT memory_mb FROM warm_pool_containers
+			UNION ALL
+			SELECT j.memory_mb FROM instance_jobs j
+			WHERE j.kind = 'create' AND j.status NOT IN ('ready', 'done', 'failed') AND j.id <> $1
+			  AND NOT EXISTS (SELECT 1 FROM instance_queue q WHERE q.job_id = j.id AND q.status = 'waiting')
+		) t`, excludeJobID).Scan(&running, &usedMemory)
+	running += warmPending
+	usedMemory += warmPendingMemory
+	if maxRunning > 0 && running >= maxRunning {
+		return false
+	}
+	if maxMemory > 0 && usedMemory+memoryMB > maxMemory {
+		return false
+	}
+	return true
+}
+
+// hasWaitingTickets 是否有选手在排队
+func hasWaitingTickets(db *sql.DB) bool {
+	var exists bool
+	db.QueryRow(`SELECT EXISTS(SELECT 1 FROM instance_queue WHERE status IN ('waiting', 'launching'))`).Scan(&exists)
+	return exists
+}
+
+// findWaitingTicket 查询同一归属+题目未完成的排队记录
+func findWaitingTicket(db *sql.DB, challengeID, ownerKey string) int64 {
+	var id int64
+	db.QueryRow(`SELECT id FROM instance_queue WHERE challenge_id = $1 AND owner_key = $2 AND status IN ('waiting', 'launching') ORDER BY id LIMIT 1`,
+		challengeID, ownerKey).Scan(&id)
+	return id
+}
+
+// queuePosition 排队位置（从 1 开始）
+func queuePosition(db *sql.DB, ticketID int64) int {
+	var pos int
+	db.QueryRow(`SELECT COUNT(*) FROM instance_queue WHERE status IN ('waiting', 'launching') AND id <= $1`, ticketID).Scan(&pos)
+	return pos
+}
+
+// queueTicketResponse 排队信息响应
+func queueTicketResponse(db *sql.DB, ticketID int64) gin.H {
+	pos := queuePosition(db, ticketID)
+	return gin.H{
+		"queued":   true,
+		"ticketId": ticketID,
+		"position": pos,
+		"message":  "容器资源紧张，已加入排队，当前第 " + strconv.Itoa(pos) + " 位，实例启动后将自动通知",
+	}
+}
+
+// enqueueLaunch 将创建请求加入排队（创建任务保持 queued 状态，由调度器继续执行），返回排队信息
+func enqueueLaunch(db *sql.DB, l *instanceLaunch) (gin.H, error) {
+	ticketID := findWaitingTicket(db, l.ChallengeID, l.OwnerKey)
+	if ticketID == 0 {
+		err := db.QueryRow(`
+			INSERT INTO instance_queue (contest_id, challenge_id, team_id, user_id, policy, owner_key, memory_mb, job_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+			l.ContestID, l.ChallengeID, l.TeamID, l.UserID, l.Policy, l.OwnerKey, l.MemoryMB, l.Job.ID).Scan(&ticketID)
+		if err != nil {
+			l.Job.update(db, JobFailed, 0, "加入排队失败")
+			return nil, err
+		}
+	}
+	wakeQueue()
+	resp := queueTicketResponse(db, ticketID)
+	resp["jobId"] = l.Job.ID
+	resp["kind"] = JobCreate
+	resp["status"] = JobQueued
+	l.Job.update(db, JobQueued, 0, resp["message"].(string))
+	return resp, nil
+}
+
+// respondQueued 加入排队并返回排队信息
+func respondQueued(c *gin.Context, db *sql.DB, l *instanceLaunch) {
+	resp, err := enqueueLaunch(db, l)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR", "message": "加入排队失败", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, resp)
+}
+
+// finishTicket 更新排队记录的最终状态并通知队伍
+func finishTicket(db *sql.DB, t *queueTicket, status string, instanceID int64, message string) {
+	db.Exec(`UPDATE instance_queue SET status = $2, instance_id = NULLIF($3, 0), error = NULLIF($4, ''), updated_at = CURRENT_TIMESTAMP WHERE id = $1`,
+		t.ID, status, instanceID, message)
+
+	ev := InstanceEvent{ContestID: t.ContestID, ChallengeID: t.ChallengeID, TicketID: t.ID, InstanceID: instanceID, Message: message}
+	if t.Policy == PolicyUser {
+		ev.UserID = t.UserID
+	}
+	if status == QueueReady {
+		ev.Type = EventInstanceReady
+	} else {
+		ev.Type = EventQueueFailed
+	}
+	PublishTeamEvent(t.TeamID, ev)
+
+	if job := loadJob(db, t.JobID); status == QueueReady {
+		job.update(db, JobReady, instanceID, "")
+	} else {
+		job.update(db, JobFailed, 0, message)
+	}
+}
+
+// dispatchQueue 按先后顺序为排队的请求创建实例，队首资源不足时停止（保证公平）
+func dispatchQueue(db *sql.DB) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	// 排队超时或比赛已结束的请求直接取消
+	rows, err := db.Query(`
+		SELECT q.id, q.contest_id, q.challenge_id, q.team_id, q.user_id, q.policy, q.owner_key, q.memory_mb, COALESCE(q.job_id, 0), q.created_at
+		FROM instance_queue q JOIN contests ct ON q.contest_id = ct.id
+		WHERE q.status = 'waiting' AND (q.created_at < $1 OR ct.status = 'ended')`, time.Now().Add(-queueTicketTimeout))
+	if err == nil {
+		var expired []queueTicket
+		for rows.Next() {
+			var t queueTicket
+			if rows.Scan(&t.ID, &t.ContestID, &t.ChallengeID, &t.TeamID, &t.UserID, &t.Policy, &t.OwnerKey, &t.MemoryMB, &t.JobID, &t.CreatedAt) == nil {
+				expired = append(expired, t)
+			}
+		}
+		rows.Close()
+		for i := range expired {
+			finishTicket(db, &expired[i], QueueCancelled, 0, "排队超时或比赛已结束，请重新启动")
+		}
+	}
+
+	for {
+		var t queueTicket
+		err := db.QueryRow(`
+			SELECT id, contest_id, challenge_id, team_id, user_id, policy, owner_key, memory_mb, COALESCE(job_id, 0), created_at
+			FROM instance_queue WHERE status = 'waiting' ORDER BY id LIMIT 1`).Scan(
+			&t.ID, &t.ContestID, &t.ChallengeID, &t.TeamID, &t.UserID, &t.Policy, &t.OwnerKey, &t.MemoryMB, &t.JobID, &t.CreatedAt)
+		if err != nil {
+			break
+		}
+		challengeID := strconv.FormatInt(t.ChallengeID, 10)
+
+		// 排队期间实例已被创建（如共享实例由其他选手启动）
+		var existingID int64
+		if db.QueryRow(`SELECT id FROM team_instances WHERE challenge_id = $1 AND owner_key = $2 AND status = 'running'`,
+			challengeID, t.OwnerKey).Scan(&existingID) == nil {
+			finishTicket(db, &t, QueueReady, existingID, "")
+			continue
+		}
+
+		launch, err := prepareLaunch(db, strconv.FormatInt(t.ContestID, 10), challengeID, t.TeamID, t.UserID, t.Policy)
+		if err != nil {
+			finishTicket(db, &t, QueueFailed, 0, err.Error())
+			continue
+		}
+
+		// 检查容量并转为 launching（之后该任务计入全局容量）
+		capacityMu.Lock()
+		if !hasWarmContainer(db, challengeID) && !hasCapacity(db, t.MemoryMB, t.JobID) {
+			capacityMu.Unlock()
+			break
+		}
+		db.Exec(`UPDATE instance_queue SET status = 'launching', updated_at = CURRENT_TIMESTAMP WHERE id = $1`, t.ID)
+		capacityMu.Unlock()
+		launch.Job = loadJob(db, t.JobID)
+		lock := getInstanceLock(t.OwnerKey, challengeID)
+		lock.Lock()
+		result, err := launchInstance(db, launch)
+		lock.Unlock()
+		if err != nil {
+			if le, ok := err.(*launchError); ok && le.Retry {
+				// 节点或端口仍不足，放回队首等待下次调度
+				db.Exec(`UPDATE instance_queue SET status = 'waiting', updated_at = CURRENT_TIMESTAMP WHERE id = $1`, t.ID)
+				launch.Job.update(db, JobQueued, 0, le.Message)
+				break
+			}
+			log.Printf("[Queue] 排队实例创建失败(ticket=%d): %v", t.ID, err)
+			finishTicket(db, &t, QueueFailed, 0, err.Error())
+			continue
+		}
+
+		log.Printf("[Queue] 排队实例已启动(ticket=%d, instance=%d, 等待 %s)", t.ID, result.InstanceID, time.Since(t.CreatedAt).Round(time.Second))
+		finishTicket(db, &t, QueueReady, result.InstanceID, "")
+		logInstanceCreate(db, launch, result, "")
+	}
+
+	broadcastQueuePositions(db)
+}
+
+// broadcastQueuePositions 推送所有排队请求的最新位置
+func broadcastQueuePositions(db *sql.DB) {
+	rows, err := db.Query(`
+		SELECT id, contest_id, challenge_id, team_id, user_id, policy,
+		       ROW_NUMBER() OVER (ORDER BY id)
+		FROM instance_queue WHERE status IN ('waiting', 'launching')`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t queueTicket
+		var pos int
+		if rows.Scan(&t.ID, &t.ContestID, &t.ChallengeID, &t.TeamID, &t.UserID, &t.Policy, &pos) != nil {
+			continue
+		}
+		ev := InstanceEvent{Type: EventQueuePosition, ContestID: t.ContestID, ChallengeID: t.ChallengeID, TicketID: t.ID, Position: pos}
+		if t.Policy == PolicyUser {
+			ev.UserID = t.UserID
+		}
+		PublishTeamEvent(t.TeamID, ev)
+	}
+}
+
+// StartInstanceQueue 启动排队调度任务（定时检查，实例销毁或新排队时立即检查）
+func StartInstanceQueue(db *sql.DB) {
+	// 服务重启时中断的创建过程重新排队，其余未完成的任务标记为失败
+	db.Exec(`UPDATE instance_queue SET status = 'waiting', updated_at = CURRENT_TIMESTAMP WHERE status = 'launching'`)
+	recoverInstanceJobs(db)
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-queueWake:
+			}
+			dispatchQueue(db)
+		}
+	}()
+}
+
+// loadUserTicket 查询当前用户可见的排队记录（本队的队伍/共享实例排队，或本人的个人实例排队）
+func loadUserTicket(c *gin.Context, db *sql.DB) (int64, string, string, bool) {
+	claims, _ := c.Get("claims")
+	claimsMap := claims.(jwt.MapClaims)
+	userID := int64(claimsMap["sub"].(float64))
+
+	var teamID sql.NullInt64
+	db.QueryRow(`SELECT team_id FROM users WHERE id = $1`, userID).Scan(&teamID)
+
+	var id, ticketTeamID, ticketUserID int64
+	var policy, status, errMsg string
+	err := db.QueryRow(`SELECT id, team_id, user_id, policy, status, COALESCE(error, '') FROM instance_queue WHERE id = $1 AND contest_id = $2`,
+		c.Param("ticketId"), c.Param("id")).Scan(&id, &ticketTeamID, &ticketUserID, &policy, &status, &errMsg)
+	if err != nil || !teamID.Valid || ticketTeamID != teamID.Int64 || (policy == PolicyUser && ticketUserID != userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "TICKET_NOT_FOUND", "message": "排队记录不存在"})
+		return 0, "", "", false
+	}
+	return id, status, errMsg, true
+}
+
+// HandleGetQueueTicket 查询排队状态
+func HandleGetQueueTicket(c *gin.Context, db *sql.DB) {
+	id, status, errMsg, ok := loadUserTicket(c, db)
+	if !ok {
+		return
+	}
+	resp := gin.H{"ticketId": id, "status": status}
+	switch status {
+	case QueueWaiting, QueueLaunching:
+		resp["position"] = queuePosition(db, id)
+	case QueueFailed, QueueCancelled:
+		resp["message"] = errMsg
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleCancelQueueTicket 取消排队
+func HandleCancelQueueTicket(c *gin.Context, db *sql.DB) {
+	id, _, _, ok := loadUserTicket(c, db)
+	if !ok {
+		return
+	}
+	var jobID int64
+	err := db.QueryRow(`UPDATE instance_queue SET status = 'cancelled', error = '选手取消排队', updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND status = 'waiting' RETURNING COALESCE(job_id, 0)`, id).Scan(&jobID)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "TICKET_NOT_WAITING", "message": "该排队请求已在创建中或已结束"})
+		return
+	}
+	loadJob(db, jobID).update(db, JobFailed, 0, "选手取消排队")
+	wakeQueue()
+	c.JSON(http.StatusOK, gin.H{"message": "已取消排队"})
+}
@@ -0,0 +1,488 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package docker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"tgctf/server/container"
+	"tgctf/server/logs"
+)
+
+// 单个实例最多自动重启次数，超过后不再重启
+const maxAutoRestarts = 5
+
+// 距上次自动重启超过该时间（期间容器正常运行）后重新计数，偶发崩溃的长期实例不会累积到上限
+const restartResetPeriod = 30 * time.Minute
+
+// 孤儿容器判定宽限期：刚创建的容器可能还未写入实例记录
+const orphanGracePeriod = 2 * time.Minute
+
+// 对账动作类型
+const (
+	ActionRestart       = "restart"        // 重启已退出的容器
+	ActionAdopt         = "adopt"          // 记录的容器丢失，但存在标签匹配的容器，更新记录指向该容器
+	ActionMarkDestroyed = "mark_destroyed" // 容器丢失，标记记录为已销毁
+	ActionMarkCrashed   = "mark_crashed"   // 反复崩溃，回收容器
+	ActionRecreate      = "recreate"       // 重建缺失的 AWD-F 容器
+	ActionRemoveOrphan  = "remove_orphan"  // 删除没有实例记录的容器
+)
+
+// RecreateAWDFContainer 重建 AWD-F 容器的函数（由 main.go 注入）
+var RecreateAWDFContainer func(db *sql.DB, teamID, contestID, challengeID int64) error
+
+// autoRecreateAWDF 启动与定期对账时是否自动补建进行中比赛缺失的 AWD-F 容器（默认关闭，由管理员手动触发）
+var autoRecreateAWDF = os.Getenv("RECONCILE_RECREATE_AWDF") == "true"
+
+// reconcileMu 防止对账任务并发执行
+var reconcileMu sync.Mutex
+
+// trackedInstance 数据库中 running 状态的实例记录
+type trackedInstance struct {
+	Table        string // team_instances | team_instances_awdf
+	ID           int64
+	TeamID       int64
+	ContestID    int64
+	ChallengeID  int64
+	ContainerID  string
+	Backend      string
+	Node         string
+	RestartCount int
+	Status       string
+}
+
+// ReconcileOptions 对账选项
+type ReconcileOptions struct {
+	DryRun          bool // 只生成差异报告，不执行任何操作
+	RecreateMissing bool // 为进行中的 AWD-F 比赛补建缺失的容器（管理员手动触发，或开启 RECONCILE_RECREATE_AWDF）
+}
+
+// ReconcileAction 对账动作
+type ReconcileAction struct {
+	Action        string `json:"action"`
+	Table         string `json:"table,omitempty"`
+	InstanceID    int64  `json:"instanceId,omitempty"`
+	TeamID        int64  `json:"teamId,omitempty"`
+	ContestID     int64  `json:"contestId,omitempty"`
+	ChallengeID   int64  `json:"challengeId,omitempty"`
+	ContainerID   string `json:"containerId,omitempty"`
+	ContainerName string `json:"containerName,omitempty"`
+	Node          string `json:"node,omitempty"`
+	Reason        string `json:"reason"`
+	Done          bool   `json:"done"`
+	Error         string `json:"error,omitempty"`
+
+	rt container.Runtime // 执行动作使用的运行时
+}
+
+// ReconcileReport 对账报告
+type ReconcileReport struct {
+	DryRun     bool              `json:"dryRun"`
+	Instances  int               `json:"instances"`  // 数据库中的实例数
+	Containers int               `json:"containers"` // 运行时中带 tg.type 标签的容器数
+	Actions    []ReconcileAction `json:"actions"`
+	StartedAt  string            `json:"startedAt"`
+	Duration   string            `json:"duration"`
+}
+
+// loadTrackedInstances 查询所有 running 状态的实例（AWD-F 包含 starting），重启次数按 restartResetPeriod 计算有效值
+func loadTrackedInstances(db *sql.DB) ([]trackedInstance, error) {
+	rows, err := db.Query(`
+		SELECT 'team_instances', id, team_id, contest_id, challenge_id, container_id,
+		       COALESCE(backend, ''), COALESCE(node, ''), ` + effectiveRestartCount + `, status
+		FROM team_instances WHERE status = 'running'
+		UNION ALL
+		SELECT 'team_instances_awdf', id, team_id, contest_id, challenge_id, container_id,
+		       COALESCE(backend, ''), COALESCE(node, ''), ` + effectiveRestartCount + `, status
+		FROM team_instances_awdf WHERE status IN ('running', 'starting')`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []trackedInstance
+	for rows.Next() {
+		var t trackedInstance
+		if err := rows.Scan(&t.Table, &t.ID, &t.TeamID, &t.ContestID, &t.ChallengeID, &t.ContainerID,
+			&t.Backend, &t.Node, &t.RestartCount, &t.Status); err != nil {
+			continue
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+// effectiveRestartCount 有效的自动重启次数：上次重启已超过 restartResetPeriod 时视为 0
+var effectiveRestartCount = fmt.Sprintf(
+	`CASE WHEN restarted_at IS NULL OR restarted_at < NOW() - INTERVAL '%d seconds' THEN 0 ELSE restart_count END`,
+	int(restartResetPeriod.Seconds()))
+
+// shortID 统一容器ID长度用于比对
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// listedContainer 运行时中的容器及其所在运行时
+type listedContainer struct {
+	container.Container
+	rt      container.Runtime
+	claimed bool // 已与实例记录匹配
+}
+
+// labelKey 按标签生成匹配键: 类型/队伍/题目
+func labelKey(kind, teamID, challengeID string) string {
+	return kind + "/" + teamID + "/" + challengeID
+}
+
+// tableKind 实例表对应的 tg.type 标签
+func tableKind(table string) string {
+	if table == "team_instances_awdf" {
+		return "awdf"
+	}
+	return "team"
+}
+
+// planReconcile 比对数据库记录与实际容器，生成对账动作
+func planReconcile(db *sql.DB, opts ReconcileOptions, report *ReconcileReport) {
+	tracked, err := loadTrackedInstances(db)
+	if err != nil {
+		log.Printf("[Reconcile] 查询实例失败: %v", err)
+		return
+	}
+	report.Instances = len(tracked)
+
+	// 列出所有节点上带 tg.type 标签的容器
+	byID := make(map[string]*listedContainer)
+	byLabel := make(map[string][]*listedContainer)
+	for _, rt := range container.All() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		list, err := rt.List(ctx, "tg.type")
+		cancel()
+		if err != nil {
+			log.Printf("[Reconcile] 列出容器失败(%s): %v", rt.Name(), err)
+			continue
+		}
+		for _, ct := range list {
+			lc := &listedContainer{Container: ct, rt: rt}
+			byID[shortID(ct.ID)] = lc
+			key := labelKey(ct.Labels["tg.type"], ct.Labels["tg.team_id"], ct.Labels["tg.challenge_id"])
+			byLabel[key] = append(byLabel[key], lc)
+		}
+	}
+	report.Containers = len(byID)
+
+	// 先标记所有被记录引用的容器，避免被其他记录认领
+	for _, t := range tracked {
+		if lc, ok := byID[shortID(t.ContainerID)]; ok {
+			lc.claimed = true
+		}
+	}
+	// 预热池中的容器由预热池任务维护
+	if rows, err := db.Query(`SELECT container_id FROM warm_pool_containers`); err == nil {
+		for rows.Next() {
+			var id string
+			if rows.Scan(&id) == nil {
+				if lc, ok := byID[shortID(id)]; ok {
+					lc.claimed = true
+				}
+			}
+		}
+		rows.Close()
+	}
+
+	runningAWDF := make(map[string]bool) // 已有 running 记录的 AWD-F 队伍/题目
+	for i := range tracked {
+		t := &tracked[i]
+		if t.Table == "team_instances_awdf" {
+			runningAWDF[fmt.Sprintf("%d/%d", t.TeamID, t.ChallengeID)] = true
+		}
+		if t.Status != "running" {
+			continue
+		}
+
+		rt := container.For(t.Backend, t.Node)
+		base := ReconcileAction{
+			Table: t.Table, InstanceID: t.ID, TeamID: t.TeamID, ContestID: t.ContestID, ChallengeID: t.ChallengeID,
+			ContainerID: t.ContainerID, Node: t.Node, rt: rt,
+		}
+
+		// 优先使用列表结果，不在列表中时单独查询（可能属于其他后端）
+		var exists, running bool
+		var exitCode int
+		if lc, ok := byID[shortID(t.ContainerID)]; ok {
+			exists, running = true, lc.Running
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			st, err := rt.Inspect(ctx, t.ContainerID)
+			cancel()
+			if err != nil {
+				continue // 守护进程不可达，状态未知，跳过
+			}
+			exists, running, exitCode = st.Exists, st.Running, st.ExitCode
+		}
+
+		if exists && running {
+			continue
+		}
+		if exists {
+			a := base
+			if t.RestartCount >= maxAutoRestarts {
+				if t.Table != "team_instances" {
+					continue // AWD-F 容器不自动回收，由管理员处理
+				}
+				a.Action = ActionMarkCrashed
+				a.Reason = fmt.Sprintf("已自动重启 %d 次仍然崩溃", t.RestartCount)
+			} else {
+				a.Action = ActionRestart
+				a.Reason = fmt.Sprintf("容器已退出(exit %d)", exitCode)
+			}
+			report.Actions = append(report.Actions, a)
+			continue
+		}
+
+		// 记录的容器已不存在，尝试按标签认领
+		key := labelKey(tableKind(t.Table), strconv.FormatInt(t.TeamID, 10), strconv.FormatInt(t.ChallengeID, 10))
+		var adopted *listedContainer
+		for _, lc := range byLabel[key] {
+			if !lc.claimed {
+				adopted = lc
+				break
+			}
+		}
+		if adopted != nil {
+			adopted.claimed = true
+			a := base
+			a.Action = ActionAdopt
+			a.ContainerID, a.ContainerName, a.Node, a.rt = adopted.ID, adopted.Name, adopted.Node, adopted.rt
+			a.Reason = "记录的容器不存在，认领标签匹配的容器 " + adopted.Name
+			report.Actions = append(report.Actions, a)
+			continue
+		}
+
+		a := base
+		if t.Table == "team_instances_awdf" && opts.RecreateMissing && isContestRunning(db, t.ContestID) {
+			a.Action = ActionRecreate
+			a.Reason = "容器不存在，比赛进行中，重建容器"
+		} else {
+			a.Action = ActionMarkDestroyed
+			a.Reason = "容器不存在"
+		}
+		report.Actions = append(report.Actions, a)
+	}
+
+	// 进行中的 AWD-F 比赛：已审核队伍 × 公开题目 缺少记录时重建
+	if opts.RecreateMissing {
+		rows, err := db.Query(`
+			SELECT ct.contest_id, ct.team_id, cc.id
+			FROM contests c
+			JOIN contest_teams ct ON ct.contest_id = c.id AND ct.status = 'approved'
+			JOIN contest_challenges_awdf cc ON cc.contest_id = c.id AND cc.status = 'public'
+			JOIN question_bank_awdf q ON cc.question_id = q.id
+			WHERE c.mode = 'awd-f' AND c.status = 'running' AND q.docker_image IS NOT NULL AND q.docker_image != ''`)
+		if err == nil {
+			for rows.Next() {
+				var contestID, teamID, challengeID int64
+				if rows.Scan(&contestID, &teamID, &challengeID) != nil {
+					continue
+				}
+				if runningAWDF[fmt.Sprintf("%d/%d", teamID, challengeID)] {
+					continue
+				}
+				report.Actions = append(report.Actions, ReconcileAction{
+					Action: ActionRecreate, Table: "team_instances_awdf", TeamID: teamID, ContestID: contestID, ChallengeID: challengeID,
+					Reason: "比赛进行中但缺少容器实例",
+				})
+			}
+			rows.Close()
+		}
+	}
+
+	// 没有记录的容器
+	for _, lc := range byID {
+		if lc.claimed {
+			continue
+		}
+		kind := lc.Labels["tg.type"]
+		if kind != "team" && kind != "awdf" && kind != "warm" {
+			continue // 测试容器等由各自的模块管理
+		}
+		if !lc.Created.IsZero() && time.Since(lc.Created) < orphanGracePeriod {
+			continue
+		}
+		teamID, _ := strconv.ParseInt(lc.Labels["tg.team_id"], 10, 64)
+		challengeID, _ := strconv.ParseInt(lc.Labels["tg.challenge_id"], 10, 64)
+		contestID, _ := strconv.ParseInt(lc.Labels["tg.contest_id"], 10, 64)
+		report.Actions = append(report.Actions, ReconcileAction{
+			Action: ActionRemoveOrphan, TeamID: teamID, ContestID: contestID, ChallengeID: challengeID,
+			ContainerID: lc.ID, ContainerName: lc.Name, Node: lc.Node, rt: lc.rt,
+			Reason: "容器没有对应的实例记录",
+		})
+	}
+}
+
+// isContestRunning 比赛是否进行中
+func isContestRunning(db *sql.DB, contestID int64) bool {
+	var status string
+	db.QueryRow(`SELECT status FROM contests WHERE id = $1`, contestID).Scan(&status)
+	return status == "running"
+}
+
+// applyAction 执行单个对账动作
+func applyAction(db *sql.DB, a *ReconcileAction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	switch a.Action {
+	case ActionRestart:
+		if err := a.rt.Start(ctx, a.ContainerID); err != nil {
+			return err
+		}
+		_, err := db.Exec(`UPDATE `+a.Table+` SET restart_count = `+effectiveRestartCount+` + 1, restarted_at = NOW(), updated_at = CURRENT_TIMESTAMP WHERE id = $1`, a.InstanceID)
+		return err
+	case ActionMarkCrashed:
+		a.rt.Remove(ctx, a.ContainerID)
+		_, err := db.Exec(`UPDATE team_instances SET status = 'crashed', updated_at = CURRENT_TIMESTAMP WHERE id = $1`, a.InstanceID)
+		return err
+	case ActionMarkDestroyed:
+		_, err := db.Exec(`UPDATE `+a.Table+` SET status = 'destroyed', updated_at = CURRENT_TIMESTAMP WHERE id = $1`, a.InstanceID)
+		return err
+	case ActionAdopt:
+		if st, err := a.rt.Inspect(ctx, a.ContainerID); err == nil && st.Exists && !st.Running {
+			if err := a.rt.Start(ctx, a.ContainerID); err != nil {
+				return err
+			}
+		}
+		_, err := db.Exec(`UPDATE `+a.Table+` SET container_id = $1, container_name = $2, node = $3, backend = $4, updated_at = CURRENT_TIMESTAMP WHERE id = $5`,
+			a.ContainerID, a.ContainerName, a.Node, a.rt.Name(), a.InstanceID)
+		return err
+	case ActionRecreate:
+		if RecreateAWDFContainer == nil {
+			return fmt.Errorf("未配置 AWD-F 重建函数")
+		}
+		return RecreateAWDFContainer(db, a.TeamID, a.ContestID, a.ChallengeID)
+	case ActionRemoveOrphan:
+		return a.rt.Remove(ctx, a.ContainerID)
+	}
+	return fmt.Errorf("未知动作: %s", a.Action)
+}
+
+// actionMessage 生成动作的日志描述
+func actionMessage(a *ReconcileAction) string {
+	switch a.Action {
+	case ActionRestart:
+		return fmt.Sprintf("实例 %d 的%s，已自动重启", a.InstanceID, a.Reason)
+	case ActionMarkCrashed:
+		return fmt.Sprintf("实例 %d %s，已回收容器", a.InstanceID, a.Reason)
+	case ActionMarkDestroyed:
+		return fmt.Sprintf("实例 %d 的%s，已标记为销毁", a.InstanceID, a.Reason)
+	case ActionAdopt:
+		return fmt.Sprintf("实例 %d %s", a.InstanceID, a.Reason)
+	case ActionRecreate:
+		return fmt.Sprintf("已重建队伍 %d 题目 %d 的 AWD-F 容器（%s）", a.TeamID, a.ChallengeID, a.Reason)
+	case ActionRemoveOrphan:
+		return "已清理孤儿容器 " + a.ContainerName
+	}
+	return a.Reason
+}
+
+// Reconcile 比对数据库实例记录与实际容器状态，并在非 dry-run 模式下执行修复
+func Reconcile(db *sql.DB, opts ReconcileOptions) *ReconcileReport {
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+
+	start := time.Now()
+	report := &ReconcileReport{DryRun: opts.DryRun, Actions: []ReconcileAction{}, StartedAt: start.Format("2006-01-02 15:04:05")}
+	planReconcile(db, opts, report)
+
+	if !opts.DryRun {
+		for i := range report.Actions {
+			a := &report.Actions[i]
+			level := logs.LevelSuccess
+			if a.Action == ActionMarkDestroyed || a.Action == ActionMarkCrashed {
+				level = logs.LevelWarning
+			}
+			message := actionMessage(a)
+			details := map[string]interface{}{"action": a.Action, "containerId": a.ContainerID, "table": a.Table, "node": a.Node}
+			if err := applyAction(db, a); err != nil {
+				a.Error = err.Error()
+				details["error"] = a.Error
+				level = logs.LevelError
+				message = fmt.Sprintf("对账动作 %s 执行失败: %s", a.Action, a.Reason)
+			} else {
+				a.Done = true
+			}
+			log.Printf("[Reconcile] %s", message)
+			var teamID, contestID, challengeID *int64
+			if a.TeamID > 0 {
+				teamID = &a.TeamID
+			}
+			if a.ContestID > 0 {
+				contestID = &a.ContestID
+			}
+			if a.ChallengeID > 0 {
+				challengeID = &a.ChallengeID
+			}
+			logs.WriteLog(db, logs.TypeContainerReconcile, level, nil, teamID, contestID, challengeID, "", message, details)
+		}
+	}
+
+	report.Duration = time.Since(start).Round(time.Millisecond).String()
+	return report
+}
+
+// ReconcileInstances 定期巡检：重启异常退出的容器，清理孤儿容器，复查出网规则
+func ReconcileInstances(db *sql.DB) {
+	Reconcile(db, ReconcileOptions{RecreateMissing: autoRecreateAWDF})
+	container.RecheckEgressRules()
+}
+
+// ReconcileOnStartup 启动时对账（宿主机或 Docker 守护进程重启后数据库与容器可能不一致）
+func ReconcileOnStartup(db *sql.DB) {
+	report := Reconcile(db, ReconcileOptions{RecreateMissing: autoRecreateAWDF})
+	log.Printf("[Reconcile] 启动对账完成: 实例 %d 个, 容器 %d 个, 执行动作 %d 个, 耗时 %s",
+		report.Instances, report.Containers, len(report.Actions), report.Duration)
+}
+
+// StartInstanceReconciler 启动容器巡检任务
+func StartInstanceReconciler(db *sql.DB) {
+	ticker := time.NewTicker(1 * time.Minute)
+	go func() {
+		for range ticker.C {
+			ReconcileInstances(db)
+		}
+	}()
+}
+
+// HandleAdminReconcile 管理员手动触发对账（dryRun=true 时只返回差异报告）
+func HandleAdminReconcile(c *gin.Context, db *sql.DB) {
+	claims, _ := c.Get("claims")
+	claimsMap := claims.(jwt.MapClaims)
+	adminID := int64(claimsMap["sub"].(float64))
+
+	dryRun := c.Query("dryRun") == "true"
+	report := Reconcile(db, ReconcileOptions{DryRun: dryRun, RecreateMissing: c.Query("recreate") == "true"})
+
+	if !dryRun {
+		logs.WriteLog(db, logs.TypeAdminOp, logs.LevelInfo, &adminID, nil, nil, nil, c.ClientIP(),
+			fmt.Sprintf("管理员执行容器对账，共 %d 个动作", len(report.Actions)), map[string]interface{}{
+				"instances": report.Instances, "containers": report.Containers,
+			})
+	}
+
+	c.JSON(http.StatusOK, report)
+}
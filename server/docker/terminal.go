@@ -0,0 +1,179 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package docker
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tgctf/server/container"
+	"tgctf/server/logs"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// 管理员 Web 终端：通过 exec 在实例容器内打开交互式 shell，输出以二进制帧推送，
+// 输入为二进制/文本帧，窗口大小变化为 {"type":"resize","cols":..,"rows":..}
+// 每次会话的开始与结束（含输入记录）都写入审计日志
+
+const (
+	terminalIdleTimeout = 30 * time.Minute // 无输入超时自动断开
+	terminalAuditLimit  = 64 * 1024        // 审计日志保留的输入长度上限
+)
+
+// CheckAdminPermission 管理员权限检查（由 main 注入 admin.CheckAdminPermission，避免循环引用）
+var CheckAdminPermission func(db *sql.DB, userID int64, role string, permission string, resourceType string, resourceID string) bool
+
+var terminalUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 32 * 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+type terminalMessage struct {
+	Type string `json:"type"`
+	Cols uint16 `json:"cols"`
+	Rows uint16 `json:"rows"`
+}
+
+// HandleAdminInstanceTerminal 实例 Web 终端: /api/admin-common/docker/terminal/:source/:instanceId（source 为 jeopardy 或 awdf）
+// 超级管理员可进入任意实例，组织管理员需要实例所属组织的 org.<id>.docker.exec 权限
+func HandleAdminInstanceTerminal(c *gin.Context, db *sql.DB) {
+	source := c.Param("source")
+	table := "team_instances"
+	switch source {
+	case "jeopardy":
+	case "awdf":
+		table = "team_instances_awdf"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_SOURCE", "message": "未知的实例类型"})
+		return
+	}
+	instanceID, err := strconv.ParseInt(c.Param("instanceId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_ID", "message": "无效的实例ID"})
+		return
+	}
+
+	userID := c.GetInt64("userID")
+	role := c.GetString("role")
+
+	var containerID, containerName, backend, node, status string
+	var teamID, contestID, challengeID int64
+	var orgID sql.NullInt64
+	err = db.QueryRow(fmt.Sprintf(`
+		SELECT ti.container_id, COALESCE(ti.container_name, ''), COALESCE(ti.backend, ''), COALESCE(ti.node, ''), ti.status,
+			ti.team_id, ti.contest_id, ti.challenge_id,
+			COALESCE((SELECT organization_id FROM users WHERE id = ti.created_by), (SELECT organization_id FROM teams WHERE id = ti.team_id))
+		FROM %s ti WHERE ti.id = $1`, table), instanceID).Scan(
+		&containerID, &containerName, &backend, &node, &status, &teamID, &contestID, &challengeID, &orgID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "NO_INSTANCE", "message": "实例不存在"})
+		return
+	}
+
+	// 不属于任何组织的实例只有超级管理员可以进入
+	allowed := role == "super"
+	if !allowed && orgID.Valid && CheckAdminPermission != nil {
+		allowed = CheckAdminPermission(db, userID, role, fmt.Sprintf("org.%d.docker.exec", orgID.Int64), "", "")
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "NO_PERMISSION", "message": "没有该实例的终端权限"})
+		return
+	}
+	if status != "running" {
+		c.JSON(http.StatusConflict, gin.H{"error": "NOT_RUNNING", "message": "实例未在运行"})
+		return
+	}
+	opener, ok := container.For(backend, node).(container.TerminalOpener)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "UNSUPPORTED", "message": "该容器后端不支持终端"})
+		return
+	}
+
+	cols, _ := strconv.Atoi(c.Query("cols"))
+	rows, _ := strconv.Atoi(c.Query("rows"))
+	term, err := opener.OpenTerminal(containerID, uint16(cols), uint16(rows))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "TERMINAL_FAILED", "message": err.Error()})
+		return
+	}
+	defer term.Close()
+
+	conn, err := terminalUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var adminName string
+	db.QueryRow(`SELECT display_name FROM users WHERE id = $1`, userID).Scan(&adminName)
+	clientIP := c.ClientIP()
+	details := map[string]interface{}{
+		"source":        source,
+		"instanceId":    instanceID,
+		"containerId":   containerID,
+		"containerName": containerName,
+		"node":          node,
+	}
+	logs.WriteLog(db, logs.TypeContainerExec, logs.LevelWarning, &userID, &teamID, &contestID, &challengeID, clientIP,
+		fmt.Sprintf("管理员 %s 打开容器终端: %s", adminName, containerName), details)
+
+	started := time.Now()
+	audit := container.NewTranscript(terminalAuditLimit)
+
+	// 终端输出 -> 浏览器
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := term.Read(buf)
+			if n > 0 {
+				if werr := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "session ended"), time.Now().Add(time.Second))
+				return
+			}
+		}
+	}()
+
+	// 浏览器输入 -> 终端
+	for {
+		conn.SetReadDeadline(time.Now().Add(terminalIdleTimeout))
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		if msgType == websocket.TextMessage && len(data) > 0 && data[0] == '{' {
+			var msg terminalMessage
+			if json.Unmarshal(data, &msg) == nil && msg.Type == "resize" {
+				term.Resize(msg.Cols, msg.Rows)
+				continue
+			}
+		}
+		audit.Write(data)
+		if _, err := term.Write(data); err != nil {
+			break
+		}
+	}
+	// 浏览器断开后结束 exec 进程，等待转发协程退出
+	term.Close()
+	<-done
+
+	details["duration"] = int(time.Since(started).Seconds())
+	details["input"] = audit.String()
+	details["truncated"] = audit.Truncated()
+	logs.WriteLog(db, logs.TypeContainerExec, logs.LevelWarning, &userID, &teamID, &contestID, &challengeID, clientIP,
+		fmt.Sprintf("管理员 %s 关闭容器终端: %s（持续 %s）", adminName, containerName, time.Since(started).Round(time.Second)), details)
+}
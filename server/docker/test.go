@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"tgctf/server/container"
 )
 
 // DockerTestRequest Docker测试请求
@@ -25,14 +26,18 @@ type DockerTestRequest struct {
 
 // DockerCreateContainerRequest 创建测试容器请求
 type DockerCreateContainerRequest struct {
-	QuestionID  int64    `json:"questionId"`
-	Image       string   `json:"image" binding:"required"`
-	Ports       []string `json:"ports"`
-	CPULimit    string   `json:"cpuLimit"`
-	MemoryLimit string   `json:"memoryLimit"`
-	Flag        string   `json:"flag"`
-	FlagEnvs    []string `json:"flagEnvs"`
-	FlagScript  string   `json:"flagScript"`
+	QuestionID      int64    `json:"questionId"`
+	Image           string   `json:"image" binding:"required"`
+	Ports           []string `json:"ports"`
+	CPULimit        string   `json:"cpuLimit"`
+	MemoryLimit     string   `json:"memoryLimit"`
+	StorageLimit    string   `json:"storageLimit"`
+	NoResourceLimit bool     `json:"noResourceLimit"`
+	ReadOnlyRootfs  bool     `json:"readOnlyRootfs"`
+	AllowSetuid     bool     `json:"allowSetuid"`
+	Flag            string   `json:"flag"`
+	FlagEnvs        []string `json:"flagEnvs"`
+	FlagScript      string   `json:"flagScript"`
 }
 
 // TestContainer 测试容器信息
@@ -132,12 +137,16 @@ func HandleCreateTestContainer(c *gin.Context, db *sql.DB) {
 		args = append(args, "-p", fmt.Sprintf(":%s", port))
 	}
 
-	if req.CPULimit != "" {
-		args = append(args, "--cpus", req.CPULimit)
+	// 资源限制与安全加固参数与正式实例保持一致
+	limits := &container.Spec{CPULimit: req.CPULimit, MemoryLimit: req.MemoryLimit, ReadOnly: req.ReadOnlyRootfs, AllowSetuid: req.AllowSetuid}
+	container.ApplyLimits(limits, req.StorageLimit, req.NoResourceLimit)
+	if limits.CPULimit != "" {
+		args = append(args, "--cpus", limits.CPULimit)
 	}
-	if req.MemoryLimit != "" {
-		args = append(args, "-m", req.MemoryLimit)
+	if limits.MemoryLimit != "" {
+		args = append(args, "-m", limits.MemoryLimit)
 	}
+	hardenAt := len(args) // 加固参数插入位置
 
 	// 处理 Flag 注入方式：环境变量 和/或 命令行参数
 	useCmdArg := false
@@ -164,8 +173,19 @@ func HandleCreateTestContainer(c *gin.Context, db *sql.DB) {
 	runCtx, runCancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer runCancel()
 
-	runCmd := exec.CommandContext(runCtx, "docker", args...)
+	withHardening := func(storageOpt bool) []string {
+		full := append([]string{}, args[:hardenAt]...)
+		full = append(full, container.HardeningArgs(limits, storageOpt)...)
+		return append(full, args[hardenAt:]...)
+	}
+
+	runCmd := exec.CommandContext(runCtx, "docker", withHardening(true)...)
 	output, err := runCmd.CombinedOutput()
+	if err != nil && limits.StorageLimit != "" && container.IsStorageOptError(string(output)) {
+		// 存储驱动不支持磁盘配额，改用 tmpfs 限制后重试
+		exec.Command("docker", "rm", "-f", containerName).Run()
+		output, err = exec.CommandContext(runCtx, "docker", withHardening(false)...).CombinedOutput()
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "CONTAINER_CREATE_FAILED",
@@ -0,0 +1,133 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package docker
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// TCP 隧道：选手通过 tgctf-connect 在本地监听端口，每个 TCP 连接对应一条 WebSocket，由服务端转发到实例端口
+// 每次连接都校验选手登录凭证（JWT）、账号状态以及对实例的访问权限，配合实例访问令牌使用
+
+var tunnelUpgrader = websocket.Upgrader{
+	ReadBufferSize:  32 * 1024,
+	WriteBufferSize: 32 * 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// HandleInstanceTunnel TCP 隧道入口: /api/instances/tunnel/:seg（seg 为 <令牌>-<端口>，登录凭证通过 Authorization 头或 token 参数传递）
+func HandleInstanceTunnel(c *gin.Context, jwtSecret []byte, db *sql.DB) {
+	token, port := parseProxySegment(c.Param("seg"))
+	inst, err := loadProxyInstance(db, token)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "NO_INSTANCE", "message": "实例不存在或已销毁"})
+		return
+	}
+	if port == "" {
+		port = inst.defaultPort()
+	}
+	if _, ok := inst.Ports[port]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "INVALID_PORT", "message": "实例没有开放该端口"})
+		return
+	}
+
+	cred := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if cred == "" {
+		cred = c.Query("token")
+	}
+	userID, ok := VerifyUserToken(db, jwtSecret, cred)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "INVALID_TOKEN", "message": "登录凭证无效或已过期，请重新登录后从比赛页面复制连接命令"})
+		return
+	}
+	if !canAccessInstance(db, userID, inst) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "FORBIDDEN", "message": "无权访问该实例"})
+		return
+	}
+
+	addr, err := resolveProxyAddr(db, inst, port)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "INSTANCE_UNREACHABLE", "message": err.Error()})
+		return
+	}
+	backend, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "INSTANCE_UNREACHABLE", "message": "实例连接失败: " + err.Error()})
+		return
+	}
+	defer backend.Close()
+
+	conn, err := tunnelUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// 实例 -> 选手
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := backend.Read(buf)
+			if n > 0 {
+				if werr := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "instance closed"), time.Now().Add(time.Second))
+				} else {
+					conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+				}
+				return
+			}
+		}
+	}()
+
+	// 选手 -> 实例
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		if _, err := backend.Write(data); err != nil {
+			break
+		}
+	}
+	// 选手断开后关闭实例连接，等待转发协程退出
+	backend.Close()
+	<-done
+}
+
+// HandleGetTunnelCommand 获取实例 tgctf-connect 连接命令（使用选手当前的登录凭证）
+func HandleGetTunnelCommand(c *gin.Context, db *sql.DB) {
+	_, accessToken, port, _, ok := loadInstanceAccess(c, db, "TUNNEL_UNAVAILABLE", "该实例不支持隧道访问，请重新启动实例")
+	if !ok {
+		return
+	}
+
+	scheme := "http"
+	if isSecureRequest(c.Request) {
+		scheme = "https"
+	}
+	server := scheme + "://" + c.Request.Host
+	cred := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	c.JSON(http.StatusOK, gin.H{
+		"server":   server,
+		"instance": accessToken + "-" + port,
+		"command":  fmt.Sprintf("tgctf-connect -server %s -instance %s-%s -token %s -listen 127.0.0.1:9999", server, accessToken, port, cred),
+	})
+}
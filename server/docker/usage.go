@@ -0,0 +1,399 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package docker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"tgctf/server/container"
+	"tgctf/server/logs"
+)
+
+const (
+	usageSampleInterval = 30 * time.Second // 采样间隔
+	usageRetention      = 24 * time.Hour   // 采样数据保留时长
+	runawaySamples      = 3                // 连续超限的采样次数达到该值判定为失控
+	defaultInstanceCPU  = 1.0              // 未配置 CPU 限制的容器按 1 核计入队伍配额
+)
+
+// 队伍配额与失控判定设置（由 main.go 注入）
+var GetTeamMemoryQuota SettingsGetter
+var GetTeamCPUQuota func(db *sql.DB) float64
+var GetRunawayCPUPercent SettingsGetter
+var GetRunawayPids SettingsGetter
+
+var (
+	runawayMu      sync.Mutex
+	runawayStrikes = make(map[string]int) // 实例 -> 连续超限次数
+)
+
+// teamQuotaLock 队伍配额检查锁（配额检查到创建任务之间互斥）
+func teamQuotaLock(teamID int64) *sync.Mutex {
+	return getInstanceLock("quota:"+strconv.FormatInt(teamID, 10), "")
+}
+
+// parseCPUCores 解析容器 CPU 限制（如 "0.5"），未配置或无法解析时按 defaultInstanceCPU 计
+func parseCPUCores(s string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || v <= 0 {
+		return defaultInstanceCPU
+	}
+	return v
+}
+
+// checkTeamQuota 检查队伍运行中实例与未结束的创建任务（含排队中）的内存 / CPU 总量加上新实例后是否超出配额（共享实例不计入）
+// 调用方需持有 teamQuotaLock，并在同一锁内创建任务，避免并发创建多道题目时都通过检查
+func checkTeamQuota(db *sql.DB, l *instanceLaunch) error {
+	if l.Policy == PolicyShared {
+		return nil
+	}
+	memQuota, cpuQuota := 0, 0.0
+	if GetTeamMemoryQuota != nil {
+		memQuota = GetTeamMemoryQuota(db)
+	}
+	if GetTeamCPUQuota != nil {
+		cpuQuota = GetTeamCPUQuota(db)
+	}
+	if memQuota <= 0 && cpuQuota <= 0 {
+		return nil
+	}
+
+	var usedMemory int
+	var usedCPU float64
+	db.QueryRow(`
+		SELECT COALESCE(SUM(memory_mb), 0), COALESCE(SUM(cpu_cores), 0) FROM (
+			SELECT memory_mb, cpu_cores FROM team_instances WHERE team_id = $1 AND status = 'running' AND owner_key <> 'shared'
+			UNION ALL
+			SELECT memory_mb, cpu_cores FROM instance_jobs
+			WHERE team_id = $1 AND kind = 'create' AND status NOT IN ('ready', 'done', 'failed') AND owner_key <> 'shared'
+		) used`,
+		l.TeamID).Scan(&usedMemory, &usedCPU)
+
+	if memQuota > 0 && usedMemory+l.MemoryMB > memQuota {
+		return &launchError{Status: http.StatusForbidden, Code: "TEAM_QUOTA_EXCEEDED",
+			Message: fmt.Sprintf("队伍内存配额不足（已用 %d MB，本题需要 %d MB，上限 %d MB），请先销毁其他实例", usedMemory, l.MemoryMB, memQuota)}
+	}
+	if cpuQuota > 0 && usedCPU+l.CPUCores > cpuQuota+1e-9 {
+		return &launchError{Status: http.StatusForbidden, Code: "TEAM_QUOTA_EXCEEDED",
+			Message: fmt.Sprintf("队伍 CPU 配额不足（已用 %.2g 核，本题需要 %.2g 核，上限 %.2g 核），请先销毁其他实例", usedCPU, l.CPUCores, cpuQuota)}
+	}
+	return nil
+}
+
+// usageTarget 需要采样的运行中实例
+type usageTarget struct {
+	Type        string // team | awdf
+	ID          int64
+	TeamID      int64
+	ContestID   int64
+	ChallengeID int64
+	ContainerID string
+	Backend     string
+	Node        string
+	CPUCores    float64
+}
+
+func (t *usageTarget) key() string {
+	return t.Type + "/" + strconv.FormatInt(t.ID, 10)
+}
+
+// loadUsageTargets 查询所有运行中的队伍实例和 AWD-F 实例
+func loadUsageTargets(db *sql.DB) ([]usageTarget, error) {
+	rows, err := db.Query(`
+		SELECT 'team', id, team_id, contest_id, challenge_id, container_id, COALESCE(backend, ''), COALESCE(node, ''), cpu_cores
+		FROM team_instances WHERE status = 'running'
+		UNION ALL
+		SELECT 'awdf', id, team_id, contest_id, challenge_id, container_id, COALESCE(backend, ''), COALESCE(node, ''), 0
+		FROM team_instances_awdf WHERE status = 'running'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []usageTarget
+	for rows.Next() {
+		var t usageTarget
+		if rows.Scan(&t.Type, &t.ID, &t.TeamID, &t.ContestID, &t.ChallengeID, &t.ContainerID, &t.Backend, &t.Node, &t.CPUCores) == nil {
+			list = append(list, t)
+		}
+	}
+	return list, nil
+}
+
+// collectUsage 采样所有运行中实例的资源占用，写入时序表并检测失控容器
+func collectUsage(db *sql.DB) {
+	targets, err := loadUsageTargets(db)
+	if err != nil {
+		log.Printf("[Usage] 查询运行中实例失败: %v", err)
+		return
+	}
+
+	// 按运行时分组，每个节点只调用一次 stats
+	groups := make(map[string][]*usageTarget)
+	for i := range targets {
+		t := &targets[i]
+		k := t.Backend + "|" + t.Node
+		groups[k] = append(groups[k], t)
+	}
+
+	cpuLimit, pidsLimit := 0, 0
+	if GetRunawayCPUPercent != nil {
+		cpuLimit = GetRunawayCPUPercent(db)
+	}
+	if GetRunawayPids != nil {
+		pidsLimit = GetRunawayPids(db)
+	}
+
+	sampledAt := time.Now()
+	seen := make(map[string]bool)
+	for _, group := range groups {
+		rt := container.For(group[0].Backend, group[0].Node)
+		reader, ok := rt.(container.StatsReader)
+		if !ok {
+			continue
+		}
+		ids := make([]string, len(group))
+		for i, t := range group {
+			ids[i] = t.ContainerID
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		stats, err := reader.Stats(ctx, ids)
+		cancel()
+		if err != nil {
+			log.Printf("[Usage] 节点 %s 采样失败: %v", group[0].Node, err)
+			continue
+		}
+
+		for _, t := range group {
+			st, ok := stats[t.ContainerID]
+			if !ok {
+				continue
+			}
+			seen[t.key()] = true
+			db.Exec(`
+				INSERT INTO instance_usage_samples (instance_type, instance_id, team_id, contest_id, challenge_id,
+					cpu_percent, memory_bytes, memory_limit_bytes, net_rx_bytes, net_tx_bytes, pids, sampled_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+				t.Type, t.ID, t.TeamID, t.ContestID, t.ChallengeID,
+				st.CPUPercent, st.MemoryBytes, st.MemoryLimit, st.NetRxBytes, st.NetTxBytes, st.PIDs, sampledAt)
+
+			if reason := runawayReason(t, st, cpuLimit, pidsLimit); reason != "" {
+				runawayMu.Lock()
+				runawayStrikes[t.key()]++
+				strikes := runawayStrikes[t.key()]
+				runawayMu.Unlock()
+				if strikes >= runawaySamples {
+					killRunaway(db, rt, t, st, reason)
+				}
+			} else {
+				runawayMu.Lock()
+				delete(runawayStrikes, t.key())
+				runawayMu.Unlock()
+			}
+		}
+	}
+
+	// 清理已不在运行的实例的计数
+	runawayMu.Lock()
+	for k := range runawayStrikes {
+		if !seen[k] {
+			delete(runawayStrikes, k)
+		}
+	}
+	runawayMu.Unlock()
+
+	db.Exec(`DELETE FROM instance_usage_samples WHERE sampled_at < $1`, sampledAt.Add(-usageRetention))
+}
+
+// runawayReason 判断单次采样是否超限，返回原因（未超限返回空字符串）
+// CPU 按实例自身的 CPU 限制计算占比，AWD-F 实例和未配置限制的实例按 1 核计
+func runawayReason(t *usageTarget, st *container.Stats, cpuLimit, pidsLimit int) string {
+	if pidsLimit > 0 && st.PIDs > pidsLimit {
+		return fmt.Sprintf("进程数 %d 超过上限 %d", st.PIDs, pidsLimit)
+	}
+	if cpuLimit > 0 {
+		cores := t.CPUCores
+		if cores <= 0 {
+			cores = defaultInstanceCPU
+		}
+		if ratio := st.CPUPercent / cores; ratio >= float64(cpuLimit) {
+			return fmt.Sprintf("CPU 占用 %.0f%% 达到限制的 %d%%", st.CPUPercent, cpuLimit)
+		}
+	}
+	return ""
+}
+
+// killRunaway 回收失控的队伍实例并记录日志
+// AWD-F 容器是队伍的防守目标，只记录告警，由管理员决定是否重置
+func killRunaway(db *sql.DB, rt container.Runtime, t *usageTarget, st *container.Stats, reason string) {
+	runawayMu.Lock()
+	delete(runawayStrikes, t.key())
+	runawayMu.Unlock()
+
+	details := map[string]interface{}{
+		"instanceType": t.Type, "instanceId": t.ID, "containerId": t.ContainerID, "node": t.Node,
+		"cpuPercent": st.CPUPercent, "memoryBytes": st.MemoryBytes, "pids": st.PIDs, "reason": reason,
+	}
+
+	if t.Type == "awdf" {
+		message := fmt.Sprintf("AWD-F 实例 %d 资源占用异常（%s），请检查", t.ID, reason)
+		log.Printf("[Usage] %s", message)
+		logs.WriteLog(db, logs.TypeContainerRunaway, logs.LevelWarning, nil, &t.TeamID, &t.ContestID, &t.ChallengeID, "", message, details)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err := rt.Remove(ctx, t.ContainerID)
+	cancel()
+	level := logs.LevelWarning
+	message := fmt.Sprintf("实例 %d 持续失控（%s），已自动销毁", t.ID, reason)
+	if err != nil {
+		level = logs.LevelError
+		message = fmt.Sprintf("实例 %d 持续失控（%s），自动销毁失败: %v", t.ID, reason, err)
+	} else {
+		db.Exec(`UPDATE team_instances SET status = 'destroyed', updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND status = 'running'`, t.ID)
+		wakeQueue()
+	}
+	log.Printf("[Usage] %s", message)
+	logs.WriteLog(db, logs.TypeContainerRunaway, level, nil, &t.TeamID, &t.ContestID, &t.ChallengeID, "", message, details)
+}
+
+// StartUsageCollector 启动资源占用采集任务
+func StartUsageCollector(db *sql.DB) {
+	ticker := time.NewTicker(usageSampleInterval)
+	go func() {
+		for range ticker.C {
+			collectUsage(db)
+		}
+	}()
+}
+
+// usageRow 资源占用汇总行
+type usageRow struct {
+	ID          int64   `json:"id"`
+	Name        string  `json:"name"`
+	Type        string  `json:"type,omitempty"` // 题目汇总: team | awdf
+	Instances   int     `json:"instances"`
+	CPUPercent  float64 `json:"cpuPercent"`
+	MemoryBytes int64   `json:"memoryBytes"`
+	NetRxBytes  int64   `json:"netRxBytes"`
+	NetTxBytes  int64   `json:"netTxBytes"`
+	PIDs        int     `json:"pids"`
+	QuotaMemory int     `json:"quotaMemoryMb,omitempty"` // 队伍汇总: 计入配额的内存(MB)
+	QuotaCPU    float64 `json:"quotaCpu,omitempty"`      // 队伍汇总: 计入配额的 CPU 核数
+}
+
+// HandleAdminGetUsage 资源占用看板：按队伍、按题目汇总最近一次采样，并返回总量时序
+// 参数: contestId 过滤比赛；teamId / challengeId 过滤时序；minutes 时序范围（默认 60）
+func HandleAdminGetUsage(c *gin.Context, db *sql.DB) {
+	contestID, _ := strconv.ParseInt(c.Query("contestId"), 10, 64)
+	minutes, _ := strconv.Atoi(c.DefaultQuery("minutes", "60"))
+	if minutes <= 0 || minutes > int(usageRetention/time.Minute) {
+		minutes = 60
+	}
+
+	// 每个实例最近一次采样（两个采样周期内）
+	latest := `
+		SELECT DISTINCT ON (instance_type, instance_id) *
+		FROM instance_usage_samples
+		WHERE sampled_at > $1 AND ($2 = 0 OR contest_id = $2)
+		ORDER BY instance_type, instance_id, sampled_at DESC`
+	since := time.Now().Add(-2*usageSampleInterval - 5*time.Second)
+
+	teams := []usageRow{}
+	rows, err := db.Query(`
+		SELECT s.team_id, COALESCE(t.name, ''), COUNT(*), SUM(s.cpu_percent), SUM(s.memory_bytes),
+		       SUM(s.net_rx_bytes), SUM(s.net_tx_bytes), SUM(s.pids),
+		       COALESCE((SELECT SUM(memory_mb) FROM team_instances ti WHERE ti.team_id = s.team_id AND ti.status = 'running' AND ti.owner_key <> 'shared'), 0),
+		       COALESCE((SELECT SUM(cpu_cores) FROM team_instances ti WHERE ti.team_id = s.team_id AND ti.status = 'running' AND ti.owner_key <> 'shared'), 0)
+		FROM (`+latest+`) s
+		LEFT JOIN teams t ON s.team_id = t.id
+		GROUP BY s.team_id, t.name
+		ORDER BY SUM(s.memory_bytes) DESC`, since, contestID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR", "details": err.Error()})
+		return
+	}
+	for rows.Next() {
+		var r usageRow
+		if rows.Scan(&r.ID, &r.Name, &r.Instances, &r.CPUPercent, &r.MemoryBytes, &r.NetRxBytes, &r.NetTxBytes, &r.PIDs,
+			&r.QuotaMemory, &r.QuotaCPU) == nil {
+			teams = append(teams, r)
+		}
+	}
+	rows.Close()
+
+	challenges := []usageRow{}
+	rows, err = db.Query(`
+		SELECT s.challenge_id, s.instance_type,
+		       CASE WHEN s.instance_type = 'awdf' THEN COALESCE(MAX(qa.title), '') ELSE COALESCE(MAX(q.title), MAX(cc.inline_title), '') END,
+		       COUNT(*), SUM(s.cpu_percent), SUM(s.memory_bytes), SUM(s.net_rx_bytes), SUM(s.net_tx_bytes), SUM(s.pids)
+		FROM (`+latest+`) s
+		LEFT JOIN contest_challenges cc ON s.instance_type = 'team' AND s.challenge_id = cc.id
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
+		LEFT JOIN contest_challenges_awdf cca ON s.instance_type = 'awdf' AND s.challenge_id = cca.id
+		LEFT JOIN question_bank_awdf qa ON cca.question_id = qa.id
+		GROUP BY s.challenge_id, s.instance_type
+		ORDER BY SUM(s.memory_bytes) DESC`, since, contestID)
+	if err == nil {
+		for rows.Next() {
+			var r usageRow
+			if rows.Scan(&r.ID, &r.Type, &r.Name, &r.Instances, &r.CPUPercent, &r.MemoryBytes, &r.NetRxBytes, &r.NetTxBytes, &r.PIDs) == nil {
+				challenges = append(challenges, r)
+			}
+		}
+		rows.Close()
+	}
+
+	// 时序：每个采样周期的总量（可按队伍或题目过滤）
+	teamID, _ := strconv.ParseInt(c.Query("teamId"), 10, 64)
+	challengeID, _ := strconv.ParseInt(c.Query("challengeId"), 10, 64)
+	series := []gin.H{}
+	rows, err = db.Query(`
+		SELECT sampled_at, COUNT(*), SUM(cpu_percent), SUM(memory_bytes), SUM(net_rx_bytes), SUM(net_tx_bytes), SUM(pids)
+		FROM instance_usage_samples
+		WHERE sampled_at > $1 AND ($2 = 0 OR contest_id = $2) AND ($3 = 0 OR team_id = $3) AND ($4 = 0 OR challenge_id = $4)
+		GROUP BY sampled_at ORDER BY sampled_at`,
+		time.Now().Add(-time.Duration(minutes)*time.Minute), contestID, teamID, challengeID)
+	if err == nil {
+		for rows.Next() {
+			var at time.Time
+			var count, pids int
+			var cpu float64
+			var mem, rx, tx int64
+			if rows.Scan(&at, &count, &cpu, &mem, &rx, &tx, &pids) == nil {
+				series = append(series, gin.H{"at": at.Format("2006-01-02 15:04:05"), "instances": count, "cpuPercent": cpu,
+					"memoryBytes": mem, "netRxBytes": rx, "netTxBytes": tx, "pids": pids})
+			}
+		}
+		rows.Close()
+	}
+
+	memQuota, cpuQuota := 0, 0.0
+	if GetTeamMemoryQuota != nil {
+		memQuota = GetTeamMemoryQuota(db)
+	}
+	if GetTeamCPUQuota != nil {
+		cpuQuota = GetTeamCPUQuota(db)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"teams":           teams,
+		"challenges":      challenges,
+		"series":          series,
+		"intervalSeconds": int(usageSampleInterval.Seconds()),
+		"teamMemoryQuota": memQuota,
+		"teamCpuQuota":    cpuQuota,
+	})
+}
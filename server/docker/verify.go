@@ -0,0 +1,332 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package docker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"tgctf/server/container"
+)
+
+// 题目自检：使用随机 Flag 启动一次性测试容器，在沙箱中运行出题人提供的解题脚本（solve_script），
+// 解题脚本与题目容器共享网络命名空间，通过 127.0.0.1:<容器端口> 访问题目，输出中包含注入的 Flag 即视为通过
+
+// 自检状态
+const (
+	VerifyPassed = "passed" // 解题脚本输出了正确的 Flag
+	VerifyFailed = "failed" // 解题脚本未输出正确的 Flag（超时、报错、输出不匹配）
+	VerifyError  = "error"  // 无法完成自检（镜像拉取失败、容器启动失败、后端不支持沙箱等）
+)
+
+const (
+	verifyStartupDelay = 2 * time.Second  // 容器启动后等待服务就绪的时间（解题脚本仍应自行重试连接）
+	verifyTimeout      = 2 * time.Minute  // 解题脚本执行时间上限
+	verifyOutputLimit  = 64 * 1024        // 保存的脚本输出上限（超出时保留末尾）
+	verifyConcurrency  = 4                // 批量自检的并发数
+	verifyContainerTTL = 10 * time.Minute // 单道题目自检的总时间上限（含拉取镜像）
+)
+
+// VerifyResult 题目自检结果
+type VerifyResult struct {
+	QuestionID int64  `json:"questionId"`
+	Version    int    `json:"version,omitempty"` // 比赛批量自检时为比赛固定的题目版本
+	Title      string `json:"title"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	Flag       string `json:"flag,omitempty"` // 本次注入的 Flag
+	Output     string `json:"output"`         // 解题脚本输出（标准输出 + 标准错误）
+	DurationMs int64  `json:"durationMs"`
+}
+
+// verifyTarget 自检所需的题目配置
+type verifyTarget struct {
+	Title, Type, Flag, FlagType, Image           string
+	Ports                                        []string
+	CPULimit, MemoryLimit, StorageLimit, Network string
+	FlagEnv, FlagScript, SolveScript             string
+	NoResourceLimit, ReadOnly, AllowSetuid       bool
+}
+
+// loadVerifyTarget 查询题目配置，version 为 0 时使用题库当前内容，否则使用对应版本快照（解题脚本不随版本快照，始终取题库当前内容）
+func loadVerifyTarget(db *sql.DB, questionID int64, version int) (*verifyTarget, error) {
+	t := &verifyTarget{}
+	var ports string
+	query := `
+		SELECT q.title, q.type, COALESCE(q.flag, ''), COALESCE(q.flag_type, 'static'), COALESCE(q.docker_image, ''), COALESCE(q.ports, ''),
+			COALESCE(q.cpu_limit, ''), COALESCE(q.memory_limit, ''), COALESCE(q.storage_limit, ''), COALESCE(q.network_policy, ''),
+			COALESCE(q.flag_env, ''), COALESCE(q.flag_script, ''), COALESCE(b.solve_script, ''),
+			COALESCE(q.no_resource_limit, false), COALESCE(q.read_only_rootfs, false), COALESCE(q.allow_setuid, false)`
+	args := []interface{}{questionID}
+	if version > 0 {
+		query += `
+		FROM question_revisions q
+		JOIN question_bank b ON b.id = q.question_id
+		WHERE q.question_id = $1 AND q.version = $2`
+		args = append(args, version)
+	} else {
+		query += `
+		FROM question_bank q
+		JOIN question_bank b ON b.id = q.id
+		WHERE q.id = $1`
+	}
+	err := db.QueryRow(query, args...).Scan(
+		&t.Title, &t.Type, &t.Flag, &t.FlagType, &t.Image, &ports,
+		&t.CPULimit, &t.MemoryLimit, &t.StorageLimit, &t.Network,
+		&t.FlagEnv, &t.FlagScript, &t.SolveScript,
+		&t.NoResourceLimit, &t.ReadOnly, &t.AllowSetuid)
+	if err != nil {
+		return nil, err
+	}
+	if ports != "" {
+		json.Unmarshal([]byte(ports), &t.Ports)
+	}
+	return t, nil
+}
+
+// verifyQuestion 对单道题目执行自检并保存结果，version 为 0 时检查题库当前内容，flagFormat 为动态 Flag 的格式（为空时使用默认格式）
+func verifyQuestion(db *sql.DB, questionID int64, version int, flagFormat string) *VerifyResult {
+	result := &VerifyResult{QuestionID: questionID, Version: version}
+	t, err := loadVerifyTarget(db, questionID, version)
+	if err != nil {
+		result.Status, result.Message = VerifyError, "题目不存在"
+		return result
+	}
+	result.Title = t.Title
+
+	start := time.Now()
+	result.Status, result.Message, result.Flag, result.Output = runVerify(db, questionID, t, flagFormat)
+	result.DurationMs = time.Since(start).Milliseconds()
+
+	// 题库中的自检状态描述当前版本，检查旧版本快照的结果不覆盖
+	db.Exec(`UPDATE question_bank SET verify_status = $1, verify_output = $2, verified_at = CURRENT_TIMESTAMP WHERE id = $3 AND ($4 = 0 OR version = $4)`,
+		result.Status, result.Message+"\n\n"+result.Output, questionID, version)
+	return result
+}
+
+// runVerify 启动测试容器并在沙箱中运行解题脚本，返回 状态、说明、注入的 Flag、脚本输出
+func runVerify(db *sql.DB, questionID int64, t *verifyTarget, flagFormat string) (string, string, string, string) {
+	if strings.TrimSpace(t.SolveScript) == "" {
+		return VerifyError, "题目未配置解题脚本", "", ""
+	}
+	if !strings.HasSuffix(t.Type, "_container") || t.Image == "" {
+		return VerifyError, "仅容器题目支持自动验证", "", ""
+	}
+
+	// 动态 Flag 题目使用随机 Flag，确保解题脚本确实从容器中取得 Flag
+	flag := t.Flag
+	if t.FlagType == "dynamic" || flag == "" {
+		flag = GenerateFlag(flagFormat)
+	}
+
+	rt, _, err := container.Place(db, "team")
+	if err != nil {
+		return VerifyError, "暂无可用的容器节点: " + err.Error(), flag, ""
+	}
+	sandbox, ok := rt.(container.SandboxRunner)
+	if !ok {
+		return VerifyError, "当前容器后端不支持沙箱执行解题脚本", flag, ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), verifyContainerTTL)
+	defer cancel()
+
+	if puller, ok := rt.(container.ImagePuller); ok && !puller.HasImage(ctx, t.Image) {
+		err := loginRegistry(ctx, db, rt, t.Image)
+		if err == nil {
+			err = puller.Pull(ctx, t.Image)
+		}
+		if err != nil {
+			return VerifyError, "拉取镜像失败: " + err.Error(), flag, ""
+		}
+	}
+
+	// 测试容器不发布端口，解题脚本通过共享网络命名空间访问
+	spec := &container.Spec{
+		Name:        fmt.Sprintf("tg_verify_%d_%d", questionID, time.Now().UnixNano()),
+		Image:       t.Image,
+		Ports:       t.Ports,
+		CPULimit:    t.CPULimit,
+		MemoryLimit: t.MemoryLimit,
+		Network:     t.Network,
+		ReadOnly:    t.ReadOnly,
+		AllowSetuid: t.AllowSetuid,
+		Internal:    true,
+		Labels: map[string]string{
+			"tg.type":        "verify",
+			"tg.question_id": strconv.FormatInt(questionID, 10),
+		},
+	}
+	container.ApplyLimits(spec, t.StorageLimit, t.NoResourceLimit)
+	spec.Env, spec.Args = container.FlagEnv(t.FlagEnv, flag)
+
+	target, err := rt.Run(ctx, spec)
+	if err != nil {
+		return VerifyError, "创建测试容器失败: " + err.Error(), flag, ""
+	}
+	defer func() {
+		rmCtx, rmCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		rt.Remove(rmCtx, target.ID)
+		rmCancel()
+	}()
+
+	if t.FlagScript != "" {
+		time.Sleep(500 * time.Millisecond) // 等待容器完全启动
+		if output, err := rt.Exec(ctx, target.ID, "sh", t.FlagScript, flag); err != nil {
+			return VerifyFailed, fmt.Sprintf("Flag 注入脚本执行失败: %v", err), flag, string(output)
+		}
+	}
+	time.Sleep(verifyStartupDelay)
+	if st, err := rt.Inspect(ctx, target.ID); err == nil && !st.Running {
+		logs, _ := rt.Logs(ctx, target.ID, 100)
+		return VerifyFailed, fmt.Sprintf("测试容器已退出（退出码 %d）", st.ExitCode), flag, string(logs)
+	}
+
+	// 解题脚本参数: <host> <port>，同时提供 TARGET_HOST / TARGET_PORT / TARGET_PORTS 环境变量
+	port := ""
+	if len(t.Ports) > 0 {
+		port = t.Ports[0]
+	}
+	run, err := sandbox.RunSandbox(ctx, &container.SandboxSpec{
+		Script:  t.SolveScript,
+		Args:    []string{"127.0.0.1", port},
+		Env:     []string{"TARGET_HOST=127.0.0.1", "TARGET_PORT=" + port, "TARGET_PORTS=" + strings.Join(t.Ports, ",")},
+		Network: "container:" + target.ID,
+		Timeout: verifyTimeout,
+	})
+	if err != nil {
+		return VerifyError, "沙箱执行失败: " + err.Error(), flag, ""
+	}
+
+	output := verifyOutput(run.Stdout, run.Stderr)
+	switch {
+	case strings.Contains(string(run.Stdout), flag):
+		return VerifyPassed, fmt.Sprintf("验证通过，用时 %.1fs", run.Duration.Seconds()), flag, output
+	case run.TimedOut:
+		return VerifyFailed, fmt.Sprintf("解题脚本执行超时（%s）", verifyTimeout), flag, output
+	case run.ExitCode != 0:
+		return VerifyFailed, fmt.Sprintf("解题脚本异常退出（退出码 %d）", run.ExitCode), flag, output
+	default:
+		return VerifyFailed, "解题脚本输出中未找到正确的 Flag", flag, output
+	}
+}
+
+// verifyOutput 合并脚本输出，超长时保留末尾
+func verifyOutput(stdout, stderr []byte) string {
+	output := string(stdout)
+	if len(stderr) > 0 {
+		output += "\n--- stderr ---\n" + string(stderr)
+	}
+	if len(output) > verifyOutputLimit {
+		output = output[len(output)-verifyOutputLimit:]
+	}
+	return strings.ToValidUTF8(output, "\uFFFD")
+}
+
+// HandleVerifyQuestion 对单道题目执行自检: POST /questions/:id/verify
+func HandleVerifyQuestion(c *gin.Context, db *sql.DB) {
+	questionID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_ID"})
+		return
+	}
+	var solveScript string
+	if err := db.QueryRow(`SELECT COALESCE(solve_script, '') FROM question_bank WHERE id = $1`, questionID).Scan(&solveScript); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "QUESTION_NOT_FOUND"})
+		return
+	}
+	if strings.TrimSpace(solveScript) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "NO_SOLVE_SCRIPT", "message": "题目未配置解题脚本"})
+		return
+	}
+
+	c.JSON(http.StatusOK, verifyQuestion(db, questionID, 0, ""))
+}
+
+// HandleVerifyContestChallenges 批量自检比赛中配置了解题脚本的容器题目: POST /contests/:id/verify-challenges
+// 请求体可选 {"challengeIds": [1, 2]}，为空时检查比赛所有题目；按比赛固定的题目版本检查，动态 Flag 使用比赛的 Flag 格式
+func HandleVerifyContestChallenges(c *gin.Context, db *sql.DB) {
+	contestID := c.Param("id")
+	var req struct {
+		ChallengeIDs []int64 `json:"challengeIds"`
+	}
+	c.ShouldBindJSON(&req)
+
+	var flagFormat string
+	if err := db.QueryRow(`SELECT COALESCE(flag_format, '') FROM contests WHERE id = $1`, contestID).Scan(&flagFormat); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "CONTEST_NOT_FOUND"})
+		return
+	}
+
+	query := `
+		SELECT DISTINCT q.question_id, q.version, q.title, COALESCE(b.solve_script, ''), q.type, COALESCE(q.docker_image, '')
+		FROM contest_challenges cc
+		JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
+		JOIN question_bank b ON b.id = q.question_id
+		WHERE cc.contest_id = $1`
+	args := []interface{}{contestID}
+	if len(req.ChallengeIDs) > 0 {
+		query += ` AND cc.id = ANY($2)`
+		args = append(args, req.ChallengeIDs)
+	}
+	rows, err := db.Query(query+` ORDER BY q.question_id, q.version`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+
+	results := []*VerifyResult{}
+	var pending []int
+	for rows.Next() {
+		r := &VerifyResult{}
+		var solveScript, questionType, image string
+		if err := rows.Scan(&r.QuestionID, &r.Version, &r.Title, &solveScript, &questionType, &image); err != nil {
+			continue
+		}
+		// 未配置解题脚本或非容器题目不参与自检
+		if strings.TrimSpace(solveScript) == "" || !strings.HasSuffix(questionType, "_container") || image == "" {
+			continue
+		}
+		pending = append(pending, len(results))
+		results = append(results, r)
+	}
+	rows.Close()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, verifyConcurrency)
+	for _, i := range pending {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = verifyQuestion(db, results[i].QuestionID, results[i].Version, flagFormat)
+		}(i)
+	}
+	wg.Wait()
+
+	passed, failed := 0, 0
+	for _, r := range results {
+		if r.Status == VerifyPassed {
+			passed++
+		} else {
+			failed++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total":   len(results),
+		"passed":  passed,
+		"failed":  failed,
+		"results": results,
+	})
+}
@@ -0,0 +1,329 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package docker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"tgctf/server/container"
+)
+
+// 预热容器使用的占位 Flag，领取时由 flag_script 替换为队伍 Flag
+const warmPlaceholderFlag = "flag{warm_pool_placeholder}"
+
+// 清空预热池时单个题目删除的容器数上限（不小于预热池最大容量）
+const maxWarmDrain = 100
+
+var poolWake = make(chan struct{}, 1) // 预热容器被领取或配置变更时唤醒补充
+
+// WakeWarmPool 唤醒预热池补充（题目预热配置变更后调用）
+func WakeWarmPool() {
+	select {
+	case poolWake <- struct{}{}:
+	default:
+	}
+}
+
+// warmPoolEligible 题目是否可以使用预热池
+// 预热容器启动时不知道队伍 Flag，只能在领取时通过 flag_script 注入；共享实例使用静态 Flag，可直接预先注入
+func warmPoolEligible(db *sql.DB, challengeID string) bool {
+	var flagScript, policy string
+	db.QueryRow(`
+		SELECT COALESCE(q.flag_script, cc.inline_flag_script, ''), COALESCE(cc.instance_policy, 'team')
+		FROM contest_challenges cc LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
+		WHERE cc.id = $1`, challengeID).Scan(&flagScript, &policy)
+	return flagScript != "" || policy == PolicyShared
+}
+
+// hasWarmContainer 题目预热池是否有可领取的容器
+func hasWarmContainer(db *sql.DB, challengeID string) bool {
+	var exists bool
+	db.QueryRow(`SELECT EXISTS(SELECT 1 FROM warm_pool_containers WHERE challenge_id = $1)`, challengeID).Scan(&exists)
+	return exists
+}
+
+// claimWarmContainer 从预热池领取容器，注入 Flag 后转为实例；没有可用容器时返回 nil
+func claimWarmContainer(db *sql.DB, l *instanceLaunch) *launchResult {
+	for attempt := 0; attempt < 3; attempt++ {
+		var id int64
+		var containerID, containerName, portsJSON, backend, node string
+		err := db.QueryRow(`
+			DELETE FROM warm_pool_containers WHERE id = (
+				SELECT id FROM warm_pool_containers WHERE challenge_id = $1 ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED
+			) RETURNING id, container_id, COALESCE(container_name, ''), COALESCE(ports, '{}'), backend, COALESCE(node, '')`,
+			l.ChallengeID).Scan(&id, &containerID, &containerName, &portsJSON, &backend, &node)
+		if err != nil {
+			return nil
+		}
+		WakeWarmPool()
+
+		rt := container.For(backend, node)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		st, err := rt.Inspect(ctx, containerID)
+		if err != nil || !st.Running {
+			rt.Remove(ctx, containerID)
+			cancel()
+			continue
+		}
+
+		// 共享实例创建时已注入静态 Flag，其余通过 flag_script 替换占位 Flag
+		if l.FlagScript == "" && l.Policy != PolicyShared {
+			rt.Remove(ctx, containerID)
+			cancel()
+			return nil
+		}
+		if l.FlagScript != "" {
+			l.Job.update(db, JobInjectingFlag, 0, "")
+			if out, err := rt.Exec(ctx, containerID, "sh", l.FlagScript, l.Flag); err != nil {
+				log.Printf("[WarmPool] 预热容器 %s 注入 Flag 失败，改为新建容器: %v, output: %s", containerName, err, string(out))
+				rt.Remove(ctx, containerID)
+				cancel()
+				return nil
+			}
+		}
+		cancel()
+
+		var ports map[string]string
+		json.Unmarshal([]byte(portsJSON), &ports)
+		r, err := saveInstance(db, l, rt, containerID, containerName, node, ports)
+		if err != nil {
+			return nil
+		}
+		return r
+	}
+	return nil
+}
+
+// warmPoolTarget 需要维护预热池的题目
+type warmPoolTarget struct {
+	ContestID   string
+	ChallengeID string
+	Size        int
+	Policy      string
+	Current     int
+}
+
+// createWarmContainer 为题目创建一个预热容器
+func createWarmContainer(db *sql.DB, t *warmPoolTarget, l *instanceLaunch) error {
+	flag := warmPlaceholderFlag
+	if t.Policy == PolicyShared {
+		if flag = getSharedFlag(db, t.ChallengeID); flag == "" {
+			return fmt.Errorf("共享实例题目未配置静态 Flag")
+		}
+	}
+
+	containerName := fmt.Sprintf("tg_warm_%s_%d", t.ChallengeID, time.Now().UnixNano())
+	spec := &container.Spec{
+		Name:        containerName,
+		Image:       l.Image,
+		Ports:       l.Ports,
+		CPULimit:    l.CPULimit,
+		MemoryLimit: l.MemoryLimit,
+		Network:     l.Network,
+		ReadOnly:    l.ReadOnly,
+		AllowSetuid: l.AllowSetuid,
+		Labels: map[string]string{
+			"tg.type":         "warm",
+			"tg.contest_id":   t.ContestID,
+			"tg.challenge_id": t.ChallengeID,
+		},
+	}
+	container.ApplyLimits(spec, l.StorageLimit, l.NoResourceLimit)
+	spec.Env, spec.Args = container.FlagEnv(l.FlagEnv, flag)
+
+	portAllocMu.Lock()
+	rt, node, err := container.Place(db, "team")
+	if err != nil {
+		portAllocMu.Unlock()
+		return err
+	}
+
+	// 节点本地没有镜像时先单独拉取（拉取期间不持有端口锁，避免阻塞选手创建实例）
+	if puller, ok := rt.(container.ImagePuller); ok {
+		pullCtx, pullCancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		if !puller.HasImage(pullCtx, l.Image) {
+			portAllocMu.Unlock()
+			err := loginRegistry(pullCtx, db, rt, l.Image)
+			if err == nil {
+				err = puller.Pull(pullCtx, l.Image)
+			}
+			pullCancel()
+			if err != nil {
+				return fmt.Errorf("拉取镜像失败: %w", err)
+			}
+			portAllocMu.Lock()
+		} else {
+			pullCancel()
+		}
+	}
+
+	if err := publishPorts(db, rt, spec, node); err != nil {
+		portAllocMu.Unlock()
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	result, err := rt.Run(ctx, spec)
+	portAllocMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	portsJSON, _ := json.Marshal(result.Ports)
+	_, err = db.Exec(`
+		INSERT INTO warm_pool_containers (contest_id, challenge_id, container_id, container_name, ports, backend, node, memory_mb)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		t.ContestID, t.ChallengeID, result.ID, containerName, string(portsJSON), rt.Name(), result.Node, l.MemoryMB)
+	if err != nil {
+		rt.Remove(context.Background(), result.ID)
+		return err
+	}
+	return nil
+}
+
+// removeWarmContainers 删除题目预热池中多余的容器（最新创建的优先删除）
+func removeWarmContainers(db *sql.DB, challengeID string, count int) {
+	rows, err := db.Query(`
+		DELETE FROM warm_pool_containers WHERE id IN (
+			SELECT id FROM warm_pool_containers WHERE challenge_id = $1 ORDER BY id DESC LIMIT $2
+		) RETURNING container_id, backend, COALESCE(node, '')`, challengeID, count)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var containerID, backend, node string
+		if rows.Scan(&containerID, &backend, &node) == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			container.For(backend, node).Remove(ctx, containerID)
+			cancel()
+		}
+	}
+}
+
+// DrainWarmPool 清空题目预热池并唤醒补充（比赛题目升级版本后调用，避免选手领取到旧版本的预热容器）
+func DrainWarmPool(db *sql.DB, challengeIDs []int64) {
+	go func() {
+		for _, id := range challengeIDs {
+			removeWarmContainers(db, strconv.FormatInt(id, 10), maxWarmDrain)
+		}
+		WakeWarmPool()
+	}()
+}
+
+// pruneDeadWarmContainers 清理已退出的预热容器
+func pruneDeadWarmContainers(db *sql.DB) {
+	rows, err := db.Query(`SELECT id, container_id, backend, COALESCE(node, '') FROM warm_pool_containers`)
+	if err != nil {
+		return
+	}
+	type warmRow struct {
+		id                         int64
+		containerID, backend, node string
+	}
+	var list []warmRow
+	for rows.Next() {
+		var w warmRow
+		if rows.Scan(&w.id, &w.containerID, &w.backend, &w.node) == nil {
+			list = append(list, w)
+		}
+	}
+	rows.Close()
+
+	for _, w := range list {
+		rt := container.For(w.backend, w.node)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		st, err := rt.Inspect(ctx, w.containerID)
+		if err == nil && !st.Running {
+			rt.Remove(ctx, w.containerID)
+			db.Exec(`DELETE FROM warm_pool_containers WHERE id = $1`, w.id)
+		}
+		cancel()
+	}
+}
+
+// refillWarmPools 按题目配置补充或缩减预热池，比赛未进行时清空
+// 有选手排队时不补充，避免预热容器与排队请求争抢资源
+func refillWarmPools(db *sql.DB) {
+	pruneDeadWarmContainers(db)
+
+	rows, err := db.Query(`
+		SELECT cc.id, cc.contest_id, CASE WHEN ct.status = 'running' THEN cc.warm_pool_size ELSE 0 END,
+		       COALESCE(cc.instance_policy, 'team'),
+		       (SELECT COUNT(*) FROM warm_pool_containers w WHERE w.challenge_id = cc.id)
+		FROM contest_challenges cc JOIN contests ct ON cc.contest_id = ct.id
+		WHERE cc.warm_pool_size > 0 OR EXISTS(SELECT 1 FROM warm_pool_containers w WHERE w.challenge_id = cc.id)
+		ORDER BY cc.id`)
+	if err != nil {
+		return
+	}
+	var targets []warmPoolTarget
+	for rows.Next() {
+		var t warmPoolTarget
+		var challengeID, contestID int64
+		if rows.Scan(&challengeID, &contestID, &t.Size, &t.Policy, &t.Current) == nil {
+			t.ChallengeID, t.ContestID = strconv.FormatInt(challengeID, 10), strconv.FormatInt(contestID, 10)
+			targets = append(targets, t)
+		}
+	}
+	rows.Close()
+
+	for i := range targets {
+		t := &targets[i]
+		if t.Current > t.Size {
+			removeWarmContainers(db, t.ChallengeID, t.Current-t.Size)
+			continue
+		}
+		if t.Current == t.Size || !warmPoolEligible(db, t.ChallengeID) {
+			continue
+		}
+
+		l, err := loadChallengeSpec(db, t.ContestID, t.ChallengeID)
+		if err != nil {
+			continue
+		}
+		for n := t.Current; n < t.Size; n++ {
+			capacityMu.Lock()
+			if hasWaitingTickets(db) || !hasCapacity(db, l.MemoryMB, 0) {
+				capacityMu.Unlock()
+				return
+			}
+			warmPending++
+			warmPendingMemory += l.MemoryMB
+			capacityMu.Unlock()
+
+			err := createWarmContainer(db, t, l)
+			capacityMu.Lock()
+			warmPending--
+			warmPendingMemory -= l.MemoryMB
+			capacityMu.Unlock()
+			if err != nil {
+				log.Printf("[WarmPool] 题目 %s 创建预热容器失败: %v", t.ChallengeID, err)
+				break
+			}
+		}
+	}
+}
+
+// StartWarmPool 启动预热池维护任务
+func StartWarmPool(db *sql.DB) {
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			refillWarmPools(db)
+			select {
+			case <-ticker.C:
+			case <-poolWake:
+			}
+		}
+	}()
+}
@@ -18,17 +18,20 @@ import (
 
 // 日志类型常量
 const (
-	TypeLogin            = "login"
-	TypeLogout           = "logout"
-	TypeContainerCreate  = "container_create"
-	TypeContainerDestroy = "container_destroy"
-	TypeContainerExtend  = "container_extend"
-	TypeFlagSubmit       = "flag_submit"
-	TypeCheating         = "cheating"
-	TypeAdminOp          = "admin_op"
-	TypeChallengeView    = "challenge_view"  // 题目首次查看
-	TypeAvatarUpdate     = "avatar_update"   // 头像更新
-	TypePasswordChange   = "password_change" // 密码修改
+	TypeLogin              = "login"
+	TypeLogout             = "logout"
+	TypeContainerCreate    = "container_create"
+	TypeContainerDestroy   = "container_destroy"
+	TypeContainerExtend    = "container_extend"
+	TypeFlagSubmit         = "flag_submit"
+	TypeCheating           = "cheating"
+	TypeAdminOp            = "admin_op"
+	TypeChallengeView      = "challenge_view"      // 题目首次查看
+	TypeAvatarUpdate       = "avatar_update"       // 头像更新
+	TypePasswordChange     = "password_change"     // 密码修改
+	TypeContainerReconcile = "container_reconcile" // 容器巡检（自动重启/清理）
+	TypeContainerRunaway   = "container_runaway"   // 失控容器（资源占用持续超限）
+	TypeContainerExec      = "container_exec"      // 管理员容器终端会话
 )
 
 // 日志级别常量
@@ -15,12 +15,14 @@ import (
 
 	"tgctf/server/admin"
 	"tgctf/server/awdf"
+	"tgctf/server/container"
 	"tgctf/server/contest"
 	"tgctf/server/docker"
 	dataimport "tgctf/server/import"
 	"tgctf/server/logs"
 	"tgctf/server/monitor"
 	"tgctf/server/question"
+	"tgctf/server/storage"
 	"tgctf/server/submission"
 	"tgctf/server/user"
 )
@@ -46,10 +48,28 @@ func main() {
 		log.Fatalf("failed to ping database: %v", err)
 	}
 
+	// 初始化附件存储（local | s3）
+	if err := storage.Init(os.Getenv("STORAGE_BACKEND")); err != nil {
+		log.Fatalf("failed to init attachment storage: %v", err)
+	}
+
+	// 子命令: tgctf gc-attachments [-dry-run] [-grace 24h] 清理未被引用的孤儿附件
+	if len(os.Args) > 1 && os.Args[1] == "gc-attachments" {
+		if err := question.RunAttachmentGC(db, os.Args[2:]); err != nil {
+			log.Fatalf("attachment gc failed: %v", err)
+		}
+		return
+	}
+
 	if err := ensureAdmin(db); err != nil {
 		log.Fatalf("failed to ensure admin user: %v", err)
 	}
 
+	// 初始化容器后端（docker | podman | kubernetes）
+	if err := container.Init(os.Getenv("CONTAINER_BACKEND")); err != nil {
+		log.Fatalf("failed to init container backend: %v", err)
+	}
+
 	// 初始化Flag生成函数（用于队伍审核通过时自动生成Flag）
 	contest.GenerateFlagsForTeamInContest = docker.GenerateFlagsForTeamInContest
 
@@ -70,15 +90,40 @@ func main() {
 	docker.GetContainerTTL = admin.GetContainerTTL
 	docker.GetContainerExtendTTL = admin.GetContainerExtendTTL
 	docker.GetContainerExtendWindow = admin.GetContainerExtendWindow
+	docker.GetContainerMaxRunning = admin.GetContainerMaxRunning
+	docker.GetContainerMaxMemory = admin.GetContainerMaxMemory
+	docker.GetTeamMemoryQuota = admin.GetTeamMemoryQuota
+	docker.GetTeamCPUQuota = admin.GetTeamCPUQuota
+	docker.GetRunawayCPUPercent = admin.GetRunawayCPUPercent
+	docker.GetRunawayPids = admin.GetRunawayPids
+	docker.CheckAdminPermission = admin.CheckAdminPermission
+	docker.SaveQuestionRevision = question.SaveRevision
+	docker.GetRegistryCredentials = admin.GetRegistryCredentials
+	contest.CheckContestImages = docker.MissingContestImages
+	question.GetTeamFlag = docker.GetOrCreateTeamFlag
+	question.IsValidInstancePolicy = docker.IsValidInstancePolicy
+
+	// 初始化预热池配置变更回调
+	question.OnWarmPoolChange = docker.WakeWarmPool
+	question.OnChallengeUpgrade = docker.DrainWarmPool
 
 	// 初始化端口分配函数
-	docker.AllocatePorts = admin.AllocatePorts
+	docker.AllocatePorts = admin.AllocatePortsOnNode
+	docker.IsPortPublishEnabled = admin.IsContainerPublishPortsEnabled
+
+	// 实例代理域名模式: <令牌>-<端口>.<INSTANCE_PROXY_DOMAIN>，未配置时使用路径模式 /i/<令牌>-<端口>/
+	docker.InstanceProxyDomain = os.Getenv("INSTANCE_PROXY_DOMAIN")
 
 	// 初始化 AWD-F 比赛状态变更钩子
 	contest.OnAWDFContestStatusChange = awdf.HandleContestStatusChange
 	contest.AllocatePortsFunc = admin.AllocatePorts
 	awdf.AllocatePortsFunc = admin.AllocatePorts
+	awdf.AllocateNodePortsFunc = admin.AllocatePortsOnNode
+
+	// 初始化容器对账时的 AWD-F 容器补建函数（保留补丁与 SSH 密码）
+	docker.RecreateAWDFContainer = awdf.RecreateTeamContainer
 	awdf.GetContainerTTLFunc = admin.GetContainerTTL
+	awdf.VerifyUserTokenFunc = docker.VerifyUserToken
 
 	// 初始化 AWD-F 大屏事件记录函数
 	awdf.AddMonitorEventFunc = monitor.AddMonitorEventToDB
@@ -93,6 +138,12 @@ func main() {
 
 	r := gin.Default()
 
+	// 实例代理（域名模式需在所有路由之前拦截）
+	r.Use(docker.InstanceProxyHostMiddleware([]byte(jwtSecret), db))
+	r.Any("/i/:seg/*path", func(c *gin.Context) {
+		docker.HandleInstanceProxy(c, []byte(jwtSecret), db)
+	})
+
 	api := r.Group("/api")
 	{
 		api.POST("/login", func(c *gin.Context) {
@@ -104,6 +155,21 @@ func main() {
 			contest.HandlePublicContests(c, db)
 		})
 
+		// 选手容器实例事件推送（不经过中间件，自己验证token）
+		api.GET("/instances/ws", func(c *gin.Context) {
+			docker.HandleInstanceEventsWebSocket(c, []byte(jwtSecret), db)
+		})
+
+		// 实例 TCP 隧道（tgctf-connect 客户端，每次连接校验选手登录凭证）
+		api.GET("/instances/tunnel/:seg", func(c *gin.Context) {
+			docker.HandleInstanceTunnel(c, []byte(jwtSecret), db)
+		})
+
+		// AWD-F 防守 Web 终端（不经过中间件，自己验证token）
+		api.GET("/contests/:id/challenges/:challengeId/terminal", func(c *gin.Context) {
+			awdf.HandleDefenseTerminal(c, []byte(jwtSecret), db)
+		})
+
 		// 大屏WebSocket实时推送（不经过中间件，自己验证token）
 		api.GET("/contests/:id/monitor/ws", func(c *gin.Context) {
 			monitor.HandleMonitorWebSocket(c, []byte(jwtSecret), db)
@@ -152,6 +218,23 @@ func main() {
 			userAPI.POST("/contests/:id/challenges/:challengeId/instance/extend", func(c *gin.Context) {
 				docker.HandleExtendUserInstance(c, db)
 			})
+			userAPI.POST("/contests/:id/challenges/:challengeId/instance/proxy-ticket", func(c *gin.Context) {
+				docker.HandleCreateProxyTicket(c, []byte(jwtSecret), db)
+			})
+			userAPI.GET("/contests/:id/challenges/:challengeId/instance/tunnel-command", func(c *gin.Context) {
+				docker.HandleGetTunnelCommand(c, db)
+			})
+			// 容器实例排队状态
+			userAPI.GET("/contests/:id/instance-queue/:ticketId", func(c *gin.Context) {
+				docker.HandleGetQueueTicket(c, db)
+			})
+			userAPI.DELETE("/contests/:id/instance-queue/:ticketId", func(c *gin.Context) {
+				docker.HandleCancelQueueTicket(c, db)
+			})
+			// 容器实例任务状态（创建/销毁/续期）
+			userAPI.GET("/contests/:id/instance-jobs/:jobId", func(c *gin.Context) {
+				docker.HandleGetInstanceJob(c, db)
+			})
 
 			// 检查用户队伍在比赛中的审核状态
 			userAPI.GET("/contests/:id/team-status", func(c *gin.Context) {
@@ -168,6 +251,13 @@ func main() {
 			userAPI.GET("/contests/:id/challenges/:challengeId/stats", func(c *gin.Context) {
 				submission.HandleGetChallengeStats(c, db)
 			})
+			// 题目评价（体感难度、质量评分与留言，每人每题一次）
+			userAPI.GET("/contests/:id/challenges/:challengeId/feedback", func(c *gin.Context) {
+				submission.HandleGetMyFeedback(c, db)
+			})
+			userAPI.POST("/contests/:id/challenges/:challengeId/feedback", func(c *gin.Context) {
+				submission.HandleSubmitFeedback(c, db)
+			})
 			// 获取选择题答题次数
 			userAPI.GET("/contests/:id/challenges/:challengeId/choice-attempts", func(c *gin.Context) {
 				submission.HandleGetChoiceAttempts(c, db)
@@ -186,6 +276,11 @@ func main() {
 				question.HandleGetReleasedHints(c, db)
 			})
 
+			// 获取题目附件的签名下载链接（校验队伍审核与放题状态）
+			userAPI.GET("/contests/:id/challenges/:challengeId/attachment", func(c *gin.Context) {
+				question.HandleGetAttachmentLink(c, db)
+			})
+
 			// ========== AWD-F 补丁上传（选手端） ==========
 			userAPI.POST("/contests/:id/challenges/:challengeId/patch", func(c *gin.Context) {
 				awdf.HandleUploadPatch(c, db)
@@ -368,18 +463,68 @@ func main() {
 			adminAPI.GET("/questions/template", func(c *gin.Context) {
 				question.HandleDownloadQuestionTemplate(c, db)
 			})
+			// 题目包（challenge.yml）导入导出
+			adminAPI.POST("/questions/import-package", func(c *gin.Context) {
+				question.HandleImportQuestionPackages(c, db)
+			})
+			adminAPI.GET("/questions/export", func(c *gin.Context) {
+				question.HandleExportQuestionPackages(c, db)
+			})
+			// 题目镜像构建（上传 Dockerfile 构建上下文，异步构建）
+			adminAPI.POST("/questions/:id/build", func(c *gin.Context) {
+				docker.HandleStartImageBuild(c, db)
+			})
+			adminAPI.GET("/questions/:id/builds", func(c *gin.Context) {
+				docker.HandleListImageBuilds(c, db)
+			})
+			adminAPI.GET("/questions/:id/builds/:buildId", func(c *gin.Context) {
+				docker.HandleGetImageBuild(c, db)
+			})
+			adminAPI.GET("/questions/:id/builds/:buildId/log", func(c *gin.Context) {
+				docker.HandleImageBuildLog(c, db)
+			})
+			adminAPI.POST("/questions/:id/builds/:buildId/activate", func(c *gin.Context) {
+				docker.HandleActivateImageBuild(c, db)
+			})
+			// 题库标签与题目被比赛使用情况
+			adminAPI.GET("/questions/tags", func(c *gin.Context) {
+				question.HandleListQuestionTags(c, db)
+			})
+			adminAPI.GET("/questions/:id/contests", func(c *gin.Context) {
+				question.HandleQuestionContests(c, db)
+			})
+			adminAPI.GET("/questions/:id/feedback", func(c *gin.Context) {
+				submission.HandleGetQuestionFeedback(c, db)
+			})
+			// 题目版本历史
+			adminAPI.GET("/questions/:id/revisions", func(c *gin.Context) {
+				question.HandleListQuestionRevisions(c, db)
+			})
+			// 解题脚本自检（随机 Flag 启动测试容器，沙箱中运行解题脚本）
+			adminAPI.POST("/questions/:id/verify", func(c *gin.Context) {
+				docker.HandleVerifyQuestion(c, db)
+			})
 			// 批量镜像测试
 			adminAPI.POST("/questions/batch-test-images", func(c *gin.Context) {
 				question.HandleBatchTestImages(c, db)
 			})
 
 			// ========== 附件管理 ==========
+			adminAPI.GET("/attachments", func(c *gin.Context) {
+				question.HandleListAttachments(c, db)
+			})
 			adminAPI.POST("/attachments/upload", func(c *gin.Context) {
 				question.HandleUploadAttachment(c, db)
 			})
 			adminAPI.DELETE("/attachments/:filename", func(c *gin.Context) {
 				question.HandleDeleteAttachment(c, db)
 			})
+			adminAPI.GET("/attachments/:filename/link", func(c *gin.Context) {
+				question.HandleGetAdminAttachmentLink(c, db)
+			})
+			adminAPI.POST("/attachments/trace", func(c *gin.Context) {
+				question.HandleTraceAttachment(c, db)
+			})
 
 			// ========== 比赛题目关联（新版，从题库添加） ==========
 			adminAPI.GET("/contests/:id/contest-challenges", func(c *gin.Context) {
@@ -404,6 +549,24 @@ func main() {
 			adminAPI.GET("/contest-challenges/:id/inline", func(c *gin.Context) {
 				question.HandleGetInlineChallenge(c, db)
 			})
+			// 比赛题目版本升级（预览差异后升级到题库最新版本）
+			adminAPI.GET("/contests/:id/question-upgrades", func(c *gin.Context) {
+				question.HandleListQuestionUpgrades(c, db)
+			})
+			adminAPI.POST("/contests/:id/question-upgrades", func(c *gin.Context) {
+				question.HandleUpgradeContestQuestions(c, db)
+			})
+			// 比赛题目批量解题自检
+			adminAPI.POST("/contests/:id/verify-challenges", func(c *gin.Context) {
+				docker.HandleVerifyContestChallenges(c, db)
+			})
+			// 选手题目评价汇总（附解题统计）
+			adminAPI.GET("/contests/:id/feedback", func(c *gin.Context) {
+				submission.HandleGetContestFeedback(c, db)
+			})
+			adminAPI.GET("/contests/:id/challenges/:challengeId/feedback", func(c *gin.Context) {
+				submission.HandleGetChallengeFeedback(c, db)
+			})
 			// 批量更新题目显示顺序
 			adminAPI.PUT("/contests/:id/contest-challenges/order", func(c *gin.Context) {
 				question.HandleBatchUpdateChallengeOrder(c, db)
@@ -416,6 +579,16 @@ func main() {
 			adminAPI.POST("/contest-challenges/:id/hint/release", func(c *gin.Context) {
 				question.HandleReleaseChallengeHint(c, db)
 			})
+			// 队伍专属附件与下载统计
+			adminAPI.GET("/contest-challenges/:id/attachments", func(c *gin.Context) {
+				question.HandleListChallengeAttachments(c, db)
+			})
+			adminAPI.PUT("/contest-challenges/:id/attachments/:teamId", func(c *gin.Context) {
+				question.HandleUploadAttachmentVariant(c, db)
+			})
+			adminAPI.DELETE("/contest-challenges/:id/attachments/:teamId", func(c *gin.Context) {
+				question.HandleDeleteAttachmentVariant(c, db)
+			})
 			// 多提示支持 - 获取题目所有提示
 			adminAPI.GET("/contest-challenges/:id/hints", func(c *gin.Context) {
 				question.HandleListChallengeHints(c, db)
@@ -574,6 +747,12 @@ func main() {
 			adminAPI.POST("/docker/pull-image", func(c *gin.Context) {
 				docker.HandlePullDockerImage(c, db)
 			})
+			adminAPI.GET("/contests/:id/prepare", func(c *gin.Context) {
+				docker.HandleGetContestPrepare(c, db)
+			})
+			adminAPI.POST("/contests/:id/prepare", func(c *gin.Context) {
+				docker.HandleStartContestPrepare(c, db)
+			})
 			adminAPI.POST("/docker/test-container", func(c *gin.Context) {
 				docker.HandleCreateTestContainer(c, db)
 			})
@@ -597,6 +776,9 @@ func main() {
 			adminAPI.GET("/docker/instances/contests", func(c *gin.Context) {
 				docker.HandleAdminGetContestsForDocker(c, db)
 			})
+			adminAPI.GET("/docker/usage", func(c *gin.Context) {
+				docker.HandleAdminGetUsage(c, db)
+			})
 			adminAPI.DELETE("/docker/instances/:instanceId", func(c *gin.Context) {
 				docker.HandleAdminDestroyInstance(c, db)
 			})
@@ -609,6 +791,26 @@ func main() {
 			adminAPI.GET("/docker/instances/:instanceId/logs", func(c *gin.Context) {
 				docker.HandleAdminGetContainerLogs(c, db)
 			})
+			adminAPI.POST("/docker/reconcile", func(c *gin.Context) {
+				docker.HandleAdminReconcile(c, db)
+			})
+
+			// ========== Docker 工作节点 ==========
+			adminAPI.GET("/docker/hosts", func(c *gin.Context) {
+				admin.HandleListDockerHosts(c, db)
+			})
+			adminAPI.POST("/docker/hosts", func(c *gin.Context) {
+				admin.HandleCreateDockerHost(c, db)
+			})
+			adminAPI.PUT("/docker/hosts/:id", func(c *gin.Context) {
+				admin.HandleUpdateDockerHost(c, db)
+			})
+			adminAPI.DELETE("/docker/hosts/:id", func(c *gin.Context) {
+				admin.HandleDeleteDockerHost(c, db)
+			})
+			adminAPI.POST("/docker/hosts/:id/check", func(c *gin.Context) {
+				admin.HandleCheckDockerHost(c, db)
+			})
 
 			// ========== 系统设置 ==========
 			adminAPI.GET("/settings", func(c *gin.Context) {
@@ -617,6 +819,12 @@ func main() {
 			adminAPI.PUT("/settings", func(c *gin.Context) {
 				admin.HandleUpdateSystemSettings(c, db)
 			})
+			adminAPI.GET("/settings/registries", func(c *gin.Context) {
+				admin.HandleGetRegistries(c, db)
+			})
+			adminAPI.PUT("/settings/registries", func(c *gin.Context) {
+				admin.HandleUpdateRegistries(c, db)
+			})
 
 			// ========== 普通管理员管理 ==========
 			adminAPI.GET("/admins", func(c *gin.Context) {
@@ -695,6 +903,15 @@ func main() {
 			adminAPI.POST("/awdf/questions", func(c *gin.Context) {
 				awdf.HandleCreateAWDFQuestion(c, db)
 			})
+			adminAPI.GET("/awdf/questions/tags", func(c *gin.Context) {
+				awdf.HandleListAWDFQuestionTags(c, db)
+			})
+			adminAPI.GET("/awdf/questions/:id/contests", func(c *gin.Context) {
+				awdf.HandleAWDFQuestionContests(c, db)
+			})
+			adminAPI.GET("/awdf/questions/:id/feedback", func(c *gin.Context) {
+				submission.HandleGetAWDFQuestionFeedback(c, db)
+			})
 			adminAPI.GET("/awdf/questions/:id", func(c *gin.Context) {
 				awdf.HandleGetAWDFQuestion(c, db)
 			})
@@ -704,6 +921,12 @@ func main() {
 			adminAPI.DELETE("/awdf/questions/:id", func(c *gin.Context) {
 				awdf.HandleDeleteAWDFQuestion(c, db)
 			})
+			adminAPI.POST("/awdf/questions/import-package", func(c *gin.Context) {
+				awdf.HandleImportAWDFPackages(c, db)
+			})
+			adminAPI.GET("/awdf/questions/export", func(c *gin.Context) {
+				awdf.HandleExportAWDFPackages(c, db)
+			})
 			adminAPI.GET("/awdf/stats", func(c *gin.Context) {
 				awdf.HandleGetAWDFStats(c, db)
 			})
@@ -726,6 +949,12 @@ func main() {
 			adminAPI.GET("/contests/:id/patches", func(c *gin.Context) {
 				awdf.HandleAdminListPatches(c, db)
 			})
+			adminAPI.GET("/contests/:id/terminal-sessions", func(c *gin.Context) {
+				awdf.HandleAdminListTerminalSessions(c, db)
+			})
+			adminAPI.GET("/contests/:id/terminal-sessions/:sessionId", func(c *gin.Context) {
+				awdf.HandleAdminGetTerminalSession(c, db)
+			})
 
 			// ========== AWD-F EXP执行（管理员） ==========
 			adminAPI.GET("/contests/:id/exp-results", func(c *gin.Context) {
@@ -823,6 +1052,9 @@ func main() {
 			adminCommonAPI.GET("/organizations/:id/docker/stats", func(c *gin.Context) {
 				admin.HandleAdminCommonOrgDockerStats(c, db)
 			})
+			adminCommonAPI.GET("/docker/terminal/:source/:instanceId", func(c *gin.Context) {
+				docker.HandleAdminInstanceTerminal(c, db)
+			})
 			
 			// 防作弊（组织范围）
 			adminCommonAPI.GET("/organizations/:id/anti-cheat", func(c *gin.Context) {
@@ -848,7 +1080,7 @@ func main() {
 		}
 		// 附件下载：/attachments/xxx
 		if len(path) > 13 && path[:13] == "/attachments/" {
-			serveAttachments(c)
+			question.HandleServeAttachment(c, db)
 			return
 		}
 		// HTML文件禁用缓存
@@ -865,6 +1097,25 @@ func main() {
 		port = "8080"
 	}
 
+	// 加载工作节点并启动健康检查任务
+	container.StartHostHealthChecker(db)
+
+	// 启动时对账数据库与容器状态，之后定期巡检（重启崩溃容器、清理孤儿容器）
+	go docker.ReconcileOnStartup(db)
+	docker.StartInstanceReconciler(db)
+
+	// 启动容器实例排队调度任务
+	docker.StartInstanceQueue(db)
+
+	// 上次未完成的镜像构建标记为失败
+	docker.RecoverImageBuilds(db)
+
+	// 启动题目容器预热池维护任务
+	docker.StartWarmPool(db)
+
+	// 启动容器资源占用采集任务（失控容器自动回收）
+	docker.StartUsageCollector(db)
+
 	// 启动过期容器自动清理任务
 	admin.StartCleanupScheduler(db)
 	log.Println("已启动过期容器自动清理任务")
@@ -884,11 +1135,3 @@ func main() {
 		log.Fatalf("server exited: %v", err)
 	}
 }
-
-// serveAttachments 处理附件下载
-func serveAttachments(c *gin.Context) {
-	path := c.Request.URL.Path
-	filename := path[13:] // 去掉 /attachments/ 前缀
-	filePath := "./attachments/" + filename
-	c.File(filePath)
-}
@@ -324,7 +324,7 @@ func HandleGetRecentSolves(c *gin.Context, db *sql.DB) {
 				FROM team_solves ts
 				JOIN teams t ON ts.team_id = t.id
 				JOIN contest_challenges cc ON ts.challenge_id = cc.id
-				LEFT JOIN question_bank q ON cc.question_id = q.id
+				LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
 				WHERE ts.contest_id = $1
 			)
 			SELECT team_id, team_name, contest_challenge_id, challenge_name, solve_order, solved_at, blood_rank
@@ -659,7 +659,7 @@ func HandleGetMonitorData(c *gin.Context, db *sql.DB, jwtSecret []byte) {
 				FROM team_solves ts
 				JOIN teams t ON ts.team_id = t.id
 				JOIN contest_challenges cc ON ts.challenge_id = cc.id
-				LEFT JOIN question_bank q ON cc.question_id = q.id
+				LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
 				LEFT JOIN challenge_first_views cfv ON cfv.contest_id = ts.contest_id 
 					AND cfv.challenge_id = ts.challenge_id AND cfv.team_id = ts.team_id
 				WHERE ts.contest_id = $1
@@ -1024,7 +1024,7 @@ func GetMonitorDataForBroadcast(db *sql.DB, contestID string) map[string]interfa
 				FROM team_solves ts
 				JOIN teams t ON ts.team_id = t.id
 				JOIN contest_challenges cc ON ts.challenge_id = cc.id
-				LEFT JOIN question_bank q ON cc.question_id = q.id
+				LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
 				LEFT JOIN challenge_first_views cfv ON cfv.contest_id = ts.contest_id 
 					AND cfv.challenge_id = ts.challenge_id AND cfv.team_id = ts.team_id
 				WHERE ts.contest_id = $1
@@ -5,72 +5,375 @@
 package question
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"tgctf/server/storage"
 )
 
-// HandleUploadAttachment 上传附件
-func HandleUploadAttachment(c *gin.Context, db *sql.DB) {
-	file, header, err := c.Request.FormFile("file")
+// AttachmentURLPrefix 平台附件访问地址前缀，后接存储对象键
+const AttachmentURLPrefix = "/attachments/"
+
+// errAttachmentTooLarge 附件超出大小上限
+var errAttachmentTooLarge = errors.New("attachment too large")
+
+// Attachment 附件元数据
+type Attachment struct {
+	ID           int64   `json:"id"`
+	Key          string  `json:"filename"`
+	URL          string  `json:"url"`
+	OriginalName string  `json:"originalName"`
+	Size         int64   `json:"size"`
+	SHA256       string  `json:"sha256"`
+	ContentType  string  `json:"contentType"`
+	Backend      string  `json:"backend"`
+	UploadedBy   *string `json:"uploadedBy,omitempty"`
+	CreatedAt    string  `json:"createdAt"`
+	RefCount     int     `json:"refCount"`
+}
+
+// attachmentRefsExpr 统计附件地址 urlExpr 被引用次数的 SQL 表达式：
+// 题库题目、被比赛固定使用的版本快照、临时题目、队伍专属附件、旧题目表
+func attachmentRefsExpr(urlExpr string) string {
+	return `((SELECT COUNT(*) FROM question_bank WHERE attachment_url = ` + urlExpr + `)
+		+ (SELECT COUNT(*) FROM question_revisions r WHERE r.attachment_url = ` + urlExpr + `
+			AND EXISTS (SELECT 1 FROM contest_challenges cc WHERE cc.question_id = r.question_id AND cc.question_version = r.version))
+		+ (SELECT COUNT(*) FROM contest_challenges WHERE inline_attachment_url = ` + urlExpr + `)
+		+ (SELECT COUNT(*) FROM challenge_attachment_variants WHERE attachment_url = ` + urlExpr + `)
+		+ (SELECT COUNT(*) FROM challenges WHERE attachment_url = ` + urlExpr + `))`
+}
+
+// attachmentRefCount 附件当前引用数
+func attachmentRefCount(db *sql.DB, key string) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT `+attachmentRefsExpr("$1"), AttachmentURLPrefix+key).Scan(&n)
+	return n, err
+}
+
+// attachmentKey 从附件地址解析存储对象键，非平台附件返回空
+func attachmentKey(url string) string {
+	if !strings.HasPrefix(url, AttachmentURLPrefix) {
+		return ""
+	}
+	key := strings.TrimPrefix(url, AttachmentURLPrefix)
+	if !storage.ValidKey(key) {
+		return ""
+	}
+	return key
+}
+
+// attachmentExt 规范化附件扩展名（仅保留字母数字，过长时丢弃）
+func attachmentExt(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if len(ext) < 2 || len(ext) > 16 {
+		return ""
+	}
+	for _, r := range ext[1:] {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return ""
+		}
+	}
+	return ext
+}
+
+// storeAttachment 保存附件到存储后端并记录元数据：计算 SHA-256、按内容检测 MIME 类型，
+// 相同内容的附件复用已有对象
+func storeAttachment(db *sql.DB, r io.Reader, originalName string, userID int64) (*Attachment, error) {
+	// 先写入临时文件，得到大小与校验和后再上传到存储后端
+	tmp, err := os.CreateTemp("", "tgctf-attachment-*")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传文件"})
-		return
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	src := r
+	if storage.MaxUploadSize > 0 {
+		src = io.LimitReader(r, storage.MaxUploadSize+1)
+	}
+	size, err := io.Copy(io.MultiWriter(tmp, hash), src)
+	if err != nil {
+		return nil, err
+	}
+	if storage.MaxUploadSize > 0 && size > storage.MaxUploadSize {
+		return nil, errAttachmentTooLarge
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	head := make([]byte, 512)
+	n, _ := tmp.ReadAt(head, 0)
+	contentType := http.DetectContentType(head[:n])
+
+	backend := storage.Default()
+	if a, err := findAttachmentBySum(db, sum, size, backend.Name()); err == nil {
+		return a, nil
 	}
-	defer file.Close()
 
-	// 生成随机文件名
 	randBytes := make([]byte, 16)
 	rand.Read(randBytes)
-	ext := filepath.Ext(header.Filename)
-	newFilename := hex.EncodeToString(randBytes) + ext
+	key := hex.EncodeToString(randBytes) + attachmentExt(originalName)
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	if err := backend.Put(ctx, key, tmp, size, contentType); err != nil {
+		return nil, err
+	}
 
-	// 确保目录存在
-	uploadDir := "./attachments"
-	os.MkdirAll(uploadDir, 0755)
+	a := &Attachment{Key: key, URL: AttachmentURLPrefix + key, OriginalName: path.Base(originalName),
+		Size: size, SHA256: sum, ContentType: contentType, Backend: backend.Name()}
+	err = db.QueryRow(`
+		INSERT INTO attachments (storage_key, original_name, size, sha256, content_type, backend, uploaded_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		key, a.OriginalName, size, sum, contentType, a.Backend, nullIfZero64(userID)).Scan(&a.ID)
+	if err != nil {
+		backend.Delete(ctx, key)
+		return nil, err
+	}
+	return a, nil
+}
 
-	// 保存文件
-	dst, err := os.Create(filepath.Join(uploadDir, newFilename))
+// findAttachmentBySum 按校验和查找当前存储后端中内容相同的附件
+func findAttachmentBySum(db *sql.DB, sum string, size int64, backend string) (*Attachment, error) {
+	a := &Attachment{SHA256: sum, Size: size, Backend: backend}
+	var originalName, contentType sql.NullString
+	err := db.QueryRow(`
+		SELECT id, storage_key, original_name, content_type FROM attachments
+		WHERE sha256 = $1 AND size = $2 AND backend = $3 ORDER BY id LIMIT 1`,
+		sum, size, backend).Scan(&a.ID, &a.Key, &originalName, &contentType)
+	if err != nil {
+		return nil, err
+	}
+	a.URL = AttachmentURLPrefix + a.Key
+	a.OriginalName, a.ContentType = originalName.String, contentType.String
+	return a, nil
+}
+
+// readAttachment 读取平台附件内容（题目包导出使用）
+func readAttachment(key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	rc, err := storage.Default().Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// removeAttachmentIfUnused 附件不再被引用时删除（导入失败回滚使用）
+func removeAttachmentIfUnused(db *sql.DB, url string) {
+	key := attachmentKey(url)
+	if key == "" {
+		return
+	}
+	if n, err := attachmentRefCount(db, key); err != nil || n > 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if storage.Default().Delete(ctx, key) == nil {
+		db.Exec(`DELETE FROM attachments WHERE storage_key = $1`, key)
+	}
+}
+
+// HandleUploadAttachment 上传附件
+func HandleUploadAttachment(c *gin.Context, db *sql.DB) {
+	if storage.MaxUploadSize > 0 {
+		// 预留 multipart 头部开销
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, storage.MaxUploadSize+1<<20)
+	}
+	file, header, err := c.Request.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法保存文件"})
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "FILE_TOO_LARGE", "message": attachmentLimitMessage()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传文件"})
+		return
+	}
+	defer file.Close()
+	if storage.MaxUploadSize > 0 && header.Size > storage.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "FILE_TOO_LARGE", "message": attachmentLimitMessage()})
 		return
 	}
-	defer dst.Close()
 
-	if _, err := io.Copy(dst, file); err != nil {
+	a, err := storeAttachment(db, file, header.Filename, c.GetInt64("userID"))
+	if errors.Is(err, errAttachmentTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "FILE_TOO_LARGE", "message": attachmentLimitMessage()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"filename": newFilename,
-		"url":      "/attachments/" + newFilename,
+		"filename":    a.Key,
+		"url":         a.URL,
+		"size":        a.Size,
+		"sha256":      a.SHA256,
+		"contentType": a.ContentType,
 	})
 }
 
-// HandleDeleteAttachment 删除附件
+// attachmentLimitMessage 附件大小上限提示
+func attachmentLimitMessage() string {
+	return fmt.Sprintf("附件大小不能超过 %d MB", storage.MaxUploadSize>>20)
+}
+
+// HandleListAttachments 附件列表（含引用数）: GET /attachments
+func HandleListAttachments(c *gin.Context, db *sql.DB) {
+	rows, err := db.Query(`
+		SELECT a.id, a.storage_key, COALESCE(a.original_name, ''), a.size, a.sha256, COALESCE(a.content_type, ''),
+		       a.backend, u.username, a.created_at, ` + attachmentRefsExpr("'"+AttachmentURLPrefix+"' || a.storage_key") + `
+		FROM attachments a
+		LEFT JOIN users u ON a.uploaded_by = u.id
+		ORDER BY a.id DESC`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	defer rows.Close()
+
+	list := []Attachment{}
+	for rows.Next() {
+		var a Attachment
+		var uploadedBy sql.NullString
+		var createdAt time.Time
+		if err := rows.Scan(&a.ID, &a.Key, &a.OriginalName, &a.Size, &a.SHA256, &a.ContentType,
+			&a.Backend, &uploadedBy, &createdAt, &a.RefCount); err != nil {
+			continue
+		}
+		a.URL = AttachmentURLPrefix + a.Key
+		a.UploadedBy = nullStringToPtr(uploadedBy)
+		a.CreatedAt = createdAt.Format(time.RFC3339)
+		list = append(list, a)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// HandleDeleteAttachment 删除附件，仍被题目引用时拒绝
 func HandleDeleteAttachment(c *gin.Context, db *sql.DB) {
-	filename := c.Param("filename")
-	if filename == "" {
+	key := c.Param("filename")
+	if !storage.ValidKey(key) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "文件名不能为空"})
 		return
 	}
 
-	filePath := filepath.Join("./attachments", filename)
-	if err := os.Remove(filePath); err != nil {
-		if os.IsNotExist(err) {
+	refs, err := attachmentRefCount(db, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	if refs > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "ATTACHMENT_IN_USE", "message": fmt.Sprintf("附件仍被 %d 处题目引用，不能删除", refs), "refCount": refs})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Minute)
+	defer cancel()
+	backend := storage.Default()
+	result, err := db.Exec(`DELETE FROM attachments WHERE storage_key = $1`, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// 没有元数据记录的旧附件，确认对象存在
+		rc, err := backend.Get(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+			return
+		}
+		if err == nil {
+			rc.Close()
 		}
+	}
+	if err := backend.Delete(ctx, key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
 }
+
+// HandleServeAttachment 附件下载: /attachments/<key>?t=&c=&e=&s=
+// 只接受有效的签名链接；以附件形式下载并禁止 MIME 嗅探，响应头 X-Checksum-Sha256 为内容校验和
+func HandleServeAttachment(c *gin.Context, db *sql.DB) {
+	key := strings.TrimPrefix(c.Request.URL.Path, AttachmentURLPrefix)
+	if !storage.ValidKey(key) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	teamID, challengeID, ok := verifyAttachmentURL(c, key)
+	if !ok {
+		c.String(http.StatusForbidden, "下载链接无效或已过期，请在题目页面重新下载")
+		return
+	}
+
+	var originalName, sum, contentType sql.NullString
+	var size sql.NullInt64
+	var modified time.Time
+	db.QueryRow(`SELECT original_name, sha256, content_type, size, created_at FROM attachments WHERE storage_key = $1`,
+		key).Scan(&originalName, &sum, &contentType, &size, &modified)
+
+	rc, err := storage.Default().Get(c.Request.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.Status(http.StatusBadGateway)
+		return
+	}
+	defer rc.Close()
+
+	ct := contentType.String
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	name := originalName.String
+	if name == "" {
+		name = key
+	}
+	recordAttachmentDownload(c, db, teamID, challengeID)
+
+	c.Header("Content-Type", ct)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	if sum.Valid {
+		c.Header("X-Checksum-Sha256", sum.String)
+	}
+
+	// 本地文件支持断点续传
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, "", modified, rs)
+		return
+	}
+	if size.Valid {
+		c.Header("Content-Length", strconv.FormatInt(size.Int64, 10))
+	}
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, rc)
+}
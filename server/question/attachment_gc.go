@@ -0,0 +1,155 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package question
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"tgctf/server/storage"
+)
+
+// AttachmentGCReport 附件垃圾回收结果
+type AttachmentGCReport struct {
+	Removed    []string // 删除（或试运行时将删除）的对象键
+	FreedBytes int64    // 释放的字节数
+	Registered int      // 补录元数据的旧附件数
+	Missing    []string // 有元数据但存储中不存在的对象
+}
+
+// CollectAttachmentGarbage 清理孤儿附件：
+// 没有任何题目引用、且上传时间早于 grace 的附件（包括存储中没有元数据记录的对象）会被删除；
+// 仍被引用但缺少元数据的旧附件补录大小与校验和。dryRun 为 true 时只统计不修改
+func CollectAttachmentGarbage(db *sql.DB, dryRun bool, grace time.Duration) (*AttachmentGCReport, error) {
+	ctx := context.Background()
+	backend := storage.Default()
+	objects, err := backend.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("列出存储对象失败: %v", err)
+	}
+
+	type meta struct {
+		created time.Time
+		refs    int
+	}
+	known := make(map[string]meta)
+	rows, err := db.Query(`SELECT storage_key, created_at, `+attachmentRefsExpr("'"+AttachmentURLPrefix+"' || storage_key")+`
+		FROM attachments WHERE backend = $1`, backend.Name())
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var key string
+		var m meta
+		if rows.Scan(&key, &m.created, &m.refs) == nil {
+			known[key] = m
+		}
+	}
+	rows.Close()
+
+	report := &AttachmentGCReport{}
+	cutoff := time.Now().Add(-grace)
+	seen := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		seen[obj.Key] = true
+		m, ok := known[obj.Key]
+		if !ok {
+			refs, err := attachmentRefCount(db, obj.Key)
+			if err != nil {
+				return nil, err
+			}
+			m = meta{created: obj.Modified, refs: refs}
+			if refs > 0 {
+				if !dryRun {
+					if err := registerAttachment(ctx, db, backend, obj); err != nil {
+						log.Printf("[AttachmentGC] 补录附件 %s 元数据失败: %v", obj.Key, err)
+						continue
+					}
+				}
+				report.Registered++
+				continue
+			}
+		}
+		if m.refs > 0 || m.created.After(cutoff) {
+			continue
+		}
+
+		if !dryRun {
+			if err := backend.Delete(ctx, obj.Key); err != nil {
+				log.Printf("[AttachmentGC] 删除附件 %s 失败: %v", obj.Key, err)
+				continue
+			}
+			db.Exec(`DELETE FROM attachments WHERE storage_key = $1`, obj.Key)
+		}
+		report.Removed = append(report.Removed, obj.Key)
+		report.FreedBytes += obj.Size
+	}
+
+	for key := range known {
+		if !seen[key] {
+			report.Missing = append(report.Missing, key)
+		}
+	}
+	return report, nil
+}
+
+// registerAttachment 为存储中已有但缺少元数据的附件补录大小、校验和与 MIME 类型
+func registerAttachment(ctx context.Context, db *sql.DB, backend storage.Storage, obj storage.Object) error {
+	rc, err := backend.Get(ctx, obj.Key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	hash := sha256.New()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(rc, head)
+	hash.Write(head[:n])
+	size, err := io.Copy(hash, rc)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO attachments (storage_key, original_name, size, sha256, content_type, backend, created_at)
+		VALUES ($1, $1, $2, $3, $4, $5, $6) ON CONFLICT (storage_key) DO NOTHING`,
+		obj.Key, size+int64(n), hex.EncodeToString(hash.Sum(nil)), http.DetectContentType(head[:n]), backend.Name(), obj.Modified)
+	return err
+}
+
+// RunAttachmentGC 命令行子命令: tgctf gc-attachments [-dry-run] [-grace 24h]
+func RunAttachmentGC(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("gc-attachments", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "只列出将被删除的附件，不实际删除")
+	grace := fs.Duration("grace", 24*time.Hour, "只清理上传时间早于该时长的附件（避免删除刚上传尚未保存到题目的文件）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := CollectAttachmentGarbage(db, *dryRun, *grace)
+	if err != nil {
+		return err
+	}
+	action := "已删除"
+	if *dryRun {
+		action = "将删除"
+	}
+	for _, key := range report.Removed {
+		fmt.Printf("%s %s\n", action, key)
+	}
+	for _, key := range report.Missing {
+		fmt.Printf("存储中缺失 %s\n", key)
+	}
+	fmt.Printf("%s %d 个孤儿附件，释放 %.1f MB；补录元数据 %d 个；缺失 %d 个\n",
+		action, len(report.Removed), float64(report.FreedBytes)/(1<<20), report.Registered, len(report.Missing))
+	return nil
+}
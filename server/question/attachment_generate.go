@@ -0,0 +1,365 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package question
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"tgctf/server/container"
+)
+
+// 动态附件：题目类型为 dynamic_attachment 且配置了生成方式时，队伍首次下载附件时生成包含本队 Flag 的专属附件，
+// 保存到附件存储并记录校验和（generated_attachments），泄露的文件可按校验和追溯到队伍。
+//   template: 将题目附件中的 {{FLAG}} 替换为队伍 Flag（zip 附件替换压缩包内各文件的内容）
+//   script:   在沙箱中运行生成脚本（环境变量 FLAG / TEAM_ID / CHALLENGE_ID），标准输出即附件内容
+// 生成结果保存为队伍专属附件（generated = true），题目版本升级后重新生成；管理员手动上传的队伍附件不会被覆盖
+
+const (
+	AttachmentGeneratorTemplate = "template"
+	AttachmentGeneratorScript   = "script"
+
+	// attachmentFlagPlaceholder 模板附件中的 Flag 占位符
+	attachmentFlagPlaceholder = "{{FLAG}}"
+	// attachmentScriptTimeout 生成脚本执行时间上限
+	attachmentScriptTimeout = 60 * time.Second
+)
+
+// GetTeamFlag 获取或创建队伍的动态 Flag（由 main.go 注入 docker.GetOrCreateTeamFlag）
+var GetTeamFlag func(db *sql.DB, teamID int64, contestID, challengeID string) string
+
+// errNoPlaceholder 模板附件中没有 Flag 占位符
+var errNoPlaceholder = errors.New("附件中未找到 " + attachmentFlagPlaceholder + " 占位符")
+
+// generateLocks 同一队伍同一题目的附件生成串行执行，避免并发下载重复生成
+var generateLocks sync.Map
+
+// validAttachmentGenerator 校验附件生成方式，script 方式必须提供生成脚本
+func validAttachmentGenerator(generator, script string) bool {
+	switch generator {
+	case "", AttachmentGeneratorTemplate:
+		return true
+	case AttachmentGeneratorScript:
+		return strings.TrimSpace(script) != ""
+	}
+	return false
+}
+
+// attachmentGeneratorSource 生成动态附件所需的题目信息（比赛固定的题目版本）
+type attachmentGeneratorSource struct {
+	Type      string
+	Generator string
+	Script    string
+	FlagType  string
+	Flag      string
+	BaseURL   string
+	BaseType  string
+	Version   int
+}
+
+// ensureGeneratedAttachment 动态附件题目为队伍生成专属附件（已生成且题目版本未变化时跳过）
+func ensureGeneratedAttachment(db *sql.DB, contestID string, challengeID, teamID int64) error {
+	lockKey := fmt.Sprintf("%d:%d", challengeID, teamID)
+	mu, _ := generateLocks.LoadOrStore(lockKey, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	var src attachmentGeneratorSource
+	var variantGenerated sql.NullBool
+	var variantVersion sql.NullInt64
+	var oldURL sql.NullString
+	err := db.QueryRow(`
+		SELECT q.type, COALESCE(q.attachment_generator, ''), COALESCE(q.attachment_script, ''),
+		       COALESCE(q.flag_type, 'static'), COALESCE(q.flag, ''),
+		       COALESCE(q.attachment_url, ''), COALESCE(q.attachment_type, 'url'), q.version,
+		       v.generated, v.question_version, v.attachment_url
+		FROM contest_challenges cc
+		JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
+		LEFT JOIN challenge_attachment_variants v ON v.challenge_id = cc.id AND v.team_id = $3
+		WHERE cc.id = $1 AND cc.contest_id = $2`, challengeID, contestID, teamID).Scan(
+		&src.Type, &src.Generator, &src.Script, &src.FlagType, &src.Flag,
+		&src.BaseURL, &src.BaseType, &src.Version,
+		&variantGenerated, &variantVersion, &oldURL)
+	if err == sql.ErrNoRows {
+		return nil // 临时题目不支持动态附件
+	}
+	if err != nil {
+		return err
+	}
+	if src.Type != "dynamic_attachment" || src.Generator == "" {
+		return nil
+	}
+	if variantGenerated.Valid && (!variantGenerated.Bool || variantVersion.Int64 == int64(src.Version)) {
+		return nil
+	}
+
+	flag := src.Flag
+	if src.FlagType == "dynamic" || flag == "" {
+		if GetTeamFlag == nil {
+			return errors.New("team flag provider not configured")
+		}
+		flag = GetTeamFlag(db, teamID, contestID, strconv.FormatInt(challengeID, 10))
+	}
+
+	baseKey := attachmentKey(src.BaseURL)
+	name := "attachment"
+	if baseKey != "" {
+		var originalName sql.NullString
+		db.QueryRow(`SELECT original_name FROM attachments WHERE storage_key = $1`, baseKey).Scan(&originalName)
+		if originalName.String != "" {
+			name = originalName.String
+		}
+	}
+
+	var content []byte
+	switch src.Generator {
+	case AttachmentGeneratorTemplate:
+		if baseKey == "" {
+			return errors.New("模板生成需要上传到平台的题目附件")
+		}
+		base, err := readAttachment(baseKey)
+		if err != nil {
+			return fmt.Errorf("读取题目附件失败: %v", err)
+		}
+		content, err = renderAttachmentTemplate(base, name, flag)
+		if err != nil {
+			return err
+		}
+	case AttachmentGeneratorScript:
+		content, err = runAttachmentScript(db, src.Script, flag, teamID, challengeID)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("未知的附件生成方式: %s", src.Generator)
+	}
+
+	a, err := storeAttachment(db, bytes.NewReader(content), name, 0)
+	if err != nil {
+		return err
+	}
+	// 生成期间管理员手动上传了队伍附件时不覆盖
+	_, err = db.Exec(`
+		INSERT INTO challenge_attachment_variants (challenge_id, team_id, attachment_url, generated, question_version)
+		VALUES ($1, $2, $3, TRUE, $4)
+		ON CONFLICT (challenge_id, team_id) DO UPDATE
+		SET attachment_url = EXCLUDED.attachment_url, question_version = EXCLUDED.question_version, created_at = CURRENT_TIMESTAMP
+		WHERE challenge_attachment_variants.generated`,
+		challengeID, teamID, a.URL, src.Version)
+	if err != nil {
+		removeAttachmentIfUnused(db, a.URL)
+		return err
+	}
+	db.Exec(`INSERT INTO generated_attachments (challenge_id, team_id, attachment_url, sha256, question_version)
+		VALUES ($1, $2, $3, $4, $5)`, challengeID, teamID, a.URL, a.SHA256, src.Version)
+	if oldURL.Valid && oldURL.String != a.URL {
+		removeAttachmentIfUnused(db, oldURL.String)
+	}
+	log.Printf("[Attachment] 为队伍 %d 生成题目 %d 的动态附件 %s (sha256 %s)", teamID, challengeID, a.Key, a.SHA256)
+	return nil
+}
+
+// renderAttachmentTemplate 替换模板附件中的 Flag 占位符；zip 附件逐个替换压缩包内文件的内容，其余文件原样复制
+func renderAttachmentTemplate(base []byte, name, flag string) ([]byte, error) {
+	placeholder := []byte(attachmentFlagPlaceholder)
+	if bytes.HasPrefix(base, []byte("PK\x03\x04")) || strings.EqualFold(attachmentExt(name), ".zip") {
+		zr, err := zip.NewReader(bytes.NewReader(base), int64(len(base)))
+		if err == nil {
+			return renderZipTemplate(zr, placeholder, []byte(flag))
+		}
+	}
+	if !bytes.Contains(base, placeholder) {
+		return nil, errNoPlaceholder
+	}
+	return bytes.ReplaceAll(base, placeholder, []byte(flag)), nil
+}
+
+// renderZipTemplate 重新打包 zip：包含占位符的文件替换后重新压缩，其余文件直接复制压缩数据
+func renderZipTemplate(zr *zip.Reader, placeholder, flag []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	zw.SetComment(zr.Comment)
+	replaced := false
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			if err := zw.Copy(f); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("读取压缩包文件 %s 失败: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("读取压缩包文件 %s 失败: %v", f.Name, err)
+		}
+		if !bytes.Contains(data, placeholder) {
+			if err := zw.Copy(f); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		header := f.FileHeader
+		header.CRC32, header.CompressedSize64, header.UncompressedSize64 = 0, 0, 0
+		w, err := zw.CreateHeader(&header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(bytes.ReplaceAll(data, placeholder, flag)); err != nil {
+			return nil, err
+		}
+		replaced = true
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if !replaced {
+		return nil, errNoPlaceholder
+	}
+	return buf.Bytes(), nil
+}
+
+// runAttachmentScript 在沙箱中运行附件生成脚本，标准输出即附件内容
+func runAttachmentScript(db *sql.DB, script, flag string, teamID, challengeID int64) ([]byte, error) {
+	rt, _, err := container.Place(db, "team")
+	if err != nil {
+		return nil, fmt.Errorf("暂无可用的容器节点: %v", err)
+	}
+	sandbox, ok := rt.(container.SandboxRunner)
+	if !ok {
+		return nil, errors.New("当前容器后端不支持沙箱执行附件生成脚本")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), attachmentScriptTimeout+30*time.Second)
+	defer cancel()
+	run, err := sandbox.RunSandbox(ctx, &container.SandboxSpec{
+		Script: script,
+		Env: []string{
+			"FLAG=" + flag,
+			"TEAM_ID=" + strconv.FormatInt(teamID, 10),
+			"CHALLENGE_ID=" + strconv.FormatInt(challengeID, 10),
+		},
+		Timeout: attachmentScriptTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("沙箱执行失败: %v", err)
+	}
+	stderr := strings.TrimSpace(string(run.Stderr))
+	if len(stderr) > 500 {
+		stderr = stderr[:500]
+	}
+	switch {
+	case run.TimedOut:
+		return nil, fmt.Errorf("附件生成脚本执行超时（%s）", attachmentScriptTimeout)
+	case run.ExitCode != 0:
+		return nil, fmt.Errorf("附件生成脚本退出码 %d: %s", run.ExitCode, stderr)
+	case len(run.Stdout) == 0:
+		return nil, errors.New("附件生成脚本没有输出")
+	case len(run.Stdout) >= container.SandboxOutputLimit:
+		return nil, fmt.Errorf("附件生成脚本输出超过 %d MB 上限", container.SandboxOutputLimit>>20)
+	}
+	return run.Stdout, nil
+}
+
+// AttachmentTrace 附件溯源结果
+type AttachmentTrace struct {
+	ContestID       int64  `json:"contestId"`
+	ContestName     string `json:"contestName"`
+	ChallengeID     int64  `json:"challengeId"`
+	ChallengeTitle  string `json:"challengeTitle"`
+	TeamID          int64  `json:"teamId"`
+	TeamName        string `json:"teamName"`
+	Generated       bool   `json:"generated"` // false 表示管理员手动上传的队伍附件
+	QuestionVersion *int   `json:"questionVersion"`
+	CreatedAt       string `json:"createdAt"`
+}
+
+// HandleTraceAttachment 根据泄露文件（multipart file）或校验和（JSON {"sha256"}）追溯下载该附件的队伍: POST /attachments/trace
+func HandleTraceAttachment(c *gin.Context, db *sql.DB) {
+	var sum string
+	if file, _, err := c.Request.FormFile("file"); err == nil {
+		defer file.Close()
+		hash := sha256.New()
+		if _, err := io.Copy(hash, file); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_FILE"})
+			return
+		}
+		sum = hex.EncodeToString(hash.Sum(nil))
+	} else {
+		var req struct {
+			SHA256 string `json:"sha256"`
+		}
+		if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST", "message": "请上传文件或填写 SHA-256"})
+			return
+		}
+		sum = strings.ToLower(strings.TrimSpace(req.SHA256))
+	}
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_SHA256", "message": "SHA-256 格式错误"})
+		return
+	}
+
+	// 生成记录保留历史版本；手动上传的队伍附件按当前附件元数据匹配
+	rows, err := db.Query(`
+		SELECT ct.id, ct.name, cc.id, COALESCE(q.title, cc.inline_title, ''), t.id, t.name, m.generated, m.question_version, m.created_at
+		FROM (
+			SELECT g.challenge_id, g.team_id, TRUE AS generated, g.question_version, g.created_at
+			FROM generated_attachments g WHERE g.sha256 = $1
+			UNION ALL
+			SELECT v.challenge_id, v.team_id, FALSE, NULL, v.created_at
+			FROM challenge_attachment_variants v
+			JOIN attachments att ON '/attachments/' || att.storage_key = v.attachment_url
+			WHERE NOT v.generated AND att.sha256 = $1
+		) m
+		JOIN contest_challenges cc ON cc.id = m.challenge_id
+		JOIN contests ct ON ct.id = cc.contest_id
+		JOIN teams t ON t.id = m.team_id
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
+		ORDER BY m.created_at DESC`, sum)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	defer rows.Close()
+
+	matches := []AttachmentTrace{}
+	for rows.Next() {
+		var m AttachmentTrace
+		var version sql.NullInt64
+		var createdAt time.Time
+		if err := rows.Scan(&m.ContestID, &m.ContestName, &m.ChallengeID, &m.ChallengeTitle,
+			&m.TeamID, &m.TeamName, &m.Generated, &version, &createdAt); err != nil {
+			continue
+		}
+		if version.Valid {
+			v := int(version.Int64)
+			m.QuestionVersion = &v
+		}
+		m.CreatedAt = createdAt.Format(time.RFC3339)
+		matches = append(matches, m)
+	}
+	c.JSON(http.StatusOK, gin.H{"sha256": sum, "matches": matches})
+}
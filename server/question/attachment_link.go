@@ -0,0 +1,225 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package question
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 附件下载链接：选手通过授权接口（校验队伍审核状态与题目放题状态）获取短时有效的 HMAC 签名链接，
+// /attachments/<key> 只接受签名有效且未过期的请求；签名绑定队伍与题目，用于按队伍统计下载次数。
+// 密钥取 ATTACHMENT_SIGNING_KEY，未配置时使用 JWT_SECRET；有效期取 ATTACHMENT_LINK_TTL（默认 10m）
+
+// attachmentLinkTTL 签名链接有效期
+var attachmentLinkTTL = func() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("ATTACHMENT_LINK_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 10 * time.Minute
+}()
+
+// attachmentSigningKey 签名密钥
+func attachmentSigningKey() []byte {
+	secret := os.Getenv("ATTACHMENT_SIGNING_KEY")
+	if secret == "" {
+		secret = os.Getenv("JWT_SECRET")
+	}
+	return []byte("tgctf-attachment:" + secret)
+}
+
+// attachmentSignature 计算附件链接签名（对象键、队伍、题目、过期时间）
+func attachmentSignature(key string, teamID, challengeID, expires int64) string {
+	mac := hmac.New(sha256.New, attachmentSigningKey())
+	fmt.Fprintf(mac, "%s\n%d\n%d\n%d", key, teamID, challengeID, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signAttachmentURL 生成签名下载链接，teamID / challengeID 为 0 表示管理员预览（不计入下载统计）
+func signAttachmentURL(key string, teamID, challengeID int64) (string, time.Time) {
+	expires := time.Now().Add(attachmentLinkTTL)
+	q := url.Values{}
+	q.Set("t", strconv.FormatInt(teamID, 10))
+	q.Set("c", strconv.FormatInt(challengeID, 10))
+	q.Set("e", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("s", attachmentSignature(key, teamID, challengeID, expires.Unix()))
+	return AttachmentURLPrefix + key + "?" + q.Encode(), expires
+}
+
+// verifyAttachmentURL 校验下载请求的签名，返回链接绑定的队伍与题目
+func verifyAttachmentURL(c *gin.Context, key string) (teamID, challengeID int64, ok bool) {
+	teamID, err1 := strconv.ParseInt(c.Query("t"), 10, 64)
+	challengeID, err2 := strconv.ParseInt(c.Query("c"), 10, 64)
+	expires, err3 := strconv.ParseInt(c.Query("e"), 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || time.Now().Unix() > expires {
+		return 0, 0, false
+	}
+	expected := attachmentSignature(key, teamID, challengeID, expires)
+	if !hmac.Equal([]byte(expected), []byte(c.Query("s"))) {
+		return 0, 0, false
+	}
+	return teamID, challengeID, true
+}
+
+// recordAttachmentDownload 记录队伍下载次数（断点续传的后续分段不重复计数）
+func recordAttachmentDownload(c *gin.Context, db *sql.DB, teamID, challengeID int64) {
+	if teamID == 0 || challengeID == 0 {
+		return
+	}
+	if r := c.GetHeader("Range"); r != "" && !strings.HasPrefix(r, "bytes=0-") {
+		return
+	}
+	db.Exec(`
+		INSERT INTO attachment_downloads (challenge_id, team_id, download_count) VALUES ($1, $2, 1)
+		ON CONFLICT (challenge_id, team_id) DO UPDATE
+		SET download_count = attachment_downloads.download_count + 1, last_downloaded_at = CURRENT_TIMESTAMP`,
+		challengeID, teamID)
+}
+
+// teamAttachment 队伍在比赛题目中可下载的附件：队伍专属附件优先，其次为题目附件
+type teamAttachment struct {
+	URL    string
+	Type   string // url | local
+	Size   *int64
+	SHA256 *string
+}
+
+// resolveTeamAttachment 查询队伍在比赛题目中的附件，题目没有附件时返回 sql.ErrNoRows
+func resolveTeamAttachment(db *sql.DB, contestID string, challengeID, teamID int64) (*teamAttachment, error) {
+	var a teamAttachment
+	var size sql.NullInt64
+	var sum sql.NullString
+	err := db.QueryRow(`
+		SELECT COALESCE(v.attachment_url, q.attachment_url, cc.inline_attachment_url, ''),
+		       CASE WHEN v.id IS NOT NULL THEN 'local' ELSE COALESCE(q.attachment_type, cc.inline_attachment_type, 'url') END,
+		       att.size, att.sha256
+		FROM contest_challenges cc
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
+		LEFT JOIN challenge_attachment_variants v ON v.challenge_id = cc.id AND v.team_id = $3
+		LEFT JOIN attachments att ON '/attachments/' || att.storage_key = COALESCE(v.attachment_url, q.attachment_url, cc.inline_attachment_url)
+		WHERE cc.id = $1 AND cc.contest_id = $2`, challengeID, contestID, teamID).Scan(&a.URL, &a.Type, &size, &sum)
+	if err != nil {
+		return nil, err
+	}
+	if a.URL == "" {
+		return nil, sql.ErrNoRows
+	}
+	if size.Valid {
+		a.Size = &size.Int64
+	}
+	a.SHA256 = nullStringToPtr(sum)
+	return &a, nil
+}
+
+// errAttachmentForbidden 无权下载附件
+var errAttachmentForbidden = errors.New("forbidden")
+
+// attachmentTeam 校验用户是否可以下载比赛题目附件，返回所属队伍（管理员未加入队伍时为 0）
+// 选手需所在队伍已通过比赛审核、比赛已开始且题目已放题
+func attachmentTeam(db *sql.DB, userID int64, role, contestID string, challengeID int64) (int64, string, error) {
+	isAdmin := role == "super" || role == "admin"
+
+	var teamID sql.NullInt64
+	db.QueryRow(`SELECT team_id FROM users WHERE id = $1`, userID).Scan(&teamID)
+	if isAdmin {
+		return teamID.Int64, "", nil
+	}
+	if !teamID.Valid {
+		return 0, "您还未加入队伍", errAttachmentForbidden
+	}
+
+	var teamStatus, contestStatus, challengeStatus string
+	err := db.QueryRow(`
+		SELECT COALESCE(ct.status, ''), c.status, cc.status
+		FROM contest_challenges cc
+		JOIN contests c ON c.id = cc.contest_id
+		LEFT JOIN contest_teams ct ON ct.contest_id = cc.contest_id AND ct.team_id = $3
+		WHERE cc.id = $1 AND cc.contest_id = $2`, challengeID, contestID, teamID.Int64).Scan(&teamStatus, &contestStatus, &challengeStatus)
+	if err != nil {
+		return 0, "", err
+	}
+	switch {
+	case teamStatus != "approved":
+		return 0, "队伍未通过比赛审核", errAttachmentForbidden
+	case contestStatus == "pending":
+		return 0, "比赛尚未开始", errAttachmentForbidden
+	case challengeStatus != "public":
+		return 0, "", sql.ErrNoRows
+	}
+	return teamID.Int64, "", nil
+}
+
+// HandleGetAttachmentLink 获取题目附件的签名下载链接: GET /contests/:id/challenges/:challengeId/attachment
+func HandleGetAttachmentLink(c *gin.Context, db *sql.DB) {
+	contestID := c.Param("id")
+	challengeID, err := strconv.ParseInt(c.Param("challengeId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_ID"})
+		return
+	}
+
+	teamID, message, err := attachmentTeam(db, c.GetInt64("userID"), c.GetString("role"), contestID, challengeID)
+	if errors.Is(err, errAttachmentForbidden) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "FORBIDDEN", "message": message})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "CHALLENGE_NOT_FOUND", "message": "题目不存在"})
+		return
+	}
+
+	if teamID > 0 {
+		if err := ensureGeneratedAttachment(db, contestID, challengeID, teamID); err != nil {
+			log.Printf("[Attachment] 生成队伍 %d 题目 %d 的动态附件失败: %v", teamID, challengeID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "GENERATE_FAILED", "message": "附件生成失败，请联系管理员"})
+			return
+		}
+	}
+
+	a, err := resolveTeamAttachment(db, contestID, challengeID, teamID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "NO_ATTACHMENT", "message": "该题目没有附件"})
+		return
+	}
+	key := attachmentKey(a.URL)
+	if a.Type != "local" || key == "" {
+		// 外部链接直接返回
+		c.JSON(http.StatusOK, gin.H{"url": a.URL, "type": "url"})
+		return
+	}
+
+	link, expires := signAttachmentURL(key, teamID, challengeID)
+	c.JSON(http.StatusOK, gin.H{
+		"url":       link,
+		"type":      "local",
+		"expiresAt": expires.Format(time.RFC3339),
+		"size":      a.Size,
+		"sha256":    a.SHA256,
+	})
+}
+
+// HandleGetAdminAttachmentLink 管理员预览附件的签名链接: GET /attachments/:filename/link
+func HandleGetAdminAttachmentLink(c *gin.Context, db *sql.DB) {
+	key := c.Param("filename")
+	if attachmentKey(AttachmentURLPrefix+key) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_FILENAME"})
+		return
+	}
+	link, expires := signAttachmentURL(key, 0, 0)
+	c.JSON(http.StatusOK, gin.H{"url": link, "expiresAt": expires.Format(time.RFC3339)})
+}
@@ -0,0 +1,158 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package question
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"tgctf/server/storage"
+)
+
+// TeamAttachmentStat 队伍的附件与下载统计
+type TeamAttachmentStat struct {
+	TeamID           int64   `json:"teamId"`
+	TeamName         string  `json:"teamName"`
+	VariantURL       *string `json:"variantUrl"` // 队伍专属附件，为空时下载题目附件
+	Generated        bool    `json:"generated"`  // 专属附件由动态附件生成器生成
+	Size             *int64  `json:"size"`
+	SHA256           *string `json:"sha256"`
+	Downloads        int     `json:"downloads"`
+	LastDownloadedAt *string `json:"lastDownloadedAt"`
+}
+
+// HandleListChallengeAttachments 比赛题目的队伍附件与下载统计: GET /contest-challenges/:id/attachments
+func HandleListChallengeAttachments(c *gin.Context, db *sql.DB) {
+	challengeID := c.Param("id")
+
+	var contestID int64
+	var baseURL, baseType string
+	err := db.QueryRow(`
+		SELECT cc.contest_id, COALESCE(q.attachment_url, cc.inline_attachment_url, ''), COALESCE(q.attachment_type, cc.inline_attachment_type, 'url')
+		FROM contest_challenges cc
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
+		WHERE cc.id = $1`, challengeID).Scan(&contestID, &baseURL, &baseType)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "CHALLENGE_NOT_FOUND"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT t.id, t.name, v.attachment_url, COALESCE(v.generated, false), att.size, att.sha256,
+		       COALESCE(d.download_count, 0), d.last_downloaded_at
+		FROM contest_teams ct
+		JOIN teams t ON t.id = ct.team_id
+		LEFT JOIN challenge_attachment_variants v ON v.challenge_id = $1 AND v.team_id = t.id
+		LEFT JOIN attachments att ON '/attachments/' || att.storage_key = v.attachment_url
+		LEFT JOIN attachment_downloads d ON d.challenge_id = $1 AND d.team_id = t.id
+		WHERE ct.contest_id = $2 AND ct.status = 'approved'
+		ORDER BY t.id`, challengeID, contestID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	defer rows.Close()
+
+	teams := []TeamAttachmentStat{}
+	for rows.Next() {
+		var s TeamAttachmentStat
+		var variant, sum sql.NullString
+		var size sql.NullInt64
+		var last sql.NullTime
+		if err := rows.Scan(&s.TeamID, &s.TeamName, &variant, &s.Generated, &size, &sum, &s.Downloads, &last); err != nil {
+			continue
+		}
+		s.VariantURL = nullStringToPtr(variant)
+		s.SHA256 = nullStringToPtr(sum)
+		if size.Valid {
+			s.Size = &size.Int64
+		}
+		if last.Valid {
+			t := last.Time.Format(time.RFC3339)
+			s.LastDownloadedAt = &t
+		}
+		teams = append(teams, s)
+	}
+	c.JSON(http.StatusOK, gin.H{"attachmentUrl": baseURL, "attachmentType": baseType, "teams": teams})
+}
+
+// HandleUploadAttachmentVariant 上传队伍专属附件: PUT /contest-challenges/:id/attachments/:teamId
+func HandleUploadAttachmentVariant(c *gin.Context, db *sql.DB) {
+	challengeID, err1 := strconv.ParseInt(c.Param("id"), 10, 64)
+	teamID, err2 := strconv.ParseInt(c.Param("teamId"), 10, 64)
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_ID"})
+		return
+	}
+	var exists bool
+	db.QueryRow(`SELECT EXISTS(SELECT 1 FROM contest_challenges WHERE id = $1)`, challengeID).Scan(&exists)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "CHALLENGE_NOT_FOUND"})
+		return
+	}
+
+	if storage.MaxUploadSize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, storage.MaxUploadSize+1<<20)
+	}
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "FILE_TOO_LARGE", "message": attachmentLimitMessage()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请上传文件"})
+		return
+	}
+	defer file.Close()
+
+	a, err := storeAttachment(db, file, header.Filename, c.GetInt64("userID"))
+	if errors.Is(err, errAttachmentTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "FILE_TOO_LARGE", "message": attachmentLimitMessage()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败"})
+		return
+	}
+
+	var oldURL sql.NullString
+	db.QueryRow(`SELECT attachment_url FROM challenge_attachment_variants WHERE challenge_id = $1 AND team_id = $2`,
+		challengeID, teamID).Scan(&oldURL)
+	_, err = db.Exec(`
+		INSERT INTO challenge_attachment_variants (challenge_id, team_id, attachment_url, created_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (challenge_id, team_id) DO UPDATE
+		SET attachment_url = EXCLUDED.attachment_url, generated = FALSE, question_version = NULL,
+		    created_by = EXCLUDED.created_by, created_at = CURRENT_TIMESTAMP`,
+		challengeID, teamID, a.URL, nullIfZero64(c.GetInt64("userID")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	if oldURL.Valid && oldURL.String != a.URL {
+		removeAttachmentIfUnused(db, oldURL.String)
+	}
+	c.JSON(http.StatusOK, gin.H{"url": a.URL, "size": a.Size, "sha256": a.SHA256, "message": "队伍附件已上传"})
+}
+
+// HandleDeleteAttachmentVariant 删除队伍专属附件（恢复为题目附件）: DELETE /contest-challenges/:id/attachments/:teamId
+func HandleDeleteAttachmentVariant(c *gin.Context, db *sql.DB) {
+	var url string
+	err := db.QueryRow(`
+		DELETE FROM challenge_attachment_variants WHERE challenge_id = $1 AND team_id = $2
+		RETURNING attachment_url`, c.Param("id"), c.Param("teamId")).Scan(&url)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "VARIANT_NOT_FOUND"})
+		return
+	}
+	removeAttachmentIfUnused(db, url)
+	c.JSON(http.StatusOK, gin.H{"message": "已删除队伍附件"})
+}
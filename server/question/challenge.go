@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -46,6 +47,8 @@ type PublicChallenge struct {
 	DisplayOrder      int     `json:"displayOrder"`
 	AttachmentURL     *string `json:"attachmentUrl"`
 	AttachmentType    string  `json:"attachmentType"`
+	AttachmentSize    *int64  `json:"attachmentSize,omitempty"`   // 平台附件字节数
+	AttachmentSHA256  *string `json:"attachmentSha256,omitempty"` // 平台附件 SHA-256 校验和
 	CreatedAt         string  `json:"createdAt,omitempty"`
 	UpdatedAt         string  `json:"updatedAt,omitempty"`
 	// AWD-F 模式特有字段
@@ -98,7 +101,7 @@ func HandleListChallenges(c *gin.Context, db *sql.DB) {
 		       cc.initial_score, cc.min_score, cc.difficulty, COALESCE(q.flag, cc.inline_flag, ''), cc.status, COALESCE(q.attachment_url, cc.inline_attachment_url, ''),
 		       COALESCE(q.docker_image, cc.inline_docker_image, ''), COALESCE(q.ports, cc.inline_ports, ''), cc.created_at, cc.updated_at
 		FROM contest_challenges cc
-		LEFT JOIN question_bank q ON cc.question_id = q.id
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
 		LEFT JOIN categories cat ON q.category_id = cat.id
 		LEFT JOIN categories icat ON cc.inline_category_id = icat.id
 		WHERE cc.contest_id = $1
@@ -373,7 +376,9 @@ func HandlePublicChallenges(c *gin.Context, db *sql.DB) {
 		}
 	} else {
 		// Jeopardy/AWD 模式：查询 contest_challenges 和 question_bank
-		// 使用 LEFT JOIN 支持临时题目（question_id 为 NULL）
+		// 使用 LEFT JOIN 支持临时题目（question_id 为 NULL），队伍专属附件优先于题目附件
+		var teamID sql.NullInt64
+		db.QueryRow(`SELECT team_id FROM users WHERE id = $1`, c.GetInt64("userID")).Scan(&teamID)
 		rows, err := db.Query(`
 			SELECT cc.id, cc.contest_id, cc.question_id,
 			       COALESCE(q.title, cc.inline_title) as title,
@@ -381,16 +386,21 @@ func HandlePublicChallenges(c *gin.Context, db *sql.DB) {
 			       COALESCE(q.type, cc.inline_type) as type,
 			       COALESCE(q.description, cc.inline_description, '') as description,
 			       cc.initial_score, cc.min_score, cc.difficulty, cc.status, COALESCE(cc.display_order, 0),
-			       COALESCE(q.attachment_url, cc.inline_attachment_url, '') as attachment_url,
-			       COALESCE(q.attachment_type, cc.inline_attachment_type, 'url') as attachment_type,
+			       COALESCE(v.attachment_url, q.attachment_url, cc.inline_attachment_url, '') as attachment_url,
+			       CASE WHEN v.id IS NOT NULL THEN 'local' ELSE COALESCE(q.attachment_type, cc.inline_attachment_type, 'url') END as attachment_type,
+			       att.size, att.sha256,
 			       cc.created_at, cc.updated_at,
-			       (cc.question_id IS NULL) as is_inline
+			       (cc.question_id IS NULL) as is_inline,
+			       COALESCE(q.type = 'dynamic_attachment' AND q.attachment_generator IS NOT NULL
+			           AND (v.id IS NULL OR (v.generated AND v.question_version IS DISTINCT FROM cc.question_version)), false) as pending_generation
 			FROM contest_challenges cc
-			LEFT JOIN question_bank q ON cc.question_id = q.id
+			LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
+			LEFT JOIN challenge_attachment_variants v ON v.challenge_id = cc.id AND v.team_id = $2
+			LEFT JOIN attachments att ON '/attachments/' || att.storage_key = COALESCE(v.attachment_url, q.attachment_url, cc.inline_attachment_url)
 			LEFT JOIN categories cat ON q.category_id = cat.id
 			LEFT JOIN categories icat ON cc.inline_category_id = icat.id
 			WHERE cc.contest_id = $1 AND cc.status = 'public'
-			ORDER BY CASE WHEN cc.display_order = 0 THEN 999999 ELSE cc.display_order END, cc.id`, contestID)
+			ORDER BY CASE WHEN cc.display_order = 0 THEN 999999 ELSE cc.display_order END, cc.id`, contestID, teamID.Int64)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
 			return
@@ -401,11 +411,29 @@ func HandlePublicChallenges(c *gin.Context, db *sql.DB) {
 			var ch PublicChallenge
 			var initialScore, minScore, difficulty int
 			var createdAt, updatedAt sql.NullTime
-			var category sql.NullString
+			var category, attachmentSHA256 sql.NullString
+			var attachmentSize sql.NullInt64
+			var pendingGeneration bool
 			if err := rows.Scan(&ch.ID, &ch.ContestID, &ch.QuestionID, &ch.Name, &category, &ch.Type, &ch.Description,
-				&initialScore, &minScore, &difficulty, &ch.Status, &ch.DisplayOrder, &ch.AttachmentURL, &ch.AttachmentType, &createdAt, &updatedAt, &ch.IsInline); err != nil {
+				&initialScore, &minScore, &difficulty, &ch.Status, &ch.DisplayOrder, &ch.AttachmentURL, &ch.AttachmentType,
+				&attachmentSize, &attachmentSHA256, &createdAt, &updatedAt, &ch.IsInline, &pendingGeneration); err != nil {
 				continue
 			}
+			if attachmentSize.Valid {
+				ch.AttachmentSize = &attachmentSize.Int64
+			}
+			ch.AttachmentSHA256 = nullStringToPtr(attachmentSHA256)
+			// 动态附件尚未为队伍生成：首次下载时生成，此时还没有大小与校验和
+			if pendingGeneration {
+				ch.AttachmentSize, ch.AttachmentSHA256 = nil, nil
+				placeholder := AttachmentURLPrefix
+				ch.AttachmentURL, ch.AttachmentType = &placeholder, "local"
+			}
+			// 平台附件不直接暴露地址，通过授权接口获取签名下载链接
+			if ch.AttachmentURL != nil && ch.AttachmentType == "local" && strings.HasPrefix(*ch.AttachmentURL, AttachmentURLPrefix) {
+				link := fmt.Sprintf("/api/contests/%d/challenges/%d/attachment", ch.ContestID, ch.ID)
+				ch.AttachmentURL = &link
+			}
 			if category.Valid {
 				ch.Category = category.String
 			}
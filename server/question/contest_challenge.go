@@ -6,11 +6,13 @@ package question
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"tgctf/server/container"
 	"tgctf/server/monitor"
 )
 
@@ -23,6 +25,15 @@ type GenerateFlagsFunc func(db *sql.DB, contestID, challengeID string)
 // AnnounceChallenge 全局变量，用于注入题目状态变更公告函数
 var AnnounceChallenge AnnounceChallengeFunc
 
+// OnWarmPoolChange 预热池配置变更回调（由 main.go 注入，唤醒预热池补充）
+var OnWarmPoolChange func()
+
+// OnChallengeUpgrade 比赛题目升级版本后的回调（由 main.go 注入，清空旧版本的预热容器）
+var OnChallengeUpgrade func(db *sql.DB, challengeIDs []int64)
+
+// 单个题目预热池的最大容器数
+const maxWarmPoolSize = 20
+
 // GenerateTeamChallengeFlag 全局变量，用于注入Flag生成函数
 var GenerateTeamChallengeFlag GenerateFlagsFunc
 
@@ -31,6 +42,8 @@ type ContestChallenge struct {
 	ID                int64          `json:"id"`
 	ContestID         int64          `json:"contestId"`
 	QuestionID        *int64         `json:"questionId"`        // 指针类型，允许 NULL（临时题目）
+	QuestionVersion   *int64         `json:"questionVersion"`   // 比赛固定的题目版本
+	LatestVersion     *int64         `json:"latestVersion"`     // 题库中的最新版本
 	IsInline          bool           `json:"isInline"`          // 是否为临时题目
 	Title             string         `json:"title"`
 	Type              string         `json:"type"`
@@ -46,6 +59,7 @@ type ContestChallenge struct {
 	Ports             sql.NullString `json:"ports"`
 	CPULimit          sql.NullString `json:"cpuLimit"`
 	MemoryLimit       sql.NullString `json:"memoryLimit"`
+	NetworkPolicy     string         `json:"networkPolicy"`     // 出网策略: full | platform | none
 	FlagEnv           string         `json:"flagEnv"`
 	FlagScript        sql.NullString `json:"flagScript"`
 	InitialScore      int            `json:"initialScore"`
@@ -55,6 +69,9 @@ type ContestChallenge struct {
 	HintReleasedCount int            `json:"hintReleasedCount"` // 已发布提示数
 	Status            string         `json:"status"`
 	ReleaseTime       *string        `json:"releaseTime"`       // 定时放题时间
+	InstancePolicy    string         `json:"instancePolicy"`    // 容器实例策略: team | user | shared
+	WarmPoolSize      int            `json:"warmPoolSize"`      // 预热池容器数
+	WarmPoolReady     int            `json:"warmPoolReady"`     // 预热池当前可领取容器数
 	// 不定项选择题字段
 	IsChoice          bool           `json:"isChoice"`          // 是否为选择题
 	Choices           sql.NullString `json:"choices"`           // 选项内容 JSON
@@ -69,7 +86,7 @@ func HandleListContestChallenges(c *gin.Context, db *sql.DB) {
 	contestID := c.Param("id")
 
 	rows, err := db.Query(`
-		SELECT cc.id, cc.contest_id, cc.question_id,
+		SELECT cc.id, cc.contest_id, cc.question_id, cc.question_version, qb.version,
 			COALESCE(q.title, cc.inline_title) as title,
 			COALESCE(q.type, cc.inline_type) as type,
 			COALESCE(q.category_id, cc.inline_category_id) as category_id,
@@ -84,6 +101,7 @@ func HandleListContestChallenges(c *gin.Context, db *sql.DB) {
 			COALESCE(q.ports, cc.inline_ports) as ports,
 			COALESCE(q.cpu_limit, cc.inline_cpu_limit) as cpu_limit,
 			COALESCE(q.memory_limit, cc.inline_memory_limit) as memory_limit,
+			COALESCE(q.network_policy, cc.inline_network_policy, 'full') as network_policy,
 			COALESCE(q.flag_env, cc.inline_flag_env, 'FLAG') as flag_env,
 			COALESCE(q.flag_script, cc.inline_flag_script) as flag_script,
 			cc.initial_score, COALESCE(cc.min_score, 17), COALESCE(cc.display_order, 0),
@@ -94,9 +112,13 @@ func HandleListContestChallenges(c *gin.Context, db *sql.DB) {
 			COALESCE(cc.inline_is_choice, false) as is_choice,
 			cc.inline_choices,
 			cc.inline_choice_answer,
-			COALESCE(cc.inline_max_attempts, 3) as max_attempts
+			COALESCE(cc.inline_max_attempts, 3) as max_attempts,
+			COALESCE(cc.instance_policy, 'team') as instance_policy,
+			cc.warm_pool_size,
+			(SELECT COUNT(*) FROM warm_pool_containers w WHERE w.challenge_id = cc.id) as warm_pool_ready
 		FROM contest_challenges cc
-		LEFT JOIN question_bank q ON cc.question_id = q.id
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
+		LEFT JOIN question_bank qb ON cc.question_id = qb.id
 		LEFT JOIN categories cat ON q.category_id = cat.id
 		LEFT JOIN categories icat ON cc.inline_category_id = icat.id
 		WHERE cc.contest_id = $1
@@ -112,15 +134,16 @@ func HandleListContestChallenges(c *gin.Context, db *sql.DB) {
 		var cc ContestChallenge
 		var createdAt, updatedAt time.Time
 		var releaseTime sql.NullTime
-		if err := rows.Scan(&cc.ID, &cc.ContestID, &cc.QuestionID,
+		if err := rows.Scan(&cc.ID, &cc.ContestID, &cc.QuestionID, &cc.QuestionVersion, &cc.LatestVersion,
 			&cc.Title, &cc.Type, &cc.CategoryID, &cc.CategoryName,
 			&cc.Difficulty, &cc.Description, &cc.Flag, &cc.FlagType,
 			&cc.DockerImage, &cc.Attachment, &cc.AttachmentType, &cc.Ports,
-			&cc.CPULimit, &cc.MemoryLimit, &cc.FlagEnv, &cc.FlagScript,
+			&cc.CPULimit, &cc.MemoryLimit, &cc.NetworkPolicy, &cc.FlagEnv, &cc.FlagScript,
 			&cc.InitialScore, &cc.MinScore, &cc.DisplayOrder,
 			&cc.Status, &releaseTime, &createdAt, &updatedAt,
 			&cc.HintCount, &cc.HintReleasedCount, &cc.IsInline,
-			&cc.IsChoice, &cc.Choices, &cc.ChoiceAnswer, &cc.MaxAttempts); err != nil {
+			&cc.IsChoice, &cc.Choices, &cc.ChoiceAnswer, &cc.MaxAttempts, &cc.InstancePolicy,
+			&cc.WarmPoolSize, &cc.WarmPoolReady); err != nil {
 			continue
 		}
 		cc.CreatedAt = createdAt.Format(time.RFC3339)
@@ -169,12 +192,6 @@ func HandleAddContestChallenge(c *gin.Context, db *sql.DB) {
 	}
 
 	// 默认值
-	if req.InitialScore == 0 {
-		req.InitialScore = 500
-	}
-	if req.MinScore == 0 {
-		req.MinScore = 17
-	}
 	if req.Difficulty == 0 {
 		req.Difficulty = 5
 	}
@@ -188,12 +205,47 @@ func HandleAddContestChallenge(c *gin.Context, db *sql.DB) {
 			continue
 		}
 
-		_, err := db.Exec(`
-			INSERT INTO contest_challenges (contest_id, question_id, initial_score, min_score, difficulty, status)
-			VALUES ($1, $2, $3, $4, $5, 'hidden')`,
-			contestID, qid, req.InitialScore, req.MinScore, req.Difficulty)
-		if err == nil {
-			added++
+		// 未指定分数时优先使用题目包导入的默认计分
+		var defaultInitial, defaultMin sql.NullInt64
+		var defaultHints sql.NullString
+		db.QueryRow("SELECT default_initial_score, default_min_score, default_hints FROM question_bank WHERE id = $1", qid).Scan(&defaultInitial, &defaultMin, &defaultHints)
+		initialScore, minScore := req.InitialScore, req.MinScore
+		if initialScore == 0 {
+			initialScore = 500
+			if defaultInitial.Valid {
+				initialScore = int(defaultInitial.Int64)
+			}
+		}
+		if minScore == 0 {
+			minScore = 17
+			if defaultMin.Valid {
+				minScore = int(defaultMin.Int64)
+			}
+		}
+
+		// 比赛固定使用题目当前版本（确保当前内容已有版本快照）
+		version, err := SaveRevision(db, qid, c.GetInt64("userID"))
+		if err != nil {
+			continue
+		}
+
+		var challengeID int64
+		err = db.QueryRow(`
+			INSERT INTO contest_challenges (contest_id, question_id, question_version, initial_score, min_score, difficulty, status)
+			VALUES ($1, $2, $3, $4, $5, $6, 'hidden') RETURNING id`,
+			contestID, qid, version, initialScore, minScore, req.Difficulty).Scan(&challengeID)
+		if err != nil {
+			continue
+		}
+		added++
+
+		// 题目自带的提示作为未发布提示加入
+		if defaultHints.Valid && defaultHints.String != "" {
+			var hints []string
+			json.Unmarshal([]byte(defaultHints.String), &hints)
+			for _, hint := range hints {
+				db.Exec("INSERT INTO contest_challenge_hints (challenge_id, content) VALUES ($1, $2)", challengeID, hint)
+			}
 		}
 	}
 
@@ -246,7 +298,7 @@ func HandleUpdateContestChallenge(c *gin.Context, db *sql.DB) {
 	var contestID int64
 	var challengeName string
 	db.QueryRow(`SELECT cc.status, cc.contest_id, COALESCE(q.title, cc.inline_title) FROM contest_challenges cc 
-		LEFT JOIN question_bank q ON cc.question_id = q.id WHERE cc.id = $1`, id).Scan(&oldStatus, &contestID, &challengeName)
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version WHERE cc.id = $1`, id).Scan(&oldStatus, &contestID, &challengeName)
 
 	// 构建动态更新SQL
 	updates := []string{"updated_at = CURRENT_TIMESTAMP"}
@@ -281,6 +333,42 @@ func HandleUpdateContestChallenge(c *gin.Context, db *sql.DB) {
 		args = append(args, req.Status)
 		argIndex++
 	}
+	// 支持更新容器实例策略
+	if v, ok := rawReq["instancePolicy"]; ok {
+		policy, _ := v.(string)
+		if !IsValidInstancePolicy(policy) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_INSTANCE_POLICY", "message": "实例策略只能为 team、user 或 shared"})
+			return
+		}
+		updates = append(updates, fmt.Sprintf("instance_policy = $%d", argIndex))
+		args = append(args, policy)
+		argIndex++
+	}
+	// 支持更新预热池大小（预热容器在领取时通过 flag_script 注入队伍 Flag，共享实例除外）
+	warmPoolChanged := false
+	if v, ok := rawReq["warmPoolSize"]; ok {
+		size, _ := v.(float64)
+		if size < 0 || size > maxWarmPoolSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_WARM_POOL_SIZE", "message": fmt.Sprintf("预热池大小范围为 0-%d", maxWarmPoolSize)})
+			return
+		}
+		if size > 0 {
+			var flagScript, policy string
+			db.QueryRow(`SELECT COALESCE(q.flag_script, cc.inline_flag_script, ''), COALESCE(cc.instance_policy, 'team')
+				FROM contest_challenges cc LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version WHERE cc.id = $1`, id).Scan(&flagScript, &policy)
+			if p, ok := rawReq["instancePolicy"].(string); ok {
+				policy = p
+			}
+			if flagScript == "" && policy != "shared" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "WARM_POOL_NEEDS_FLAG_SCRIPT", "message": "预热池需要题目配置 Flag 注入脚本（共享实例除外）"})
+				return
+			}
+		}
+		updates = append(updates, fmt.Sprintf("warm_pool_size = $%d", argIndex))
+		args = append(args, int(size))
+		argIndex++
+		warmPoolChanged = true
+	}
 
 	// 处理 releaseTime：区分"未传递"和"传递null"
 	if _, exists := rawReq["releaseTime"]; exists {
@@ -325,6 +413,10 @@ func HandleUpdateContestChallenge(c *gin.Context, db *sql.DB) {
 		return
 	}
 
+	if warmPoolChanged && OnWarmPoolChange != nil {
+		OnWarmPoolChange()
+	}
+
 	// 状态变更时自动发布公告
 	if req.Status != "" && req.Status != oldStatus && AnnounceChallenge != nil && contestID > 0 {
 		if req.Status == "public" {
@@ -405,7 +497,7 @@ func HandleUpdateChallengeHint(c *gin.Context, db *sql.DB) {
 	var challengeName string
 	var oldHintReleased bool
 	err := db.QueryRow(`SELECT cc.contest_id, COALESCE(q.title, cc.inline_title), COALESCE(cc.hint_released, false) FROM contest_challenges cc 
-		LEFT JOIN question_bank q ON cc.question_id = q.id WHERE cc.id = $1`, id).Scan(&contestID, &challengeName, &oldHintReleased)
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version WHERE cc.id = $1`, id).Scan(&contestID, &challengeName, &oldHintReleased)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "NOT_FOUND"})
 		return
@@ -437,7 +529,7 @@ func HandleReleaseChallengeHint(c *gin.Context, db *sql.DB) {
 	var hint string
 	var oldHintReleased bool
 	err := db.QueryRow(`SELECT cc.contest_id, COALESCE(q.title, cc.inline_title), COALESCE(cc.hint, ''), COALESCE(cc.hint_released, false) 
-		FROM contest_challenges cc LEFT JOIN question_bank q ON cc.question_id = q.id WHERE cc.id = $1`, id).Scan(&contestID, &challengeName, &hint, &oldHintReleased)
+		FROM contest_challenges cc LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version WHERE cc.id = $1`, id).Scan(&contestID, &challengeName, &hint, &oldHintReleased)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "NOT_FOUND"})
 		return
@@ -591,7 +683,7 @@ func HandleReleaseSingleHint(c *gin.Context, db *sql.DB) {
 	var contestID int64
 	var challengeName string
 	db.QueryRow(`SELECT cc.contest_id, COALESCE(q.title, cc.inline_title) FROM contest_challenges cc 
-		LEFT JOIN question_bank q ON cc.question_id = q.id WHERE cc.id = $1`, challengeID).Scan(&contestID, &challengeName)
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version WHERE cc.id = $1`, challengeID).Scan(&contestID, &challengeName)
 
 	// 发布公告
 	if AnnounceChallenge != nil && contestID > 0 {
@@ -674,7 +766,7 @@ func checkAndReleaseScheduledChallenges(db *sql.DB) {
 	rows, err := db.Query(`
 		SELECT cc.id, cc.contest_id, COALESCE(q.title, cc.inline_title)
 		FROM contest_challenges cc
-		LEFT JOIN question_bank q ON cc.question_id = q.id
+		LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
 		WHERE cc.status = 'hidden'
 		  AND cc.release_time IS NOT NULL
 		  AND cc.release_time <= $1`, now)
@@ -769,6 +861,7 @@ type CreateInlineChallengeRequest struct {
 	Ports          string `json:"ports,omitempty"`          // JSON数组
 	CPULimit       string `json:"cpuLimit,omitempty"`
 	MemoryLimit    string `json:"memoryLimit,omitempty"`
+	NetworkPolicy  string `json:"networkPolicy,omitempty"` // 出网策略: full | platform | none
 	FlagEnv        string `json:"flagEnv,omitempty"`
 	FlagScript     string `json:"flagScript,omitempty"`
 	InitialScore   int    `json:"initialScore"`
@@ -779,8 +872,12 @@ type CreateInlineChallengeRequest struct {
 	Choices        string `json:"choices,omitempty"`        // 选项内容 JSON: ["选项1", "选项2", ...]
 	ChoiceAnswer   string `json:"choiceAnswer,omitempty"`   // 正确答案索引: "0,2"
 	MaxAttempts    int    `json:"maxAttempts"`              // 最大答题次数
+	InstancePolicy string `json:"instancePolicy,omitempty"` // 容器实例策略: team | user | shared
 }
 
+// IsValidInstancePolicy 校验容器实例策略（由 main.go 注入 docker.IsValidInstancePolicy，策略列表只在 docker 包维护）
+var IsValidInstancePolicy func(policy string) bool
+
 // HandleCreateInlineChallenge 创建临时题目
 func HandleCreateInlineChallenge(c *gin.Context, db *sql.DB) {
 	contestID := c.Param("id")
@@ -827,6 +924,18 @@ func HandleCreateInlineChallenge(c *gin.Context, db *sql.DB) {
 	if req.MaxAttempts == 0 {
 		req.MaxAttempts = 3
 	}
+	if req.InstancePolicy == "" {
+		req.InstancePolicy = "team"
+	}
+	if !IsValidInstancePolicy(req.InstancePolicy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_INSTANCE_POLICY", "message": "实例策略只能为 team、user 或 shared"})
+		return
+	}
+	networkPolicy, ok := container.ParseNetworkPolicy(req.NetworkPolicy)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_NETWORK_POLICY", "message": "出网策略只能为 full、platform 或 none"})
+		return
+	}
 
 	// 插入临时题目（question_id 为 NULL）
 	var id int64
@@ -837,14 +946,16 @@ func HandleCreateInlineChallenge(c *gin.Context, db *sql.DB) {
 			inline_flag, inline_flag_type, inline_docker_image,
 			inline_attachment_url, inline_attachment_type, inline_ports,
 			inline_cpu_limit, inline_memory_limit, inline_flag_env, inline_flag_script,
-			inline_is_choice, inline_choices, inline_choice_answer, inline_max_attempts
+			inline_is_choice, inline_choices, inline_choice_answer, inline_max_attempts,
+			instance_policy, inline_network_policy
 		) VALUES (
 			$1, NULL, $2, $3, $4, 'hidden',
 			$5, $6, $7, $8,
 			$9, $10, $11,
 			$12, $13, $14,
 			$15, $16, $17, $18,
-			$19, $20, $21, $22
+			$19, $20, $21, $22,
+			$23, $24
 		) RETURNING id`,
 		contestID, req.InitialScore, req.MinScore, req.Difficulty,
 		req.Title, req.Type, req.CategoryID, req.Description,
@@ -852,6 +963,7 @@ func HandleCreateInlineChallenge(c *gin.Context, db *sql.DB) {
 		req.AttachmentURL, req.AttachmentType, req.Ports,
 		req.CPULimit, req.MemoryLimit, req.FlagEnv, req.FlagScript,
 		req.IsChoice, req.Choices, req.ChoiceAnswer, req.MaxAttempts,
+		req.InstancePolicy, networkPolicy,
 	).Scan(&id)
 
 	if err != nil {
@@ -946,6 +1058,16 @@ func HandleUpdateInlineChallenge(c *gin.Context, db *sql.DB) {
 	updates = append(updates, fmt.Sprintf("inline_memory_limit = $%d", argIndex))
 	args = append(args, req.MemoryLimit)
 	argIndex++
+	if req.NetworkPolicy != "" {
+		networkPolicy, ok := container.ParseNetworkPolicy(req.NetworkPolicy)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_NETWORK_POLICY", "message": "出网策略只能为 full、platform 或 none"})
+			return
+		}
+		updates = append(updates, fmt.Sprintf("inline_network_policy = $%d", argIndex))
+		args = append(args, networkPolicy)
+		argIndex++
+	}
 
 	if req.FlagEnv != "" {
 		updates = append(updates, fmt.Sprintf("inline_flag_env = $%d", argIndex))
@@ -972,6 +1094,15 @@ func HandleUpdateInlineChallenge(c *gin.Context, db *sql.DB) {
 		args = append(args, req.MaxAttempts)
 		argIndex++
 	}
+	if req.InstancePolicy != "" {
+		if !IsValidInstancePolicy(req.InstancePolicy) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_INSTANCE_POLICY", "message": "实例策略只能为 team、user 或 shared"})
+			return
+		}
+		updates = append(updates, fmt.Sprintf("instance_policy = $%d", argIndex))
+		args = append(args, req.InstancePolicy)
+		argIndex++
+	}
 
 	// 分数配置
 	if req.InitialScore > 0 {
@@ -1029,6 +1160,7 @@ func HandleGetInlineChallenge(c *gin.Context, db *sql.DB) {
 		Ports          sql.NullString `json:"ports"`
 		CPULimit       sql.NullString `json:"cpuLimit"`
 		MemoryLimit    sql.NullString `json:"memoryLimit"`
+		NetworkPolicy  string         `json:"networkPolicy"`
 		FlagEnv        sql.NullString `json:"flagEnv"`
 		FlagScript     sql.NullString `json:"flagScript"`
 		InitialScore   int            `json:"initialScore"`
@@ -1039,6 +1171,7 @@ func HandleGetInlineChallenge(c *gin.Context, db *sql.DB) {
 		Choices        sql.NullString `json:"choices"`
 		ChoiceAnswer   sql.NullString `json:"choiceAnswer"`
 		MaxAttempts    int            `json:"maxAttempts"`
+		InstancePolicy string         `json:"instancePolicy"`
 	}
 
 	err := db.QueryRow(`
@@ -1046,18 +1179,20 @@ func HandleGetInlineChallenge(c *gin.Context, db *sql.DB) {
 			inline_title, inline_type, inline_category_id, inline_description,
 			inline_flag, inline_flag_type, inline_docker_image,
 			inline_attachment_url, inline_attachment_type, inline_ports,
-			inline_cpu_limit, inline_memory_limit, inline_flag_env, inline_flag_script,
+			inline_cpu_limit, inline_memory_limit, COALESCE(inline_network_policy, 'full'), inline_flag_env, inline_flag_script,
 			initial_score, min_score, difficulty,
-			COALESCE(inline_is_choice, false), inline_choices, inline_choice_answer, COALESCE(inline_max_attempts, 3)
+			COALESCE(inline_is_choice, false), inline_choices, inline_choice_answer, COALESCE(inline_max_attempts, 3),
+			COALESCE(instance_policy, 'team')
 		FROM contest_challenges
 		WHERE id = $1 AND question_id IS NULL`, id).Scan(
 		&cc.ID, &cc.ContestID,
 		&cc.Title, &cc.Type, &cc.CategoryID, &cc.Description,
 		&cc.Flag, &cc.FlagType, &cc.DockerImage,
 		&cc.AttachmentURL, &cc.AttachmentType, &cc.Ports,
-		&cc.CPULimit, &cc.MemoryLimit, &cc.FlagEnv, &cc.FlagScript,
+		&cc.CPULimit, &cc.MemoryLimit, &cc.NetworkPolicy, &cc.FlagEnv, &cc.FlagScript,
 		&cc.InitialScore, &cc.MinScore, &cc.Difficulty,
 		&cc.IsChoice, &cc.Choices, &cc.ChoiceAnswer, &cc.MaxAttempts,
+		&cc.InstancePolicy,
 	)
 
 	if err != nil {
@@ -1085,6 +1220,7 @@ func HandleGetInlineChallenge(c *gin.Context, db *sql.DB) {
 		"ports":          cc.Ports.String,
 		"cpuLimit":       cc.CPULimit.String,
 		"memoryLimit":    cc.MemoryLimit.String,
+		"networkPolicy":  cc.NetworkPolicy,
 		"flagEnv":        cc.FlagEnv.String,
 		"flagScript":     cc.FlagScript.String,
 		"initialScore":   cc.InitialScore,
@@ -1094,6 +1230,7 @@ func HandleGetInlineChallenge(c *gin.Context, db *sql.DB) {
 		"choices":        cc.Choices.String,
 		"choiceAnswer":   cc.ChoiceAnswer.String,
 		"maxAttempts":    cc.MaxAttempts,
+		"instancePolicy": cc.InstancePolicy,
 	}
 
 	c.JSON(http.StatusOK, result)
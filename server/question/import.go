@@ -251,16 +251,18 @@ func HandleImportQuestions(c *gin.Context, db *sql.DB) {
 		result.NeedsEdit = needsEdit
 
 		// 插入数据库
-		_, err := db.Exec(`
+		var questionID int64
+		err := db.QueryRow(`
 			INSERT INTO question_bank (
 				title, type, category_id, difficulty, description, 
 				flag, flag_type, docker_image, ports,
 				cpu_limit, memory_limit, storage_limit, no_resource_limit, flag_env, needs_edit
 			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+			RETURNING id
 		`, title, qType, categoryID, difficulty, NullIfEmpty(description),
 			NullIfEmpty(flag), flagType, NullIfEmpty(dockerImage), NullIfEmpty(ports),
 			NullIfEmpty(cpuLimit), NullIfEmpty(memoryLimit), NullIfEmpty(storageLimit),
-			noResourceLimit, flagEnv, needsEdit)
+			noResourceLimit, flagEnv, needsEdit).Scan(&questionID)
 
 		if err != nil {
 			result.Message = "数据库错误: " + err.Error()
@@ -269,6 +271,8 @@ func HandleImportQuestions(c *gin.Context, db *sql.DB) {
 			continue
 		}
 
+		SaveRevision(db, questionID, c.GetInt64("userID"))
+
 		result.Success = true
 		if needsEdit {
 			result.Message = "导入成功，需要再次编辑"
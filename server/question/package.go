@@ -0,0 +1,349 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package question
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"tgctf/server/bank"
+	"tgctf/server/challengepkg"
+	"tgctf/server/container"
+)
+
+// PackageImportResult 题目包导入结果
+type PackageImportResult struct {
+	Dir       string `json:"dir"`
+	Title     string `json:"title"`
+	ID        int64  `json:"id,omitempty"`
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	NeedsEdit bool   `json:"needsEdit"`
+}
+
+// HandleImportQuestionPackages 导入 challenge.yml 题目包（zip 或目录上传，可包含多道题）
+func HandleImportQuestionPackages(c *gin.Context, db *sql.DB) {
+	files, err := challengepkg.ReadRequest(c.Request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_PACKAGE", "message": err.Error()})
+		return
+	}
+	pkgs, err := challengepkg.Split(files)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_PACKAGE", "message": err.Error()})
+		return
+	}
+
+	results := []PackageImportResult{}
+	successCount := 0
+	for _, pkg := range pkgs {
+		result := importQuestionPackage(db, pkg, c.GetInt64("userID"))
+		if result.Success {
+			successCount++
+		}
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": successCount,
+		"failed":  len(results) - successCount,
+		"results": results,
+	})
+}
+
+// importQuestionPackage 导入单个题目包
+func importQuestionPackage(db *sql.DB, pkg *challengepkg.Package, userID int64) PackageImportResult {
+	spec := &pkg.Spec
+	result := PackageImportResult{Dir: pkg.Dir, Title: spec.Title}
+	fail := func(format string, args ...interface{}) PackageImportResult {
+		result.Message = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	if err := spec.Validate(challengepkg.KindJeopardy); err != nil {
+		return fail("%v", err)
+	}
+	buildContext := pkg.BuildContext()
+	hasContainer := spec.Container.Image != "" || buildContext != nil
+
+	// 题目类型：未填写时根据是否有容器与 flag 类型推断
+	if spec.Type == "" {
+		spec.Type = "static_attachment"
+		if hasContainer {
+			spec.Type = "static_container"
+		}
+		if spec.Flag.Type == "dynamic" {
+			spec.Type = strings.Replace(spec.Type, "static_", "dynamic_", 1)
+		}
+	}
+	switch spec.Type {
+	case "static_attachment", "static_container", "dynamic_attachment", "dynamic_container":
+	default:
+		return fail("无效的题目类型: %s", spec.Type)
+	}
+	isContainer := strings.HasSuffix(spec.Type, "_container")
+	if isContainer && !hasContainer {
+		return fail("容器题目需要 container.image 或 Dockerfile")
+	}
+	if spec.Flag.Type == "" {
+		spec.Flag.Type = "static"
+		if strings.HasPrefix(spec.Type, "dynamic_") {
+			spec.Flag.Type = "dynamic"
+		}
+	}
+	if spec.Flag.Type != "static" && spec.Flag.Type != "dynamic" {
+		return fail("无效的 flag.type: %s", spec.Flag.Type)
+	}
+	networkPolicy, ok := container.ParseNetworkPolicy(spec.Container.Network)
+	if !ok {
+		return fail("无效的 container.network: %s", spec.Container.Network)
+	}
+	categoryID, categoryName, err := challengepkg.ResolveCategory(db, spec.Category)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	// 附件：外部链接直接保存，包内文件复制到附件目录
+	attachmentURL, attachmentType := "", "url"
+	if spec.Attachment != "" {
+		if challengepkg.IsURL(spec.Attachment) {
+			attachmentURL = spec.Attachment
+		} else {
+			data, ok := pkg.File(spec.Attachment)
+			if !ok {
+				return fail("附件不存在: %s", spec.Attachment)
+			}
+			a, err := storeAttachment(db, bytes.NewReader(data), spec.Attachment, userID)
+			if err != nil {
+				return fail("保存附件失败: %v", err)
+			}
+			attachmentURL, attachmentType = a.URL, "local"
+		}
+	}
+
+	solveScript := ""
+	if spec.Solve != "" {
+		data, ok := pkg.File(spec.Solve)
+		if !ok {
+			return fail("解题脚本不存在: %s", spec.Solve)
+		}
+		solveScript = string(data)
+	}
+
+	generatorScript := ""
+	if spec.GenScript != "" {
+		data, ok := pkg.File(spec.GenScript)
+		if !ok {
+			return fail("附件生成脚本不存在: %s", spec.GenScript)
+		}
+		generatorScript = string(data)
+	}
+	if !validAttachmentGenerator(spec.Generator, generatorScript) {
+		return fail("无效的 attachment_generator: %s（script 方式需提供 attachment_script）", spec.Generator)
+	}
+
+	var ports, hints string
+	if len(spec.Container.Ports) > 0 {
+		data, _ := json.Marshal(spec.Container.Ports)
+		ports = string(data)
+	}
+	if len(spec.Hints) > 0 {
+		data, _ := json.Marshal(spec.Hints)
+		hints = string(data)
+	}
+
+	// 只提供 Dockerfile 的题目需要先构建镜像，标记为需要再次编辑
+	needsEdit := isContainer && spec.Container.Image == ""
+
+	var id int64
+	err = db.QueryRow(`
+		INSERT INTO question_bank (
+			title, type, category_id, difficulty, description,
+			flag, flag_type, docker_image, attachment_url, attachment_type,
+			ports, cpu_limit, memory_limit, storage_limit, no_resource_limit, flag_env, flag_script, network_policy, read_only_rootfs, allow_setuid,
+			needs_edit, default_hints, default_initial_score, default_min_score, solve_script, author, source,
+			attachment_generator, attachment_script
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29)
+		RETURNING id
+	`,
+		spec.Title, spec.Type, categoryID, spec.Difficulty, spec.Description,
+		NullIfEmpty(spec.Flag.Value), spec.Flag.Type, NullIfEmpty(spec.Container.Image), NullIfEmpty(attachmentURL), attachmentType,
+		NullIfEmpty(ports), NullIfEmpty(spec.Limits.CPU), NullIfEmpty(spec.Limits.Memory), NullIfEmpty(spec.Limits.Storage),
+		spec.Limits.Unlimited, NullIfEmpty(spec.Flag.Env), NullIfEmpty(spec.Flag.Script), networkPolicy, spec.Container.ReadOnlyRootfs, spec.Container.AllowSetuid,
+		needsEdit, NullIfEmpty(hints), nullIfZero(spec.Scoring.Initial), nullIfZero(spec.Scoring.Minimum), NullIfEmpty(solveScript),
+		NullIfEmpty(spec.Author), NullIfEmpty(spec.Source),
+		NullIfEmpty(spec.Generator), NullIfEmpty(generatorScript),
+	).Scan(&id)
+	if err != nil {
+		if attachmentType == "local" {
+			removeAttachmentIfUnused(db, attachmentURL)
+		}
+		return fail("数据库错误: %v", err)
+	}
+	bank.SetTags(db, bank.Jeopardy, id, spec.Tags)
+	SaveRevision(db, id, userID)
+	result.ID = id
+	result.Success = true
+	result.NeedsEdit = needsEdit
+	result.Message = "导入成功，类别: " + categoryName
+
+	if buildContext != nil {
+		if err := challengepkg.SaveContext(challengepkg.KindJeopardy, id, buildContext); err != nil {
+			result.Message += "；保存 Dockerfile 构建上下文失败: " + err.Error()
+		} else if needsEdit {
+			result.Message += "；已保存 Dockerfile 构建上下文，请构建镜像后填写镜像名"
+		}
+	}
+	return result
+}
+
+// nullIfZero 0 值存为 NULL
+func nullIfZero(n int) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// HandleExportQuestionPackages 导出题目包: /questions/export?ids=1,2,3
+// 单道题目时 challenge.yml 位于压缩包根目录，多道题目时每道题一个目录
+func HandleExportQuestionPackages(c *gin.Context, db *sql.DB) {
+	ids, err := challengepkg.ParseIDs(c.Query("ids"))
+	if err != nil || len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_IDS", "message": "请选择要导出的题目"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT q.id, q.title, q.type, COALESCE(cat.name, ''), q.difficulty, COALESCE(q.description, ''),
+			COALESCE(q.flag, ''), COALESCE(q.flag_type, 'static'), COALESCE(q.flag_env, ''), COALESCE(q.flag_script, ''),
+			COALESCE(q.docker_image, ''), COALESCE(q.ports, ''), COALESCE(q.network_policy, ''), COALESCE(q.read_only_rootfs, false), COALESCE(q.allow_setuid, false),
+			COALESCE(q.cpu_limit, ''), COALESCE(q.memory_limit, ''), COALESCE(q.storage_limit, ''), COALESCE(q.no_resource_limit, false),
+			COALESCE(q.attachment_url, ''), COALESCE(q.attachment_type, 'url'),
+			COALESCE(q.default_hints, ''), COALESCE(q.default_initial_score, 0), COALESCE(q.default_min_score, 0),
+			COALESCE(q.solve_script, ''), COALESCE(q.author, ''), COALESCE(q.source, ''),
+			COALESCE(q.attachment_generator, ''), COALESCE(q.attachment_script, '')
+		FROM question_bank q
+		LEFT JOIN categories cat ON q.category_id = cat.id
+		WHERE q.id = ANY($1)
+		ORDER BY q.id`, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	defer rows.Close()
+
+	tags := bank.LoadTags(db, bank.Jeopardy, ids)
+	var buf bytes.Buffer
+	w := challengepkg.NewWriter(&buf)
+	count := 0
+	for rows.Next() {
+		var id int64
+		var ports, hints, attachmentURL, attachmentType, solveScript, generatorScript string
+		spec := challengepkg.Spec{
+			Kind:      challengepkg.KindJeopardy,
+			Flag:      &challengepkg.Flag{},
+			Container: &challengepkg.Container{},
+			Limits:    &challengepkg.Limits{},
+			Scoring:   &challengepkg.Scoring{},
+		}
+		if err := rows.Scan(&id, &spec.Title, &spec.Type, &spec.Category, &spec.Difficulty, &spec.Description,
+			&spec.Flag.Value, &spec.Flag.Type, &spec.Flag.Env, &spec.Flag.Script,
+			&spec.Container.Image, &ports, &spec.Container.Network, &spec.Container.ReadOnlyRootfs, &spec.Container.AllowSetuid,
+			&spec.Limits.CPU, &spec.Limits.Memory, &spec.Limits.Storage, &spec.Limits.Unlimited,
+			&attachmentURL, &attachmentType,
+			&hints, &spec.Scoring.Initial, &spec.Scoring.Minimum,
+			&solveScript, &spec.Author, &spec.Source,
+			&spec.Generator, &generatorScript); err != nil {
+			continue
+		}
+		spec.Tags = tags[id]
+		if ports != "" {
+			json.Unmarshal([]byte(ports), &spec.Container.Ports)
+		}
+		if hints != "" {
+			json.Unmarshal([]byte(hints), &spec.Hints)
+		}
+
+		files := make(map[string][]byte)
+		if key := attachmentKey(attachmentURL); attachmentType == "local" && key != "" {
+			if data, err := readAttachment(key); err == nil {
+				spec.Attachment = challengepkg.AttachmentsDir + "/" + key
+				files[spec.Attachment] = data
+			}
+		} else {
+			spec.Attachment = attachmentURL
+		}
+		if solveScript != "" {
+			spec.Solve = scriptPath("solve", solveScript)
+			files[spec.Solve] = []byte(solveScript)
+		}
+		if generatorScript != "" {
+			spec.GenScript = scriptPath("gen_attachment", generatorScript)
+			files[spec.GenScript] = []byte(generatorScript)
+		}
+		for name, data := range challengepkg.LoadContext(challengepkg.KindJeopardy, id) {
+			files[challengepkg.DockerDir+"/"+name] = data
+		}
+		exportSpec(&spec)
+
+		dir := ""
+		if len(ids) > 1 {
+			dir = challengepkg.DirName(id, spec.Title)
+		}
+		if err := w.Add(dir, spec, files); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "EXPORT_FAILED", "message": err.Error()})
+			return
+		}
+		count++
+	}
+	if err := w.Close(); err != nil || count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "QUESTION_NOT_FOUND"})
+		return
+	}
+
+	filename := "challenges.zip"
+	if len(ids) == 1 {
+		filename = fmt.Sprintf("challenge-%d.zip", ids[0])
+	}
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+// scriptPath 导出时脚本在题目包中的路径（按首行解释器选择扩展名）
+func scriptPath(base, script string) string {
+	name := base + ".sh"
+	if strings.HasPrefix(script, "#!") && strings.Contains(strings.SplitN(script, "\n", 2)[0], "python") {
+		name = base + ".py"
+	}
+	return challengepkg.ScriptsDir + "/" + name
+}
+
+// exportSpec 去掉与默认值相同的配置段，使导出的 challenge.yml 更简洁
+func exportSpec(spec *challengepkg.Spec) {
+	if spec.Flag.Env == "FLAG" {
+		spec.Flag.Env = ""
+	}
+	if spec.Container.Network == container.NetworkFull {
+		spec.Container.Network = ""
+	}
+	if *spec.Flag == (challengepkg.Flag{}) {
+		spec.Flag = nil
+	}
+	if spec.Container.Image == "" && len(spec.Container.Ports) == 0 && spec.Container.Network == "" && !spec.Container.ReadOnlyRootfs && !spec.Container.AllowSetuid {
+		spec.Container = nil
+	}
+	if *spec.Limits == (challengepkg.Limits{}) {
+		spec.Limits = nil
+	}
+	if *spec.Scoring == (challengepkg.Scoring{}) {
+		spec.Scoring = nil
+	}
+}
@@ -7,36 +7,55 @@ package question
 import (
 	"database/sql"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"tgctf/server/bank"
+	"tgctf/server/challengepkg"
+	"tgctf/server/container"
 )
 
 // QuestionBank 题库题目
 type QuestionBank struct {
-	ID              int64   `json:"id"`
-	Title           string  `json:"title"`
-	Type            string  `json:"type"`
-	CategoryID      int64   `json:"categoryId"`
-	CategoryName    *string `json:"categoryName,omitempty"`
-	Difficulty      int     `json:"difficulty"`
-	Description     *string `json:"description"`
-	Flag            *string `json:"flag,omitempty"`
-	FlagType        string  `json:"flagType"`
-	DockerImage     *string `json:"dockerImage"`
-	AttachmentURL   *string `json:"attachmentUrl"`
-	AttachmentType  string  `json:"attachmentType"`
-	Ports           *string `json:"ports"`
-	CPULimit        *string `json:"cpuLimit"`
-	MemoryLimit     *string `json:"memoryLimit"`
-	StorageLimit    *string `json:"storageLimit"`
-	NoResourceLimit bool    `json:"noResourceLimit"`
-	FlagEnv         *string `json:"flagEnv"`
-	FlagScript      *string `json:"flagScript"`
-	NeedsEdit       bool    `json:"needsEdit"`
-	ImageStatus     *string `json:"imageStatus"`
-	CreatedAt       string  `json:"createdAt"`
-	UpdatedAt       string  `json:"updatedAt"`
+	ID                  int64    `json:"id"`
+	Title               string   `json:"title"`
+	Type                string   `json:"type"`
+	CategoryID          int64    `json:"categoryId"`
+	CategoryName        *string  `json:"categoryName,omitempty"`
+	Difficulty          int      `json:"difficulty"`
+	Description         *string  `json:"description"`
+	Flag                *string  `json:"flag,omitempty"`
+	FlagType            string   `json:"flagType"`
+	DockerImage         *string  `json:"dockerImage"`
+	AttachmentURL       *string  `json:"attachmentUrl"`
+	AttachmentType      string   `json:"attachmentType"`
+	AttachmentGenerator *string  `json:"attachmentGenerator"`
+	AttachmentScript    *string  `json:"attachmentScript,omitempty"`
+	Ports               *string  `json:"ports"`
+	CPULimit            *string  `json:"cpuLimit"`
+	MemoryLimit         *string  `json:"memoryLimit"`
+	StorageLimit        *string  `json:"storageLimit"`
+	NoResourceLimit     bool     `json:"noResourceLimit"`
+	NetworkPolicy       string   `json:"networkPolicy"`
+	ReadOnlyRootfs      bool     `json:"readOnlyRootfs"`
+	AllowSetuid         bool     `json:"allowSetuid"`
+	FlagEnv             *string  `json:"flagEnv"`
+	FlagScript          *string  `json:"flagScript"`
+	NeedsEdit           bool     `json:"needsEdit"`
+	ImageStatus         *string  `json:"imageStatus"`
+	SolveScript         *string  `json:"solveScript,omitempty"`
+	VerifyStatus        *string  `json:"verifyStatus"`
+	VerifyOutput        *string  `json:"verifyOutput,omitempty"`
+	VerifiedAt          *string  `json:"verifiedAt"`
+	Author              *string  `json:"author"`
+	Source              *string  `json:"source"`
+	Tags                []string `json:"tags"`
+	UsedCount           int      `json:"usedCount"`  // 使用该题目的比赛数
+	LastUsedAt          *string  `json:"lastUsedAt"` // 最近使用的比赛开始时间
+	CreatedAt           string   `json:"createdAt"`
+	UpdatedAt           string   `json:"updatedAt"`
 }
 
 // nullStringToPtr 将 sql.NullString 转换为 *string
@@ -47,46 +66,73 @@ func nullStringToPtr(ns sql.NullString) *string {
 	return nil
 }
 
+// nullTimeToPtr 将 sql.NullTime 转换为 RFC3339 格式的 *string
+func nullTimeToPtr(nt sql.NullTime) *string {
+	if nt.Valid {
+		s := nt.Time.Format(time.RFC3339)
+		return &s
+	}
+	return nil
+}
+
 // CreateQuestionRequest 创建题目请求
 type CreateQuestionRequest struct {
-	Title           string `json:"title" binding:"required"`
-	Type            string `json:"type" binding:"required"`
-	CategoryID      int64  `json:"categoryId" binding:"required"`
-	Difficulty      int    `json:"difficulty"`
-	Description     string `json:"description"`
-	Flag            string `json:"flag"`
-	FlagType        string `json:"flagType"`
-	DockerImage     string `json:"dockerImage"`
-	AttachmentURL   string `json:"attachmentUrl"`
-	AttachmentType  string `json:"attachmentType"`
-	Ports           string `json:"ports"`
-	CPULimit        string `json:"cpuLimit"`
-	MemoryLimit     string `json:"memoryLimit"`
-	StorageLimit    string `json:"storageLimit"`
-	NoResourceLimit bool   `json:"noResourceLimit"`
-	FlagEnv         string `json:"flagEnv"`
-	FlagScript      string `json:"flagScript"`
+	Title               string   `json:"title" binding:"required"`
+	Type                string   `json:"type" binding:"required"`
+	CategoryID          int64    `json:"categoryId" binding:"required"`
+	Difficulty          int      `json:"difficulty"`
+	Description         string   `json:"description"`
+	Flag                string   `json:"flag"`
+	FlagType            string   `json:"flagType"`
+	DockerImage         string   `json:"dockerImage"`
+	AttachmentURL       string   `json:"attachmentUrl"`
+	AttachmentType      string   `json:"attachmentType"`
+	AttachmentGenerator string   `json:"attachmentGenerator"`
+	AttachmentScript    string   `json:"attachmentScript"`
+	Ports               string   `json:"ports"`
+	CPULimit            string   `json:"cpuLimit"`
+	MemoryLimit         string   `json:"memoryLimit"`
+	StorageLimit        string   `json:"storageLimit"`
+	NoResourceLimit     bool     `json:"noResourceLimit"`
+	NetworkPolicy       string   `json:"networkPolicy"`
+	ReadOnlyRootfs      bool     `json:"readOnlyRootfs"`
+	AllowSetuid         bool     `json:"allowSetuid"`
+	FlagEnv             string   `json:"flagEnv"`
+	FlagScript          string   `json:"flagScript"`
+	SolveScript         string   `json:"solveScript"`
+	Author              string   `json:"author"`
+	Source              string   `json:"source"`
+	Tags                []string `json:"tags"`
 }
 
 // UpdateQuestionRequest 更新题目请求
 type UpdateQuestionRequest struct {
-	Title           string `json:"title"`
-	Type            string `json:"type"`
-	CategoryID      int64  `json:"categoryId"`
-	Difficulty      int    `json:"difficulty"`
-	Description     string `json:"description"`
-	Flag            string `json:"flag"`
-	FlagType        string `json:"flagType"`
-	DockerImage     string `json:"dockerImage"`
-	AttachmentURL   string `json:"attachmentUrl"`
-	AttachmentType  string `json:"attachmentType"`
-	Ports           string `json:"ports"`
-	CPULimit        string `json:"cpuLimit"`
-	MemoryLimit     string `json:"memoryLimit"`
-	StorageLimit    string `json:"storageLimit"`
-	NoResourceLimit bool   `json:"noResourceLimit"`
-	FlagEnv         string `json:"flagEnv"`
-	FlagScript      string `json:"flagScript"`
+	Title               string    `json:"title"`
+	Type                string    `json:"type"`
+	CategoryID          int64     `json:"categoryId"`
+	Difficulty          int       `json:"difficulty"`
+	Description         string    `json:"description"`
+	Flag                string    `json:"flag"`
+	FlagType            string    `json:"flagType"`
+	DockerImage         string    `json:"dockerImage"`
+	AttachmentURL       string    `json:"attachmentUrl"`
+	AttachmentType      string    `json:"attachmentType"`
+	AttachmentGenerator string    `json:"attachmentGenerator"`
+	AttachmentScript    string    `json:"attachmentScript"`
+	Ports               string    `json:"ports"`
+	CPULimit            string    `json:"cpuLimit"`
+	MemoryLimit         string    `json:"memoryLimit"`
+	StorageLimit        string    `json:"storageLimit"`
+	NoResourceLimit     bool      `json:"noResourceLimit"`
+	NetworkPolicy       string    `json:"networkPolicy"`
+	ReadOnlyRootfs      bool      `json:"readOnlyRootfs"`
+	AllowSetuid         bool      `json:"allowSetuid"`
+	FlagEnv             string    `json:"flagEnv"`
+	FlagScript          string    `json:"flagScript"`
+	SolveScript         string    `json:"solveScript"`
+	Author              string    `json:"author"`
+	Source              string    `json:"source"`
+	Tags                *[]string `json:"tags"` // 为空时保持不变
 }
 
 // NullIfEmpty 如果字符串为空返回nil
@@ -98,42 +144,30 @@ func NullIfEmpty(s string) interface{} {
 }
 
 // HandleListQuestions 获取题库列表
+// 查询参数见 bank.Filter：q（全文检索）、categoryId、type、difficulty / minDifficulty / maxDifficulty、
+// imageStatus、tags、author、source、used、lastUsedAfter / lastUsedBefore
 func HandleListQuestions(c *gin.Context, db *sql.DB) {
-	categoryID := c.Query("categoryId")
-	questionType := c.Query("type")
-	difficulty := c.Query("difficulty")
+	filter, err := bank.ParseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_FILTER", "details": err.Error()})
+		return
+	}
 
 	query := `
 		SELECT q.id, q.title, q.type, q.category_id, c.name as category_name,
 			q.difficulty, q.description, q.flag, q.flag_type,
 			q.docker_image, q.attachment_url, q.attachment_type, q.ports,
-			q.cpu_limit, q.memory_limit, q.storage_limit, q.no_resource_limit, q.flag_env, q.flag_script,
-			COALESCE(q.needs_edit, false), q.image_status,
+			q.cpu_limit, q.memory_limit, q.storage_limit, q.no_resource_limit, COALESCE(q.network_policy, 'full'), COALESCE(q.read_only_rootfs, false), COALESCE(q.allow_setuid, false), q.flag_env, q.flag_script,
+			COALESCE(q.needs_edit, false), q.image_status, q.verify_status, q.verified_at,
+			q.author, q.source, ` + bank.Jeopardy.UsageColumns("q") + `,
 			q.created_at, q.updated_at
 		FROM question_bank q
 		LEFT JOIN categories c ON q.category_id = c.id
 		WHERE 1=1
 	`
 	args := []interface{}{}
-	argIndex := 1
-
-	if categoryID != "" {
-		query += " AND q.category_id = $" + string(rune('0'+argIndex))
-		args = append(args, categoryID)
-		argIndex++
-	}
-	if questionType != "" {
-		query += " AND q.type = $" + string(rune('0'+argIndex))
-		args = append(args, questionType)
-		argIndex++
-	}
-	if difficulty != "" {
-		query += " AND q.difficulty = $" + string(rune('0'+argIndex))
-		args = append(args, difficulty)
-		argIndex++
-	}
-
-	query += " ORDER BY q.created_at ASC"
+	where, orderBy := filter.Where(bank.Jeopardy, "q", &args)
+	query += where + " ORDER BY " + orderBy
 
 	rows, err := db.Query(query, args...)
 	if err != nil {
@@ -147,13 +181,16 @@ func HandleListQuestions(c *gin.Context, db *sql.DB) {
 		var q QuestionBank
 		var createdAt, updatedAt time.Time
 		var categoryName, description, flag, dockerImage, attachmentURL, ports sql.NullString
-		var cpuLimit, memoryLimit, storageLimit, flagEnv, flagScript, imageStatus sql.NullString
+		var cpuLimit, memoryLimit, storageLimit, flagEnv, flagScript, imageStatus, verifyStatus sql.NullString
+		var author, source sql.NullString
+		var verifiedAt, lastUsedAt sql.NullTime
 		err := rows.Scan(
 			&q.ID, &q.Title, &q.Type, &q.CategoryID, &categoryName,
 			&q.Difficulty, &description, &flag, &q.FlagType,
 			&dockerImage, &attachmentURL, &q.AttachmentType, &ports,
-			&cpuLimit, &memoryLimit, &storageLimit, &q.NoResourceLimit, &flagEnv, &flagScript,
-			&q.NeedsEdit, &imageStatus,
+			&cpuLimit, &memoryLimit, &storageLimit, &q.NoResourceLimit, &q.NetworkPolicy, &q.ReadOnlyRootfs, &q.AllowSetuid, &flagEnv, &flagScript,
+			&q.NeedsEdit, &imageStatus, &verifyStatus, &verifiedAt,
+			&author, &source, &q.UsedCount, &lastUsedAt,
 			&createdAt, &updatedAt,
 		)
 		if err != nil {
@@ -171,6 +208,11 @@ func HandleListQuestions(c *gin.Context, db *sql.DB) {
 		q.FlagEnv = nullStringToPtr(flagEnv)
 		q.FlagScript = nullStringToPtr(flagScript)
 		q.ImageStatus = nullStringToPtr(imageStatus)
+		q.VerifyStatus = nullStringToPtr(verifyStatus)
+		q.VerifiedAt = nullTimeToPtr(verifiedAt)
+		q.Author = nullStringToPtr(author)
+		q.Source = nullStringToPtr(source)
+		q.LastUsedAt = nullTimeToPtr(lastUsedAt)
 		q.CreatedAt = createdAt.Format(time.RFC3339)
 		q.UpdatedAt = updatedAt.Format(time.RFC3339)
 		questions = append(questions, q)
@@ -179,6 +221,17 @@ func HandleListQuestions(c *gin.Context, db *sql.DB) {
 	if questions == nil {
 		questions = []QuestionBank{}
 	}
+	ids := make([]int64, len(questions))
+	for i := range questions {
+		ids[i] = questions[i].ID
+	}
+	tags := bank.LoadTags(db, bank.Jeopardy, ids)
+	for i := range questions {
+		questions[i].Tags = tags[questions[i].ID]
+		if questions[i].Tags == nil {
+			questions[i].Tags = []string{}
+		}
+	}
 
 	c.JSON(http.StatusOK, questions)
 }
@@ -218,14 +271,24 @@ func HandleCreateQuestion(c *gin.Context, db *sql.DB) {
 	if req.AttachmentType == "" {
 		req.AttachmentType = "url"
 	}
+	networkPolicy, ok := container.ParseNetworkPolicy(req.NetworkPolicy)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_NETWORK_POLICY"})
+		return
+	}
+	if !validAttachmentGenerator(req.AttachmentGenerator, req.AttachmentScript) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_ATTACHMENT_GENERATOR"})
+		return
+	}
 
 	var id int64
 	err := db.QueryRow(`
 		INSERT INTO question_bank (
 			title, type, category_id, difficulty, description,
 			flag, flag_type, docker_image, attachment_url, attachment_type,
-			ports, cpu_limit, memory_limit, storage_limit, no_resource_limit, flag_env, flag_script
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+			ports, cpu_limit, memory_limit, storage_limit, no_resource_limit, flag_env, flag_script, network_policy, read_only_rootfs,
+			solve_script, attachment_generator, attachment_script, author, source, allow_setuid
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25)
 		RETURNING id
 	`,
 		req.Title, req.Type, req.CategoryID, req.Difficulty, req.Description,
@@ -233,13 +296,20 @@ func HandleCreateQuestion(c *gin.Context, db *sql.DB) {
 		NullIfEmpty(req.DockerImage), NullIfEmpty(req.AttachmentURL), req.AttachmentType,
 		NullIfEmpty(req.Ports), NullIfEmpty(req.CPULimit),
 		NullIfEmpty(req.MemoryLimit), NullIfEmpty(req.StorageLimit),
-		req.NoResourceLimit, NullIfEmpty(req.FlagEnv), NullIfEmpty(req.FlagScript),
+		req.NoResourceLimit, NullIfEmpty(req.FlagEnv), NullIfEmpty(req.FlagScript), networkPolicy, req.ReadOnlyRootfs,
+		NullIfEmpty(req.SolveScript), NullIfEmpty(req.AttachmentGenerator), NullIfEmpty(req.AttachmentScript),
+		NullIfEmpty(strings.TrimSpace(req.Author)), NullIfEmpty(strings.TrimSpace(req.Source)), req.AllowSetuid,
 	).Scan(&id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR", "details": err.Error()})
 		return
 	}
+	if err := bank.SetTags(db, bank.Jeopardy, id, req.Tags); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR", "details": err.Error()})
+		return
+	}
+	SaveRevision(db, id, c.GetInt64("userID"))
 
 	c.JSON(http.StatusCreated, gin.H{"id": id, "message": "Question created"})
 }
@@ -252,12 +322,18 @@ func HandleGetQuestion(c *gin.Context, db *sql.DB) {
 	var createdAt, updatedAt time.Time
 	var categoryName, description, flag, dockerImage, attachmentURL, ports sql.NullString
 	var cpuLimit, memoryLimit, storageLimit, flagEnv, flagScript, imageStatus sql.NullString
+	var solveScript, verifyStatus, verifyOutput sql.NullString
+	var attachmentGenerator, attachmentScript, author, source sql.NullString
+	var verifiedAt, lastUsedAt sql.NullTime
 	err := db.QueryRow(`
 		SELECT q.id, q.title, q.type, q.category_id, c.name as category_name,
 			q.difficulty, q.description, q.flag, q.flag_type,
 			q.docker_image, q.attachment_url, q.attachment_type, q.ports,
-			q.cpu_limit, q.memory_limit, q.storage_limit, q.no_resource_limit, q.flag_env, q.flag_script,
+			q.cpu_limit, q.memory_limit, q.storage_limit, q.no_resource_limit, COALESCE(q.network_policy, 'full'), COALESCE(q.read_only_rootfs, false), COALESCE(q.allow_setuid, false), q.flag_env, q.flag_script,
 			COALESCE(q.needs_edit, false), q.image_status,
+			q.solve_script, q.verify_status, q.verify_output, q.verified_at,
+			q.attachment_generator, q.attachment_script,
+			q.author, q.source, `+bank.Jeopardy.UsageColumns("q")+`,
 			q.created_at, q.updated_at
 		FROM question_bank q
 		LEFT JOIN categories c ON q.category_id = c.id
@@ -266,8 +342,11 @@ func HandleGetQuestion(c *gin.Context, db *sql.DB) {
 		&q.ID, &q.Title, &q.Type, &q.CategoryID, &categoryName,
 		&q.Difficulty, &description, &flag, &q.FlagType,
 		&dockerImage, &attachmentURL, &q.AttachmentType, &ports,
-		&cpuLimit, &memoryLimit, &storageLimit, &q.NoResourceLimit, &flagEnv, &flagScript,
+		&cpuLimit, &memoryLimit, &storageLimit, &q.NoResourceLimit, &q.NetworkPolicy, &q.ReadOnlyRootfs, &q.AllowSetuid, &flagEnv, &flagScript,
 		&q.NeedsEdit, &imageStatus,
+		&solveScript, &verifyStatus, &verifyOutput, &verifiedAt,
+		&attachmentGenerator, &attachmentScript,
+		&author, &source, &q.UsedCount, &lastUsedAt,
 		&createdAt, &updatedAt,
 	)
 
@@ -292,6 +371,19 @@ func HandleGetQuestion(c *gin.Context, db *sql.DB) {
 	q.FlagEnv = nullStringToPtr(flagEnv)
 	q.FlagScript = nullStringToPtr(flagScript)
 	q.ImageStatus = nullStringToPtr(imageStatus)
+	q.SolveScript = nullStringToPtr(solveScript)
+	q.VerifyStatus = nullStringToPtr(verifyStatus)
+	q.VerifyOutput = nullStringToPtr(verifyOutput)
+	q.VerifiedAt = nullTimeToPtr(verifiedAt)
+	q.AttachmentGenerator = nullStringToPtr(attachmentGenerator)
+	q.AttachmentScript = nullStringToPtr(attachmentScript)
+	q.Author = nullStringToPtr(author)
+	q.Source = nullStringToPtr(source)
+	q.LastUsedAt = nullTimeToPtr(lastUsedAt)
+	q.Tags = bank.LoadTags(db, bank.Jeopardy, []int64{q.ID})[q.ID]
+	if q.Tags == nil {
+		q.Tags = []string{}
+	}
 	q.CreatedAt = createdAt.Format(time.RFC3339)
 	q.UpdatedAt = updatedAt.Format(time.RFC3339)
 
@@ -330,6 +422,20 @@ func HandleUpdateQuestion(c *gin.Context, db *sql.DB) {
 		}
 	}
 
+	// 出网策略为空时保持不变
+	networkPolicy := ""
+	if req.NetworkPolicy != "" {
+		var ok bool
+		if networkPolicy, ok = container.ParseNetworkPolicy(req.NetworkPolicy); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_NETWORK_POLICY"})
+			return
+		}
+	}
+	if !validAttachmentGenerator(req.AttachmentGenerator, req.AttachmentScript) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_ATTACHMENT_GENERATOR"})
+		return
+	}
+
 	result, err := db.Exec(`
 		UPDATE question_bank SET
 			title = COALESCE(NULLIF($1, ''), title),
@@ -349,6 +455,15 @@ func HandleUpdateQuestion(c *gin.Context, db *sql.DB) {
 			no_resource_limit = $15,
 			flag_env = CASE WHEN $16 = '' THEN flag_env ELSE $16 END,
 			flag_script = $17,
+			network_policy = COALESCE(NULLIF($19, ''), network_policy),
+			read_only_rootfs = $20,
+			solve_script = $21,
+			verify_status = CASE WHEN solve_script IS DISTINCT FROM $21 THEN NULL ELSE verify_status END,
+			attachment_generator = $22,
+			attachment_script = $23,
+			author = $24,
+			source = $25,
+			allow_setuid = $26,
 			needs_edit = false,
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = $18
@@ -356,7 +471,9 @@ func HandleUpdateQuestion(c *gin.Context, db *sql.DB) {
 		req.Title, req.Type, req.CategoryID, req.Difficulty, req.Description,
 		req.Flag, req.FlagType, req.DockerImage, req.AttachmentURL, req.AttachmentType,
 		req.Ports, req.CPULimit, req.MemoryLimit, req.StorageLimit,
-		req.NoResourceLimit, req.FlagEnv, NullIfEmpty(req.FlagScript), id,
+		req.NoResourceLimit, req.FlagEnv, NullIfEmpty(req.FlagScript), id, networkPolicy, req.ReadOnlyRootfs,
+		NullIfEmpty(req.SolveScript), NullIfEmpty(req.AttachmentGenerator), NullIfEmpty(req.AttachmentScript),
+		NullIfEmpty(strings.TrimSpace(req.Author)), NullIfEmpty(strings.TrimSpace(req.Source)), req.AllowSetuid,
 	)
 
 	if err != nil {
@@ -370,7 +487,21 @@ func HandleUpdateQuestion(c *gin.Context, db *sql.DB) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Question updated"})
+	// 内容有变化时生成新版本，已添加到比赛的题目仍使用原版本
+	questionID, _ := strconv.ParseInt(id, 10, 64)
+	if req.Tags != nil {
+		if err := bank.SetTags(db, bank.Jeopardy, questionID, *req.Tags); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+			return
+		}
+	}
+	version, err := SaveRevision(db, questionID, c.GetInt64("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Question updated", "version": version})
 }
 
 // HandleDeleteQuestion 删除题库题目
@@ -395,6 +526,34 @@ func HandleDeleteQuestion(c *gin.Context, db *sql.DB) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "QUESTION_NOT_FOUND"})
 		return
 	}
+	if qid, err := strconv.ParseInt(id, 10, 64); err == nil {
+		challengepkg.RemoveContext(challengepkg.KindJeopardy, qid)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Question deleted"})
 }
+
+// HandleListQuestionTags 题库标签及使用数: GET /questions/tags
+func HandleListQuestionTags(c *gin.Context, db *sql.DB) {
+	tags, err := bank.ListTags(db, bank.Jeopardy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+// HandleQuestionContests 使用该题目的比赛: GET /questions/:id/contests
+func HandleQuestionContests(c *gin.Context, db *sql.DB) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_ID"})
+		return
+	}
+	usages, err := bank.UsedIn(db, bank.Jeopardy, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	c.JSON(http.StatusOK, usages)
+}
@@ -0,0 +1,301 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package question
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 题目版本：每次修改题库题目内容都保存一份完整快照（question_revisions），
+// 比赛题目固定引用添加时的版本（contest_challenges.question_version），题库修改不会影响已有比赛，
+// 管理员预览差异后可显式将比赛题目升级到最新版本；已结束的比赛不允许升级，始终展示选手当时看到的内容
+
+// revisionField 参与版本管理的题目字段
+type revisionField struct {
+	Column string
+	Label  string
+}
+
+// revisionFields 快照字段（与 question_revisions 的内容列一致）
+var revisionFields = []revisionField{
+	{"title", "标题"},
+	{"type", "类型"},
+	{"category_id", "类别"},
+	{"difficulty", "难度"},
+	{"description", "描述"},
+	{"flag", "Flag"},
+	{"flag_type", "Flag 类型"},
+	{"docker_image", "镜像"},
+	{"attachment_url", "附件"},
+	{"attachment_type", "附件类型"},
+	{"attachment_generator", "附件生成方式"},
+	{"attachment_script", "附件生成脚本"},
+	{"ports", "端口"},
+	{"cpu_limit", "CPU 限制"},
+	{"memory_limit", "内存限制"},
+	{"storage_limit", "存储限制"},
+	{"no_resource_limit", "不限制资源"},
+	{"network_policy", "出网策略"},
+	{"read_only_rootfs", "只读根文件系统"},
+	{"allow_setuid", "允许提权"},
+	{"flag_env", "Flag 环境变量"},
+	{"flag_script", "Flag 注入脚本"},
+}
+
+// revisionColumns 快照字段列表，prefix 为表别名前缀（如 "q."）
+func revisionColumns(prefix string) string {
+	cols := make([]string, len(revisionFields))
+	for i, f := range revisionFields {
+		cols[i] = prefix + f.Column
+	}
+	return strings.Join(cols, ", ")
+}
+
+// SaveRevision 题目内容与当前版本快照不同时保存为新版本，返回题目当前版本号
+// 题库题目的每个写入路径（创建、编辑、导入、镜像构建）修改内容后都需要调用
+func SaveRevision(db *sql.DB, questionID, userID int64) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// 锁定题目行，避免并发编辑产生相同版本号
+	var version int
+	var changed bool
+	err = tx.QueryRow(`
+		SELECT q.version, NOT EXISTS (
+			SELECT 1 FROM question_revisions r
+			WHERE r.question_id = q.id AND r.version = q.version
+			  AND (`+revisionColumns("r.")+`) IS NOT DISTINCT FROM (`+revisionColumns("q.")+`)
+		)
+		FROM question_bank q WHERE q.id = $1 FOR UPDATE`, questionID).Scan(&version, &changed)
+	if err != nil {
+		return 0, err
+	}
+	if !changed {
+		return version, tx.Commit()
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO question_revisions (question_id, version, `+revisionColumns("")+`, created_by)
+		SELECT q.id, COALESCE((SELECT MAX(version) FROM question_revisions WHERE question_id = q.id), 0) + 1,
+			`+revisionColumns("q.")+`, $2
+		FROM question_bank q WHERE q.id = $1
+		RETURNING version`, questionID, nullIfZero64(userID)).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE question_bank SET version = $1 WHERE id = $2`, version, questionID); err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
+
+// nullIfZero64 0 值存为 NULL
+func nullIfZero64(n int64) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// RevisionChange 两个版本之间的字段差异
+type RevisionChange struct {
+	Field string `json:"field"`
+	Label string `json:"label"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// loadRevision 读取版本快照的展示值（字段 -> 文本，类别显示为名称）
+func loadRevision(db *sql.DB, questionID int64, version int) (map[string]string, error) {
+	cols := make([]string, len(revisionFields))
+	for i, f := range revisionFields {
+		if f.Column == "category_id" {
+			cols[i] = "COALESCE((SELECT name FROM categories WHERE id = r.category_id), r.category_id::text)"
+		} else {
+			cols[i] = "COALESCE(r." + f.Column + "::text, '')"
+		}
+	}
+	values := make([]string, len(revisionFields))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err := db.QueryRow(`SELECT `+strings.Join(cols, ", ")+` FROM question_revisions r WHERE r.question_id = $1 AND r.version = $2`,
+		questionID, version).Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(values))
+	for i, f := range revisionFields {
+		result[f.Column] = values[i]
+	}
+	return result, nil
+}
+
+// diffRevisions 比较题目两个版本的差异
+func diffRevisions(db *sql.DB, questionID int64, from, to int) ([]RevisionChange, error) {
+	changes := []RevisionChange{}
+	if from == to {
+		return changes, nil
+	}
+	oldRev, err := loadRevision(db, questionID, from)
+	if err != nil {
+		return nil, err
+	}
+	newRev, err := loadRevision(db, questionID, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range revisionFields {
+		if oldRev[f.Column] != newRev[f.Column] {
+			changes = append(changes, RevisionChange{Field: f.Column, Label: f.Label, Old: oldRev[f.Column], New: newRev[f.Column]})
+		}
+	}
+	return changes, nil
+}
+
+// QuestionRevision 题目版本记录
+type QuestionRevision struct {
+	Version   int     `json:"version"`
+	Title     string  `json:"title"`
+	CreatedBy *string `json:"createdBy"`
+	CreatedAt string  `json:"createdAt"`
+	Contests  int     `json:"contests"` // 固定使用该版本的比赛数
+}
+
+// HandleListQuestionRevisions 题目版本历史: GET /questions/:id/revisions
+func HandleListQuestionRevisions(c *gin.Context, db *sql.DB) {
+	rows, err := db.Query(`
+		SELECT r.version, r.title, u.username, r.created_at,
+			(SELECT COUNT(*) FROM contest_challenges cc WHERE cc.question_id = r.question_id AND cc.question_version = r.version)
+		FROM question_revisions r
+		LEFT JOIN users u ON r.created_by = u.id
+		WHERE r.question_id = $1
+		ORDER BY r.version DESC`, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	defer rows.Close()
+
+	revisions := []QuestionRevision{}
+	for rows.Next() {
+		var r QuestionRevision
+		var createdBy sql.NullString
+		var createdAt time.Time
+		if err := rows.Scan(&r.Version, &r.Title, &createdBy, &createdAt, &r.Contests); err != nil {
+			continue
+		}
+		r.CreatedBy = nullStringToPtr(createdBy)
+		r.CreatedAt = createdAt.Format(time.RFC3339)
+		revisions = append(revisions, r)
+	}
+	c.JSON(http.StatusOK, revisions)
+}
+
+// QuestionUpgrade 比赛题目可升级的版本
+type QuestionUpgrade struct {
+	ChallengeID   int64            `json:"challengeId"`
+	QuestionID    int64            `json:"questionId"`
+	Title         string           `json:"title"`
+	PinnedVersion int              `json:"pinnedVersion"`
+	LatestVersion int              `json:"latestVersion"`
+	Changes       []RevisionChange `json:"changes"`
+}
+
+// listQuestionUpgrades 查询比赛中落后于题库最新版本的题目及差异
+func listQuestionUpgrades(db *sql.DB, contestID string) ([]QuestionUpgrade, error) {
+	rows, err := db.Query(`
+		SELECT cc.id, q.id, q.title, cc.question_version, q.version
+		FROM contest_challenges cc
+		JOIN question_bank q ON cc.question_id = q.id
+		WHERE cc.contest_id = $1 AND cc.question_version < q.version
+		ORDER BY CASE WHEN cc.display_order = 0 THEN 999999 ELSE cc.display_order END, cc.id`, contestID)
+	if err != nil {
+		return nil, err
+	}
+	upgrades := []QuestionUpgrade{}
+	for rows.Next() {
+		var u QuestionUpgrade
+		if err := rows.Scan(&u.ChallengeID, &u.QuestionID, &u.Title, &u.PinnedVersion, &u.LatestVersion); err == nil {
+			upgrades = append(upgrades, u)
+		}
+	}
+	rows.Close()
+
+	for i := range upgrades {
+		u := &upgrades[i]
+		if u.Changes, err = diffRevisions(db, u.QuestionID, u.PinnedVersion, u.LatestVersion); err != nil {
+			return nil, err
+		}
+	}
+	return upgrades, nil
+}
+
+// HandleListQuestionUpgrades 预览比赛题目升级到题库最新版本的差异: GET /contests/:id/question-upgrades
+func HandleListQuestionUpgrades(c *gin.Context, db *sql.DB) {
+	upgrades, err := listQuestionUpgrades(db, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	c.JSON(http.StatusOK, upgrades)
+}
+
+// HandleUpgradeContestQuestions 将比赛题目升级到题库最新版本: POST /contests/:id/question-upgrades
+// 请求体 {"challengeIds": [1, 2]}，为空时升级所有落后的题目；已结束的比赛不允许升级
+func HandleUpgradeContestQuestions(c *gin.Context, db *sql.DB) {
+	contestID := c.Param("id")
+	var req struct {
+		ChallengeIDs []int64 `json:"challengeIds"`
+	}
+	c.ShouldBindJSON(&req)
+
+	var status string
+	if err := db.QueryRow(`SELECT status FROM contests WHERE id = $1`, contestID).Scan(&status); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "CONTEST_NOT_FOUND"})
+		return
+	}
+	if status == "ended" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CONTEST_ENDED", "message": "比赛已结束，题目保持选手作答时的版本"})
+		return
+	}
+
+	query := `
+		UPDATE contest_challenges cc SET question_version = q.version, updated_at = CURRENT_TIMESTAMP
+		FROM question_bank q
+		WHERE cc.question_id = q.id AND cc.contest_id = $1 AND cc.question_version < q.version`
+	args := []interface{}{contestID}
+	if len(req.ChallengeIDs) > 0 {
+		query += ` AND cc.id = ANY($2)`
+		args = append(args, req.ChallengeIDs)
+	}
+	rows, err := db.Query(query+` RETURNING cc.id`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DATABASE_ERROR"})
+		return
+	}
+	upgraded := []int64{}
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			upgraded = append(upgraded, id)
+		}
+	}
+	rows.Close()
+
+	if len(upgraded) > 0 && OnChallengeUpgrade != nil {
+		OnChallengeUpgrade(db, upgraded)
+	}
+	c.JSON(http.StatusOK, gin.H{"upgraded": len(upgraded), "challengeIds": upgraded, "message": "题目已升级到最新版本"})
+}
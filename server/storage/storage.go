@@ -0,0 +1,105 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+// Package storage 附件存储后端（本地磁盘 / S3 兼容对象存储，如 MinIO）
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("object not found")
+
+// Object 存储中的对象
+type Object struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// Storage 附件存储后端，对象键为不含目录的文件名
+type Storage interface {
+	// Name 后端名称: local | s3
+	Name() string
+	// Put 写入对象，size 为内容长度
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get 读取对象，调用方负责关闭；本地后端返回的 Reader 同时实现 io.ReadSeeker（支持断点续传）
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete 删除对象，对象不存在时不报错
+	Delete(ctx context.Context, key string) error
+	// List 列出全部对象（垃圾回收使用）
+	List(ctx context.Context) ([]Object, error)
+}
+
+var current Storage
+
+// MaxUploadSize 单个附件大小上限（ATTACHMENT_MAX_SIZE，单位 MB，默认 200），0 表示不限
+var MaxUploadSize = envInt64("ATTACHMENT_MAX_SIZE", 200) << 20
+
+// Init 根据 STORAGE_BACKEND 初始化附件存储（local | s3），未配置时使用本地目录
+func Init(backend string) error {
+	switch strings.ToLower(strings.TrimSpace(backend)) {
+	case "", BackendLocal:
+		current = NewLocal(envOr("ATTACHMENT_DIR", "./attachments"))
+	case BackendS3, "minio":
+		s, err := NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    envOr("S3_REGION", "us-east-1"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Prefix:    os.Getenv("S3_PREFIX"),
+		})
+		if err != nil {
+			return err
+		}
+		current = s
+	default:
+		return fmt.Errorf("unknown storage backend: %s", backend)
+	}
+	return nil
+}
+
+// Default 当前附件存储（未初始化时使用本地目录）
+func Default() Storage {
+	if current == nil {
+		current = NewLocal("./attachments")
+	}
+	return current
+}
+
+// ValidKey 对象键是否合法（仅允许文件名，禁止路径穿越）
+func ValidKey(key string) bool {
+	if key == "" || key == "." || key == ".." || len(key) > 255 {
+		return false
+	}
+	return !strings.ContainsAny(key, "/\\\x00")
+}
+
+func envOr(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt64(key string, def int64) int64 {
+	if n, err := strconv.ParseInt(strings.TrimSpace(os.Getenv(key)), 10, 64); err == nil && n >= 0 {
+		return n
+	}
+	return def
+}
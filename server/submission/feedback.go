@@ -0,0 +1,513 @@
+// Author: tan91
+// GitHub: https://github.com/NUDTTAN91
+// Blog: https://blog.csdn.net/ZXW_NUDT
+
+package submission
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxFeedbackComment 评价留言最大字符数
+const maxFeedbackComment = 500
+
+// feedbackMode 不同比赛模式下题目、解题与尝试记录所在的表
+type feedbackMode struct {
+	challenges string // 比赛题目表
+	solves     string // 解题记录表
+	attempts   string // 尝试记录表（有记录即视为尝试过）
+}
+
+var (
+	jeopardyFeedback = feedbackMode{challenges: "contest_challenges", solves: "team_solves", attempts: "submissions"}
+	awdfFeedback     = feedbackMode{challenges: "contest_challenges_awdf", solves: "team_solves_awdf", attempts: "awdf_patches"}
+)
+
+func feedbackModeOf(contestMode string) feedbackMode {
+	if contestMode == "awd-f" {
+		return awdfFeedback
+	}
+	return jeopardyFeedback
+}
+
+// summaryColumns 评价汇总与解题统计列（比赛题目表别名为 cc，需配合 feedbackJoin）
+func (m feedbackMode) summaryColumns() string {
+	return `COALESCE(f.ratings, 0), f.avg_difficulty, f.avg_quality, COALESCE(f.comments, 0),
+		(SELECT COUNT(*) FROM ` + m.solves + ` s WHERE s.contest_id = cc.contest_id AND s.challenge_id = cc.id),
+		(SELECT COUNT(*) FROM (
+			SELECT team_id FROM ` + m.attempts + ` WHERE contest_id = cc.contest_id AND challenge_id = cc.id
+			UNION SELECT team_id FROM ` + m.solves + ` WHERE contest_id = cc.contest_id AND challenge_id = cc.id) a)`
+}
+
+// feedbackJoin 按比赛题目聚合评价
+const feedbackJoin = `
+	LEFT JOIN (
+		SELECT contest_id, challenge_id, COUNT(*) AS ratings,
+			ROUND(AVG(difficulty), 2)::float8 AS avg_difficulty,
+			ROUND(AVG(quality), 2)::float8 AS avg_quality,
+			COUNT(*) FILTER (WHERE COALESCE(comment, '') <> '') AS comments
+		FROM challenge_feedback
+		GROUP BY contest_id, challenge_id
+	) f ON f.contest_id = cc.contest_id AND f.challenge_id = cc.id`
+
+// FeedbackSummary 题目评价与解题情况汇总
+type FeedbackSummary struct {
+	Ratings        int      `json:"ratings"`
+	AvgDifficulty  *float64 `json:"avgDifficulty"` // 体感难度均值（1-10）
+	AvgQuality     *float64 `json:"avgQuality"`    // 质量星级均值（1-5）
+	Comments       int      `json:"comments"`
+	SolveCount     int      `json:"solveCount"`
+	AttemptedTeams int      `json:"attemptedTeams"` // 提交过或解出的队伍数
+	SolveRate      *float64 `json:"solveRate"`      // 解出队伍 / 尝试队伍
+}
+
+func (s *FeedbackSummary) scanTargets() []interface{} {
+	return []interface{}{&s.Ratings, &s.AvgDifficulty, &s.AvgQuality, &s.Comments, &s.SolveCount, &s.AttemptedTeams}
+}
+
+func (s *FeedbackSummary) computeRate() {
+	if s.AttemptedTeams > 0 {
+		rate := math.Round(float64(s.SolveCount)/float64(s.AttemptedTeams)*10000) / 10000
+		s.SolveRate = &rate
+	}
+}
+
+// ChallengeFeedback 比赛题目的评价汇总
+type ChallengeFeedback struct {
+	ChallengeID  int64  `json:"challengeId"`
+	Title        string `json:"title"`
+	CategoryName string `json:"categoryName"`
+	Difficulty   int    `json:"difficulty"` // 题目设定难度
+	Status       string `json:"status"`
+	FeedbackSummary
+}
+
+// FeedbackEntry 单条选手评价
+type FeedbackEntry struct {
+	ID          int64   `json:"id"`
+	ContestID   int64   `json:"contestId"`
+	ContestName string  `json:"contestName,omitempty"`
+	ChallengeID int64   `json:"challengeId"`
+	UserName    string  `json:"userName,omitempty"`
+	TeamName    string  `json:"teamName,omitempty"`
+	Solved      bool    `json:"solved"`
+	Difficulty  int     `json:"difficulty"`
+	Quality     int     `json:"quality"`
+	Comment     *string `json:"comment"`
+	CreatedAt   string  `json:"createdAt"`
+}
+
+// FeedbackRequest 选手提交题目评价
+type FeedbackRequest struct {
+	Difficulty int    `json:"difficulty" binding:"required,min=1,max=10"`
+	Quality    int    `json:"quality" binding:"required,min=1,max=5"`
+	Comment    string `json:"comment"`
+}
+
+// feedbackError 无法评价的原因
+type feedbackError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *feedbackError) Error() string { return e.code }
+
+// feedbackTarget 选手可评价的比赛题目
+type feedbackTarget struct {
+	teamID     int64
+	questionID sql.NullInt64
+	solved     bool
+	attempted  bool
+}
+
+// loadFeedbackTarget 校验选手队伍已通过比赛审核、比赛已开始，并查询队伍对该题的解题 / 尝试情况
+func loadFeedbackTarget(db *sql.DB, contestID, challengeID string, userID int64) (*feedbackTarget, error) {
+	var contestStatus, contestMode string
+	err := db.QueryRow(`SELECT status, mode FROM contests WHERE id = $1`, contestID).Scan(&contestStatus, &contestMode)
+	if err == sql.ErrNoRows {
+		return nil, &feedbackError{http.StatusNotFound, "CONTEST_NOT_FOUND", "比赛不存在"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if contestStatus == "pending" {
+		return nil, &feedbackError{http.StatusBadRequest, "CONTEST_NOT_STARTED", "比赛尚未开始"}
+	}
+
+	var teamID sql.NullInt64
+	db.QueryRow(`SELECT team_id FROM users WHERE id = $1`, userID).Scan(&teamID)
+	if !teamID.Valid {
+		return nil, &feedbackError{http.StatusBadRequest, "NO_TEAM", "您还未加入队伍"}
+	}
+	var teamStatus string
+	db.QueryRow(`SELECT status FROM contest_teams WHERE contest_id = $1 AND team_id = $2`, contestID, teamID.Int64).Scan(&teamStatus)
+	if teamStatus != "approved" {
+		return nil, &feedbackError{http.StatusForbidden, "TEAM_NOT_APPROVED", "队伍未通过比赛审核"}
+	}
+
+	mode := feedbackModeOf(contestMode)
+	t := &feedbackTarget{teamID: teamID.Int64}
+	err = db.QueryRow(`
+		SELECT question_id,
+			EXISTS (SELECT 1 FROM `+mode.solves+` WHERE contest_id = $2 AND challenge_id = $1 AND team_id = $3),
+			EXISTS (SELECT 1 FROM `+mode.attempts+` WHERE contest_id = $2 AND challenge_id = $1 AND team_id = $3)
+		FROM `+mode.challenges+` WHERE id = $1 AND contest_id = $2`,
+		challengeID, contestID, teamID.Int64).Scan(&t.questionID, &t.solved, &t.attempted)
+	if err == sql.ErrNoRows {
+		return nil, &feedbackError{http.StatusNotFound, "CHALLENGE_NOT_FOUND", "题目不存在"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func respondFeedbackError(c *gin.Context, err error) {
+	if fe, ok := err.(*feedbackError); ok {
+		c.JSON(fe.status, gin.H{"error": fe.code, "message": fe.message})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR"})
+}
+
+// HandleGetMyFeedback 获取本人对题目的评价及是否可以评价: GET /contests/:id/challenges/:challengeId/feedback
+func HandleGetMyFeedback(c *gin.Context, db *sql.DB) {
+	contestID := c.Param("id")
+	challengeID := c.Param("challengeId")
+	userID := c.GetInt64("userID")
+
+	t, err := loadFeedbackTarget(db, contestID, challengeID, userID)
+	if err != nil {
+		respondFeedbackError(c, err)
+		return
+	}
+
+	var entry *FeedbackEntry
+	var e FeedbackEntry
+	var comment sql.NullString
+	var createdAt time.Time
+	err = db.QueryRow(`
+		SELECT id, contest_id, challenge_id, solved, difficulty, quality, comment, created_at
+		FROM challenge_feedback WHERE contest_id = $1 AND challenge_id = $2 AND user_id = $3`,
+		contestID, challengeID, userID).Scan(&e.ID, &e.ContestID, &e.ChallengeID, &e.Solved, &e.Difficulty, &e.Quality, &comment, &createdAt)
+	if err == nil {
+		if comment.Valid {
+			e.Comment = &comment.String
+		}
+		e.CreatedAt = createdAt.Format("2006-01-02 15:04:05")
+		entry = &e
+	} else if err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"eligible": t.solved || t.attempted,
+		"solved":   t.solved,
+		"feedback": entry,
+	})
+}
+
+// HandleSubmitFeedback 提交题目评价（每人每题一次，需队伍已解出或尝试过）: POST /contests/:id/challenges/:challengeId/feedback
+func HandleSubmitFeedback(c *gin.Context, db *sql.DB) {
+	contestID := c.Param("id")
+	challengeID := c.Param("challengeId")
+	userID := c.GetInt64("userID")
+
+	var req FeedbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_REQUEST", "message": "体感难度为 1-10，质量评分为 1-5 星"})
+		return
+	}
+	req.Comment = strings.TrimSpace(req.Comment)
+	if utf8.RuneCountInString(req.Comment) > maxFeedbackComment {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "COMMENT_TOO_LONG", "message": "留言不能超过 " + strconv.Itoa(maxFeedbackComment) + " 字"})
+		return
+	}
+
+	t, err := loadFeedbackTarget(db, contestID, challengeID, userID)
+	if err != nil {
+		respondFeedbackError(c, err)
+		return
+	}
+	if !t.solved && !t.attempted {
+		c.JSON(http.StatusForbidden, gin.H{"error": "NOT_ATTEMPTED", "message": "解出或尝试过该题目后才能评价"})
+		return
+	}
+
+	var comment sql.NullString
+	if req.Comment != "" {
+		comment = sql.NullString{String: req.Comment, Valid: true}
+	}
+	var id int64
+	err = db.QueryRow(`
+		INSERT INTO challenge_feedback (contest_id, challenge_id, question_id, team_id, user_id, solved, difficulty, quality, comment)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (contest_id, challenge_id, user_id) DO NOTHING
+		RETURNING id`,
+		contestID, challengeID, t.questionID, t.teamID, userID, t.solved, req.Difficulty, req.Quality, comment).Scan(&id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "ALREADY_RATED", "message": "您已评价过该题目"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "感谢您的评价", "id": id})
+}
+
+// contestFeedback 查询比赛题目的评价汇总，challengeID 为空时返回全部题目
+func contestFeedback(db *sql.DB, contestMode, contestID, challengeID string) ([]ChallengeFeedback, error) {
+	mode := feedbackModeOf(contestMode)
+	var query string
+	if contestMode == "awd-f" {
+		query = `
+			SELECT cc.id, q.title, COALESCE(cat.name, ''), q.difficulty, cc.status, ` + mode.summaryColumns() + `
+			FROM contest_challenges_awdf cc
+			JOIN question_bank_awdf q ON q.id = cc.question_id
+			LEFT JOIN categories cat ON cat.id = q.category_id` + feedbackJoin
+	} else {
+		query = `
+			SELECT cc.id, COALESCE(q.title, cc.inline_title, ''), COALESCE(cat.name, ''), COALESCE(q.difficulty, cc.difficulty),
+				cc.status, ` + mode.summaryColumns() + `
+			FROM contest_challenges cc
+			LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version
+			LEFT JOIN categories cat ON cat.id = COALESCE(q.category_id, cc.inline_category_id)` + feedbackJoin
+	}
+	args := []interface{}{contestID}
+	query += ` WHERE cc.contest_id = $1`
+	if challengeID != "" {
+		args = append(args, challengeID)
+		query += ` AND cc.id = $2`
+	}
+	query += ` ORDER BY cc.display_order ASC, cc.id ASC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []ChallengeFeedback{}
+	for rows.Next() {
+		var f ChallengeFeedback
+		dest := append([]interface{}{&f.ChallengeID, &f.Title, &f.CategoryName, &f.Difficulty, &f.Status}, f.scanTargets()...)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		f.computeRate()
+		result = append(result, f)
+	}
+	return result, rows.Err()
+}
+
+// loadFeedbackEntries 查询评价明细，where 为 challenge_feedback（别名 f）的过滤条件
+func loadFeedbackEntries(db *sql.DB, where string, args ...interface{}) ([]FeedbackEntry, error) {
+	rows, err := db.Query(`
+		SELECT f.id, f.contest_id, c.name, f.challenge_id, COALESCE(u.display_name, u.username), COALESCE(t.name, ''),
+			f.solved, f.difficulty, f.quality, f.comment, f.created_at
+		FROM challenge_feedback f
+		JOIN contests c ON c.id = f.contest_id
+		JOIN users u ON u.id = f.user_id
+		LEFT JOIN teams t ON t.id = f.team_id
+		WHERE `+where+`
+		ORDER BY f.created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []FeedbackEntry{}
+	for rows.Next() {
+		var e FeedbackEntry
+		var comment sql.NullString
+		var createdAt time.Time
+		if err := rows.Scan(&e.ID, &e.ContestID, &e.ContestName, &e.ChallengeID, &e.UserName, &e.TeamName,
+			&e.Solved, &e.Difficulty, &e.Quality, &comment, &createdAt); err != nil {
+			return nil, err
+		}
+		if comment.Valid {
+			e.Comment = &comment.String
+		}
+		e.CreatedAt = createdAt.Format("2006-01-02 15:04:05")
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// voteDistribution 统计体感难度（1-10）与质量星级（1-5）的投票分布
+func voteDistribution(entries []FeedbackEntry) (difficulty [10]int, quality [5]int) {
+	for _, e := range entries {
+		if e.Difficulty >= 1 && e.Difficulty <= 10 {
+			difficulty[e.Difficulty-1]++
+		}
+		if e.Quality >= 1 && e.Quality <= 5 {
+			quality[e.Quality-1]++
+		}
+	}
+	return
+}
+
+// HandleGetContestFeedback 比赛各题目的评价汇总与解题统计: GET /admin/contests/:id/feedback
+func HandleGetContestFeedback(c *gin.Context, db *sql.DB) {
+	contestID := c.Param("id")
+
+	var contestMode string
+	if err := db.QueryRow(`SELECT mode FROM contests WHERE id = $1`, contestID).Scan(&contestMode); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "CONTEST_NOT_FOUND"})
+		return
+	}
+	challenges, err := contestFeedback(db, contestMode, contestID, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR"})
+		return
+	}
+	c.JSON(http.StatusOK, challenges)
+}
+
+// HandleGetChallengeFeedback 比赛题目的评价明细，附带解题榜: GET /admin/contests/:id/challenges/:challengeId/feedback
+func HandleGetChallengeFeedback(c *gin.Context, db *sql.DB) {
+	contestID := c.Param("id")
+	challengeID := c.Param("challengeId")
+	if _, err := strconv.ParseInt(challengeID, 10, 64); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_ID"})
+		return
+	}
+
+	var contestMode string
+	if err := db.QueryRow(`SELECT mode FROM contests WHERE id = $1`, contestID).Scan(&contestMode); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "CONTEST_NOT_FOUND"})
+		return
+	}
+	summaries, err := contestFeedback(db, contestMode, contestID, challengeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR"})
+		return
+	}
+	if len(summaries) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "CHALLENGE_NOT_FOUND"})
+		return
+	}
+	entries, err := loadFeedbackEntries(db, `f.contest_id = $1 AND f.challenge_id = $2`, contestID, challengeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR"})
+		return
+	}
+	solvers, err := loadChallengeSolvers(db, contestMode, contestID, challengeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR"})
+		return
+	}
+
+	difficultyVotes, qualityVotes := voteDistribution(entries)
+	c.JSON(http.StatusOK, gin.H{
+		"challenge":       summaries[0],
+		"difficultyVotes": difficultyVotes,
+		"qualityVotes":    qualityVotes,
+		"feedback":        entries,
+		"solvers":         solvers,
+	})
+}
+
+// QuestionFeedbackContest 题库题目在某场比赛中的评价汇总
+type QuestionFeedbackContest struct {
+	ContestID   int64  `json:"contestId"`
+	ContestName string `json:"contestName"`
+	StartTime   string `json:"startTime"`
+	ChallengeID int64  `json:"challengeId"`
+	FeedbackSummary
+}
+
+// questionFeedback 汇总题库题目在各场比赛中的评价与解题情况
+func questionFeedback(c *gin.Context, db *sql.DB, contestMode string) {
+	questionID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "INVALID_ID"})
+		return
+	}
+	mode := feedbackModeOf(contestMode)
+	modeCond := `c.mode <> 'awd-f'`
+	if contestMode == "awd-f" {
+		modeCond = `c.mode = 'awd-f'`
+	}
+
+	rows, err := db.Query(`
+		SELECT c.id, c.name, c.start_time, cc.id, `+mode.summaryColumns()+`
+		FROM `+mode.challenges+` cc
+		JOIN contests c ON c.id = cc.contest_id`+feedbackJoin+`
+		WHERE cc.question_id = $1
+		ORDER BY c.start_time DESC`, questionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR"})
+		return
+	}
+	defer rows.Close()
+
+	contests := []QuestionFeedbackContest{}
+	var total FeedbackSummary
+	for rows.Next() {
+		var q QuestionFeedbackContest
+		var startTime time.Time
+		dest := append([]interface{}{&q.ContestID, &q.ContestName, &startTime, &q.ChallengeID}, q.scanTargets()...)
+		if err := rows.Scan(dest...); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR"})
+			return
+		}
+		q.StartTime = startTime.Format("2006-01-02 15:04:05")
+		q.computeRate()
+		total.SolveCount += q.SolveCount
+		total.AttemptedTeams += q.AttemptedTeams
+		contests = append(contests, q)
+	}
+
+	// 评价按题库题目记录，题目从比赛中移除后仍计入总体评价
+	entries, err := loadFeedbackEntries(db, `f.question_id = $1 AND `+modeCond, questionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR"})
+		return
+	}
+	var difficultySum, qualitySum int
+	for _, e := range entries {
+		difficultySum += e.Difficulty
+		qualitySum += e.Quality
+		if e.Comment != nil && *e.Comment != "" {
+			total.Comments++
+		}
+	}
+	if total.Ratings = len(entries); total.Ratings > 0 {
+		avgDifficulty := math.Round(float64(difficultySum)/float64(total.Ratings)*100) / 100
+		avgQuality := math.Round(float64(qualitySum)/float64(total.Ratings)*100) / 100
+		total.AvgDifficulty, total.AvgQuality = &avgDifficulty, &avgQuality
+	}
+	total.computeRate()
+
+	difficultyVotes, qualityVotes := voteDistribution(entries)
+	c.JSON(http.StatusOK, gin.H{
+		"summary":         total,
+		"difficultyVotes": difficultyVotes,
+		"qualityVotes":    qualityVotes,
+		"contests":        contests,
+		"feedback":        entries,
+	})
+}
+
+// HandleGetQuestionFeedback 题库题目跨比赛的评价汇总: GET /admin/questions/:id/feedback
+func HandleGetQuestionFeedback(c *gin.Context, db *sql.DB) {
+	questionFeedback(c, db, "jeopardy")
+}
+
+// HandleGetAWDFQuestionFeedback AWD-F 题库题目跨比赛的评价汇总: GET /admin/awdf/questions/:id/feedback
+func HandleGetAWDFQuestionFeedback(c *gin.Context, db *sql.DB) {
+	questionFeedback(c, db, "awd-f")
+}
@@ -293,11 +293,20 @@ func HandleSubmitFlag(c *gin.Context, db *sql.DB) {
 	} else {
 		// 判断是否为临时题目（question_id 为 NULL）
 		if questionID.Valid {
-			db.QueryRow(`SELECT flag_type, flag FROM question_bank WHERE id = $1`, questionID.Int64).Scan(&flagType, &staticFlag)
+			// 使用比赛固定的题目版本
+			db.QueryRow(`SELECT r.flag_type, r.flag FROM contest_challenges cc
+				JOIN question_revisions r ON r.question_id = cc.question_id AND r.version = cc.question_version
+				WHERE cc.id = $1`, challengeID).Scan(&flagType, &staticFlag)
 		} else {
 			// 临时题目，从 contest_challenges 的 inline_* 字段获取
 			db.QueryRow(`SELECT COALESCE(inline_flag_type, 'static'), inline_flag FROM contest_challenges WHERE id = $1`, challengeID).Scan(&flagType, &staticFlag)
 		}
+		// 全场共享实例只注入题目静态 Flag，按静态 Flag 校验
+		var instancePolicy string
+		db.QueryRow(`SELECT COALESCE(instance_policy, 'team') FROM contest_challenges WHERE id = $1`, challengeID).Scan(&instancePolicy)
+		if instancePolicy == "shared" {
+			flagType = "static"
+		}
 	}
 
 	submittedFlag := strings.TrimSpace(req.Flag)
@@ -482,7 +491,7 @@ func HandleSubmitFlag(c *gin.Context, db *sql.DB) {
 			if contestMode == "awd-f" {
 				db.QueryRow(`SELECT q.title FROM question_bank_awdf q JOIN contest_challenges_awdf cc ON q.id = cc.question_id WHERE cc.id = $1`, challengeID).Scan(&challengeName)
 			} else {
-				db.QueryRow(`SELECT COALESCE(q.title, cc.inline_title, '') FROM contest_challenges cc LEFT JOIN question_bank q ON q.id = cc.question_id WHERE cc.id = $1`, challengeID).Scan(&challengeName)
+				db.QueryRow(`SELECT COALESCE(q.title, cc.inline_title, '') FROM contest_challenges cc LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version WHERE cc.id = $1`, challengeID).Scan(&challengeName)
 			}
 			var teamName string
 			db.QueryRow(`SELECT name FROM teams WHERE id = $1`, teamID.Int64).Scan(&teamName)
@@ -527,7 +536,7 @@ func HandleSubmitFlag(c *gin.Context, db *sql.DB) {
 	if contestMode == "awd-f" {
 		db.QueryRow(`SELECT q.title FROM question_bank_awdf q JOIN contest_challenges_awdf cc ON q.id = cc.question_id WHERE cc.id = $1`, challengeID).Scan(&challengeName)
 	} else {
-		db.QueryRow(`SELECT COALESCE(q.title, cc.inline_title, '') FROM contest_challenges cc LEFT JOIN question_bank q ON q.id = cc.question_id WHERE cc.id = $1`, challengeID).Scan(&challengeName)
+		db.QueryRow(`SELECT COALESCE(q.title, cc.inline_title, '') FROM contest_challenges cc LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version WHERE cc.id = $1`, challengeID).Scan(&challengeName)
 	}
 	
 	// 获取该队伍在该题目的提交次数
@@ -705,6 +714,47 @@ func CalculateScoreWithBonus(baseScore, solveOrder, firstBonus, secondBonus, thi
 	return baseScore + (baseScore * bonusPercent / 100)
 }
 
+// SolverInfo 题目解题队伍
+type SolverInfo struct {
+	Rank     int    `json:"rank"`
+	TeamID   int64  `json:"teamId"`
+	TeamName string `json:"teamName"`
+	SolvedAt string `json:"solvedAt"`
+}
+
+// loadChallengeSolvers 查询题目的所有解题队伍（按解题时间排序）
+func loadChallengeSolvers(db *sql.DB, contestMode, contestID, challengeID string) ([]SolverInfo, error) {
+	solvesTable := "team_solves"
+	if contestMode == "awd-f" {
+		solvesTable = "team_solves_awdf"
+	}
+	rows, err := db.Query(`
+		SELECT ts.team_id, t.name, ts.solved_at
+		FROM `+solvesTable+` ts
+		JOIN teams t ON ts.team_id = t.id
+		WHERE ts.contest_id = $1 AND ts.challenge_id = $2
+		ORDER BY ts.solved_at ASC`, contestID, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var solvers []SolverInfo
+	rank := 1
+	for rows.Next() {
+		var s SolverInfo
+		var solvedAt time.Time
+		if err := rows.Scan(&s.TeamID, &s.TeamName, &solvedAt); err != nil {
+			continue
+		}
+		s.Rank = rank
+		s.SolvedAt = solvedAt.Format("2006-01-02 15:04:05")
+		solvers = append(solvers, s)
+		rank++
+	}
+	return solvers, nil
+}
+
 // HandleGetChallengeStats 获取题目解题统计
 func HandleGetChallengeStats(c *gin.Context, db *sql.DB) {
 	contestID := c.Param("id")
@@ -736,7 +786,7 @@ func HandleGetChallengeStats(c *gin.Context, db *sql.DB) {
 				if contestMode == "awd-f" {
 					db.QueryRow(`SELECT q.title FROM question_bank_awdf q JOIN contest_challenges_awdf cc ON q.id = cc.question_id WHERE cc.id = $1`, challengeID).Scan(&challengeName)
 				} else {
-					db.QueryRow(`SELECT COALESCE(q.title, cc.inline_title, '') FROM contest_challenges cc LEFT JOIN question_bank q ON q.id = cc.question_id WHERE cc.id = $1`, challengeID).Scan(&challengeName)
+					db.QueryRow(`SELECT COALESCE(q.title, cc.inline_title, '') FROM contest_challenges cc LEFT JOIN question_revisions q ON q.question_id = cc.question_id AND q.version = cc.question_version WHERE cc.id = $1`, challengeID).Scan(&challengeName)
 				}
 				db.QueryRow(`SELECT COALESCE(display_name, username) FROM users WHERE id = $1`, userID).Scan(&userName)
 				
@@ -747,53 +797,16 @@ func HandleGetChallengeStats(c *gin.Context, db *sql.DB) {
 	}
 
 	var solveCount int
-	var rows *sql.Rows
-	var err error
 	if contestMode == "awd-f" {
 		db.QueryRow(`SELECT COUNT(*) FROM team_solves_awdf WHERE contest_id = $1 AND challenge_id = $2`, contestID, challengeID).Scan(&solveCount)
-		// 查询所有解题者（按时间排序）
-		rows, err = db.Query(`
-			SELECT ts.team_id, t.name, ts.solved_at
-			FROM team_solves_awdf ts
-			JOIN teams t ON ts.team_id = t.id
-			WHERE ts.contest_id = $1 AND ts.challenge_id = $2
-			ORDER BY ts.solved_at ASC`, contestID, challengeID)
 	} else {
 		db.QueryRow(`SELECT COUNT(*) FROM team_solves WHERE contest_id = $1 AND challenge_id = $2`, contestID, challengeID).Scan(&solveCount)
-		// 查询所有解题者（按时间排序）
-		rows, err = db.Query(`
-			SELECT ts.team_id, t.name, ts.solved_at
-			FROM team_solves ts
-			JOIN teams t ON ts.team_id = t.id
-			WHERE ts.contest_id = $1 AND ts.challenge_id = $2
-			ORDER BY ts.solved_at ASC`, contestID, challengeID)
 	}
+	solvers, err := loadChallengeSolvers(db, contestMode, contestID, challengeID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB_ERROR"})
 		return
 	}
-	defer rows.Close()
-
-	type SolverInfo struct {
-		Rank     int    `json:"rank"`
-		TeamID   int64  `json:"teamId"`
-		TeamName string `json:"teamName"`
-		SolvedAt string `json:"solvedAt"`
-	}
-
-	var solvers []SolverInfo
-	rank := 1
-	for rows.Next() {
-		var s SolverInfo
-		var solvedAt time.Time
-		if err := rows.Scan(&s.TeamID, &s.TeamName, &solvedAt); err != nil {
-			continue
-		}
-		s.Rank = rank
-		s.SolvedAt = solvedAt.Format("2006-01-02 15:04:05")
-		solvers = append(solvers, s)
-		rank++
-	}
 
 	resp := gin.H{"solveCount": solveCount, "solvers": solvers}
 